@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mdxabu/bridge/internal/api"
+	"github.com/mdxabu/bridge/internal/capture"
+	"github.com/mdxabu/bridge/internal/logger"
+	"github.com/spf13/cobra"
+)
+
+var (
+	captureAPIAddr  string
+	captureJSON     bool
+	captureProtocol string
+	captureHost     string
+	capturePort     uint16
+	captureStages   []string
+	captureMaxSize  int
+	captureDuration time.Duration
+)
+
+var captureCmd = &cobra.Command{
+	Use:   "capture",
+	Short: "Capture packets of the running bridge to pcapng files",
+	Long: `Capture the packets of a running bridge through its REST API. Packets are
+recorded at each stage of translation: received on tun-ipv6 (ipv6-in), sent
+on tun-ipv4 (ipv4-out), received on tun-ipv4 (ipv4-in) and sent on tun-ipv6
+(ipv6-out). Each stage is a separate interface of the pcapng file, which is
+written by the bridge to its capture directory (capture.dir).`,
+}
+
+var captureStartCmd = &cobra.Command{
+	Use:   "start [file]",
+	Short: "Start a packet capture",
+	Long: `Start capturing packets to a pcapng file in the capture directory of the
+bridge. The file name defaults to bridge-<time>.pcapng. The capture stops
+after --duration, when the file reaches --max-size, or with bridge capture stop.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		req := api.CaptureRequest{
+			File: "bridge-" + time.Now().Format("20060102-150405") + ".pcapng",
+			Filter: capture.Filter{
+				Protocol: captureProtocol,
+				Address:  captureHost,
+				Port:     capturePort,
+			},
+			Stages:  captureStages,
+			MaxSize: int64(captureMaxSize) << 20,
+		}
+		if len(args) > 0 {
+			req.File = args[0]
+		}
+		if captureDuration > 0 {
+			req.Duration = captureDuration.String()
+		}
+
+		status, err := newAPIClient(captureAPIAddr).StartCapture(req)
+		if err != nil {
+			logger.Error("Failed to start capture: %v", err)
+			os.Exit(1)
+		}
+
+		if captureJSON {
+			printJSON(status)
+			return
+		}
+		logger.Success("Capturing to %s", status.File)
+		printCapture(status)
+	},
+}
+
+var captureStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the running packet capture",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		status, err := newAPIClient(captureAPIAddr).StopCapture()
+		if err != nil {
+			logger.Error("Failed to stop capture: %v", err)
+			os.Exit(1)
+		}
+
+		if captureJSON {
+			printJSON(status)
+			return
+		}
+		logger.Success("Capture saved to %s", status.File)
+		printCapture(status)
+	},
+}
+
+var captureStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the progress of the current or last packet capture",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		status, err := newAPIClient(captureAPIAddr).CaptureStatus()
+		if err != nil {
+			logger.Error("Failed to get capture status: %v", err)
+			os.Exit(1)
+		}
+
+		if captureJSON {
+			printJSON(status)
+			return
+		}
+		printCapture(status)
+	},
+}
+
+// printCapture prints the details of a capture
+func printCapture(status *capture.Status) {
+	state := "running"
+	if !status.Running {
+		state = "stopped"
+		if status.Reason != capture.ReasonStopped {
+			state += " (" + status.Reason + ")"
+		}
+	}
+
+	fmt.Println("Capture details:")
+	fmt.Printf("- State:    %s\n", state)
+	fmt.Printf("- File:     %s\n", status.File)
+	fmt.Printf("- Filter:   %s\n", status.Filter)
+	fmt.Printf("- Stages:   %s\n", strings.Join(status.Stages, ", "))
+	fmt.Printf("- Packets:  %d\n", status.Packets)
+	fmt.Printf("- Size:     %s of %s\n", formatBytes(uint64(status.Bytes)), formatBytes(uint64(status.MaxSize)))
+	if status.Duration != "" {
+		fmt.Printf("- Duration: %s\n", status.Duration)
+	}
+	fmt.Printf("- Started:  %s\n", status.Started.Format(time.RFC3339))
+	if status.Stopped != nil {
+		fmt.Printf("- Stopped:  %s\n", status.Stopped.Format(time.RFC3339))
+	}
+	if status.Error != "" {
+		fmt.Printf("- Error:    %s\n", status.Error)
+	}
+}
+
+func init() {
+	captureCmd.PersistentFlags().StringVar(&captureAPIAddr, "api", "", "address of the bridge API (default <api_listen>:<api_port>)")
+	captureCmd.PersistentFlags().BoolVar(&captureJSON, "json", false, "print the output as JSON")
+
+	captureStartCmd.Flags().StringVarP(&captureProtocol, "protocol", "p", "", "only packets of this protocol (tcp, udp or icmp)")
+	captureStartCmd.Flags().StringVar(&captureHost, "host", "", "only packets from or to this address or prefix")
+	captureStartCmd.Flags().Uint16Var(&capturePort, "port", 0, "only packets from or to this TCP or UDP port")
+	captureStartCmd.Flags().StringSliceVar(&captureStages, "stage", nil, "capture only at these stages: ipv6-in, ipv4-out, ipv4-in, ipv6-out (default all)")
+	captureStartCmd.Flags().IntVar(&captureMaxSize, "max-size", 0, "stop after this many megabytes (default capture.max_size)")
+	captureStartCmd.Flags().DurationVar(&captureDuration, "duration", 0, "stop after this long (default no limit)")
+
+	captureCmd.AddCommand(captureStartCmd)
+	captureCmd.AddCommand(captureStopCmd)
+	captureCmd.AddCommand(captureStatusCmd)
+	rootCmd.AddCommand(captureCmd)
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"github.com/mdxabu/bridge/internal/config"
+	"github.com/mdxabu/bridge/internal/logger"
+	"github.com/spf13/cobra"
+)
+
+// clatConfigFlags maps the flags of bridge clat to their configuration keys
+var clatConfigFlags = map[string]string{
+	"plat-prefix": "clat.plat_prefix",
+	"ipv4":        "clat.ipv4",
+	"ipv6":        "clat.ipv6",
+}
+
+var clatCmd = &cobra.Command{
+	Use:   "clat",
+	Short: "Run the customer-side translator of 464XLAT",
+	Long: `Run the bridge as a CLAT (RFC 6877). Local IPv4-only applications use the
+IPv4 TUN interface; their packets are translated statelessly to IPv6, with the
+CLAT's IPv6 address as source and destinations embedded in the PLAT prefix.
+A stateful NAT64 bridge (the PLAT) on the other end translates them back to IPv4.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Info("Starting CLAT...")
+
+		// Load configuration, with the CLAT flags on top
+		flags := changedConfigFlags(cmd, clatConfigFlags)
+		flags["mode"] = config.ModeCLAT
+		opts := config.LoadOptions{Flags: flags}
+		loaded, err := config.Load(opts)
+		if err != nil {
+			logger.Error("Failed to parse configuration: %v", err)
+			return
+		}
+
+		runBridge(loaded.Config, opts)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(clatCmd)
+
+	clatCmd.Flags().String("plat-prefix", "", "NAT64 prefix of the provider-side translator (default clat.plat_prefix)")
+	clatCmd.Flags().String("ipv4", "", "IPv4 address of local applications (default clat.ipv4)")
+	clatCmd.Flags().String("ipv6", "", "IPv6 address the CLAT translates to (default clat.ipv6)")
+}
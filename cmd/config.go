@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/mdxabu/bridge/internal/config"
+	"github.com/mdxabu/bridge/internal/logger"
+	"github.com/spf13/cobra"
+)
+
+var configShowJSON bool
+
+// startConfigFlags maps the configuration flags of bridge start to their keys
+var startConfigFlags = map[string]string{
+	"mode":           "mode",
+	"interface":      "interface",
+	"nat64-prefix":   "nat64_prefix",
+	"api-port":       "api_port",
+	"api-listen":     "api_listen",
+	"ipv4-mtu":       "ipv4_mtu",
+	"ipv6-mtu":       "ipv6_mtu",
+	"filtering":      "filtering",
+	"state-file":     "state.file",
+	"workers":        "pipeline.workers",
+	"tun-queues":     "pipeline.tun_queues",
+	"dns64":          "dns64.enabled",
+	"dns64-upstream": "dns64.upstream",
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect and validate the bridge configuration",
+	Long: `Inspect the bridge configuration. Values are layered: defaults, then the
+configuration file (--config, YAML or JSON), then BRIDGE_* environment
+variables, then the flags of bridge start.`,
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the effective configuration and where each value came from",
+	Run: func(cmd *cobra.Command, args []string) {
+		loaded, err := config.Load(config.LoadOptions{})
+		if err != nil {
+			logger.Error("Failed to load configuration: %v", err)
+			os.Exit(1)
+		}
+
+		if configShowJSON {
+			printJSON(map[string]interface{}{
+				"file":     loaded.Path,
+				"settings": loaded.Settings,
+			})
+			return
+		}
+
+		if loaded.Path != "" {
+			fmt.Printf("Configuration file: %s\n\n", loaded.Path)
+		} else {
+			fmt.Printf("Configuration file: none (%s not found)\n\n", config.Path())
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+		for _, setting := range loaded.Settings {
+			source := setting.Source
+			if setting.Origin != "" {
+				source += " (" + setting.Origin + ")"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", setting.Key, valueOr(setting.Value, `""`), source)
+		}
+		w.Flush()
+	},
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [file...]",
+	Short: "Check configuration files for errors",
+	Long: `Check configuration files for unknown keys and invalid values, reporting
+each problem with its file, line and column. Without arguments the effective
+configuration is checked: --config and BRIDGE_* environment variables. Files
+given as arguments are checked on their own, without the environment.
+
+The exit status is 1 if any file is invalid.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var targets []config.LoadOptions
+		for _, path := range args {
+			targets = append(targets, config.LoadOptions{Path: path, Environ: []string{}})
+		}
+		if len(targets) == 0 {
+			targets = append(targets, config.LoadOptions{})
+		}
+
+		failed := false
+		for _, opts := range targets {
+			name := opts.Path
+			if name == "" {
+				name = config.Path()
+			}
+
+			_, err := config.Load(opts)
+			var invalid *config.ValidationError
+			switch {
+			case errors.As(err, &invalid):
+				logger.Error("%s: %d problem(s)", name, len(invalid.Problems))
+				for _, p := range invalid.Problems {
+					fmt.Printf("  %s\n", p)
+				}
+				failed = true
+			case err != nil:
+				logger.Error("%s: %v", name, err)
+				failed = true
+			default:
+				logger.Success("%s is valid", name)
+			}
+		}
+
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// changedConfigFlags returns the values of the configuration flags set on the
+// command line, by configuration key. --log-level sets log_level and the
+// logging.levels of the subsystems it names.
+func changedConfigFlags(cmd *cobra.Command, keys map[string]string) map[string]string {
+	values := make(map[string]string)
+	for name, key := range keys {
+		if flag := cmd.Flags().Lookup(name); flag != nil && flag.Changed {
+			values[key] = flag.Value.String()
+		}
+	}
+
+	if level, subsystems, err := logger.ParseLevelSpec(logLevel); err == nil {
+		if level != "" {
+			values["log_level"] = level
+		}
+		for subsystem, level := range subsystems {
+			values["logging.levels."+subsystem] = level
+		}
+	}
+	return values
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configValidateCmd)
+
+	configShowCmd.Flags().BoolVar(&configShowJSON, "json", false, "print the output as JSON")
+}
@@ -19,12 +19,12 @@ var initCmd = &cobra.Command{
 			return
 		}
 
-		logger.Success("Configuration file created: bridgeconfig.yaml")
+		logger.Success("Configuration file created: %s", config.Path())
 		logger.Info("Default settings:")
 		logger.Info("  NAT64 Prefix: 64:ff9b::/96")
 		logger.Info("  Gateway IP: 64:ff9b::1")
 		logger.Info("  API Port: 8080")
-		logger.Info("\nEdit bridgeconfig.yaml to customize settings")
+		logger.Info("\nEdit %s to customize settings", config.Path())
 	},
 }
 
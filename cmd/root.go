@@ -3,16 +3,32 @@ package cmd
 import (
 	"fmt"
 	"os"
+
+	"github.com/mdxabu/bridge/internal/config"
+	"github.com/mdxabu/bridge/internal/logger"
 	"github.com/spf13/cobra"
 )
 
 var cfgFile string
+var logLevel string
 
 var rootCmd = &cobra.Command{
 	Use:   "bridge",
 	Short: "A Stateful NAT64 Gateway",
 	Long: `bridge is a Stateful NAT64 gateway that enables communication
 between IPv6-only clients and IPv4-only servers.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		config.SetPath(cfgFile)
+
+		if logLevel != "" {
+			level, subsystems, err := parseLogLevelFlag(logLevel)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			logger.SetLevels(level, subsystems)
+		}
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		asciiart := `
 ██████╗ ██████╗ ██╗██████╗  ██████╗ ███████╗
@@ -34,6 +50,21 @@ func Execute() {
 	}
 }
 
+// parseLogLevelFlag parses the --log-level flag, e.g. "info,nat=debug"
+func parseLogLevelFlag(spec string) (logger.LogLevel, map[string]logger.LogLevel, error) {
+	name, names, err := logger.ParseLevelSpec(spec)
+	if err != nil {
+		return logger.InfoLevel, nil, fmt.Errorf("invalid --log-level: %w", err)
+	}
+	level, _ := logger.ParseLevel(name)
+	subsystems := make(map[string]logger.LogLevel)
+	for subsystem, name := range names {
+		subsystems[subsystem], _ = logger.ParseLevel(name)
+	}
+	return level, subsystems, nil
+}
+
 func init() {
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is ./config.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file, YAML or JSON (default is ./bridgeconfig.yaml)")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "log level, optionally per subsystem, e.g. info,nat=debug (subsystems: nat, tun, api, translator, dns64)")
 }
\ No newline at end of file
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mdxabu/bridge/internal/logger"
+	"github.com/mdxabu/bridge/internal/nat"
+	"github.com/spf13/cobra"
+)
+
+var (
+	sessionsAPIAddr  string
+	sessionsJSON     bool
+	sessionsProtocol string
+	sessionsSrc      string
+	sessionsDst      string
+	sessionsState    string
+	sessionsMinBytes uint64
+	sessionsSort     string
+	sessionsDesc     bool
+	sessionsLimit    int
+	sessionsOffset   int
+)
+
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "Inspect and manage NAT sessions of the running bridge",
+	Long:  `Inspect and manage the NAT sessions of a running bridge through its REST API.`,
+}
+
+var sessionsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List NAT sessions",
+	Long:  `List NAT sessions, optionally filtered, sorted and paginated.`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		query := url.Values{}
+		setQuery(query, "protocol", sessionsProtocol)
+		setQuery(query, "src", sessionsSrc)
+		setQuery(query, "dst", sessionsDst)
+		setQuery(query, "state", sessionsState)
+		setQuery(query, "sort", sessionsSort)
+		if sessionsMinBytes > 0 {
+			query.Set("min_bytes", strconv.FormatUint(sessionsMinBytes, 10))
+		}
+		if sessionsDesc {
+			query.Set("order", "desc")
+		}
+		if sessionsLimit > 0 {
+			query.Set("limit", strconv.Itoa(sessionsLimit))
+		}
+		if sessionsOffset > 0 {
+			query.Set("offset", strconv.Itoa(sessionsOffset))
+		}
+
+		list, err := newAPIClient(sessionsAPIAddr).Sessions(query)
+		if err != nil {
+			logger.Error("Failed to list sessions: %v", err)
+			os.Exit(1)
+		}
+
+		if sessionsJSON {
+			printJSON(list)
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tPROTO\tIPV6 SOURCE\tIPV4 MAPPING\tIPV4 DESTINATION\tSTATE\tBYTES\tIDLE")
+		for _, session := range list.Sessions {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+				session.ID,
+				protocolName(session.Protocol),
+				transportAddress(session.IPv6SrcIP, session.IPv6SrcPort),
+				transportAddress(session.IPv4SrcIP, session.IPv4SrcPort),
+				transportAddress(session.IPv4DstIP, session.IPv4DstPort),
+				session.State,
+				session.BytesSent+session.BytesReceived,
+				time.Since(session.LastActivity).Round(time.Second))
+		}
+		w.Flush()
+
+		fmt.Printf("\nShowing %d of %d sessions\n", list.Count, list.Total)
+	},
+}
+
+var sessionsShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Show a NAT session",
+	Long:  `Show all details of a single NAT session.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		session, err := newAPIClient(sessionsAPIAddr).Session(args[0])
+		if err != nil {
+			logger.Error("Failed to get session: %v", err)
+			os.Exit(1)
+		}
+
+		if sessionsJSON {
+			printJSON(session)
+			return
+		}
+
+		printSession(session)
+	},
+}
+
+var sessionsKillCmd = &cobra.Command{
+	Use:   "kill <id>",
+	Short: "Terminate a NAT session",
+	Long:  `Terminate a NAT session and release its IPv4 port.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := newAPIClient(sessionsAPIAddr).KillSession(args[0]); err != nil {
+			logger.Error("Failed to kill session: %v", err)
+			os.Exit(1)
+		}
+
+		logger.Success("Session %s terminated", args[0])
+	},
+}
+
+// printSession prints the details of a session
+func printSession(session *nat.SessionState) {
+	fmt.Println("Session details:")
+	fmt.Printf("- ID:               %s\n", session.ID)
+	fmt.Printf("- Protocol:         %s\n", protocolName(session.Protocol))
+	fmt.Printf("- State:            %s\n", session.State)
+	fmt.Printf("- IPv6 Source:      %s\n", transportAddress(session.IPv6SrcIP, session.IPv6SrcPort))
+	fmt.Printf("- IPv6 Destination: %s\n", transportAddress(session.IPv6DstIP, session.IPv6DstPort))
+	fmt.Printf("- IPv4 Mapping:     %s\n", transportAddress(session.IPv4SrcIP, session.IPv4SrcPort))
+	fmt.Printf("- IPv4 Destination: %s\n", transportAddress(session.IPv4DstIP, session.IPv4DstPort))
+	fmt.Printf("- Created:          %s\n", session.CreatedAt.Format(time.RFC3339))
+	fmt.Printf("- Last Activity:    %s (%s ago)\n", session.LastActivity.Format(time.RFC3339), time.Since(session.LastActivity).Round(time.Second))
+	fmt.Printf("- Sent:             %d packets, %s\n", session.PacketsSent, formatBytes(session.BytesSent))
+	fmt.Printf("- Received:         %d packets, %s\n", session.PacketsReceived, formatBytes(session.BytesReceived))
+}
+
+// setQuery sets a query parameter when the value is not empty
+func setQuery(query url.Values, key, value string) {
+	if value != "" {
+		query.Set(key, value)
+	}
+}
+
+// printJSON prints v as indented JSON
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		logger.Error("Failed to encode JSON: %v", err)
+	}
+}
+
+// protocolName returns the name of an IP protocol number
+func protocolName(protocol uint8) string {
+	switch protocol {
+	case 6:
+		return "TCP"
+	case 17:
+		return "UDP"
+	case 1, 58:
+		return "ICMP"
+	}
+	return strconv.Itoa(int(protocol))
+}
+
+// transportAddress formats an address and port, bracketing IPv6 addresses
+func transportAddress(ip net.IP, port uint16) string {
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+}
+
+func init() {
+	sessionsCmd.PersistentFlags().StringVar(&sessionsAPIAddr, "api", "", "address of the bridge API (default <api_listen>:<api_port>)")
+	sessionsCmd.PersistentFlags().BoolVar(&sessionsJSON, "json", false, "print the output as JSON")
+
+	sessionsListCmd.Flags().StringVarP(&sessionsProtocol, "protocol", "p", "", "only sessions of this protocol (tcp, udp or icmp)")
+	sessionsListCmd.Flags().StringVar(&sessionsSrc, "src", "", "only sessions from this IPv6 address or prefix")
+	sessionsListCmd.Flags().StringVar(&sessionsDst, "dst", "", "only sessions to this IPv4 address or prefix")
+	sessionsListCmd.Flags().StringVar(&sessionsState, "state", "", "only sessions in this state")
+	sessionsListCmd.Flags().Uint64Var(&sessionsMinBytes, "min-bytes", 0, "only sessions that moved at least this many bytes")
+	sessionsListCmd.Flags().StringVar(&sessionsSort, "sort", "", "sort by created, activity, bytes, packets or id")
+	sessionsListCmd.Flags().BoolVar(&sessionsDesc, "desc", false, "sort in descending order")
+	sessionsListCmd.Flags().IntVar(&sessionsLimit, "limit", 0, "maximum number of sessions to show")
+	sessionsListCmd.Flags().IntVar(&sessionsOffset, "offset", 0, "number of sessions to skip")
+
+	sessionsCmd.AddCommand(sessionsListCmd)
+	sessionsCmd.AddCommand(sessionsShowCmd)
+	sessionsCmd.AddCommand(sessionsKillCmd)
+	rootCmd.AddCommand(sessionsCmd)
+}
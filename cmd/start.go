@@ -5,7 +5,9 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/mdxabu/bridge/internal/api"
 	"github.com/mdxabu/bridge/internal/config"
+	"github.com/mdxabu/bridge/internal/dns64"
 	"github.com/mdxabu/bridge/internal/logger"
 	"github.com/mdxabu/bridge/internal/tun"
 	"github.com/spf13/cobra"
@@ -18,62 +20,206 @@ var startCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		logger.Info("Starting NAT64 Bridge...")
 
-		// Load configuration
-		cfg, err := config.ParseConfig()
+		// Load configuration, with the flags given on the command line on top
+		opts := config.LoadOptions{Flags: changedConfigFlags(cmd, startConfigFlags)}
+		loaded, err := config.Load(opts)
 		if err != nil {
 			logger.Error("Failed to parse configuration: %v", err)
 			return
 		}
+		if loaded.Path != "" {
+			logger.Info("Loaded configuration from %s", loaded.Path)
+		}
 
-		nat64Prefix := cfg.GetNAT64Prefix()
-		nat64Gateway := cfg.GetNAT64Gateway()
+		runBridge(loaded.Config, opts)
+	},
+}
 
-		// Create bridge
-		bridge, err := tun.NewBridge(nat64Prefix)
-		if err != nil {
-			logger.Error("Failed to create bridge: %v", err)
-			return
-		}
+// runBridge runs the bridge, its REST API and DNS64 resolver until
+// interrupted. SIGHUP reloads the configuration with opts.
+func runBridge(cfg *config.BridgeConfig, opts config.LoadOptions) {
+	logOpts, err := cfg.LoggerOptions()
+	if err == nil {
+		err = logger.Configure(logOpts)
+	}
+	if err != nil {
+		logger.Error("Failed to set up logging: %v", err)
+		return
+	}
+	defer logger.Close()
 
-		// Create TUN interfaces
-		logger.Info("Creating TUN interfaces...")
+	nat64Prefix := cfg.GetNAT64Prefix()
+	nat64Gateway := cfg.GetNAT64Gateway()
 
-		err = bridge.CreateTUNInterface("tun-ipv6", true)
-		if err != nil {
-			logger.Error("Failed to create IPv6 TUN interface: %v", err)
-			logger.Warn("Note: TUN interface creation requires root/admin privileges")
-			return
-		}
+	// Create bridge
+	bridge, err := tun.NewBridge(cfg)
+	if err != nil {
+		logger.Error("Failed to create bridge: %v", err)
+		return
+	}
 
-		err = bridge.CreateTUNInterface("tun-ipv4", false)
-		if err != nil {
-			logger.Error("Failed to create IPv4 TUN interface: %v", err)
-			return
+	// Create TUN interfaces
+	logger.Info("Creating TUN interfaces...")
+
+	err = bridge.CreateTUNInterface("tun-ipv6", true)
+	if err != nil {
+		logger.Error("Failed to create IPv6 TUN interface: %v", err)
+		logger.Warn("Note: TUN interface creation requires root/admin privileges")
+		return
+	}
+
+	err = bridge.CreateTUNInterface("tun-ipv4", false)
+	if err != nil {
+		logger.Error("Failed to create IPv4 TUN interface: %v", err)
+		return
+	}
+
+	// Start the bridge
+	err = bridge.Start()
+	if err != nil {
+		logger.Error("Failed to start bridge: %v", err)
+		return
+	}
+
+	// Start the REST API alongside the bridge
+	apiServer := api.NewServer(cfg.GetAPIAddress(), bridge)
+	apiServer.SetToken(cfg.GetAPIToken())
+	apiServer.SetReloader(func() (interface{}, error) {
+		return reloadConfig(bridge, opts)
+	})
+	go func() {
+		if err := apiServer.Start(); err != nil {
+			logger.Error("API server failed: %v", err)
 		}
+	}()
 
-		// Start the bridge
-		err = bridge.Start()
+	// Start the DNS64 resolver when enabled
+	var dnsServer *dns64.Server
+	if dnsCfg := cfg.GetDNS64(); dnsCfg.Enabled {
+		dnsServer, err = dns64.NewServer(dns64.Config{
+			Listen:   dnsCfg.Listen,
+			Upstream: dnsCfg.Upstream,
+			Prefix:   nat64Prefix,
+			Exclude:  dnsCfg.Exclude,
+			Timeout:  dnsCfg.Timeout,
+		})
 		if err != nil {
-			logger.Error("Failed to start bridge: %v", err)
+			logger.Error("Failed to create DNS64 server: %v", err)
+			apiServer.Stop()
+			bridge.Stop()
 			return
 		}
+		go func() {
+			if err := dnsServer.Start(); err != nil {
+				logger.Error("DNS64 server failed: %v", err)
+			}
+		}()
+	}
 
-		logger.Success("NAT64 Bridge is running")
+	logger.Success("NAT64 Bridge is running")
+	logger.Info("Mode: %s", cfg.GetMode())
+	switch cfg.GetMode() {
+	case config.ModeSIIT:
+		logger.Info("Pool6: %s", cfg.GetPool6())
+		for _, entry := range cfg.GetEAMT() {
+			logger.Info("EAMT: %s <-> %s", entry.IPv4, entry.IPv6)
+		}
+	case config.ModeCLAT:
+		logger.Info("PLAT Prefix: %s", cfg.GetCLAT().PLATPrefix)
+		logger.Info("CLAT Address: %s <-> %s", cfg.GetCLAT().IPv4, cfg.GetCLAT().IPv6)
+	default:
 		logger.Info("NAT64 Prefix: %s", nat64Prefix)
 		logger.Info("NAT64 Gateway IP: %s", nat64Gateway)
-		logger.Info("Press Ctrl+C to stop")
+		for _, entry := range cfg.GetPool4() {
+			logger.Info("IPv4 Pool: %s ports %s", entry.Prefix, entry.Ports)
+		}
+	}
+	logger.Info("REST API listening on %s", cfg.GetAPIAddress())
+	if dnsServer != nil {
+		logger.Info("DNS64 listening on %s, forwarding to %s", cfg.GetDNS64().Listen, cfg.GetDNS64().Upstream)
+	}
+	logger.Info("Press Ctrl+C to stop, send SIGHUP to reload the configuration")
 
-		// Wait for interrupt signal
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-		<-sigChan
+	// Wait for interrupt signal, reloading on SIGHUP
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		logger.Info("Received SIGHUP, reloading configuration...")
+		reloadConfig(bridge, opts)
+	}
 
-		logger.Info("Shutting down...")
-		bridge.Stop()
-		logger.Success("Bridge stopped successfully")
-	},
+	logger.Info("Shutting down...")
+	if dnsServer != nil {
+		if err := dnsServer.Stop(); err != nil {
+			logger.Error("Failed to stop DNS64 server: %v", err)
+		}
+	}
+	if err := apiServer.Stop(); err != nil {
+		logger.Error("Failed to stop API server: %v", err)
+	}
+	bridge.Stop()
+	logger.Success("Bridge stopped successfully")
+}
+
+// reloadConfig re-reads and validates the configuration and applies it to the
+// running bridge, logging the outcome. An invalid configuration changes
+// nothing.
+func reloadConfig(bridge *tun.Bridge, opts config.LoadOptions) (*tun.ReloadResult, error) {
+	loaded, err := config.Load(opts)
+	if err != nil {
+		logger.Error("Failed to reload configuration: %v", err)
+		return nil, err
+	}
+
+	result, err := bridge.Reload(loaded.Config)
+	if err != nil {
+		logger.Error("Failed to reload configuration: %v", err)
+		return nil, err
+	}
+
+	logReload(result)
+	return result, nil
+}
+
+// logReload logs the changes of a configuration reload
+func logReload(result *tun.ReloadResult) {
+	for _, key := range result.Applied {
+		logger.Info("Reloaded %s", key)
+	}
+	for key, reason := range result.Failed {
+		logger.Error("Failed to apply %s: %s", key, reason)
+	}
+	for _, key := range result.RestartRequired {
+		logger.Warn("%s changed but requires a restart to take effect", key)
+	}
+	for _, key := range result.NotApplicable {
+		logger.Warn("%s changed but is not used by the running bridge", key)
+	}
+	if len(result.Applied)+len(result.Failed)+len(result.RestartRequired)+len(result.NotApplicable) == 0 {
+		logger.Info("Configuration unchanged")
+	} else {
+		logger.Success("Configuration reloaded: %d applied, %d require a restart", len(result.Applied), len(result.RestartRequired))
+	}
 }
 
 func init() {
 	rootCmd.AddCommand(startCmd)
+
+	// Each flag overrides the configuration key it is mapped to in startConfigFlags
+	startCmd.Flags().String("mode", "", "translation mode: nat64, siit or clat")
+	startCmd.Flags().String("interface", "", "network interface to use")
+	startCmd.Flags().String("nat64-prefix", "", "NAT64 prefix (RFC 6052)")
+	startCmd.Flags().Int("api-port", 0, "REST API port")
+	startCmd.Flags().String("api-listen", "", "address the REST API listens on")
+	startCmd.Flags().Int("ipv4-mtu", 0, "MTU of the IPv4 side")
+	startCmd.Flags().Int("ipv6-mtu", 0, "MTU of the IPv6 side")
+	startCmd.Flags().String("filtering", "", "endpoint-independent, address-dependent or address-and-port-dependent")
+	startCmd.Flags().String("state-file", "", "file the NAT session table is saved to")
+	startCmd.Flags().Int("workers", 0, "number of translation workers")
+	startCmd.Flags().Int("tun-queues", 0, "queues per TUN device")
+	startCmd.Flags().Bool("dns64", false, "run the built-in DNS64 resolver")
+	startCmd.Flags().String("dns64-upstream", "", "resolver the DNS64 server forwards to")
 }
@@ -2,19 +2,172 @@ package cmd
 
 import (
 	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
 
+	"github.com/mdxabu/bridge/internal/api"
+	"github.com/mdxabu/bridge/internal/config"
+	"github.com/mdxabu/bridge/internal/logger"
 	"github.com/spf13/cobra"
 )
 
+var (
+	statusAPIAddr  string
+	statusJSON     bool
+	statusWatch    bool
+	statusInterval time.Duration
+)
+
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Check the status of the translation process",
-	Long: `Check the status of the translation process to see if it is running and functioning correctly.`,
+	Long:  `Check the status of the translation process to see if it is running and functioning correctly.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("status called")
+		client := newAPIClient(statusAPIAddr)
+
+		for {
+			status, err := client.Status()
+			if err == nil {
+				var stats map[string]interface{}
+				stats, err = client.Stats()
+				if err == nil {
+					if statusWatch && !statusJSON {
+						fmt.Print("\033[H\033[2J")
+					}
+					printStatus(status, stats)
+				}
+			}
+			if err != nil {
+				logger.Error("Failed to query bridge: %v", err)
+				if !statusWatch {
+					os.Exit(1)
+				}
+			}
+
+			if !statusWatch {
+				return
+			}
+			time.Sleep(statusInterval)
+		}
 	},
 }
 
+// newAPIClient returns a client for the bridge API at the address of the
+// flag or of the configuration, sending the configured api_token
+func newAPIClient(flag string) *api.Client {
+	cfg, err := config.ParseConfig()
+	if err != nil {
+		cfg = config.Defaults()
+	}
+
+	client := api.NewClient(apiAddress(flag, cfg))
+	client.SetToken(cfg.GetAPIToken())
+	return client
+}
+
+// apiAddress returns the address of the bridge API, taken from the flag or
+// the api_listen and api_port of the configuration
+func apiAddress(flag string, cfg *config.BridgeConfig) string {
+	if flag != "" {
+		return flag
+	}
+
+	host := cfg.APIListen
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(cfg.GetAPIPort()))
+}
+
+// printStatus prints the bridge status as a table or as JSON
+func printStatus(status, stats map[string]interface{}) {
+	if statusJSON {
+		printJSON(map[string]interface{}{
+			"status": status,
+			"stats":  stats,
+		})
+		return
+	}
+
+	state := fmt.Sprint(status["status"])
+	if uptime, ok := status["uptime"].(float64); ok && state == "running" {
+		state += fmt.Sprintf(" (up %s)", time.Duration(uptime*float64(time.Second)).Round(time.Second))
+	}
+
+	fmt.Println("Bridge status:")
+	fmt.Printf("- State:        %s\n", state)
+	fmt.Printf("- Mode:         %s\n", valueOr(status["mode"], "nat64"))
+	fmt.Printf("- IPv6 TUN:     %s\n", valueOr(status["ipv6_interface"], "-"))
+	fmt.Printf("- IPv4 TUN:     %s\n", valueOr(status["ipv4_interface"], "-"))
+	fmt.Printf("- NAT64 Prefix: %s\n", valueOr(status["nat64_prefix"], "-"))
+	if _, ok := status["plat_prefix"]; ok {
+		fmt.Printf("- PLAT Prefix:  %s\n", valueOr(status["plat_prefix"], "-"))
+		fmt.Printf("- CLAT Address: %s <-> %s\n", valueOr(status["clat_ipv4"], "-"), valueOr(status["clat_ipv6"], "-"))
+	}
+	if _, ok := status["eamt_entries"]; ok {
+		fmt.Printf("- Pool6:        %s\n", valueOr(status["pool6"], "-"))
+		fmt.Printf("- EAMT:         %d entries\n", count(status["eamt_entries"]))
+	}
+	fmt.Printf("- IPv4 Pool:    %s\n", valueOr(stats["pool4"], "-"))
+	fmt.Printf("- Sessions:     %d (TCP %d, UDP %d, ICMP %d)\n",
+		count(stats["total_sessions"]), count(stats["tcp_sessions"]), count(stats["udp_sessions"]), count(stats["icmp_sessions"]))
+
+	// Each protocol has its own port space of pool_size transport addresses
+	poolSize := count(stats["pool_size"])
+	fmt.Printf("- Pool Usage:   TCP %s, UDP %s, ICMP %s\n",
+		utilization(count(stats["tcp_bindings"]), poolSize),
+		utilization(count(stats["udp_bindings"]), poolSize),
+		utilization(count(stats["icmp_bindings"]), poolSize))
+
+	fmt.Printf("- IPv6->IPv4:   %d packets, %s\n", count(stats["ipv6_to_ipv4_packets"]), formatBytes(count(stats["ipv6_to_ipv4_bytes"])))
+	fmt.Printf("- IPv4->IPv6:   %d packets, %s\n", count(stats["ipv4_to_ipv6_packets"]), formatBytes(count(stats["ipv4_to_ipv6_bytes"])))
+}
+
+// valueOr returns v as a string, or fallback when it is missing
+func valueOr(v interface{}, fallback string) string {
+	if v == nil || v == "" {
+		return fallback
+	}
+	return fmt.Sprint(v)
+}
+
+// count converts a JSON number to an integer
+func count(v interface{}) uint64 {
+	if n, ok := v.(float64); ok && n > 0 {
+		return uint64(n)
+	}
+	return 0
+}
+
+// utilization formats used out of total with a percentage
+func utilization(used, total uint64) string {
+	if total == 0 {
+		return fmt.Sprintf("%d", used)
+	}
+	return fmt.Sprintf("%d/%d (%.1f%%)", used, total, float64(used)*100/float64(total))
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 func init() {
+	statusCmd.Flags().StringVar(&statusAPIAddr, "api", "", "address of the bridge API (default <api_listen>:<api_port>)")
+	statusCmd.Flags().BoolVar(&statusJSON, "json", false, "print the status as JSON")
+	statusCmd.Flags().BoolVarP(&statusWatch, "watch", "w", false, "refresh the status until interrupted")
+	statusCmd.Flags().DurationVar(&statusInterval, "interval", 2*time.Second, "refresh interval for --watch")
 	rootCmd.AddCommand(statusCmd)
 }
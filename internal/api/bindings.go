@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/mdxabu/bridge/internal/nat"
+)
+
+// bindingRequest is the body of POST /api/bindings
+type bindingRequest struct {
+	Protocol string `json:"protocol"`
+	IPv6     string `json:"ipv6"`
+	IPv6Port uint16 `json:"ipv6_port"`
+	IPv4     string `json:"ipv4"`
+	IPv4Port uint16 `json:"ipv4_port"`
+}
+
+// handleBindings returns the static bindings
+func (s *Server) handleBindings(w http.ResponseWriter, r *http.Request) {
+	if s.bridge == nil {
+		http.Error(w, "Bridge not initialized", http.StatusServiceUnavailable)
+		return
+	}
+
+	bindings := s.bridge.GetStaticBindings()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"bindings": bindings,
+		"count":    len(bindings),
+	})
+}
+
+// handleAddBinding adds a static binding
+func (s *Server) handleAddBinding(w http.ResponseWriter, r *http.Request) {
+	if s.bridge == nil {
+		http.Error(w, "Bridge not initialized", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req bindingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid binding: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	protocol, err := nat.ParseProtocol(req.Protocol)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ipv6IP := net.ParseIP(req.IPv6)
+	if ipv6IP == nil {
+		http.Error(w, fmt.Sprintf("invalid IPv6 address %q", req.IPv6), http.StatusBadRequest)
+		return
+	}
+	ipv4IP := net.ParseIP(req.IPv4)
+	if ipv4IP == nil {
+		http.Error(w, fmt.Sprintf("invalid IPv4 address %q", req.IPv4), http.StatusBadRequest)
+		return
+	}
+
+	binding, err := s.bridge.AddStaticBinding(protocol, ipv6IP, req.IPv6Port, ipv4IP, req.IPv4Port)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(binding)
+}
+
+// handleDeleteBinding removes a static binding, addressed by protocol and
+// IPv4 transport address, e.g. DELETE /api/bindings/tcp/192.0.2.1:8080
+func (s *Server) handleDeleteBinding(w http.ResponseWriter, r *http.Request) {
+	if s.bridge == nil {
+		http.Error(w, "Bridge not initialized", http.StatusServiceUnavailable)
+		return
+	}
+
+	protocol, err := nat.ParseProtocol(r.PathValue("protocol"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	host, portStr, err := net.SplitHostPort(r.PathValue("address"))
+	ip := net.ParseIP(host)
+	port, portErr := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || ip == nil || portErr != nil {
+		http.Error(w, fmt.Sprintf("invalid IPv4 transport address %q", r.PathValue("address")), http.StatusBadRequest)
+		return
+	}
+
+	if !s.bridge.RemoveStaticBinding(protocol, ip, uint16(port)) {
+		http.Error(w, "Binding not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/mdxabu/bridge/internal/capture"
+)
+
+// CaptureRequest is the body of POST /api/capture
+type CaptureRequest struct {
+	File     string         `json:"file"` // Name of the file in the capture directory
+	Filter   capture.Filter `json:"filter"`
+	Stages   []string       `json:"stages,omitempty"`   // Capture points, all of them when empty
+	MaxSize  int64          `json:"max_size,omitempty"` // Bytes, capture.max_size when 0
+	Duration string         `json:"duration,omitempty"` // e.g. "30s", no limit when empty
+}
+
+// handleCapture returns the progress of the current or last capture
+func (s *Server) handleCapture(w http.ResponseWriter, r *http.Request) {
+	if s.bridge == nil {
+		http.Error(w, "Bridge not initialized", http.StatusServiceUnavailable)
+		return
+	}
+
+	status, ok := s.bridge.CaptureStatus()
+	if !ok {
+		http.Error(w, "No capture", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleStartCapture starts a packet capture
+func (s *Server) handleStartCapture(w http.ResponseWriter, r *http.Request) {
+	if s.bridge == nil {
+		http.Error(w, "Bridge not initialized", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req CaptureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid capture: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	opts := capture.Options{
+		File:    req.File,
+		Filter:  req.Filter,
+		Stages:  req.Stages,
+		MaxSize: req.MaxSize,
+	}
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid duration %q", req.Duration), http.StatusBadRequest)
+			return
+		}
+		opts.Duration = d
+	}
+
+	status, err := s.bridge.StartCapture(opts)
+	switch {
+	case errors.Is(err, capture.ErrRunning), errors.Is(err, fs.ErrExist):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleStopCapture stops the running capture and returns its final status
+func (s *Server) handleStopCapture(w http.ResponseWriter, r *http.Request) {
+	if s.bridge == nil {
+		http.Error(w, "Bridge not initialized", http.StatusServiceUnavailable)
+		return
+	}
+
+	status, err := s.bridge.StopCapture()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mdxabu/bridge/internal/capture"
+	"github.com/mdxabu/bridge/internal/nat"
+)
+
+// Client queries the REST API of a running bridge
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// NewClient creates a client for the API at addr, e.g. "localhost:8080" or
+// "http://localhost:8080"
+func NewClient(addr string) *Client {
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		addr = "http://" + addr
+	}
+
+	return &Client{
+		baseURL: strings.TrimRight(addr, "/"),
+		http:    &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// SetToken sends token as a bearer token, as the API requires for changes
+// when api_token is set
+func (c *Client) SetToken(token string) {
+	c.token = token
+}
+
+// Status returns the response of /api/status
+func (c *Client) Status() (map[string]interface{}, error) {
+	var status map[string]interface{}
+	if err := c.get("/api/status", &status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// Stats returns the response of /api/stats
+func (c *Client) Stats() (map[string]interface{}, error) {
+	var stats map[string]interface{}
+	if err := c.get("/api/stats", &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Sessions returns the sessions matching the /api/sessions query parameters
+func (c *Client) Sessions(query url.Values) (*SessionList, error) {
+	var list SessionList
+	if err := c.get("/api/sessions?"+query.Encode(), &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// Session returns a single session by ID
+func (c *Client) Session(id string) (*nat.SessionState, error) {
+	var session nat.SessionState
+	if err := c.get("/api/sessions/"+url.PathEscape(id), &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// KillSession terminates a session by ID
+func (c *Client) KillSession(id string) error {
+	return c.do(http.MethodDelete, "/api/sessions/"+url.PathEscape(id), nil, nil)
+}
+
+// CaptureStatus returns the progress of the current or last packet capture
+func (c *Client) CaptureStatus() (*capture.Status, error) {
+	var status capture.Status
+	if err := c.get("/api/capture", &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// StartCapture starts a packet capture
+func (c *Client) StartCapture(req CaptureRequest) (*capture.Status, error) {
+	var status capture.Status
+	if err := c.do(http.MethodPost, "/api/capture", req, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// StopCapture stops the running packet capture
+func (c *Client) StopCapture() (*capture.Status, error) {
+	var status capture.Status
+	if err := c.do(http.MethodDelete, "/api/capture", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// get fetches path and decodes the JSON response into v
+func (c *Client) get(path string, v interface{}) error {
+	return c.do(http.MethodGet, path, nil, v)
+}
+
+// do sends a request, with body encoded as JSON if not nil, and decodes the
+// JSON response into v, if not nil
+func (c *Client) do(method, path string, body, v interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("bridge API unreachable at %s: %w", c.baseURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		if msg := strings.TrimSpace(string(body)); msg != "" {
+			return fmt.Errorf("bridge API returned %s: %s", resp.Status, msg)
+		}
+		return fmt.Errorf("bridge API returned %s for %s", resp.Status, path)
+	}
+
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", path, err)
+	}
+	return nil
+}
@@ -1,83 +1,139 @@
 package api
+
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"io"
+	"net"
 	"net/http"
-	"sync"
+	"strings"
 	"time"
 
+	"github.com/mdxabu/bridge/internal/capture"
+	"github.com/mdxabu/bridge/internal/config"
+	"github.com/mdxabu/bridge/internal/logger"
 	"github.com/mdxabu/bridge/internal/nat"
 )
 
+var apiLog = logger.For(logger.API)
+
 // Server represents the API server
 type Server struct {
-	bridge      BridgeInterface
-	addr        string
-	server      *http.Server
-	mu          sync.RWMutex
-	startTime   time.Time
-	isRunning   bool
+	bridge    BridgeInterface
+	addr      string
+	server    *http.Server
+	startTime time.Time
+	reload    ReloadFunc
+	token     string
 }
 
+// ReloadFunc re-reads the configuration and applies it to the running bridge.
+// The result, a summary of the changes, is returned as JSON.
+type ReloadFunc func() (interface{}, error)
+
+// shutdownTimeout bounds how long Stop waits for in-flight requests
+const shutdownTimeout = 5 * time.Second
+
 // BridgeInterface defines the interface for bridge operations
 type BridgeInterface interface {
 	GetStats() map[string]interface{}
 	GetActiveSessions() []*nat.SessionState
+	GetSession(id string) (*nat.SessionState, bool)
+	RemoveSession(id string) bool
+	GetStaticBindings() []*nat.BindingEntry
+	AddStaticBinding(protocol uint8, ipv6IP net.IP, ipv6Port uint16, ipv4IP net.IP, ipv4Port uint16) (*nat.BindingEntry, error)
+	RemoveStaticBinding(protocol uint8, ipv4IP net.IP, ipv4Port uint16) bool
+	GetStatus() map[string]interface{}
+	IsRunning() bool
+	WriteMetrics(w io.Writer) error
+	StartCapture(opts capture.Options) (*capture.Status, error)
+	StopCapture() (*capture.Status, error)
+	CaptureStatus() (*capture.Status, bool)
 }
 
 // NewServer creates a new API server
 func NewServer(addr string, bridge BridgeInterface) *Server {
-	return &Server{
+	s := &Server{
 		bridge:    bridge,
 		addr:      addr,
 		startTime: time.Now(),
 	}
+	s.server = &http.Server{
+		Addr:    addr,
+		Handler: logRequests(s.enableCORS(s.authorize(s.routes()))),
+	}
+	return s
 }
 
-// Start starts the API server
-func (s *Server) Start() error {
-	s.mu.Lock()
-	s.isRunning = true
-	s.mu.Unlock()
-
+// routes registers the endpoints of the API
+func (s *Server) routes() *http.ServeMux {
 	mux := http.NewServeMux()
-	
-	// Register endpoints
+
 	mux.HandleFunc("/api/status", s.handleStatus)
 	mux.HandleFunc("/api/stats", s.handleStats)
 	mux.HandleFunc("/api/sessions", s.handleSessions)
+	mux.HandleFunc("GET /api/sessions/{id}", s.handleSession)
+	mux.HandleFunc("DELETE /api/sessions/{id}", s.handleDeleteSession)
+	mux.HandleFunc("GET /api/bindings", s.handleBindings)
+	mux.HandleFunc("POST /api/bindings", s.handleAddBinding)
+	mux.HandleFunc("DELETE /api/bindings/{protocol}/{address}", s.handleDeleteBinding)
+	mux.HandleFunc("POST /api/reload", s.handleReload)
+	mux.HandleFunc("GET /api/capture", s.handleCapture)
+	mux.HandleFunc("POST /api/capture", s.handleStartCapture)
+	mux.HandleFunc("DELETE /api/capture", s.handleStopCapture)
 	mux.HandleFunc("/api/health", s.handleHealth)
+	mux.HandleFunc("/metrics", s.handleMetrics)
 
-	s.server = &http.Server{
-		Addr:    s.addr,
-		Handler: s.enableCORS(mux),
-	}
+	return mux
+}
 
-	return s.server.ListenAndServe()
+// SetReloader enables POST /api/reload
+func (s *Server) SetReloader(reload ReloadFunc) {
+	s.reload = reload
 }
 
-// Stop stops the API server
-func (s *Server) Stop() error {
-	s.mu.Lock()
-	s.isRunning = false
-	s.mu.Unlock()
+// SetToken requires token as a bearer token on requests that change the
+// bridge. Without a token they are only accepted from the local host.
+func (s *Server) SetToken(token string) {
+	s.token = token
+}
 
-	if s.server != nil {
-		return s.server.Close()
+// Start starts the API server and blocks until it is stopped. It returns nil
+// after a call to Stop, including one made before Start.
+func (s *Server) Start() error {
+	err := s.server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
 	}
-	return nil
+	return err
+}
+
+// Stop gracefully stops the API server, waiting for in-flight requests
+func (s *Server) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	return s.server.Shutdown(ctx)
 }
 
 // handleStatus returns the bridge status
 func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
 	status := map[string]interface{}{
 		"status":     s.getStatusString(),
 		"uptime":     time.Since(s.startTime).Seconds(),
 		"start_time": s.startTime.Format(time.RFC3339),
 	}
 
+	// The bridge knows how long it has been forwarding and on which interfaces
+	if s.bridge != nil {
+		for key, value := range s.bridge.GetStatus() {
+			status[key] = value
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(status)
 }
 
@@ -90,48 +146,72 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 
 	stats := s.bridge.GetStats()
 	stats["uptime"] = time.Since(s.startTime).Seconds()
-	
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(stats)
 }
 
-// handleSessions returns active NAT sessions
-func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
-	if s.bridge == nil {
-		http.Error(w, "Bridge not initialized", http.StatusServiceUnavailable)
+// handleReload reloads the configuration. Invalid configurations are
+// rejected with the problems found and leave the bridge unchanged.
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if s.reload == nil {
+		http.Error(w, "Reload not supported", http.StatusNotImplemented)
+		return
+	}
+
+	result, err := s.reload()
+	var invalid *config.ValidationError
+	if errors.As(err, &invalid) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	sessions := s.bridge.GetActiveSessions()
-	
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"sessions": sessions,
-		"count":    len(sessions),
-	})
+	json.NewEncoder(w).Encode(result)
 }
 
 // handleHealth returns health status
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	running := s.bridge != nil && s.bridge.IsRunning()
 
 	health := map[string]interface{}{
 		"status":  "healthy",
-		"running": s.isRunning,
+		"running": running,
 		"uptime":  time.Since(s.startTime).Seconds(),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	if !running {
+		health["status"] = "unhealthy"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
 	json.NewEncoder(w).Encode(health)
 }
 
-// enableCORS enables CORS for the API
+// handleMetrics returns bridge metrics in the Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if s.bridge == nil {
+		http.Error(w, "Bridge not initialized", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.bridge.WriteMetrics(w)
+}
+
+// enableCORS lets web pages on any origin read the API. Requests that change
+// the bridge get no CORS headers, so browsers do not let pages send them.
 func (s *Server) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		if readOnly(r) {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		}
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -142,9 +222,83 @@ func (s *Server) enableCORS(next http.Handler) http.Handler {
 	})
 }
 
+// authorize guards the requests that change the bridge. With a token they
+// must carry it. Without one they must come from the local host and not from
+// a browser, where any page could send a simple POST to a local port.
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if readOnly(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if s.token != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="bridge"`)
+				http.Error(w, "Invalid or missing API token", http.StatusUnauthorized)
+				return
+			}
+		} else if !loopbackClient(r) || r.Header.Get("Origin") != "" {
+			http.Error(w, "Set api_token to change the bridge from other hosts or browsers", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readOnly reports whether a request only reads from the API
+func readOnly(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+}
+
+// loopbackClient reports whether a request comes from the local host
+func loopbackClient(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// logRequests logs each request at debug level, and failed ones at warn level
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		log := apiLog.With(
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"remote", r.RemoteAddr,
+			"duration", time.Since(start),
+		)
+		if rec.status >= http.StatusInternalServerError {
+			log.Warn("Request failed")
+		} else {
+			log.Debug("Request handled")
+		}
+	})
+}
+
 // getStatusString returns a human-readable status string
 func (s *Server) getStatusString() string {
-	if s.isRunning {
+	if s.bridge != nil && s.bridge.IsRunning() {
 		return "running"
 	}
 	return "stopped"
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORSOnlyOnReadRequests(t *testing.T) {
+	s := &Server{}
+	handler := s.enableCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		method string
+		cors   bool
+		status int
+	}{
+		{http.MethodGet, true, http.StatusNoContent},
+		{http.MethodOptions, true, http.StatusOK},
+		{http.MethodPost, false, http.StatusNoContent},
+		{http.MethodDelete, false, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(tt.method, "/api/sessions", nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.method, w.Code, tt.status)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin") == "*"; got != tt.cors {
+			t.Errorf("%s: CORS header sent = %v, want %v", tt.method, got, tt.cors)
+		}
+		if methods := w.Header().Get("Access-Control-Allow-Methods"); tt.cors && methods != "GET, OPTIONS" {
+			t.Errorf("%s: allowed methods %q", tt.method, methods)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		remote string
+		origin string
+		auth   string
+		status int
+	}{
+		{"read from anywhere", "", http.MethodGet, "192.0.2.1:40000", "", "", http.StatusNoContent},
+		{"change from local host", "", http.MethodPost, "127.0.0.1:40000", "", "", http.StatusNoContent},
+		{"change from local IPv6 host", "", http.MethodDelete, "[::1]:40000", "", "", http.StatusNoContent},
+		{"change from other host", "", http.MethodDelete, "192.0.2.1:40000", "", "", http.StatusForbidden},
+		{"change from local browser", "", http.MethodPost, "127.0.0.1:40000", "http://example.com", "", http.StatusForbidden},
+		{"token not configured", "", http.MethodPost, "192.0.2.1:40000", "", "Bearer s3cret", http.StatusForbidden},
+		{"read without token", "s3cret", http.MethodGet, "192.0.2.1:40000", "", "", http.StatusNoContent},
+		{"change with token", "s3cret", http.MethodDelete, "192.0.2.1:40000", "", "Bearer s3cret", http.StatusNoContent},
+		{"change with wrong token", "s3cret", http.MethodPost, "192.0.2.1:40000", "", "Bearer guess", http.StatusUnauthorized},
+		{"change without token", "s3cret", http.MethodPost, "127.0.0.1:40000", "", "", http.StatusUnauthorized},
+		{"token without bearer", "s3cret", http.MethodPost, "127.0.0.1:40000", "", "s3cret", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{}
+			s.SetToken(tt.token)
+			handler := s.authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}))
+
+			r := httptest.NewRequest(tt.method, "/api/reload", nil)
+			r.RemoteAddr = tt.remote
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d", w.Code, tt.status)
+			}
+			if tt.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Fatal("no WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+// startServer runs Start in the background and returns its result channel
+func startServer(s *Server) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- s.Start() }()
+	return done
+}
+
+// waitStart waits for Start to return and checks it returned nil
+func waitStart(t *testing.T, done <-chan error) {
+	t.Helper()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Start = %v, want nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Start still serving after Stop")
+	}
+}
+
+func TestStopBeforeStart(t *testing.T) {
+	s := NewServer("127.0.0.1:0", nil)
+	if err := s.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	waitStart(t, startServer(s))
+}
+
+func TestStopAfterStart(t *testing.T) {
+	s := NewServer("127.0.0.1:0", nil)
+	done := startServer(s)
+
+	// Let Start reach ListenAndServe before stopping it
+	time.Sleep(50 * time.Millisecond)
+	if err := s.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	waitStart(t, done)
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mdxabu/bridge/internal/nat"
+)
+
+// SessionList is the response of /api/sessions
+type SessionList struct {
+	Sessions []*nat.SessionState `json:"sessions"`
+	Count    int                 `json:"count"` // Sessions in this page
+	Total    int                 `json:"total"` // Sessions matching the filters
+	Offset   int                 `json:"offset"`
+}
+
+// sessionQuery holds the filters, sorting and pagination of /api/sessions
+type sessionQuery struct {
+	protocol uint8
+	src      *net.IPNet // IPv6 source prefix
+	dst      *net.IPNet // IPv4 destination prefix
+	state    string
+	minBytes uint64
+	sortBy   string
+	desc     bool
+	limit    int
+	offset   int
+}
+
+// parseSessionQuery reads the query parameters of /api/sessions
+func parseSessionQuery(values url.Values) (*sessionQuery, error) {
+	q := &sessionQuery{sortBy: "created"}
+
+	if v := values.Get("protocol"); v != "" {
+		protocol, err := nat.ParseProtocol(v)
+		if err != nil {
+			return nil, err
+		}
+		q.protocol = protocol
+	}
+
+	if v := values.Get("src"); v != "" {
+		src, err := parsePrefix(v)
+		if err != nil || src.IP.To4() != nil {
+			return nil, fmt.Errorf("invalid IPv6 source prefix %q", v)
+		}
+		q.src = src
+	}
+
+	if v := values.Get("dst"); v != "" {
+		dst, err := parsePrefix(v)
+		if err != nil || dst.IP.To4() == nil {
+			return nil, fmt.Errorf("invalid IPv4 destination %q", v)
+		}
+		q.dst = dst
+	}
+
+	q.state = values.Get("state")
+
+	if v := values.Get("min_bytes"); v != "" {
+		minBytes, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid min_bytes %q", v)
+		}
+		q.minBytes = minBytes
+	}
+
+	if v := values.Get("sort"); v != "" {
+		switch v {
+		case "created", "activity", "bytes", "packets", "id":
+			q.sortBy = v
+		default:
+			return nil, fmt.Errorf("invalid sort field %q (created, activity, bytes, packets or id)", v)
+		}
+	}
+
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		q.desc = true
+	default:
+		return nil, fmt.Errorf("invalid order %q (asc or desc)", values.Get("order"))
+	}
+
+	var err error
+	if q.limit, err = parseCount(values, "limit"); err != nil {
+		return nil, err
+	}
+	if q.offset, err = parseCount(values, "offset"); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// parsePrefix accepts an address or CIDR prefix
+func parsePrefix(v string) (*net.IPNet, error) {
+	if !strings.Contains(v, "/") {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q", v)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+
+	_, prefix, err := net.ParseCIDR(v)
+	return prefix, err
+}
+
+// parseCount reads a non-negative integer parameter
+func parseCount(values url.Values, name string) (int, error) {
+	v := values.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return n, nil
+}
+
+// matches reports whether a session passes the filters
+func (q *sessionQuery) matches(session *nat.SessionState) bool {
+	if q.protocol != 0 && session.Protocol != q.protocol {
+		return false
+	}
+	if q.src != nil && !q.src.Contains(session.IPv6SrcIP) {
+		return false
+	}
+	if q.dst != nil && !q.dst.Contains(session.IPv4DstIP) {
+		return false
+	}
+	if q.state != "" && !strings.EqualFold(session.State, q.state) {
+		return false
+	}
+	if session.BytesSent+session.BytesReceived < q.minBytes {
+		return false
+	}
+	return true
+}
+
+// less orders two sessions by the sort field, falling back to the ID so that
+// pages are stable
+func (q *sessionQuery) less(a, b *nat.SessionState) bool {
+	switch q.sortBy {
+	case "created":
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+	case "activity":
+		if !a.LastActivity.Equal(b.LastActivity) {
+			return a.LastActivity.Before(b.LastActivity)
+		}
+	case "bytes":
+		if x, y := a.BytesSent+a.BytesReceived, b.BytesSent+b.BytesReceived; x != y {
+			return x < y
+		}
+	case "packets":
+		if x, y := a.PacketsSent+a.PacketsReceived, b.PacketsSent+b.PacketsReceived; x != y {
+			return x < y
+		}
+	}
+	return a.ID < b.ID
+}
+
+// apply filters, sorts and paginates sessions
+func (q *sessionQuery) apply(sessions []*nat.SessionState) *SessionList {
+	matched := make([]*nat.SessionState, 0, len(sessions))
+	for _, session := range sessions {
+		if q.matches(session) {
+			matched = append(matched, session)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		if q.desc {
+			return q.less(matched[j], matched[i])
+		}
+		return q.less(matched[i], matched[j])
+	})
+
+	list := &SessionList{Total: len(matched), Offset: q.offset}
+
+	page := matched[min(q.offset, len(matched)):]
+	if q.limit > 0 && q.limit < len(page) {
+		page = page[:q.limit]
+	}
+	list.Sessions = page
+	list.Count = len(page)
+
+	return list
+}
+
+// handleSessions returns active NAT sessions matching the query filters
+func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if s.bridge == nil {
+		http.Error(w, "Bridge not initialized", http.StatusServiceUnavailable)
+		return
+	}
+
+	q, err := parseSessionQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	list := q.apply(s.bridge.GetActiveSessions())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// handleSession returns a single NAT session
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	if s.bridge == nil {
+		http.Error(w, "Bridge not initialized", http.StatusServiceUnavailable)
+		return
+	}
+
+	session, found := s.bridge.GetSession(r.PathValue("id"))
+	if !found {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session)
+}
+
+// handleDeleteSession terminates a NAT session and releases its port
+func (s *Server) handleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	if s.bridge == nil {
+		http.Error(w, "Bridge not initialized", http.StatusServiceUnavailable)
+		return
+	}
+
+	if !s.bridge.RemoveSession(r.PathValue("id")) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,301 @@
+package capture
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Capture points of the bridge. Each is written as its own pcapng interface.
+const (
+	StageIPv6In  = "ipv6-in"  // Received on tun-ipv6, before translation
+	StageIPv4Out = "ipv4-out" // Translated or generated, written to tun-ipv4
+	StageIPv4In  = "ipv4-in"  // Received on tun-ipv4, before translation
+	StageIPv6Out = "ipv6-out" // Translated or generated, written to tun-ipv6
+)
+
+// Stages lists every capture point
+var Stages = []string{StageIPv6In, StageIPv4Out, StageIPv4In, StageIPv6Out}
+
+// stageDescriptions are the interface descriptions written for each stage
+var stageDescriptions = map[string]string{
+	StageIPv6In:  "tun-ipv6, received from IPv6 hosts",
+	StageIPv4Out: "tun-ipv4, sent to IPv4 hosts",
+	StageIPv4In:  "tun-ipv4, received from IPv4 hosts",
+	StageIPv6Out: "tun-ipv6, sent to IPv6 hosts",
+}
+
+// Reasons a capture stopped
+const (
+	ReasonStopped  = "stopped"
+	ReasonMaxSize  = "size limit reached"
+	ReasonDuration = "duration elapsed"
+	ReasonError    = "write error"
+)
+
+var (
+	// ErrRunning is returned when starting a capture while another runs
+	ErrRunning = errors.New("a capture is already running")
+	// ErrNotRunning is returned when there is no capture to stop
+	ErrNotRunning = errors.New("no capture is running")
+)
+
+// flushInterval is how often buffered packets are written to the file, so
+// that it can be followed while the capture runs
+const flushInterval = time.Second
+
+// packetOverhead bounds the bytes a packet block adds to the packet: block
+// header and trailer, fixed fields, padding and the flags option
+const packetOverhead = 48
+
+// Options configure a capture
+type Options struct {
+	File     string        // pcapng file, which must not exist yet
+	Filter   Filter        // Packets to keep
+	Stages   []string      // Capture points, all of them when empty
+	MaxSize  int64         // Bytes after which the capture stops
+	Duration time.Duration // Time after which the capture stops, 0 for none
+}
+
+// Status describes a running or finished capture
+type Status struct {
+	Running  bool       `json:"running"`
+	File     string     `json:"file"`
+	Stages   []string   `json:"stages"`
+	Filter   Filter     `json:"filter"`
+	Packets  uint64     `json:"packets"`
+	Bytes    int64      `json:"bytes"`
+	MaxSize  int64      `json:"max_size"`
+	Duration string     `json:"duration,omitempty"`
+	Started  time.Time  `json:"started"`
+	Stopped  *time.Time `json:"stopped,omitempty"`
+	Reason   string     `json:"reason,omitempty"` // Why the capture stopped
+	Error    string     `json:"error,omitempty"`
+}
+
+// Capture writes the packets passing its filter to a pcapng file until it is
+// stopped or reaches its size or duration limit
+type Capture struct {
+	opts    Options
+	match   *matcher
+	ifaces  map[string]uint32 // Interface ID of each stage
+	running atomic.Bool
+
+	mu      sync.Mutex
+	file    *os.File
+	buf     *bufio.Writer
+	size    *counter // Bytes written to buf
+	w       *Writer
+	packets uint64
+	started time.Time
+	stopped time.Time
+	reason  string
+	err     error
+	done    chan struct{}
+}
+
+// Start creates the capture file and starts capturing
+func Start(opts Options) (*Capture, error) {
+	match, err := opts.Filter.compile()
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Stages) == 0 {
+		opts.Stages = Stages
+	}
+	for _, stage := range opts.Stages {
+		if !slices.Contains(Stages, stage) {
+			return nil, fmt.Errorf("invalid stage %q (ipv6-in, ipv4-out, ipv4-in or ipv6-out)", stage)
+		}
+	}
+	if opts.MaxSize <= 0 {
+		return nil, fmt.Errorf("invalid size limit %d", opts.MaxSize)
+	}
+	if opts.Duration < 0 {
+		return nil, fmt.Errorf("invalid duration %s", opts.Duration)
+	}
+
+	file, err := os.OpenFile(opts.File, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create capture file: %w", err)
+	}
+
+	c := &Capture{
+		opts:    opts,
+		match:   match,
+		ifaces:  make(map[string]uint32),
+		file:    file,
+		buf:     bufio.NewWriter(file),
+		started: time.Now(),
+		done:    make(chan struct{}),
+	}
+	c.size = &counter{w: c.buf}
+
+	c.w, err = NewWriter(c.size, "bridge")
+	if err == nil {
+		for _, stage := range opts.Stages {
+			if c.ifaces[stage], err = c.w.AddInterface(stage, stageDescriptions[stage], LinkTypeRaw); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(opts.File)
+		return nil, fmt.Errorf("failed to write capture file: %w", err)
+	}
+
+	c.running.Store(true)
+	go c.run()
+	return c, nil
+}
+
+// counter counts the bytes written to w
+type counter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// run flushes the file periodically and stops the capture when its duration
+// has elapsed
+func (c *Capture) run() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var deadline <-chan time.Time
+	if c.opts.Duration > 0 {
+		timer := time.NewTimer(c.opts.Duration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+			if c.running.Load() {
+				if err := c.buf.Flush(); err != nil {
+					c.stopLocked(ReasonError, err)
+				}
+			}
+			c.mu.Unlock()
+		case <-deadline:
+			c.stop(ReasonDuration)
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// Packet records a packet seen at a stage, if the stage is captured and the
+// packet passes the filter. It is safe to call from many goroutines.
+func (c *Capture) Packet(stage string, data []byte) {
+	if !c.running.Load() {
+		return
+	}
+	iface, ok := c.ifaces[stage]
+	if !ok || !c.match.match(data) {
+		return
+	}
+	inbound := stage == StageIPv6In || stage == StageIPv4In
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.running.Load() {
+		return
+	}
+
+	if c.size.n+int64(len(data))+packetOverhead > c.opts.MaxSize {
+		c.stopLocked(ReasonMaxSize, nil)
+		return
+	}
+	if _, err := c.w.WritePacket(iface, now, data, inbound); err != nil {
+		c.stopLocked(ReasonError, err)
+		return
+	}
+	c.packets++
+}
+
+// Running reports whether the capture is still writing packets
+func (c *Capture) Running() bool {
+	return c.running.Load()
+}
+
+// Stop ends the capture and closes its file. Stopping a finished capture
+// does nothing.
+func (c *Capture) Stop() Status {
+	c.stop(ReasonStopped)
+	return c.Status()
+}
+
+// stop ends the capture for a reason
+func (c *Capture) stop(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopLocked(reason, nil)
+}
+
+// stopLocked ends the capture with c.mu held
+func (c *Capture) stopLocked(reason string, err error) {
+	if !c.running.Load() {
+		return
+	}
+	c.running.Store(false)
+	close(c.done)
+
+	if flushErr := c.buf.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := c.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil && reason != ReasonError {
+		reason = ReasonError
+	}
+	c.stopped = time.Now()
+	c.reason = reason
+	c.err = err
+}
+
+// Status returns the progress of the capture
+func (c *Capture) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := Status{
+		Running: c.running.Load(),
+		File:    c.opts.File,
+		Stages:  c.opts.Stages,
+		Filter:  c.opts.Filter,
+		Packets: c.packets,
+		Bytes:   c.size.n,
+		MaxSize: c.opts.MaxSize,
+		Started: c.started,
+		Reason:  c.reason,
+	}
+	if c.opts.Duration > 0 {
+		status.Duration = c.opts.Duration.String()
+	}
+	if !status.Running {
+		stopped := c.stopped
+		status.Stopped = &stopped
+	}
+	if c.err != nil {
+		status.Error = c.err.Error()
+	}
+	return status
+}
@@ -0,0 +1,69 @@
+package capture
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCaptureFilterAndSizeLimit(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bridge.pcapng")
+	c, err := Start(Options{
+		File:    file,
+		Filter:  Filter{Protocol: "udp"},
+		Stages:  []string{StageIPv4In},
+		MaxSize: 1024,
+	})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	udp := ipv4Packet(protocolUDP, "192.0.2.1", "198.51.100.7", 0, ports(5353, 53))
+	tcp := ipv4Packet(protocolTCP, "192.0.2.1", "198.51.100.7", 0, ports(40000, 80))
+
+	c.Packet(StageIPv4In, tcp)  // Filtered out
+	c.Packet(StageIPv6Out, udp) // Stage not captured
+	for c.Running() {
+		c.Packet(StageIPv4In, udp)
+	}
+
+	status := c.Status()
+	if status.Reason != ReasonMaxSize || status.Error != "" {
+		t.Fatalf("capture stopped with reason %q error %q, want %q", status.Reason, status.Error, ReasonMaxSize)
+	}
+	if status.Bytes > 1024 {
+		t.Fatalf("capture wrote %d bytes, more than its 1024 byte limit", status.Bytes)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if int64(len(data)) != status.Bytes {
+		t.Fatalf("file has %d bytes, status reports %d", len(data), status.Bytes)
+	}
+
+	var packets uint64
+	for _, b := range readBlocks(t, data) {
+		if b.typ == blockEnhancedPacket {
+			packets++
+		}
+	}
+	if packets == 0 || packets != status.Packets {
+		t.Fatalf("file holds %d packets, status reports %d", packets, status.Packets)
+	}
+}
+
+func TestStartRejectsExistingFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bridge.pcapng")
+	if err := os.WriteFile(file, []byte("keep"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	if _, err := Start(Options{File: file, MaxSize: 1024}); err == nil {
+		t.Fatal("Start over an existing file succeeded")
+	}
+	if data, _ := os.ReadFile(file); string(data) != "keep" {
+		t.Fatalf("existing file now holds %q", data)
+	}
+}
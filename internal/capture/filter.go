@@ -0,0 +1,197 @@
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Filter selects the packets a capture keeps. Empty fields match every
+// packet; a packet must match all of the others.
+type Filter struct {
+	Protocol string `json:"protocol,omitempty"` // tcp, udp or icmp
+	Address  string `json:"address,omitempty"`  // IPv4 or IPv6 address or prefix, source or destination
+	Port     uint16 `json:"port,omitempty"`     // TCP or UDP port, source or destination
+}
+
+// String describes the filter, e.g. "tcp host 192.0.2.1 port 80"
+func (f Filter) String() string {
+	var parts []string
+	if f.Protocol != "" {
+		parts = append(parts, strings.ToLower(f.Protocol))
+	}
+	if f.Address != "" {
+		parts = append(parts, "host "+f.Address)
+	}
+	if f.Port != 0 {
+		parts = append(parts, fmt.Sprintf("port %d", f.Port))
+	}
+	if len(parts) == 0 {
+		return "all packets"
+	}
+	return strings.Join(parts, " ")
+}
+
+// matcher is a parsed Filter
+type matcher struct {
+	protocols []uint8 // Empty for any
+	network   *net.IPNet
+	port      uint16
+}
+
+// compile parses the filter
+func (f Filter) compile() (*matcher, error) {
+	m := &matcher{port: f.Port}
+
+	switch strings.ToLower(f.Protocol) {
+	case "":
+	case "tcp":
+		m.protocols = []uint8{protocolTCP}
+	case "udp":
+		m.protocols = []uint8{protocolUDP}
+	case "icmp":
+		m.protocols = []uint8{protocolICMP, protocolICMPv6}
+	default:
+		return nil, fmt.Errorf("invalid protocol %q (tcp, udp or icmp)", f.Protocol)
+	}
+	if f.Port != 0 && len(m.protocols) > 0 && m.protocols[0] == protocolICMP {
+		return nil, fmt.Errorf("ICMP has no ports")
+	}
+
+	if f.Address != "" {
+		network, err := parseNetwork(f.Address)
+		if err != nil {
+			return nil, err
+		}
+		m.network = network
+	}
+	return m, nil
+}
+
+// parseNetwork parses an address or prefix
+func parseNetwork(s string) (*net.IPNet, error) {
+	if _, network, err := net.ParseCIDR(s); err == nil {
+		return network, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// IP protocol numbers
+const (
+	protocolICMP   = 1
+	protocolTCP    = 6
+	protocolUDP    = 17
+	protocolICMPv6 = 58
+)
+
+// match reports whether a raw IPv4 or IPv6 packet passes the filter
+func (m *matcher) match(data []byte) bool {
+	if len(m.protocols) == 0 && m.network == nil && m.port == 0 {
+		return true
+	}
+
+	h, ok := parseHeaders(data)
+	if !ok {
+		return false
+	}
+
+	if len(m.protocols) > 0 {
+		found := false
+		for _, p := range m.protocols {
+			found = found || p == h.protocol
+		}
+		if !found {
+			return false
+		}
+	}
+	if m.network != nil && !m.network.Contains(h.src) && !m.network.Contains(h.dst) {
+		return false
+	}
+	if m.port != 0 && (!h.hasPorts || (h.srcPort != m.port && h.dstPort != m.port)) {
+		return false
+	}
+	return true
+}
+
+// headers are the fields of a packet a filter looks at
+type headers struct {
+	protocol         uint8
+	src, dst         net.IP
+	srcPort, dstPort uint16
+	hasPorts         bool
+}
+
+// IPv6 extension headers skipped to find the upper-layer protocol
+const (
+	extHopByHop    = 0
+	extRouting     = 43
+	extFragment    = 44
+	extDestination = 60
+)
+
+// parseHeaders reads the addresses, protocol and ports of a raw packet. Ports
+// are only read from the first fragment of a datagram.
+func parseHeaders(data []byte) (headers, bool) {
+	var h headers
+	if len(data) < 1 {
+		return h, false
+	}
+
+	var payload []byte
+	firstFragment := true
+	switch data[0] >> 4 {
+	case 4:
+		if len(data) < 20 {
+			return h, false
+		}
+		ihl := int(data[0]&0x0f) * 4
+		if ihl < 20 || len(data) < ihl {
+			return h, false
+		}
+		h.protocol = data[9]
+		h.src = net.IP(data[12:16])
+		h.dst = net.IP(data[16:20])
+		firstFragment = binary.BigEndian.Uint16(data[6:8])&0x1fff == 0
+		payload = data[ihl:]
+	case 6:
+		if len(data) < 40 {
+			return h, false
+		}
+		h.src = net.IP(data[8:24])
+		h.dst = net.IP(data[24:40])
+		next, offset := data[6], 40
+		for next == extHopByHop || next == extRouting || next == extFragment || next == extDestination {
+			if len(data) < offset+8 {
+				return h, false
+			}
+			if next == extFragment {
+				firstFragment = binary.BigEndian.Uint16(data[offset+2:offset+4])&0xfff8 == 0
+				next, offset = data[offset], offset+8
+				continue
+			}
+			next, offset = data[offset], offset+(int(data[offset+1])+1)*8
+		}
+		if len(data) < offset {
+			return h, false
+		}
+		h.protocol = next
+		payload = data[offset:]
+	default:
+		return h, false
+	}
+
+	if (h.protocol == protocolTCP || h.protocol == protocolUDP) && firstFragment && len(payload) >= 4 {
+		h.srcPort = binary.BigEndian.Uint16(payload[0:2])
+		h.dstPort = binary.BigEndian.Uint16(payload[2:4])
+		h.hasPorts = true
+	}
+	return h, true
+}
@@ -0,0 +1,133 @@
+package capture
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// ipv4Packet builds an IPv4 header followed by payload
+func ipv4Packet(protocol uint8, src, dst string, fragOffset uint16, payload []byte) []byte {
+	packet := make([]byte, 20, 20+len(payload))
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:4], uint16(20+len(payload)))
+	binary.BigEndian.PutUint16(packet[6:8], fragOffset/8)
+	packet[8] = 64
+	packet[9] = protocol
+	copy(packet[12:16], net.ParseIP(src).To4())
+	copy(packet[16:20], net.ParseIP(dst).To4())
+	return append(packet, payload...)
+}
+
+// ipv6Packet builds an IPv6 header, an optional Fragment header and payload
+func ipv6Packet(protocol uint8, src, dst string, fragment bool, fragOffset uint16, payload []byte) []byte {
+	packet := make([]byte, 40, 48+len(payload))
+	packet[0] = 0x60
+	packet[6] = protocol
+	packet[7] = 64
+	copy(packet[8:24], net.ParseIP(src))
+	copy(packet[24:40], net.ParseIP(dst))
+	if fragment {
+		packet[6] = extFragment
+		header := make([]byte, 8)
+		header[0] = protocol
+		binary.BigEndian.PutUint16(header[2:4], fragOffset)
+		packet = append(packet, header...)
+	}
+	packet = append(packet, payload...)
+	binary.BigEndian.PutUint16(packet[4:6], uint16(len(packet)-40))
+	return packet
+}
+
+// ports returns the start of a TCP or UDP header
+func ports(src, dst uint16) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint16(b[0:2], src)
+	binary.BigEndian.PutUint16(b[2:4], dst)
+	return b
+}
+
+func TestFilterMatch(t *testing.T) {
+	tcp4 := ipv4Packet(protocolTCP, "192.0.2.1", "198.51.100.7", 0, ports(40000, 80))
+	udp4 := ipv4Packet(protocolUDP, "192.0.2.1", "198.51.100.7", 0, ports(5353, 53))
+	icmp4 := ipv4Packet(protocolICMP, "192.0.2.1", "198.51.100.7", 0, make([]byte, 8))
+	tcp4Later := ipv4Packet(protocolTCP, "192.0.2.1", "198.51.100.7", 1480, ports(40000, 80))
+	tcp6 := ipv6Packet(protocolTCP, "2001:db8::1", "64:ff9b::c633:6407", false, 0, ports(40000, 443))
+	icmp6 := ipv6Packet(protocolICMPv6, "2001:db8::1", "64:ff9b::c633:6407", false, 0, make([]byte, 8))
+	udp6First := ipv6Packet(protocolUDP, "2001:db8::1", "64:ff9b::c633:6407", true, 0x0001, ports(5353, 53))
+	udp6Later := ipv6Packet(protocolUDP, "2001:db8::1", "64:ff9b::c633:6407", true, 1232, ports(5353, 53))
+
+	tests := []struct {
+		name   string
+		filter Filter
+		packet []byte
+		want   bool
+	}{
+		{"empty filter", Filter{}, tcp4, true},
+		{"empty filter garbage", Filter{}, []byte{0xff}, true},
+		{"tcp", Filter{Protocol: "tcp"}, tcp4, true},
+		{"tcp upper case", Filter{Protocol: "TCP"}, tcp6, true},
+		{"tcp against udp", Filter{Protocol: "tcp"}, udp4, false},
+		{"icmp v4", Filter{Protocol: "icmp"}, icmp4, true},
+		{"icmp v6", Filter{Protocol: "icmp"}, icmp6, true},
+		{"icmp against tcp", Filter{Protocol: "icmp"}, tcp6, false},
+		{"source address", Filter{Address: "192.0.2.1"}, tcp4, true},
+		{"destination address", Filter{Address: "198.51.100.7"}, udp4, true},
+		{"other address", Filter{Address: "192.0.2.2"}, tcp4, false},
+		{"ipv4 prefix", Filter{Address: "198.51.100.0/24"}, tcp4, true},
+		{"ipv6 prefix", Filter{Address: "64:ff9b::/96"}, tcp6, true},
+		{"ipv6 prefix against ipv4", Filter{Address: "64:ff9b::/96"}, tcp4, false},
+		{"source port", Filter{Port: 40000}, tcp4, true},
+		{"destination port", Filter{Port: 53}, udp4, true},
+		{"other port", Filter{Port: 8080}, tcp4, false},
+		{"port against icmp", Filter{Port: 53}, icmp4, false},
+		{"port in later fragment", Filter{Port: 80}, tcp4Later, false},
+		{"port after fragment header", Filter{Port: 53}, udp6First, true},
+		{"port in later ipv6 fragment", Filter{Port: 53}, udp6Later, false},
+		{"protocol after fragment header", Filter{Protocol: "udp"}, udp6Later, true},
+		{"all fields", Filter{Protocol: "tcp", Address: "2001:db8::/32", Port: 443}, tcp6, true},
+		{"all fields one wrong", Filter{Protocol: "udp", Address: "2001:db8::/32", Port: 443}, tcp6, false},
+		{"truncated packet", Filter{Protocol: "tcp"}, tcp4[:12], false},
+		{"unknown version", Filter{Protocol: "tcp"}, append([]byte{0x50}, tcp4[1:]...), false},
+	}
+
+	for _, tt := range tests {
+		m, err := tt.filter.compile()
+		if err != nil {
+			t.Fatalf("%s: compile: %v", tt.name, err)
+		}
+		if got := m.match(tt.packet); got != tt.want {
+			t.Errorf("%s: %s matched %v, want %v", tt.name, tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestFilterCompileErrors(t *testing.T) {
+	for _, f := range []Filter{
+		{Protocol: "sctp"},
+		{Protocol: "icmp", Port: 53},
+		{Address: "not-an-address"},
+		{Address: "192.0.2.0/33"},
+	} {
+		if _, err := f.compile(); err == nil {
+			t.Errorf("compile(%+v) succeeded, want error", f)
+		}
+	}
+}
+
+func TestFilterString(t *testing.T) {
+	tests := []struct {
+		filter Filter
+		want   string
+	}{
+		{Filter{}, "all packets"},
+		{Filter{Protocol: "TCP", Address: "192.0.2.1", Port: 80}, "tcp host 192.0.2.1 port 80"},
+		{Filter{Address: "2001:db8::/32"}, "host 2001:db8::/32"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.filter.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.filter, got, tt.want)
+		}
+	}
+}
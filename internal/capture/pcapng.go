@@ -0,0 +1,158 @@
+package capture
+
+import (
+	"encoding/binary"
+	"io"
+	"runtime"
+	"time"
+)
+
+// LinkTypeRaw is the link type of packets that start with an IPv4 or IPv6
+// header, as read from a TUN device
+const LinkTypeRaw = 101
+
+// pcapng block types
+const (
+	blockSectionHeader  = 0x0A0D0D0A
+	blockInterfaceDesc  = 0x00000001
+	blockEnhancedPacket = 0x00000006
+)
+
+// pcapng option codes
+const (
+	optEndOfOpt      = 0
+	optSHBHardware   = 2
+	optSHBOS         = 3
+	optSHBUserAppl   = 4
+	optIfName        = 2
+	optIfDescription = 3
+	optIfTSResol     = 9
+	optEPBFlags      = 2
+)
+
+const (
+	byteOrderMagic        = 0x1A2B3C4D
+	tsResolNanoseconds    = 9 // if_tsresol of 10^-9 seconds
+	snapLen               = 65535
+	directionInbound      = 1 // epb_flags direction bits
+	directionOutbound     = 2
+	blockHeaderAndTrailer = 12 // Type, total length and the repeated total length
+)
+
+// Writer writes packets in the pcapng format (draft-ietf-opsawg-pcapng), one
+// section with an interface per capture point. Timestamps have nanosecond
+// resolution.
+type Writer struct {
+	w   io.Writer
+	buf []byte
+	n   int
+}
+
+// NewWriter writes the section header to w, naming application as the
+// program that wrote the file
+func NewWriter(w io.Writer, application string) (*Writer, error) {
+	pw := &Writer{w: w}
+
+	body := binary.LittleEndian.AppendUint32(nil, byteOrderMagic)
+	body = binary.LittleEndian.AppendUint16(body, 1) // Version 1.0
+	body = binary.LittleEndian.AppendUint16(body, 0)
+	body = binary.LittleEndian.AppendUint64(body, ^uint64(0)) // Section length unknown
+	body = appendOption(body, optSHBHardware, []byte(runtime.GOARCH))
+	body = appendOption(body, optSHBOS, []byte(runtime.GOOS))
+	body = appendOption(body, optSHBUserAppl, []byte(application))
+	body = appendOption(body, optEndOfOpt, nil)
+
+	if err := pw.writeBlock(blockSectionHeader, body); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// AddInterface writes an interface description and returns its ID, which
+// packets captured on it are written with
+func (pw *Writer) AddInterface(name, description string, linkType uint16) (uint32, error) {
+	body := binary.LittleEndian.AppendUint16(nil, linkType)
+	body = binary.LittleEndian.AppendUint16(body, 0)
+	body = binary.LittleEndian.AppendUint32(body, snapLen)
+	body = appendOption(body, optIfName, []byte(name))
+	if description != "" {
+		body = appendOption(body, optIfDescription, []byte(description))
+	}
+	body = appendOption(body, optIfTSResol, []byte{tsResolNanoseconds})
+	body = appendOption(body, optEndOfOpt, nil)
+
+	if err := pw.writeBlock(blockInterfaceDesc, body); err != nil {
+		return 0, err
+	}
+	id := uint32(pw.n)
+	pw.n++
+	return id, nil
+}
+
+// WritePacket writes a packet captured on an interface at time t. Packets
+// larger than the snapshot length are truncated. inbound tells whether the
+// packet was received or sent on the interface.
+func (pw *Writer) WritePacket(iface uint32, t time.Time, data []byte, inbound bool) (int, error) {
+	captured := data
+	if len(captured) > snapLen {
+		captured = captured[:snapLen]
+	}
+
+	ts := uint64(t.UnixNano())
+	body := pw.buf[:0]
+	body = binary.LittleEndian.AppendUint32(body, iface)
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts>>32))
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(captured)))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(data)))
+	body = append(body, captured...)
+	body = appendPadding(body)
+
+	var flags [4]byte
+	if inbound {
+		binary.LittleEndian.PutUint32(flags[:], directionInbound)
+	} else {
+		binary.LittleEndian.PutUint32(flags[:], directionOutbound)
+	}
+	body = appendOption(body, optEPBFlags, flags[:])
+	body = appendOption(body, optEndOfOpt, nil)
+	pw.buf = body
+
+	if err := pw.writeBlock(blockEnhancedPacket, body); err != nil {
+		return 0, err
+	}
+	return len(body) + blockHeaderAndTrailer, nil
+}
+
+// writeBlock writes a block with its type and length around body
+func (pw *Writer) writeBlock(blockType uint32, body []byte) error {
+	length := uint32(len(body) + blockHeaderAndTrailer)
+
+	var header [8]byte
+	binary.LittleEndian.PutUint32(header[0:], blockType)
+	binary.LittleEndian.PutUint32(header[4:], length)
+	if _, err := pw.w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := pw.w.Write(body); err != nil {
+		return err
+	}
+	_, err := pw.w.Write(header[4:])
+	return err
+}
+
+// appendOption appends an option padded to 32 bits
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return appendPadding(b)
+}
+
+// appendPadding pads b to a multiple of 32 bits
+func appendPadding(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
@@ -0,0 +1,195 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// block is a pcapng block read back from a capture
+type block struct {
+	typ  uint32
+	body []byte
+}
+
+// readBlocks splits a capture into blocks, checking their framing
+func readBlocks(t *testing.T, data []byte) []block {
+	t.Helper()
+
+	var blocks []block
+	for len(data) > 0 {
+		if len(data) < blockHeaderAndTrailer {
+			t.Fatalf("%d trailing bytes", len(data))
+		}
+		length := binary.LittleEndian.Uint32(data[4:8])
+		if length%4 != 0 || int(length) > len(data) {
+			t.Fatalf("block length %d is not aligned or exceeds the %d bytes left", length, len(data))
+		}
+		if trailer := binary.LittleEndian.Uint32(data[length-4 : length]); trailer != length {
+			t.Fatalf("block trailer length %d, want %d", trailer, length)
+		}
+		blocks = append(blocks, block{typ: binary.LittleEndian.Uint32(data[0:4]), body: data[8 : length-4]})
+		data = data[length:]
+	}
+	return blocks
+}
+
+// readOptions parses the options of a block body, checking their padding
+func readOptions(t *testing.T, b []byte) map[uint16][]byte {
+	t.Helper()
+
+	options := make(map[uint16][]byte)
+	for {
+		if len(b) < 4 {
+			t.Fatalf("options end without opt_endofopt")
+		}
+		code, length := binary.LittleEndian.Uint16(b[0:2]), int(binary.LittleEndian.Uint16(b[2:4]))
+		if code == optEndOfOpt {
+			if length != 0 || len(b) != 4 {
+				t.Fatalf("opt_endofopt with length %d followed by %d bytes", length, len(b)-4)
+			}
+			return options
+		}
+		padded := (length + 3) &^ 3
+		if len(b) < 4+padded {
+			t.Fatalf("option %d of length %d exceeds the block", code, length)
+		}
+		options[code] = b[4 : 4+length]
+		b = b[4+padded:]
+	}
+}
+
+func TestWriterLayout(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, "bridge-test")
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	first, err := w.AddInterface("ipv6-in", "tun-ipv6", LinkTypeRaw)
+	if err != nil {
+		t.Fatalf("AddInterface: %v", err)
+	}
+	second, err := w.AddInterface("ipv4-out", "", LinkTypeRaw)
+	if err != nil {
+		t.Fatalf("AddInterface: %v", err)
+	}
+	if first != 0 || second != 1 {
+		t.Fatalf("interface IDs %d and %d, want 0 and 1", first, second)
+	}
+
+	packet := []byte{0x45, 0, 0, 21, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17}
+	ts := time.Unix(1700000000, 123456789)
+	n, err := w.WritePacket(second, ts, packet, false)
+	if err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+	if _, err := w.WritePacket(first, ts, packet[:20], true); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+
+	blocks := readBlocks(t, buf.Bytes())
+	if len(blocks) != 5 {
+		t.Fatalf("%d blocks, want 5", len(blocks))
+	}
+
+	// Section header block
+	shb := blocks[0]
+	if shb.typ != blockSectionHeader {
+		t.Fatalf("first block type %#x, want a section header", shb.typ)
+	}
+	if magic := binary.LittleEndian.Uint32(shb.body[0:4]); magic != byteOrderMagic {
+		t.Fatalf("byte-order magic %#x, want %#x", magic, byteOrderMagic)
+	}
+	if major, minor := binary.LittleEndian.Uint16(shb.body[4:6]), binary.LittleEndian.Uint16(shb.body[6:8]); major != 1 || minor != 0 {
+		t.Fatalf("version %d.%d, want 1.0", major, minor)
+	}
+	if length := binary.LittleEndian.Uint64(shb.body[8:16]); length != ^uint64(0) {
+		t.Fatalf("section length %d, want unspecified", length)
+	}
+	options := readOptions(t, shb.body[16:])
+	if string(options[optSHBOS]) != runtime.GOOS || string(options[optSHBUserAppl]) != "bridge-test" {
+		t.Fatalf("section options %q, want shb_os %q and shb_userappl bridge-test", options, runtime.GOOS)
+	}
+
+	// Interface description blocks
+	for i, want := range []struct {
+		name, description string
+	}{{"ipv6-in", "tun-ipv6"}, {"ipv4-out", ""}} {
+		idb := blocks[1+i]
+		if idb.typ != blockInterfaceDesc {
+			t.Fatalf("block %d type %#x, want an interface description", 1+i, idb.typ)
+		}
+		if linkType := binary.LittleEndian.Uint16(idb.body[0:2]); linkType != LinkTypeRaw {
+			t.Fatalf("link type %d, want %d", linkType, LinkTypeRaw)
+		}
+		if snap := binary.LittleEndian.Uint32(idb.body[4:8]); snap != snapLen {
+			t.Fatalf("snapshot length %d, want %d", snap, snapLen)
+		}
+		options := readOptions(t, idb.body[8:])
+		if string(options[optIfName]) != want.name || string(options[optIfDescription]) != want.description {
+			t.Fatalf("interface options %q, want if_name %q and if_description %q", options, want.name, want.description)
+		}
+		if resol := options[optIfTSResol]; len(resol) != 1 || resol[0] != tsResolNanoseconds {
+			t.Fatalf("if_tsresol %v, want nanoseconds", resol)
+		}
+	}
+
+	// Enhanced packet blocks
+	for i, want := range []struct {
+		iface     uint32
+		data      []byte
+		direction uint32
+	}{{second, packet, directionOutbound}, {first, packet[:20], directionInbound}} {
+		epb := blocks[3+i]
+		if epb.typ != blockEnhancedPacket {
+			t.Fatalf("block %d type %#x, want an enhanced packet", 3+i, epb.typ)
+		}
+		body := epb.body
+		if iface := binary.LittleEndian.Uint32(body[0:4]); iface != want.iface {
+			t.Fatalf("packet %d on interface %d, want %d", i, iface, want.iface)
+		}
+		stamp := uint64(binary.LittleEndian.Uint32(body[4:8]))<<32 | uint64(binary.LittleEndian.Uint32(body[8:12]))
+		if stamp != uint64(ts.UnixNano()) {
+			t.Fatalf("packet %d timestamp %d, want %d", i, stamp, ts.UnixNano())
+		}
+		captured, original := binary.LittleEndian.Uint32(body[12:16]), binary.LittleEndian.Uint32(body[16:20])
+		if int(captured) != len(want.data) || int(original) != len(want.data) {
+			t.Fatalf("packet %d lengths %d/%d, want %d", i, captured, original, len(want.data))
+		}
+		padded := (len(want.data) + 3) &^ 3
+		if !bytes.Equal(body[20:20+captured], want.data) || !bytes.Equal(body[20+captured:20+padded], make([]byte, padded-len(want.data))) {
+			t.Fatalf("packet %d data %x, want %x with zero padding", i, body[20:20+padded], want.data)
+		}
+		options := readOptions(t, body[20+padded:])
+		if flags := options[optEPBFlags]; len(flags) != 4 || binary.LittleEndian.Uint32(flags) != want.direction {
+			t.Fatalf("packet %d epb_flags %x, want direction %d", i, flags, want.direction)
+		}
+	}
+
+	if want := len(blocks[3].body) + blockHeaderAndTrailer; n != want {
+		t.Fatalf("WritePacket returned %d bytes, want %d", n, want)
+	}
+	if n > len(packet)+packetOverhead {
+		t.Fatalf("packet block of %d bytes exceeds packetOverhead for a %d byte packet", n, len(packet))
+	}
+}
+
+func TestWriterTruncatesToSnapLen(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, "bridge-test")
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	iface, _ := w.AddInterface("ipv4-in", "", LinkTypeRaw)
+	if _, err := w.WritePacket(iface, time.Now(), make([]byte, snapLen+100), true); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+
+	epb := readBlocks(t, buf.Bytes())[2]
+	captured, original := binary.LittleEndian.Uint32(epb.body[12:16]), binary.LittleEndian.Uint32(epb.body[16:20])
+	if captured != snapLen || original != snapLen+100 {
+		t.Fatalf("lengths %d/%d, want %d/%d", captured, original, snapLen, snapLen+100)
+	}
+}
@@ -0,0 +1,379 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the name of every environment variable read by Load.
+// The rest is the configuration key in upper case with dots replaced by
+// underscores, e.g. BRIDGE_DNS64_UPSTREAM for dns64.upstream.
+const EnvPrefix = "BRIDGE_"
+
+// Sources of configuration values
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// configPath is the file ParseConfig reads, set from the --config flag
+var configPath = DefaultConfigPath
+
+// SetPath sets the configuration file used by ParseConfig and
+// CreateDefaultConfig. An empty path selects DefaultConfigPath.
+func SetPath(path string) {
+	if path == "" {
+		path = DefaultConfigPath
+	}
+	configPath = path
+}
+
+// Path returns the configuration file used by ParseConfig
+func Path() string {
+	return configPath
+}
+
+// LoadOptions selects the layers Load merges over the defaults
+type LoadOptions struct {
+	Path    string            // Configuration file (YAML or JSON), Path() when empty
+	Environ []string          // Environment as KEY=value pairs, os.Environ() when nil
+	Flags   map[string]string // Command-line values by configuration key
+}
+
+// Setting is one configuration value and the layer it came from
+type Setting struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"` // default, file, env or flag
+	Origin string `json:"origin,omitempty"`
+}
+
+// Loaded is the result of Load
+type Loaded struct {
+	Config   *BridgeConfig
+	Path     string    // Configuration file that was read, empty if none
+	Settings []Setting // Every key in order, with its source
+}
+
+// field is a configuration value addressed by its dotted key
+type field struct {
+	key   string
+	value reflect.Value
+}
+
+// Load builds the configuration from the defaults, then the configuration
+// file, then BRIDGE_* environment variables, then command-line flags, and
+// validates the result. A missing file is only an error when it was asked for
+// explicitly. Invalid values, including unknown keys in the file, are
+// returned together as a *ValidationError.
+func Load(opts LoadOptions) (*Loaded, error) {
+	config := Defaults()
+	fields := configFields(config)
+	sources := make(map[string]Setting, len(fields))
+	var problems []Problem
+
+	path := opts.Path
+	explicit := path != ""
+	if !explicit {
+		path = Path()
+		explicit = path != DefaultConfigPath
+	}
+
+	loaded := &Loaded{Config: config}
+	idx := &fileIndex{path: path}
+
+	// Configuration file. JSON is read as YAML, of which it is a subset.
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		idx, err = decodeFile(path, data, config)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		problems = append(problems, idx.problems...)
+		for _, f := range fields {
+			if _, ok := idx.positions[f.key]; ok {
+				sources[f.key] = Setting{Source: SourceFile, Origin: path}
+			}
+		}
+		loaded.Path = path
+	case errors.Is(err, fs.ErrNotExist) && !explicit:
+		// Run on defaults, environment and flags alone
+	default:
+		return nil, err
+	}
+
+	// Environment
+	environ := opts.Environ
+	if environ == nil {
+		environ = os.Environ()
+	}
+	env := make(map[string]string)
+	for _, entry := range environ {
+		if name, value, ok := strings.Cut(entry, "="); ok && strings.HasPrefix(name, EnvPrefix) {
+			env[name] = value
+		}
+	}
+	for _, f := range fields {
+		name := EnvName(f.key)
+		value, ok := env[name]
+		if !ok {
+			continue
+		}
+		if err := setField(f.value, value); err != nil {
+			problems = append(problems, Problem{Key: f.key, Message: err.Error(), Position: name})
+		}
+		sources[f.key] = Setting{Source: SourceEnv, Origin: name}
+	}
+
+	// Command-line flags
+	for _, f := range fields {
+		value, ok := opts.Flags[f.key]
+		if !ok {
+			continue
+		}
+		if err := setField(f.value, value); err != nil {
+			problems = append(problems, Problem{Key: f.key, Message: err.Error(), Position: positionFlag})
+		}
+		sources[f.key] = Setting{Source: SourceFlag}
+	}
+	for key := range opts.Flags {
+		if !hasKey(fields, key) {
+			return nil, fmt.Errorf("unknown configuration key %q", key)
+		}
+	}
+
+	// Fill in values left empty and those derived from others
+	config.setDefaults()
+
+	var invalid *ValidationError
+	if err := config.Validate(); errors.As(err, &invalid) {
+		for _, p := range invalid.Problems {
+			p.Position = locate(p.Key, sources, idx)
+			problems = append(problems, p)
+		}
+	}
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+
+	for _, f := range fields {
+		setting, ok := sources[f.key]
+		if !ok {
+			setting = Setting{Source: SourceDefault}
+		}
+		setting.Key = f.key
+		setting.Value = formatValue(f.value.Interface())
+		if f.key == "api_token" && setting.Value != "" {
+			setting.Value = "(hidden)"
+		}
+		loaded.Settings = append(loaded.Settings, setting)
+	}
+
+	return loaded, nil
+}
+
+// positionFlag is the position of problems with command-line values
+const positionFlag = "command line"
+
+// locate returns where the value of key was set: its file position,
+// environment variable or the command line. Defaults have no position.
+func locate(key string, sources map[string]Setting, idx *fileIndex) string {
+	fieldKey, _, _ := strings.Cut(key, "[")
+	setting := sources[fieldKey]
+	switch setting.Source {
+	case SourceFile:
+		if pos, ok := idx.locate(key); ok {
+			return pos
+		}
+		return idx.path
+	case SourceEnv:
+		return setting.Origin
+	case SourceFlag:
+		return positionFlag
+	}
+	return ""
+}
+
+// decodeFile decodes a configuration file over config. The returned index
+// holds the position of every key the file sets and the keys it does not
+// know; type errors are added to its problems.
+func decodeFile(path string, data []byte, config *BridgeConfig) (*fileIndex, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	idx := indexFile(path, &root)
+	if root.Kind == 0 {
+		return idx, nil // Empty file
+	}
+
+	if err := root.Decode(config); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, err
+		}
+		idx.addTypeErrors(typeErr)
+	}
+	return idx, nil
+}
+
+// Diff returns the keys whose values differ between two configurations. Values
+// are compared as config show prints them, so an empty list equals none.
+func Diff(old, new *BridgeConfig) []string {
+	oldFields, newFields := configFields(old), configFields(new)
+
+	var keys []string
+	for i, f := range oldFields {
+		if formatValue(f.value.Interface()) != formatValue(newFields[i].value.Interface()) {
+			keys = append(keys, f.key)
+		}
+	}
+	return keys
+}
+
+// Update returns a copy of the configuration with the values of keys taken
+// from next
+func (c *BridgeConfig) Update(next *BridgeConfig, keys []string) *BridgeConfig {
+	updated := *c
+	fields, nextFields := configFields(&updated), configFields(next)
+	for _, key := range keys {
+		for i, f := range fields {
+			if f.key == key {
+				f.value.Set(nextFields[i].value)
+			}
+		}
+	}
+	return &updated
+}
+
+// configFields lists the settable values of a configuration. Nested structs
+// are flattened into dotted keys; lists are single values.
+func configFields(config *BridgeConfig) []field {
+	var fields []field
+	walkFields(reflect.ValueOf(config).Elem(), "", &fields)
+	return fields
+}
+
+// walkFields appends the fields of a struct value under prefix
+func walkFields(v reflect.Value, prefix string, fields *[]field) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + name
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			walkFields(fv, key+".", fields)
+			continue
+		}
+		*fields = append(*fields, field{key: key, value: fv})
+	}
+}
+
+// hasKey reports whether key names a configuration field
+func hasKey(fields []field, key string) bool {
+	for _, f := range fields {
+		if f.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// EnvName returns the environment variable of a configuration key
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// setField parses a string into a configuration value. Lists of strings are
+// comma-separated; other lists are given in YAML or JSON flow syntax.
+func setField(v reflect.Value, s string) error {
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		v.SetBool(b)
+	case v.Kind() >= reflect.Int && v.Kind() <= reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		v.SetInt(n)
+	case v.Kind() >= reflect.Uint && v.Kind() <= reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		v.SetUint(n)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		target := reflect.New(v.Type())
+		if err := yaml.Unmarshal([]byte(s), target.Interface()); err != nil {
+			return fmt.Errorf("invalid value %q: %w", s, err)
+		}
+		v.Set(target.Elem())
+	}
+	return nil
+}
+
+// formatValue formats a configuration value the way it would be written in
+// YAML, with lists on one line
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case time.Duration:
+		return v.String()
+	case string:
+		return v
+	}
+
+	var node yaml.Node
+	if err := node.Encode(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	flowStyle(&node)
+	data, err := yaml.Marshal(&node)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// flowStyle switches a YAML node and its children to flow style
+func flowStyle(node *yaml.Node) {
+	if node.Kind == yaml.SequenceNode || node.Kind == yaml.MappingNode {
+		node.Style = yaml.FlowStyle
+	}
+	for _, child := range node.Content {
+		flowStyle(child)
+	}
+}
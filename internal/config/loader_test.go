@@ -0,0 +1,288 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a configuration file into a temporary directory
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+// setting returns the setting of key
+func setting(t *testing.T, loaded *Loaded, key string) Setting {
+	t.Helper()
+
+	for _, s := range loaded.Settings {
+		if s.Key == key {
+			return s
+		}
+	}
+	t.Fatalf("no setting for %s", key)
+	return Setting{}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+ipv4_mtu: 1400
+api_port: 9000
+log_level: warn
+`)
+
+	loaded, err := Load(LoadOptions{
+		Path:    path,
+		Environ: []string{"BRIDGE_API_PORT=9100", "BRIDGE_LOG_LEVEL=debug", "HOME=/root"},
+		Flags:   map[string]string{"log_level": "error"},
+	})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if loaded.Path != path {
+		t.Fatalf("Path = %q, want %q", loaded.Path, path)
+	}
+
+	tests := []struct {
+		key    string
+		want   Setting
+		actual interface{}
+	}{
+		{"ipv6_mtu", Setting{Key: "ipv6_mtu", Value: "1500", Source: SourceDefault}, loaded.Config.IPv6MTU},
+		{"ipv4_mtu", Setting{Key: "ipv4_mtu", Value: "1400", Source: SourceFile, Origin: path}, loaded.Config.IPv4MTU},
+		{"api_port", Setting{Key: "api_port", Value: "9100", Source: SourceEnv, Origin: "BRIDGE_API_PORT"}, loaded.Config.APIPort},
+		{"log_level", Setting{Key: "log_level", Value: "error", Source: SourceFlag}, loaded.Config.LogLevel},
+	}
+	for _, tt := range tests {
+		if got := setting(t, loaded, tt.key); got != tt.want {
+			t.Errorf("setting %s = %+v, want %+v", tt.key, got, tt.want)
+		}
+		if got := formatValue(tt.actual); got != tt.want.Value {
+			t.Errorf("config %s = %s, want %s", tt.key, got, tt.want.Value)
+		}
+	}
+}
+
+func TestLoadWithoutFile(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+	if _, err := Load(LoadOptions{Path: missing, Environ: []string{}}); err == nil {
+		t.Fatal("Load of a missing explicit file succeeded")
+	}
+
+	SetPath("")
+	defer SetPath("")
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd: %v", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("Chdir: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	loaded, err := Load(LoadOptions{Environ: []string{"BRIDGE_IPV4_MTU=1280"}})
+	if err != nil {
+		t.Fatalf("Load without a file: %v", err)
+	}
+	if loaded.Path != "" || loaded.Config.IPv4MTU != 1280 {
+		t.Fatalf("Load without a file = path %q ipv4_mtu %d, want no path and 1280", loaded.Path, loaded.Config.IPv4MTU)
+	}
+}
+
+func TestLoadHidesAPIToken(t *testing.T) {
+	loaded, err := Load(LoadOptions{Path: writeConfig(t, "api_token: secret\n"), Environ: []string{}})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if loaded.Config.APIToken != "secret" {
+		t.Fatalf("APIToken = %q, want secret", loaded.Config.APIToken)
+	}
+	if got := setting(t, loaded, "api_token").Value; got != "(hidden)" {
+		t.Fatalf("api_token setting = %q, want (hidden)", got)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"mode", "BRIDGE_MODE"},
+		{"log_level", "BRIDGE_LOG_LEVEL"},
+		{"dns64.upstream", "BRIDGE_DNS64_UPSTREAM"},
+		{"logging.levels.nat", "BRIDGE_LOGGING_LEVELS_NAT"},
+	}
+
+	for _, tt := range tests {
+		if got := EnvName(tt.key); got != tt.want {
+			t.Errorf("EnvName(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestSetField(t *testing.T) {
+	var values struct {
+		Duration time.Duration
+		List     []string
+		Bool     bool
+		Int      int
+		Port     uint16
+		Pool4    []Pool4Entry
+	}
+	v := reflect.ValueOf(&values).Elem()
+
+	tests := []struct {
+		field string
+		input string
+		want  interface{}
+		valid bool
+	}{
+		{"Duration", "90s", 90 * time.Second, true},
+		{"Duration", "1h30m", 90 * time.Minute, true},
+		{"Duration", "90", nil, false},
+		{"List", "64:ff9b::/96, 2001:db8::/32,", []string{"64:ff9b::/96", "2001:db8::/32"}, true},
+		{"List", "", []string(nil), true},
+		{"Bool", "true", true, true},
+		{"Bool", "0", false, true},
+		{"Bool", "yes", nil, false},
+		{"Int", "-5", -5, true},
+		{"Int", "five", nil, false},
+		{"Port", "53", uint16(53), true},
+		{"Port", "65536", nil, false},
+		{"Pool4", `[{prefix: 192.0.2.0/24, ports: "1024-2047"}]`, []Pool4Entry{{Prefix: "192.0.2.0/24", Ports: "1024-2047"}}, true},
+		{"Pool4", "[{prefix: ", nil, false},
+	}
+
+	for _, tt := range tests {
+		f := v.FieldByName(tt.field)
+		err := setField(f, tt.input)
+		if !tt.valid {
+			if err == nil {
+				t.Errorf("setField(%s, %q) = %v, want error", tt.field, tt.input, f.Interface())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("setField(%s, %q): %v", tt.field, tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(f.Interface(), tt.want) {
+			t.Errorf("setField(%s, %q) = %#v, want %#v", tt.field, tt.input, f.Interface(), tt.want)
+		}
+	}
+}
+
+// loadProblems loads a configuration that must be invalid and returns its problems
+func loadProblems(t *testing.T, opts LoadOptions) []Problem {
+	t.Helper()
+
+	_, err := Load(opts)
+	invalid, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Load error = %v, want a *ValidationError", err)
+	}
+	return invalid.Problems
+}
+
+func TestLoadUnknownKeys(t *testing.T) {
+	path := writeConfig(t, `log_levle: debug
+dns64:
+  upstrem: 192.0.2.53
+pool4:
+  - prefix: 192.0.2.0/24
+    portz: "1024-2047"
+frobnicate: true
+`)
+
+	problems := loadProblems(t, LoadOptions{Path: path, Environ: []string{}})
+	want := []Problem{
+		{Key: "log_levle", Message: `unknown key "log_levle", did you mean "log_level"?`, Position: path + ":1:1"},
+		{Key: "dns64.upstrem", Message: `unknown key "upstrem", did you mean "upstream"?`, Position: path + ":3:3"},
+		{Key: "pool4[0].portz", Message: `unknown key "portz", did you mean "ports"?`, Position: path + ":6:5"},
+		{Key: "frobnicate", Message: `unknown key "frobnicate"`, Position: path + ":7:1"},
+	}
+	if !reflect.DeepEqual(problems, want) {
+		t.Fatalf("problems = %+v, want %+v", problems, want)
+	}
+}
+
+func TestLoadProblemPositions(t *testing.T) {
+	path := writeConfig(t, `mode: nat64
+ipv6_mtu: 1000
+pool4:
+  - prefix: 192.0.2.0/24
+  - prefix: 192.0.2.300/24
+dns64:
+  enabled: true
+  timeout: 1m
+`)
+
+	problems := loadProblems(t, LoadOptions{
+		Path:    path,
+		Environ: []string{"BRIDGE_IPV4_MTU=20", "BRIDGE_API_PORT=http"},
+		Flags:   map[string]string{"fragments.max_buffers": "-1", "filtering": "full-cone"},
+	})
+
+	want := map[string]string{
+		"ipv6_mtu":              path + ":2:1",
+		"pool4[1]":              path + ":5:5",
+		"dns64.timeout":         path + ":8:3",
+		"ipv4_mtu":              "BRIDGE_IPV4_MTU",
+		"api_port":              "BRIDGE_API_PORT",
+		"fragments.max_buffers": positionFlag,
+		"filtering":             positionFlag,
+	}
+	got := make(map[string]string)
+	for _, p := range problems {
+		got[p.Key] = p.Position
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("problem positions = %v, want %v", got, want)
+	}
+}
+
+func TestLoadTypeErrors(t *testing.T) {
+	path := writeConfig(t, `api_port: eighty
+fragments:
+  reassemble: sometimes
+ipv4_mtu: 1400
+`)
+
+	problems := loadProblems(t, LoadOptions{Path: path, Environ: []string{}})
+	if len(problems) != 2 {
+		t.Fatalf("problems = %+v, want 2", problems)
+	}
+	for i, want := range []Problem{
+		{Key: "api_port", Message: "cannot unmarshal !!str `eighty` into int", Position: path + ":1:1"},
+		{Key: "fragments.reassemble", Message: "cannot unmarshal !!str `sometimes` into bool", Position: path + ":3:3"},
+	} {
+		if problems[i] != want {
+			t.Errorf("problem %d = %+v, want %+v", i, problems[i], want)
+		}
+	}
+}
+
+func TestLoadRejectsUnknownFlagKey(t *testing.T) {
+	_, err := Load(LoadOptions{Path: writeConfig(t, ""), Environ: []string{}, Flags: map[string]string{"no_such_key": "1"}})
+	if err == nil {
+		t.Fatal("Load with an unknown flag key succeeded")
+	}
+	if _, ok := err.(*ValidationError); ok {
+		t.Fatalf("Load error = %v, want an error other than *ValidationError", err)
+	}
+}
+
+func TestLoadSyntaxError(t *testing.T) {
+	path := writeConfig(t, "mode: [nat64\n")
+	if _, err := Load(LoadOptions{Path: path, Environ: []string{}}); err == nil {
+		t.Fatal("Load of malformed YAML succeeded")
+	}
+}
@@ -1,44 +1,380 @@
 package config
 
 import (
+	"fmt"
+	"net"
 	"os"
+	"runtime"
+	"strconv"
+	"time"
 
+	"github.com/mdxabu/bridge/internal/logger"
+	"github.com/mdxabu/bridge/internal/nat"
 	"gopkg.in/yaml.v3"
 )
 
 const DefaultConfigPath = "bridgeconfig.yaml"
 
+// DefaultAPIListen keeps the REST API, which has no authentication unless
+// api_token is set, on the local host
+const DefaultAPIListen = "127.0.0.1"
+
 type BridgeConfig struct {
-	Interface    string `yaml:"interface"`
-	NAT64Prefix  string `yaml:"nat64_prefix"`
-	NAT64Gateway string `yaml:"nat64_gateway"`
-	APIPort      int    `yaml:"api_port"`
+	Mode         string         `yaml:"mode"` // nat64 (stateful), siit (stateless) or clat (464XLAT customer side)
+	Interface    string         `yaml:"interface"`
+	NAT64Prefix  string         `yaml:"nat64_prefix"`
+	NAT64Gateway string         `yaml:"nat64_gateway"`
+	APIPort      int            `yaml:"api_port"`
+	APIListen    string         `yaml:"api_listen"` // Address the REST API binds to
+	APIToken     string         `yaml:"api_token"`  // Bearer token required to change the bridge through the API
+	IPv4MTU      int            `yaml:"ipv4_mtu"`
+	IPv6MTU      int            `yaml:"ipv6_mtu"`
+	Fragments    FragmentConfig `yaml:"fragments"`
+	Pool4        []Pool4Entry   `yaml:"pool4"`
+	Filtering    string         `yaml:"filtering"` // endpoint-independent, address-dependent or address-and-port-dependent
+	State        StateConfig    `yaml:"state"`
+
+	StaticBindings []StaticBinding `yaml:"static_bindings"`
+
+	Pool6 string      `yaml:"pool6"` // SIIT prefix, defaults to nat64_prefix
+	EAMT  []EAMTEntry `yaml:"eamt"`
+
+	DNS64 DNS64Config `yaml:"dns64"`
+	CLAT  CLATConfig  `yaml:"clat"`
+
+	Pipeline PipelineConfig `yaml:"pipeline"`
+
+	Timeouts TimeoutConfig `yaml:"timeouts"`
+	LogLevel string        `yaml:"log_level"` // debug, info, warn or error
+	Logging  LoggingConfig `yaml:"logging"`
+	Capture  CaptureConfig `yaml:"capture"`
+}
+
+// CaptureConfig controls packet captures started through the API
+type CaptureConfig struct {
+	Dir     string `yaml:"dir"`      // Directory capture files are written to
+	MaxSize int    `yaml:"max_size"` // Megabytes a capture may grow to
+}
+
+// DefaultCaptureConfig returns the capture settings used when none are configured
+func DefaultCaptureConfig() CaptureConfig {
+	return CaptureConfig{
+		Dir:     "captures",
+		MaxSize: 100,
+	}
+}
+
+// LoggingConfig controls where and how the bridge logs
+type LoggingConfig struct {
+	Format     string          `yaml:"format"`      // text or json
+	Output     string          `yaml:"output"`      // stdout, stderr, file, syslog or journald
+	File       string          `yaml:"file"`        // Log file when output is file
+	MaxSize    int             `yaml:"max_size"`    // Megabytes before the file is rotated, 0 for no rotation
+	MaxBackups int             `yaml:"max_backups"` // Rotated files kept
+	SampleRate int             `yaml:"sample_rate"` // Per-packet messages of each kind logged per second, 0 for no limit
+	Levels     SubsystemLevels `yaml:"levels"`
+}
+
+// SubsystemLevels overrides log_level for parts of the bridge. Empty levels
+// inherit log_level.
+type SubsystemLevels struct {
+	NAT        string `yaml:"nat"`
+	TUN        string `yaml:"tun"`
+	API        string `yaml:"api"`
+	Translator string `yaml:"translator"`
+	DNS64      string `yaml:"dns64"`
+}
+
+// Get returns the level of a subsystem, empty when it inherits log_level
+func (l SubsystemLevels) Get(subsystem string) string {
+	switch subsystem {
+	case logger.NAT:
+		return l.NAT
+	case logger.TUN:
+		return l.TUN
+	case logger.API:
+		return l.API
+	case logger.Translator:
+		return l.Translator
+	case logger.DNS64:
+		return l.DNS64
+	}
+	return ""
+}
+
+// DefaultLoggingConfig returns the logging settings used when none are configured
+func DefaultLoggingConfig() LoggingConfig {
+	return LoggingConfig{
+		Format:     "text",
+		Output:     "stdout",
+		MaxSize:    100,
+		MaxBackups: 5,
+		SampleRate: 10,
+	}
+}
+
+// TimeoutConfig holds the idle timeouts of NAT sessions
+type TimeoutConfig struct {
+	UDP            time.Duration `yaml:"udp"`
+	ICMP           time.Duration `yaml:"icmp"`
+	TCPEstablished time.Duration `yaml:"tcp_established"`
+	TCPTransitory  time.Duration `yaml:"tcp_transitory"` // Opening and closing connections
+}
+
+// DefaultTimeoutConfig returns the session timeouts used when none are configured
+func DefaultTimeoutConfig() TimeoutConfig {
+	return TimeoutConfig{
+		UDP:            60 * time.Second,
+		ICMP:           60 * time.Second,
+		TCPEstablished: nat.TCPEstablishedTimeout,
+		TCPTransitory:  nat.TCPTransitoryTimeout,
+	}
+}
+
+// DefaultLogLevel is the log level used when none is configured
+const DefaultLogLevel = "info"
+
+// PipelineConfig controls how packets are spread over translation workers
+type PipelineConfig struct {
+	Workers   int `yaml:"workers"`    // Translation workers, defaults to the number of CPUs
+	QueueSize int `yaml:"queue_size"` // Packets buffered per worker before dropping
+	TUNQueues int `yaml:"tun_queues"` // Queues per TUN device, more than 1 needs IFF_MULTI_QUEUE (Linux)
+}
+
+// DefaultPipelineConfig returns the pipeline settings used when none are configured
+func DefaultPipelineConfig() PipelineConfig {
+	return PipelineConfig{
+		Workers:   runtime.NumCPU(),
+		QueueSize: 256,
+		TUNQueues: 1,
+	}
+}
+
+// CLATConfig configures the customer-side translator of 464XLAT (RFC 6877)
+type CLATConfig struct {
+	PLATPrefix string `yaml:"plat_prefix"` // NAT64 prefix of the provider-side translator, defaults to nat64_prefix
+	IPv4       string `yaml:"ipv4"`        // Address local IPv4 applications use
+	IPv6       string `yaml:"ipv6"`        // Dedicated IPv6 address the CLAT translates it to
 }
 
+// DefaultCLATIPv4 is the CLAT address from the IPv4 Service Continuity Prefix (RFC 7335)
+const DefaultCLATIPv4 = "192.0.0.2"
+
+// DNS64Config controls the built-in DNS64 resolver
+type DNS64Config struct {
+	Enabled  bool          `yaml:"enabled"`
+	Listen   string        `yaml:"listen"`   // UDP and TCP address on the IPv6 side
+	Upstream string        `yaml:"upstream"` // Resolver queries are forwarded to
+	Exclude  []string      `yaml:"exclude"`  // IPv6 ranges of ignored AAAA records, IPv4 ranges never synthesized
+	Timeout  time.Duration `yaml:"timeout"`  // Timeout of upstream queries
+}
+
+// DefaultDNS64Config returns the DNS64 settings used when none are configured
+func DefaultDNS64Config() DNS64Config {
+	return DNS64Config{
+		Enabled:  false,
+		Listen:   "[::]:53",
+		Upstream: "8.8.8.8:53",
+		Exclude:  []string{"::ffff:0:0/96"},
+		Timeout:  2 * time.Second,
+	}
+}
+
+// EAMTEntry maps an IPv4 address or prefix onto an IPv6 address or prefix in SIIT mode
+type EAMTEntry struct {
+	IPv4 string `yaml:"ipv4"`
+	IPv6 string `yaml:"ipv6"`
+}
+
+// Translation modes
+const (
+	ModeNAT64 = "nat64"
+	ModeSIIT  = "siit"
+	ModeCLAT  = "clat"
+)
+
+// StaticBinding forwards an IPv4 pool address and port to an IPv6 host
+type StaticBinding struct {
+	Protocol string `yaml:"protocol"` // tcp or udp
+	IPv6     string `yaml:"ipv6"`
+	IPv6Port uint16 `yaml:"ipv6_port"`
+	IPv4     string `yaml:"ipv4"` // Address within pool4
+	IPv4Port uint16 `yaml:"ipv4_port"`
+}
+
+// StateConfig controls persistence of the NAT session table across restarts
+type StateConfig struct {
+	File     string        `yaml:"file"`     // Snapshot file, persistence is disabled when empty
+	Interval time.Duration `yaml:"interval"` // Time between periodic snapshots
+}
+
+// DefaultStateInterval is the snapshot interval used when none is configured
+const DefaultStateInterval = 30 * time.Second
+
+// Pool4Entry is a block of IPv4 addresses the NAT allocates source transport addresses from
+type Pool4Entry struct {
+	Prefix string `yaml:"prefix"` // IPv4 address or CIDR
+	Ports  string `yaml:"ports"`  // Port range, e.g. "1024-65535"
+}
+
+// DefaultPool4 returns the pool used when none is configured
+func DefaultPool4() []Pool4Entry {
+	return []Pool4Entry{
+		{Prefix: "10.64.0.1/32", Ports: "10000-65000"},
+	}
+}
+
+// FragmentConfig controls reassembly of fragmented packets
+type FragmentConfig struct {
+	Reassemble bool          `yaml:"reassemble"`
+	Timeout    time.Duration `yaml:"timeout"`
+	MaxBuffers int           `yaml:"max_buffers"`
+}
+
+// DefaultFragmentConfig returns the fragment settings used when none are configured
+func DefaultFragmentConfig() FragmentConfig {
+	return FragmentConfig{
+		Reassemble: true,
+		Timeout:    2 * time.Second,
+		MaxBuffers: 1024,
+	}
+}
+
+// ParseConfig loads the configuration from the file set by SetPath and the
+// environment
 func ParseConfig() (*BridgeConfig, error) {
-	data, err := os.ReadFile(DefaultConfigPath)
+	loaded, err := Load(LoadOptions{})
 	if err != nil {
 		return nil, err
 	}
+	return loaded.Config, nil
+}
 
-	var config BridgeConfig
-	err = yaml.Unmarshal(data, &config)
-	if err != nil {
-		return nil, err
+// Defaults returns the configuration used for every value that is not set
+// elsewhere. Empty values are filled in by setDefaults.
+func Defaults() *BridgeConfig {
+	return &BridgeConfig{
+		Mode:         ModeNAT64,
+		NAT64Prefix:  "64:ff9b::/96",
+		NAT64Gateway: "64:ff9b::1",
+		APIPort:      8080,
+		APIListen:    DefaultAPIListen,
+		IPv4MTU:      1500,
+		IPv6MTU:      1500,
+		Fragments:    DefaultFragmentConfig(),
+		Pool4:        DefaultPool4(),
+		Filtering:    "address-dependent",
+		State:        StateConfig{Interval: DefaultStateInterval},
+		DNS64:        DefaultDNS64Config(),
+		CLAT:         CLATConfig{IPv4: DefaultCLATIPv4},
+		Pipeline:     DefaultPipelineConfig(),
+		Timeouts:     DefaultTimeoutConfig(),
+		LogLevel:     DefaultLogLevel,
+		Logging:      DefaultLoggingConfig(),
+		Capture:      DefaultCaptureConfig(),
 	}
+}
 
-	// Set defaults if not specified
-	if config.NAT64Prefix == "" {
-		config.NAT64Prefix = "64:ff9b::/96"
+// setDefaults fills in values left empty. Validate checks the result.
+func (c *BridgeConfig) setDefaults() {
+	if c.Mode == "" {
+		c.Mode = ModeNAT64
+	}
+	if c.NAT64Prefix == "" {
+		c.NAT64Prefix = "64:ff9b::/96"
+	}
+	if c.NAT64Gateway == "" {
+		c.NAT64Gateway = "64:ff9b::1"
 	}
-	if config.NAT64Gateway == "" {
-		config.NAT64Gateway = "64:ff9b::1"
+	if c.APIPort == 0 {
+		c.APIPort = 8080
 	}
-	if config.APIPort == 0 {
-		config.APIPort = 8080
+	if c.APIListen == "" {
+		c.APIListen = DefaultAPIListen
 	}
+	if c.IPv4MTU == 0 {
+		c.IPv4MTU = 1500
+	}
+	if c.IPv6MTU == 0 {
+		c.IPv6MTU = 1500
+	}
+	if c.Fragments.Timeout == 0 {
+		c.Fragments.Timeout = DefaultFragmentConfig().Timeout
+	}
+	if c.Fragments.MaxBuffers == 0 {
+		c.Fragments.MaxBuffers = DefaultFragmentConfig().MaxBuffers
+	}
+	if len(c.Pool4) == 0 {
+		c.Pool4 = DefaultPool4()
+	}
+	if c.Filtering == "" {
+		c.Filtering = "address-dependent"
+	}
+	if c.State.Interval == 0 {
+		c.State.Interval = DefaultStateInterval
+	}
+	if c.Pool6 == "" {
+		c.Pool6 = c.NAT64Prefix
+	}
+	if c.CLAT.PLATPrefix == "" {
+		c.CLAT.PLATPrefix = c.NAT64Prefix
+	}
+	if c.CLAT.IPv4 == "" {
+		c.CLAT.IPv4 = DefaultCLATIPv4
+	}
+	if c.Pipeline.Workers <= 0 {
+		c.Pipeline.Workers = DefaultPipelineConfig().Workers
+	}
+	if c.Pipeline.QueueSize <= 0 {
+		c.Pipeline.QueueSize = DefaultPipelineConfig().QueueSize
+	}
+	if c.Pipeline.TUNQueues <= 0 {
+		c.Pipeline.TUNQueues = DefaultPipelineConfig().TUNQueues
+	}
+	if c.DNS64.Listen == "" {
+		c.DNS64.Listen = DefaultDNS64Config().Listen
+	}
+	if c.DNS64.Upstream == "" {
+		c.DNS64.Upstream = DefaultDNS64Config().Upstream
+	}
+	if len(c.DNS64.Exclude) == 0 {
+		c.DNS64.Exclude = DefaultDNS64Config().Exclude
+	}
+	if c.DNS64.Timeout == 0 {
+		c.DNS64.Timeout = DefaultDNS64Config().Timeout
+	}
+	defaults := DefaultTimeoutConfig()
+	if c.Timeouts.UDP == 0 {
+		c.Timeouts.UDP = defaults.UDP
+	}
+	if c.Timeouts.ICMP == 0 {
+		c.Timeouts.ICMP = defaults.ICMP
+	}
+	if c.Timeouts.TCPEstablished == 0 {
+		c.Timeouts.TCPEstablished = defaults.TCPEstablished
+	}
+	if c.Timeouts.TCPTransitory == 0 {
+		c.Timeouts.TCPTransitory = defaults.TCPTransitory
+	}
+	if c.LogLevel == "" {
+		c.LogLevel = DefaultLogLevel
+	}
+	if c.Logging.Format == "" {
+		c.Logging.Format = DefaultLoggingConfig().Format
+	}
+	if c.Logging.Output == "" {
+		c.Logging.Output = DefaultLoggingConfig().Output
+	}
+	if c.Capture.Dir == "" {
+		c.Capture.Dir = DefaultCaptureConfig().Dir
+	}
+	if c.Capture.MaxSize == 0 {
+		c.Capture.MaxSize = DefaultCaptureConfig().MaxSize
+	}
+}
 
-	return &config, nil
+func (c *BridgeConfig) GetMode() string {
+	return c.Mode
 }
 
 func (c *BridgeConfig) GetInterface() string {
@@ -57,12 +393,122 @@ func (c *BridgeConfig) GetAPIPort() int {
 	return c.APIPort
 }
 
+// GetAPIAddress returns the address the REST API listens on
+func (c *BridgeConfig) GetAPIAddress() string {
+	return net.JoinHostPort(c.APIListen, strconv.Itoa(c.APIPort))
+}
+
+func (c *BridgeConfig) GetAPIToken() string {
+	return c.APIToken
+}
+
+func (c *BridgeConfig) GetIPv4MTU() int {
+	return c.IPv4MTU
+}
+
+func (c *BridgeConfig) GetIPv6MTU() int {
+	return c.IPv6MTU
+}
+
+func (c *BridgeConfig) GetPool4() []Pool4Entry {
+	return c.Pool4
+}
+
+func (c *BridgeConfig) GetFiltering() string {
+	return c.Filtering
+}
+
+func (c *BridgeConfig) GetState() StateConfig {
+	return c.State
+}
+
+func (c *BridgeConfig) GetStaticBindings() []StaticBinding {
+	return c.StaticBindings
+}
+
+func (c *BridgeConfig) GetPool6() string {
+	return c.Pool6
+}
+
+func (c *BridgeConfig) GetEAMT() []EAMTEntry {
+	return c.EAMT
+}
+
+func (c *BridgeConfig) GetDNS64() DNS64Config {
+	return c.DNS64
+}
+
+func (c *BridgeConfig) GetCLAT() CLATConfig {
+	return c.CLAT
+}
+
+func (c *BridgeConfig) GetPipeline() PipelineConfig {
+	return c.Pipeline
+}
+
+func (c *BridgeConfig) GetTimeouts() TimeoutConfig {
+	return c.Timeouts
+}
+
+func (c *BridgeConfig) GetLogLevel() string {
+	return c.LogLevel
+}
+
+func (c *BridgeConfig) GetLogging() LoggingConfig {
+	return c.Logging
+}
+
+func (c *BridgeConfig) GetCapture() CaptureConfig {
+	return c.Capture
+}
+
+// LoggerOptions returns the logger settings of the configuration
+func (c *BridgeConfig) LoggerOptions() (logger.Options, error) {
+	level, err := logger.ParseLevel(c.LogLevel)
+	if err != nil {
+		return logger.Options{}, fmt.Errorf("log_level: %w", err)
+	}
+	opts := logger.Options{
+		Level:      level,
+		Levels:     make(map[string]logger.LogLevel),
+		Format:     c.Logging.Format,
+		Output:     c.Logging.Output,
+		File:       c.Logging.File,
+		MaxSize:    int64(c.Logging.MaxSize) << 20,
+		MaxBackups: c.Logging.MaxBackups,
+		SampleRate: c.Logging.SampleRate,
+	}
+	for _, name := range logger.Subsystems {
+		if c.Logging.Levels.Get(name) == "" {
+			continue
+		}
+		if opts.Levels[name], err = logger.ParseLevel(c.Logging.Levels.Get(name)); err != nil {
+			return logger.Options{}, fmt.Errorf("logging.levels.%s: %w", name, err)
+		}
+	}
+	return opts, nil
+}
+
 func CreateDefaultConfig() error {
 	config := BridgeConfig{
+		Mode:         ModeNAT64,
 		Interface:    "",
 		NAT64Prefix:  "64:ff9b::/96",
 		NAT64Gateway: "64:ff9b::1",
 		APIPort:      8080,
+		APIListen:    DefaultAPIListen,
+		IPv4MTU:      1500,
+		IPv6MTU:      1500,
+		Fragments:    DefaultFragmentConfig(),
+		Pool4:        DefaultPool4(),
+		Filtering:    "address-dependent",
+		State:        StateConfig{Interval: DefaultStateInterval},
+		DNS64:        DefaultDNS64Config(),
+		Pipeline:     PipelineConfig{QueueSize: 256, TUNQueues: 1},
+		Timeouts:     DefaultTimeoutConfig(),
+		LogLevel:     DefaultLogLevel,
+		Logging:      DefaultLoggingConfig(),
+		Capture:      DefaultCaptureConfig(),
 	}
 
 	data, err := yaml.Marshal(&config)
@@ -70,5 +516,5 @@ func CreateDefaultConfig() error {
 		return err
 	}
 
-	return os.WriteFile(DefaultConfigPath, data, 0644)
+	return os.WriteFile(Path(), data, 0644)
 }
@@ -0,0 +1,496 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mdxabu/bridge/internal/logger"
+	"github.com/mdxabu/bridge/internal/nat"
+	"github.com/mdxabu/bridge/internal/translator"
+	"gopkg.in/yaml.v3"
+)
+
+// Limits of values that have no natural bound of their own
+const (
+	maxFragmentTimeout = 60 * time.Second // RFC 8200 reassembly timeout
+	maxDNS64Timeout    = 30 * time.Second
+	minStateInterval   = time.Second
+)
+
+// Problem is a configuration value that failed validation
+type Problem struct {
+	Key      string `json:"key"`
+	Message  string `json:"message"`
+	Position string `json:"position,omitempty"` // file:line:column, environment variable or "command line"
+}
+
+// String formats the problem as "position: key: message"
+func (p Problem) String() string {
+	s := p.Message
+	if p.Key != "" {
+		s = p.Key + ": " + s
+	}
+	if p.Position != "" {
+		s = p.Position + ": " + s
+	}
+	return s
+}
+
+// ValidationError lists every problem found in a configuration
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Problems) == 1 {
+		return "invalid configuration: " + e.Problems[0].String()
+	}
+	lines := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		lines[i] = "  " + p.String()
+	}
+	return fmt.Sprintf("invalid configuration (%d problems):\n%s", len(e.Problems), strings.Join(lines, "\n"))
+}
+
+// validator collects the problems of a configuration
+type validator struct {
+	problems []Problem
+}
+
+// add records a problem with key
+func (v *validator) add(key, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{Key: key, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks a configuration with its defaults filled in. It returns a
+// *ValidationError listing every problem, or nil.
+func (c *BridgeConfig) Validate() error {
+	v := &validator{}
+
+	switch c.Mode {
+	case ModeNAT64, ModeSIIT, ModeCLAT:
+	default:
+		v.add("mode", "invalid mode %q: expected %s, %s or %s", c.Mode, ModeNAT64, ModeSIIT, ModeCLAT)
+	}
+
+	prefix, err := translator.ParseNAT64Prefix(c.NAT64Prefix)
+	if err != nil {
+		v.add("nat64_prefix", "%v", err)
+	}
+	gateway := net.ParseIP(c.NAT64Gateway)
+	switch {
+	case gateway == nil || gateway.To4() != nil:
+		v.add("nat64_gateway", "invalid IPv6 address %q", c.NAT64Gateway)
+	case prefix != nil && !prefix.Contains(gateway):
+		v.add("nat64_gateway", "%s is outside the NAT64 prefix %s", gateway, prefix)
+	}
+
+	if c.APIPort < 1 || c.APIPort > 65535 {
+		v.add("api_port", "port %d is out of range (1-65535)", c.APIPort)
+	}
+	if c.APIListen != "localhost" && net.ParseIP(c.APIListen) == nil {
+		v.add("api_listen", "invalid IP address %q", c.APIListen)
+	}
+	if c.IPv4MTU < 68 || c.IPv4MTU > 65535 {
+		v.add("ipv4_mtu", "MTU %d is out of range (68-65535)", c.IPv4MTU)
+	}
+	if c.IPv6MTU < 1280 || c.IPv6MTU > 65535 {
+		v.add("ipv6_mtu", "MTU %d is out of range (1280-65535, RFC 8200)", c.IPv6MTU)
+	}
+
+	if c.Fragments.Timeout <= 0 || c.Fragments.Timeout > maxFragmentTimeout {
+		v.add("fragments.timeout", "timeout %s is out of range (at most %s)", c.Fragments.Timeout, maxFragmentTimeout)
+	}
+	if c.Fragments.MaxBuffers < 1 {
+		v.add("fragments.max_buffers", "must be at least 1")
+	}
+
+	pool := v.pool4(c.Pool4)
+
+	if _, err := nat.ParseFilteringMode(c.Filtering); err != nil {
+		v.add("filtering", "%v (endpoint-independent, address-dependent or address-and-port-dependent)", err)
+	}
+	if c.State.Interval < minStateInterval {
+		v.add("state.interval", "interval %s is shorter than %s", c.State.Interval, minStateInterval)
+	}
+
+	for _, t := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"timeouts.udp", c.Timeouts.UDP},
+		{"timeouts.icmp", c.Timeouts.ICMP},
+		{"timeouts.tcp_established", c.Timeouts.TCPEstablished},
+		{"timeouts.tcp_transitory", c.Timeouts.TCPTransitory},
+	} {
+		if t.value < time.Second {
+			v.add(t.key, "timeout %s is shorter than 1s", t.value)
+		}
+	}
+	if c.Timeouts.TCPTransitory > c.Timeouts.TCPEstablished {
+		v.add("timeouts.tcp_transitory", "timeout %s is longer than timeouts.tcp_established (%s)", c.Timeouts.TCPTransitory, c.Timeouts.TCPEstablished)
+	}
+
+	if _, err := logger.ParseLevel(c.LogLevel); err != nil {
+		v.add("log_level", "%v", err)
+	}
+	v.logging(c.Logging)
+	if c.Capture.MaxSize < 0 {
+		v.add("capture.max_size", "size %d is negative", c.Capture.MaxSize)
+	}
+
+	if c.Mode == ModeNAT64 {
+		v.staticBindings(c.StaticBindings, pool)
+	}
+
+	// pool6 and plat_prefix default to nat64_prefix, which is checked above
+	if c.Mode == ModeSIIT {
+		if c.Pool6 != c.NAT64Prefix {
+			if _, err := translator.ParseNAT64Prefix(c.Pool6); err != nil {
+				v.add("pool6", "%v", err)
+			}
+		}
+		v.eamt(c.EAMT)
+	}
+	if c.Mode == ModeCLAT {
+		v.clat(c.CLAT, c.NAT64Prefix)
+	}
+
+	if c.DNS64.Enabled {
+		v.dns64(c.DNS64)
+	}
+
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: v.problems}
+}
+
+// pool4 checks the pool4 ranges and returns the pool they form, or nil
+func (v *validator) pool4(entries []Pool4Entry) *nat.Pool4 {
+	var ranges []nat.Pool4Range
+	valid := true
+	for i, entry := range entries {
+		key := fmt.Sprintf("pool4[%d]", i)
+		r, err := nat.ParsePool4Range(entry.Prefix, entry.Ports)
+		if err != nil {
+			v.add(key, "%v", err)
+			valid = false
+			continue
+		}
+		for j, other := range ranges {
+			if r.Network.Contains(other.Network.IP) || other.Network.Contains(r.Network.IP) {
+				v.add(key, "%s overlaps pool4[%d] (%s)", r.Network, j, other.Network)
+				valid = false
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	if !valid {
+		return nil
+	}
+
+	pool, err := nat.NewPool4(ranges)
+	if err != nil {
+		v.add("pool4", "%v", err)
+		return nil
+	}
+	return pool
+}
+
+// staticBindings checks the static bindings against pool4
+func (v *validator) staticBindings(bindings []StaticBinding, pool *nat.Pool4) {
+	type transport struct {
+		protocol uint8
+		ip       string
+		port     uint16
+	}
+	seen := make(map[transport]int)
+
+	for i, b := range bindings {
+		key := fmt.Sprintf("static_bindings[%d]", i)
+
+		protocol, err := nat.ParseProtocol(b.Protocol)
+		if err != nil || (protocol != 6 && protocol != 17) {
+			v.add(key+".protocol", "invalid protocol %q (tcp or udp)", b.Protocol)
+		}
+		if ip := net.ParseIP(b.IPv6); ip == nil || ip.To4() != nil {
+			v.add(key+".ipv6", "invalid IPv6 address %q", b.IPv6)
+		}
+		if b.IPv6Port == 0 {
+			v.add(key+".ipv6_port", "port must not be zero")
+		}
+		if b.IPv4Port == 0 {
+			v.add(key+".ipv4_port", "port must not be zero")
+		}
+
+		ip := net.ParseIP(b.IPv4).To4()
+		switch {
+		case ip == nil:
+			v.add(key+".ipv4", "invalid IPv4 address %q", b.IPv4)
+			continue
+		case pool != nil && !pool.ContainsAddress(ip):
+			v.add(key+".ipv4", "%s is not in pool4 (%s)", ip, pool)
+		}
+
+		t := transport{protocol: protocol, ip: ip.String(), port: b.IPv4Port}
+		if j, ok := seen[t]; ok {
+			v.add(key, "%s port %d is already bound by static_bindings[%d]", ip, b.IPv4Port, j)
+		}
+		seen[t] = i
+	}
+}
+
+// eamt checks the Explicit Address Mapping Table
+func (v *validator) eamt(entries []EAMTEntry) {
+	var parsed []translator.EAMEntry
+	for i, e := range entries {
+		key := fmt.Sprintf("eamt[%d]", i)
+		entry, err := translator.ParseEAMEntry(e.IPv4, e.IPv6)
+		if err != nil {
+			v.add(key, "%v", err)
+			continue
+		}
+		for j, other := range parsed {
+			if entry.IPv4.String() == other.IPv4.String() || entry.IPv6.String() == other.IPv6.String() {
+				v.add(key, "%s duplicates a prefix of eamt[%d] (%s)", entry, j, other)
+			}
+		}
+		parsed = append(parsed, entry)
+	}
+}
+
+// clat checks the 464XLAT customer-side addresses
+func (v *validator) clat(c CLATConfig, nat64Prefix string) {
+	// An invalid nat64_prefix the PLAT prefix defaults to is reported once
+	plat, err := translator.ParseNAT64Prefix(c.PLATPrefix)
+	if err != nil && c.PLATPrefix != nat64Prefix {
+		v.add("clat.plat_prefix", "%v", err)
+	}
+
+	if ip := net.ParseIP(c.IPv4); ip == nil || ip.To4() == nil {
+		v.add("clat.ipv4", "invalid IPv4 address %q", c.IPv4)
+	}
+	ip := net.ParseIP(c.IPv6)
+	switch {
+	case c.IPv6 == "":
+		v.add("clat.ipv6", "must be set in %s mode", ModeCLAT)
+	case ip == nil || ip.To4() != nil:
+		v.add("clat.ipv6", "invalid IPv6 address %q", c.IPv6)
+	case plat != nil && plat.Contains(ip):
+		v.add("clat.ipv6", "%s lies within the PLAT prefix %s", ip, plat)
+	}
+}
+
+// dns64 checks the DNS64 resolver settings
+func (v *validator) dns64(c DNS64Config) {
+	if err := checkHostPort(c.Listen); err != nil {
+		v.add("dns64.listen", "%v", err)
+	}
+
+	upstream := c.Upstream
+	if _, _, err := net.SplitHostPort(upstream); err != nil {
+		upstream = net.JoinHostPort(strings.Trim(upstream, "[]"), "53")
+	}
+	if err := checkHostPort(upstream); err != nil {
+		v.add("dns64.upstream", "%v", err)
+	}
+
+	for i, entry := range c.Exclude {
+		if _, _, err := net.ParseCIDR(entry); err != nil {
+			v.add(fmt.Sprintf("dns64.exclude[%d]", i), "invalid prefix %q", entry)
+		}
+	}
+	if c.Timeout <= 0 || c.Timeout > maxDNS64Timeout {
+		v.add("dns64.timeout", "timeout %s is out of range (at most %s)", c.Timeout, maxDNS64Timeout)
+	}
+}
+
+// logging checks the log output and the subsystem levels
+func (v *validator) logging(c LoggingConfig) {
+	switch c.Format {
+	case logger.FormatText, logger.FormatJSON:
+	default:
+		v.add("logging.format", "unknown format %q (text or json)", c.Format)
+	}
+
+	switch c.Output {
+	case logger.OutputStdout, logger.OutputStderr, logger.OutputSyslog, logger.OutputJournald:
+	case logger.OutputFile:
+		if c.File == "" {
+			v.add("logging.output", "output file needs logging.file")
+		}
+	default:
+		v.add("logging.output", "unknown output %q (stdout, stderr, file, syslog or journald)", c.Output)
+	}
+
+	if c.MaxSize < 0 {
+		v.add("logging.max_size", "size %d is negative", c.MaxSize)
+	}
+	if c.MaxBackups < 0 {
+		v.add("logging.max_backups", "count %d is negative", c.MaxBackups)
+	}
+	if c.SampleRate < 0 {
+		v.add("logging.sample_rate", "rate %d is negative", c.SampleRate)
+	}
+
+	for _, name := range logger.Subsystems {
+		level := c.Levels.Get(name)
+		if level == "" {
+			continue
+		}
+		if _, err := logger.ParseLevel(level); err != nil {
+			v.add("logging.levels."+name, "%v", err)
+		}
+	}
+}
+
+// checkHostPort checks a "host:port" address with a numeric port
+func checkHostPort(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: expected host:port", addr)
+	}
+	if host == "" && strings.HasPrefix(addr, "[") {
+		return fmt.Errorf("invalid address %q: empty host", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port in address %q", addr)
+	}
+	return nil
+}
+
+// fileIndex records where each key of a configuration file is written
+type fileIndex struct {
+	path      string
+	positions map[string]string // Dotted key, with [i] for list items, to file:line:column
+	problems  []Problem         // Unknown keys
+}
+
+// indexFile walks the YAML tree of a configuration file alongside the
+// configuration type, recording key positions and keys that do not exist
+func indexFile(path string, root *yaml.Node) *fileIndex {
+	idx := &fileIndex{path: path, positions: make(map[string]string)}
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		idx.walk(root.Content[0], reflect.TypeOf(BridgeConfig{}), "")
+	}
+	return idx
+}
+
+// position formats the position of a node
+func (idx *fileIndex) position(node *yaml.Node) string {
+	return fmt.Sprintf("%s:%d:%d", idx.path, node.Line, node.Column)
+}
+
+// walk indexes node, which holds a value of type t under prefix
+func (idx *fileIndex) walk(node *yaml.Node, t reflect.Type, prefix string) {
+	switch {
+	case node.Kind == yaml.MappingNode && t.Kind() == reflect.Struct:
+		names := yamlNames(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyNode, valueNode := node.Content[i], node.Content[i+1]
+			key := prefix + keyNode.Value
+			fieldType, ok := names[keyNode.Value]
+			if !ok {
+				idx.problems = append(idx.problems, Problem{
+					Key:      key,
+					Message:  unknownKeyMessage(keyNode.Value, names),
+					Position: idx.position(keyNode),
+				})
+				continue
+			}
+			idx.positions[key] = idx.position(keyNode)
+			idx.walk(valueNode, fieldType, key+".")
+		}
+	case node.Kind == yaml.SequenceNode && t.Kind() == reflect.Slice:
+		base := strings.TrimSuffix(prefix, ".")
+		for i, item := range node.Content {
+			key := fmt.Sprintf("%s[%d]", base, i)
+			idx.positions[key] = idx.position(item)
+			idx.walk(item, t.Elem(), key+".")
+		}
+	}
+}
+
+// locate returns the position of key, or of the closest enclosing key
+func (idx *fileIndex) locate(key string) (string, bool) {
+	for {
+		if pos, ok := idx.positions[key]; ok {
+			return pos, true
+		}
+		i := strings.LastIndexAny(key, ".[")
+		if i < 0 {
+			return "", false
+		}
+		key = key[:i]
+	}
+}
+
+// yamlNames maps the YAML names of a struct type's fields to their types
+func yamlNames(t reflect.Type) map[string]reflect.Type {
+	names := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name != "" && name != "-" {
+			names[name] = t.Field(i).Type
+		}
+	}
+	return names
+}
+
+// unknownKeyMessage reports an unknown key, suggesting a known key that is
+// only a typo away
+func unknownKeyMessage(name string, names map[string]reflect.Type) string {
+	best, bestDistance := "", 3
+	for known := range names {
+		if d := editDistance(name, known); d < bestDistance || (d == bestDistance && known < best) {
+			best, bestDistance = known, d
+		}
+	}
+	if best != "" {
+		return fmt.Sprintf("unknown key %q, did you mean %q?", name, best)
+	}
+	return fmt.Sprintf("unknown key %q", name)
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	row := make([]int, len(b)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		prev := row[0]
+		row[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			prev, row[j] = row[j], min(row[j]+1, row[j-1]+1, prev+cost)
+		}
+	}
+	return row[len(b)]
+}
+
+// addTypeErrors records the errors of decoding a file as problems, naming the
+// key written on the line of each error
+func (idx *fileIndex) addTypeErrors(err *yaml.TypeError) {
+	for _, msg := range err.Errors {
+		p := Problem{Message: msg, Position: idx.path}
+		if line, rest, ok := strings.Cut(strings.TrimPrefix(msg, "line "), ": "); ok && line != msg {
+			p.Message = rest
+			p.Position = idx.path + ":" + line
+			for key, pos := range idx.positions {
+				if strings.HasPrefix(pos, p.Position+":") && (len(key) > len(p.Key) || len(key) == len(p.Key) && key < p.Key) {
+					p.Key, p.Position = key, pos
+				}
+			}
+		}
+		idx.problems = append(idx.problems, p)
+	}
+}
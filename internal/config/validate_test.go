@@ -0,0 +1,125 @@
+package config
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *BridgeConfig)
+		keys   []string // Keys of the expected problems
+	}{
+		{"defaults", func(c *BridgeConfig) {}, nil},
+		{"mode", func(c *BridgeConfig) { c.Mode = "nat46" }, []string{"mode"}},
+		{"prefix length", func(c *BridgeConfig) { c.NAT64Prefix = "64:ff9b::/80" }, []string{"nat64_prefix"}},
+		{"gateway outside prefix", func(c *BridgeConfig) { c.NAT64Gateway = "2001:db8::1" }, []string{"nat64_gateway"}},
+		{"api listen", func(c *BridgeConfig) { c.APIListen = "example.com" }, []string{"api_listen"}},
+		{"ipv6 mtu", func(c *BridgeConfig) { c.IPv6MTU = 1279 }, []string{"ipv6_mtu"}},
+		{"fragment timeout", func(c *BridgeConfig) { c.Fragments.Timeout = 2 * time.Minute }, []string{"fragments.timeout"}},
+		{"overlapping pool4", func(c *BridgeConfig) {
+			c.Pool4 = []Pool4Entry{{Prefix: "192.0.2.0/24"}, {Prefix: "192.0.2.128/25"}}
+		}, []string{"pool4[1]"}},
+		{"static binding outside pool4", func(c *BridgeConfig) {
+			c.StaticBindings = []StaticBinding{{Protocol: "tcp", IPv6: "2001:db8::1", IPv6Port: 80, IPv4: "203.0.113.1", IPv4Port: 80}}
+		}, []string{"static_bindings[0].ipv4"}},
+		{"duplicate static binding", func(c *BridgeConfig) {
+			b := StaticBinding{Protocol: "udp", IPv6: "2001:db8::1", IPv6Port: 53, IPv4: "10.64.0.1", IPv4Port: 53}
+			c.StaticBindings = []StaticBinding{b, b}
+		}, []string{"static_bindings[1]"}},
+		{"transitory timeout", func(c *BridgeConfig) { c.Timeouts.TCPTransitory = 3 * time.Hour }, []string{"timeouts.tcp_transitory"}},
+		{"log level", func(c *BridgeConfig) { c.LogLevel = "verbose" }, []string{"log_level"}},
+		{"log file", func(c *BridgeConfig) { c.Logging.Output = "file" }, []string{"logging.output"}},
+		{"clat without ipv6", func(c *BridgeConfig) { c.Mode = ModeCLAT }, []string{"clat.ipv6"}},
+		{"dns64 exclude", func(c *BridgeConfig) {
+			c.DNS64.Enabled = true
+			c.DNS64.Exclude = []string{"::ffff:0:0/96", "bogus"}
+		}, []string{"dns64.exclude[1]"}},
+		{"dns64 disabled", func(c *BridgeConfig) { c.DNS64.Exclude = []string{"bogus"} }, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Defaults()
+			c.setDefaults()
+			tt.modify(c)
+
+			var keys []string
+			if err := c.Validate(); err != nil {
+				invalid, ok := err.(*ValidationError)
+				if !ok {
+					t.Fatalf("Validate error = %v, want a *ValidationError", err)
+				}
+				for _, p := range invalid.Problems {
+					keys = append(keys, p.Key)
+				}
+			}
+			if !slices.Equal(keys, tt.keys) {
+				t.Fatalf("problems with %v, want %v", keys, tt.keys)
+			}
+		})
+	}
+}
+
+func TestValidationErrorString(t *testing.T) {
+	one := &ValidationError{Problems: []Problem{
+		{Key: "ipv6_mtu", Message: "MTU 1000 is out of range", Position: "bridge.yaml:2:1"},
+	}}
+	if got, want := one.Error(), "invalid configuration: bridge.yaml:2:1: ipv6_mtu: MTU 1000 is out of range"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+
+	two := &ValidationError{Problems: []Problem{
+		{Key: "ipv6_mtu", Message: "MTU 1000 is out of range", Position: "BRIDGE_IPV6_MTU"},
+		{Message: "did not find expected key", Position: "bridge.yaml:3"},
+	}}
+	want := "invalid configuration (2 problems):\n" +
+		"  BRIDGE_IPV6_MTU: ipv6_mtu: MTU 1000 is out of range\n" +
+		"  bridge.yaml:3: did not find expected key"
+	if got := two.Error(); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
+
+func TestUnknownKeyMessage(t *testing.T) {
+	names := yamlNames(reflect.TypeOf(DNS64Config{}))
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"upstrem", `unknown key "upstrem", did you mean "upstream"?`},
+		{"enable", `unknown key "enable", did you mean "enabled"?`},
+		{"timout", `unknown key "timout", did you mean "timeout"?`},
+		{"resolver", `unknown key "resolver"`},
+	}
+
+	for _, tt := range tests {
+		if got := unknownKeyMessage(tt.name, names); got != tt.want {
+			t.Errorf("unknownKeyMessage(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"mode", "mode", 0},
+		{"mode", "mdoe", 2},
+		{"pool4", "pool6", 1},
+		{"", "mtu", 3},
+		{"kitten", "sitting", 3},
+	}
+
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
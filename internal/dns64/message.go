@@ -0,0 +1,360 @@
+// Package dns64 implements a DNS64 resolver (RFC 6147) that forwards queries
+// to an upstream resolver and synthesizes AAAA records from A records using
+// the bridge's NAT64 prefix.
+package dns64
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// Record types
+const (
+	typeA     = 1
+	typeNS    = 2
+	typeCNAME = 5
+	typeSOA   = 6
+	typePTR   = 12
+	typeMX    = 15
+	typeAAAA  = 28
+	typeSRV   = 33
+	typeDNAME = 39
+	typeOPT   = 41
+)
+
+const classINET = 1
+
+// Header flags and response codes
+const (
+	flagQR = 1 << 15
+	flagTC = 1 << 9
+	flagRD = 1 << 8
+	flagAD = 1 << 5
+	flagCD = 1 << 4
+
+	rcodeMask          = 0x000f
+	rcodeSuccess       = 0
+	rcodeFormatError   = 1
+	rcodeServerFailure = 2
+)
+
+const (
+	headerLen     = 12
+	maxNameLen    = 255
+	maxPointers   = 64
+	minUDPSize    = 512
+	maxUDPSize    = 4096
+	optDNSSECFlag = 1 << 15 // DO bit in the OPT record TTL
+)
+
+var errMalformed = errors.New("malformed DNS message")
+
+// question is an entry of the question section
+type question struct {
+	Name  []byte // Uncompressed wire format
+	Type  uint16
+	Class uint16
+}
+
+// record is a resource record. Names inside the data of well-known types are
+// stored uncompressed so the record can be packed into another message.
+type record struct {
+	Name  []byte // Uncompressed wire format
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	Data  []byte
+}
+
+// message is a parsed DNS message
+type message struct {
+	ID         uint16
+	Flags      uint16
+	Questions  []question
+	Answers    []record
+	Authority  []record
+	Additional []record
+}
+
+// rcode returns the response code
+func (m *message) rcode() int {
+	return int(m.Flags & rcodeMask)
+}
+
+// opt returns the EDNS OPT record, if present
+func (m *message) opt() *record {
+	for i := range m.Additional {
+		if m.Additional[i].Type == typeOPT {
+			return &m.Additional[i]
+		}
+	}
+	return nil
+}
+
+// udpSize returns the largest UDP response the sender of m accepts
+func (m *message) udpSize() int {
+	opt := m.opt()
+	if opt == nil {
+		return minUDPSize
+	}
+	size := int(opt.Class)
+	if size < minUDPSize {
+		return minUDPSize
+	}
+	if size > maxUDPSize {
+		return maxUDPSize
+	}
+	return size
+}
+
+// dnssecOK reports whether the sender of m set the EDNS DO bit
+func (m *message) dnssecOK() bool {
+	opt := m.opt()
+	return opt != nil && opt.TTL&optDNSSECFlag != 0
+}
+
+// parseMessage parses a DNS message
+func parseMessage(b []byte) (*message, error) {
+	if len(b) < headerLen {
+		return nil, errMalformed
+	}
+
+	m := &message{
+		ID:    binary.BigEndian.Uint16(b[0:2]),
+		Flags: binary.BigEndian.Uint16(b[2:4]),
+	}
+	qdCount := int(binary.BigEndian.Uint16(b[4:6]))
+	counts := [3]int{
+		int(binary.BigEndian.Uint16(b[6:8])),
+		int(binary.BigEndian.Uint16(b[8:10])),
+		int(binary.BigEndian.Uint16(b[10:12])),
+	}
+
+	off := headerLen
+	for i := 0; i < qdCount; i++ {
+		name, next, err := readName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(b) {
+			return nil, errMalformed
+		}
+		m.Questions = append(m.Questions, question{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(b[next : next+2]),
+			Class: binary.BigEndian.Uint16(b[next+2 : next+4]),
+		})
+		off = next + 4
+	}
+
+	sections := [3]*[]record{&m.Answers, &m.Authority, &m.Additional}
+	for i, section := range sections {
+		for j := 0; j < counts[i]; j++ {
+			rr, next, err := readRecord(b, off)
+			if err != nil {
+				return nil, err
+			}
+			*section = append(*section, rr)
+			off = next
+		}
+	}
+
+	return m, nil
+}
+
+// readRecord reads the resource record at off
+func readRecord(b []byte, off int) (record, int, error) {
+	name, off, err := readName(b, off)
+	if err != nil {
+		return record{}, 0, err
+	}
+	if off+10 > len(b) {
+		return record{}, 0, errMalformed
+	}
+
+	rr := record{
+		Name:  name,
+		Type:  binary.BigEndian.Uint16(b[off : off+2]),
+		Class: binary.BigEndian.Uint16(b[off+2 : off+4]),
+		TTL:   binary.BigEndian.Uint32(b[off+4 : off+8]),
+	}
+	length := int(binary.BigEndian.Uint16(b[off+8 : off+10]))
+	off += 10
+	if off+length > len(b) {
+		return record{}, 0, errMalformed
+	}
+
+	rr.Data, err = expandData(b, rr.Type, off, length)
+	if err != nil {
+		return record{}, 0, err
+	}
+	return rr, off + length, nil
+}
+
+// expandData returns record data with compressed names expanded. Only the
+// types of RFC 1035 and RFC 2782 may use compression.
+func expandData(b []byte, typ uint16, off, length int) ([]byte, error) {
+	end := off + length
+
+	// Number of fixed bytes before and after the embedded names
+	var prefix, names, suffix int
+	switch typ {
+	case typeNS, typeCNAME, typePTR, typeDNAME:
+		names = 1
+	case typeMX:
+		prefix, names = 2, 1
+	case typeSRV:
+		prefix, names = 6, 1
+	case typeSOA:
+		names, suffix = 2, 20
+	default:
+		return append([]byte(nil), b[off:end]...), nil
+	}
+
+	if off+prefix > end {
+		return nil, errMalformed
+	}
+	data := append([]byte(nil), b[off:off+prefix]...)
+	off += prefix
+
+	for i := 0; i < names; i++ {
+		name, next, err := readName(b, off)
+		if err != nil || next > end {
+			return nil, errMalformed
+		}
+		data = append(data, name...)
+		off = next
+	}
+
+	if off+suffix != end {
+		return nil, errMalformed
+	}
+	return append(data, b[off:end]...), nil
+}
+
+// readName reads a possibly compressed name at off. It returns the name in
+// uncompressed wire format and the offset following it in b.
+func readName(b []byte, off int) ([]byte, int, error) {
+	var name []byte
+	next := -1
+	pointers := 0
+
+	for {
+		if off >= len(b) {
+			return nil, 0, errMalformed
+		}
+		length := int(b[off])
+
+		switch length & 0xc0 {
+		case 0x00:
+			if off+1+length > len(b) || len(name)+1+length > maxNameLen {
+				return nil, 0, errMalformed
+			}
+			name = append(name, b[off:off+1+length]...)
+			off += 1 + length
+			if length == 0 {
+				if next < 0 {
+					next = off
+				}
+				return name, next, nil
+			}
+		case 0xc0:
+			if off+2 > len(b) || pointers >= maxPointers {
+				return nil, 0, errMalformed
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:off+2]) & 0x3fff)
+			pointers++
+		default:
+			return nil, 0, errMalformed
+		}
+	}
+}
+
+// pack encodes the message without name compression
+func (m *message) pack() []byte {
+	b := make([]byte, headerLen, minUDPSize)
+	binary.BigEndian.PutUint16(b[0:2], m.ID)
+	binary.BigEndian.PutUint16(b[2:4], m.Flags)
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(m.Questions)))
+	binary.BigEndian.PutUint16(b[6:8], uint16(len(m.Answers)))
+	binary.BigEndian.PutUint16(b[8:10], uint16(len(m.Authority)))
+	binary.BigEndian.PutUint16(b[10:12], uint16(len(m.Additional)))
+
+	for _, q := range m.Questions {
+		b = append(b, q.Name...)
+		b = binary.BigEndian.AppendUint16(b, q.Type)
+		b = binary.BigEndian.AppendUint16(b, q.Class)
+	}
+
+	for _, section := range [][]record{m.Answers, m.Authority, m.Additional} {
+		for _, rr := range section {
+			b = append(b, rr.Name...)
+			b = binary.BigEndian.AppendUint16(b, rr.Type)
+			b = binary.BigEndian.AppendUint16(b, rr.Class)
+			b = binary.BigEndian.AppendUint32(b, rr.TTL)
+			b = binary.BigEndian.AppendUint16(b, uint16(len(rr.Data)))
+			b = append(b, rr.Data...)
+		}
+	}
+
+	return b
+}
+
+// reply returns an empty response to m with the given response code
+func (m *message) reply(rcode int) *message {
+	return &message{
+		ID:        m.ID,
+		Flags:     flagQR | m.Flags&flagRD | uint16(rcode),
+		Questions: m.Questions,
+	}
+}
+
+// truncated returns the response reduced to its question with TC set, for
+// answers too large for the client's UDP buffer
+func (m *message) truncated() *message {
+	return &message{
+		ID:        m.ID,
+		Flags:     m.Flags | flagTC,
+		Questions: m.Questions,
+	}
+}
+
+// sameName compares two wire format names, ignoring ASCII case
+func sameName(a, b []byte) bool {
+	// Label lengths are at most 63, below any letter, so folding is safe
+	return bytes.EqualFold(a, b)
+}
+
+// nameLabels splits a wire format name into its labels
+func nameLabels(name []byte) []string {
+	var labels []string
+	for off := 0; off < len(name) && name[off] != 0; {
+		length := int(name[off])
+		if off+1+length > len(name) {
+			break
+		}
+		labels = append(labels, string(name[off+1:off+1+length]))
+		off += 1 + length
+	}
+	return labels
+}
+
+// nameString formats a wire format name for logging
+func nameString(name []byte) string {
+	return strings.Join(nameLabels(name), ".") + "."
+}
+
+// encodeName encodes dot-separated labels in wire format
+func encodeName(labels ...string) []byte {
+	var name []byte
+	for _, label := range labels {
+		name = append(name, byte(len(label)))
+		name = append(name, label...)
+	}
+	return append(name, 0)
+}
@@ -0,0 +1,129 @@
+package dns64
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestPackParseRoundTrip(t *testing.T) {
+	name := encodeName("www", "example", "com")
+	m := &message{
+		ID:        0xbeef,
+		Flags:     flagQR | flagRD,
+		Questions: []question{{Name: name, Type: typeAAAA, Class: classINET}},
+		Answers: []record{
+			{Name: name, Type: typeCNAME, Class: classINET, TTL: 60, Data: encodeName("web", "example", "com")},
+			{Name: encodeName("web", "example", "com"), Type: typeAAAA, Class: classINET, TTL: 300, Data: net.ParseIP("2001:db8::1")},
+		},
+		Authority:  []record{{Name: encodeName("example", "com"), Type: typeNS, Class: classINET, TTL: 3600, Data: encodeName("ns", "example", "com")}},
+		Additional: []record{{Name: encodeName(), Type: typeOPT, Class: 1232, TTL: optDNSSECFlag}},
+	}
+
+	parsed, err := parseMessage(m.pack())
+	if err != nil {
+		t.Fatalf("parseMessage: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, m) {
+		t.Fatalf("parseMessage(pack()) = %+v, want %+v", parsed, m)
+	}
+	if parsed.udpSize() != 1232 || !parsed.dnssecOK() {
+		t.Fatalf("udpSize %d dnssecOK %v, want 1232 and true", parsed.udpSize(), parsed.dnssecOK())
+	}
+}
+
+func TestParseCompressedNames(t *testing.T) {
+	// Header with one question and one MX answer whose names point back at it
+	b := []byte{0x12, 0x34, 0x81, 0x80, 0, 1, 0, 1, 0, 0, 0, 0}
+	b = append(b, encodeName("example", "com")...) // Offset 12
+	b = append(b, 0, typeMX, 0, classINET)
+	b = append(b, 0xc0, 12)                        // Owner name: pointer to example.com
+	b = append(b, 0, typeMX, 0, classINET)         // Type and class
+	b = append(b, 0, 0, 0x0e, 0x10)                // TTL 3600
+	b = append(b, 0, 9)                            // Data length
+	b = append(b, 0, 10)                           // Preference
+	b = append(b, 4, 'm', 'a', 'i', 'l', 0xc0, 12) // mail.example.com
+
+	m, err := parseMessage(b)
+	if err != nil {
+		t.Fatalf("parseMessage: %v", err)
+	}
+	if len(m.Answers) != 1 {
+		t.Fatalf("parsed %d answers, want 1", len(m.Answers))
+	}
+
+	rr := m.Answers[0]
+	if !bytes.Equal(rr.Name, encodeName("example", "com")) || rr.TTL != 3600 {
+		t.Fatalf("answer %s TTL %d, want example.com. TTL 3600", nameString(rr.Name), rr.TTL)
+	}
+	wantData := append([]byte{0, 10}, encodeName("mail", "example", "com")...)
+	if !bytes.Equal(rr.Data, wantData) {
+		t.Fatalf("MX data = %x, want %x", rr.Data, wantData)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	header := func(qd, an uint16) []byte {
+		b := make([]byte, headerLen)
+		binary.BigEndian.PutUint16(b[4:6], qd)
+		binary.BigEndian.PutUint16(b[6:8], an)
+		return b
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"short header", []byte{0, 1, 0, 0}},
+		{"missing question", header(1, 0)},
+		{"truncated question", append(header(1, 0), encodeName("example", "com")...)},
+		{"pointer loop", append(header(1, 0), 0xc0, 12, 0, 1, 0, 1)},
+		{"pointer out of range", append(header(1, 0), 0xc0, 0xff, 0, 1, 0, 1)},
+		{"reserved label type", append(header(1, 0), 0x40, 0, 1, 0, 1)},
+		{"record data beyond message", append(append(header(0, 1), 0, 0, typeA, 0, 1, 0, 0, 0, 0, 0, 8), 1, 2, 3, 4)},
+		{"CNAME data longer than name", append(append(header(0, 1), 0, 0, typeCNAME, 0, 1, 0, 0, 0, 0, 0, 2), 0, 0)},
+	}
+
+	for _, tt := range tests {
+		if m, err := parseMessage(tt.data); err == nil {
+			t.Errorf("%s: parseMessage = %+v, want error", tt.name, m)
+		}
+	}
+}
+
+func TestParseIP6Arpa(t *testing.T) {
+	ip := net.ParseIP("64:ff9b::c000:221")
+	got, ok := parseIP6Arpa(ip6ArpaName(ip))
+	if !ok || !got.Equal(ip) {
+		t.Fatalf("parseIP6Arpa = %s, %v, want %s", got, ok, ip)
+	}
+
+	for _, name := range [][]byte{
+		encodeName("1", "ip6", "arpa"),
+		encodeName("4", "3", "2", "1", "in-addr", "arpa"),
+		append(encodeName("10")[:3], ip6ArpaName(ip)[2:]...), // Two-digit nibble
+	} {
+		if ip, ok := parseIP6Arpa(name); ok {
+			t.Errorf("parseIP6Arpa(%s) = %s, want failure", nameString(name), ip)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	m := &message{
+		ID:        7,
+		Flags:     flagQR,
+		Questions: []question{{Name: encodeName("example", "com"), Type: typeA, Class: classINET}},
+		Answers:   []record{{Name: encodeName("example", "com"), Type: typeA, Class: classINET, TTL: 60, Data: []byte{192, 0, 2, 1}}},
+	}
+
+	truncated, err := parseMessage(truncate(m.pack()))
+	if err != nil {
+		t.Fatalf("parseMessage: %v", err)
+	}
+	if truncated.Flags&flagTC == 0 || len(truncated.Answers) != 0 || len(truncated.Questions) != 1 {
+		t.Fatalf("truncated message %+v, want TC set and the question only", truncated)
+	}
+}
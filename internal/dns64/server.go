@@ -0,0 +1,622 @@
+package dns64
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdxabu/bridge/internal/logger"
+	"github.com/mdxabu/bridge/internal/translator"
+)
+
+// Loggers of the resolver. Messages about single queries are sampled.
+var (
+	dnsLog   = logger.For(logger.DNS64)
+	queryLog = logger.For(logger.DNS64).Sampled()
+)
+
+// DefaultExclude is used when no exclusion ranges are configured. AAAA
+// records of IPv4-mapped addresses are never returned (RFC 6147 section 5.1.4).
+var DefaultExclude = []string{"::ffff:0:0/96"}
+
+// tcpIdleTimeout bounds how long a TCP client may stay idle between queries
+const tcpIdleTimeout = 10 * time.Second
+
+// Config holds the settings of the DNS64 server
+type Config struct {
+	Listen   string        // Address for both UDP and TCP
+	Upstream string        // Resolver queries are forwarded to, port 53 if omitted
+	Prefix   string        // NAT64 prefix used for synthesis
+	Exclude  []string      // IPv6 ranges of ignored AAAA records, IPv4 ranges never synthesized
+	Timeout  time.Duration // Timeout of upstream queries
+}
+
+// Server is a DNS64 resolver listening on UDP and TCP
+type Server struct {
+	listen   string
+	upstream string
+	prefix   string
+	exclude4 []*net.IPNet
+	exclude6 []*net.IPNet
+	timeout  time.Duration
+
+	mu       sync.Mutex
+	udp      net.PacketConn
+	tcp      net.Listener
+	closed   bool
+	inflight sync.WaitGroup
+}
+
+// NewServer creates a DNS64 server
+func NewServer(cfg Config) (*Server, error) {
+	if _, err := translator.ParseNAT64Prefix(cfg.Prefix); err != nil {
+		return nil, err
+	}
+	if cfg.Upstream == "" {
+		return nil, fmt.Errorf("DNS64 upstream resolver is not configured")
+	}
+
+	upstream := cfg.Upstream
+	if _, _, err := net.SplitHostPort(upstream); err != nil {
+		upstream = net.JoinHostPort(strings.Trim(upstream, "[]"), "53")
+	}
+
+	s := &Server{
+		listen:   cfg.Listen,
+		upstream: upstream,
+		prefix:   cfg.Prefix,
+		timeout:  cfg.Timeout,
+	}
+	if s.timeout <= 0 {
+		s.timeout = 2 * time.Second
+	}
+
+	exclude := cfg.Exclude
+	if len(exclude) == 0 {
+		exclude = DefaultExclude
+	}
+	for _, entry := range exclude {
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DNS64 exclusion range %q", entry)
+		}
+		if len(ipNet.IP) == net.IPv4len {
+			s.exclude4 = append(s.exclude4, ipNet)
+		} else {
+			s.exclude6 = append(s.exclude6, ipNet)
+		}
+	}
+
+	return s, nil
+}
+
+// Start listens on UDP and TCP and blocks until the server is stopped. It
+// returns nil after a call to Stop.
+func (s *Server) Start() error {
+	udp, err := net.ListenPacket("udp", s.listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on UDP %s: %w", s.listen, err)
+	}
+	tcp, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		udp.Close()
+		return fmt.Errorf("failed to listen on TCP %s: %w", s.listen, err)
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		udp.Close()
+		tcp.Close()
+		return nil
+	}
+	s.udp, s.tcp = udp, tcp
+	s.mu.Unlock()
+
+	errChan := make(chan error, 2)
+	go func() { errChan <- s.serveUDP(udp) }()
+	go func() { errChan <- s.serveTCP(tcp) }()
+
+	err = <-errChan
+	s.Stop()
+	if err2 := <-errChan; err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Addr returns the UDP address the server listens on, or nil before Start
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.udp == nil {
+		return nil
+	}
+	return s.udp.LocalAddr()
+}
+
+// Stop closes the listeners and waits for queries in progress
+func (s *Server) Stop() error {
+	s.mu.Lock()
+	s.closed = true
+	udp, tcp := s.udp, s.tcp
+	s.mu.Unlock()
+
+	var err error
+	if udp != nil {
+		err = udp.Close()
+	}
+	if tcp != nil {
+		if tcpErr := tcp.Close(); err == nil {
+			err = tcpErr
+		}
+	}
+	s.inflight.Wait()
+
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// isClosed reports whether Stop was called
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// serveUDP answers queries received on conn
+func (s *Server) serveUDP(conn net.PacketConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			return err
+		}
+
+		query := append([]byte(nil), buf[:n]...)
+		s.inflight.Add(1)
+		go func() {
+			defer s.inflight.Done()
+
+			response, limit := s.handle(query)
+			if response == nil {
+				return
+			}
+			if len(response) > limit {
+				response = truncate(response)
+			}
+			if _, err := conn.WriteTo(response, addr); err != nil {
+				queryLog.Debug("Failed to send DNS64 response to %s: %v", addr, err)
+			}
+		}()
+	}
+}
+
+// serveTCP answers queries on connections accepted from l
+func (s *Server) serveTCP(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			return err
+		}
+
+		s.inflight.Add(1)
+		go func() {
+			defer s.inflight.Done()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// serveConn answers length-prefixed queries on a TCP connection
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	for !s.isClosed() {
+		conn.SetDeadline(time.Now().Add(tcpIdleTimeout))
+
+		query, err := readTCPMessage(conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				dnsLog.Debug("DNS64 TCP connection from %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		response, _ := s.handle(query)
+		if response == nil {
+			return
+		}
+		if err := writeTCPMessage(conn, response); err != nil {
+			return
+		}
+	}
+}
+
+// handle answers a query. It returns the response and the largest UDP
+// response the client accepts, or nil when the query must be ignored.
+func (s *Server) handle(query []byte) ([]byte, int) {
+	req, err := parseMessage(query)
+	if err != nil {
+		if len(query) < headerLen {
+			return nil, 0
+		}
+		// Answer what we can of the header
+		m := &message{ID: binary.BigEndian.Uint16(query[0:2]), Flags: binary.BigEndian.Uint16(query[2:4])}
+		return m.reply(rcodeFormatError).pack(), minUDPSize
+	}
+	if req.Flags&flagQR != 0 {
+		return nil, 0
+	}
+	limit := req.udpSize()
+
+	var response []byte
+	if len(req.Questions) == 1 && req.Questions[0].Class == classINET {
+		q := req.Questions[0]
+		switch q.Type {
+		case typeAAAA:
+			response, err = s.resolveAAAA(req, query)
+		case typePTR:
+			response, err = s.resolvePTR(req, query)
+		default:
+			response, err = s.exchange(query)
+		}
+	} else {
+		response, err = s.exchange(query)
+	}
+
+	if err != nil {
+		queryLog.Error("DNS64 query %s failed: %v", describe(req), err)
+		return req.reply(rcodeServerFailure).pack(), limit
+	}
+	return response, limit
+}
+
+// resolveAAAA forwards an AAAA query and synthesizes records from A records
+// when the name has no usable AAAA record (RFC 6147 section 5.1)
+func (s *Server) resolveAAAA(req *message, query []byte) ([]byte, error) {
+	raw, err := s.exchange(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// A client validating DNSSEC itself must see the real answer
+	if req.Flags&flagCD != 0 && req.dnssecOK() {
+		return raw, nil
+	}
+
+	resp, err := parseMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+	if resp.rcode() != rcodeSuccess {
+		// NXDOMAIN and failures are passed on unchanged
+		return raw, nil
+	}
+
+	// Drop AAAA records within the exclusion ranges
+	answers := make([]record, 0, len(resp.Answers))
+	usable := false
+	for _, rr := range resp.Answers {
+		if rr.Type == typeAAAA && rr.Class == classINET {
+			if len(rr.Data) != net.IPv6len || s.excluded6(net.IP(rr.Data)) {
+				continue
+			}
+			usable = true
+		}
+		answers = append(answers, rr)
+	}
+	if usable {
+		if len(answers) == len(resp.Answers) {
+			return raw, nil
+		}
+		resp.Answers = answers
+		return resp.pack(), nil
+	}
+
+	synthesized, err := s.synthesize(req, resp)
+	if err != nil {
+		return nil, err
+	}
+	if synthesized == nil {
+		// Nothing to synthesize from, keep the upstream answer
+		resp.Answers = answers
+		return resp.pack(), nil
+	}
+	if dnsLog.Enabled(logger.DebugLevel) {
+		dnsLog.Debug("DNS64 synthesized %d AAAA records for %s", countType(synthesized.Answers, typeAAAA), describe(req))
+	}
+	return synthesized.pack(), nil
+}
+
+// synthesize queries the A records of the name in req and returns an AAAA
+// response built from them, or nil when there are none
+func (s *Server) synthesize(req, aaaa *message) (*message, error) {
+	q := req.Questions[0]
+	aReq := &message{
+		ID:         req.ID,
+		Flags:      req.Flags &^ (flagAD | flagCD),
+		Questions:  []question{{Name: q.Name, Type: typeA, Class: classINET}},
+		Additional: ednsOnly(req.Additional),
+	}
+
+	raw, err := s.exchange(aReq.pack())
+	if err != nil {
+		return nil, err
+	}
+	aResp, err := parseMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+	if aResp.rcode() != rcodeSuccess {
+		return nil, nil
+	}
+
+	// Synthesized records live no longer than the negative AAAA answer
+	// (RFC 6147 section 5.1.7)
+	maxTTL, capped := negativeTTL(aaaa)
+
+	var answers []record
+	found := false
+	for _, rr := range aResp.Answers {
+		switch {
+		case rr.Type == typeA && rr.Class == classINET:
+			if len(rr.Data) != net.IPv4len || s.excluded4(net.IP(rr.Data)) {
+				continue
+			}
+			ipv6, err := translator.IPv4ToNAT64(net.IP(rr.Data).String(), s.prefix)
+			if err != nil {
+				return nil, err
+			}
+			ttl := rr.TTL
+			if capped && maxTTL < ttl {
+				ttl = maxTTL
+			}
+			answers = append(answers, record{Name: rr.Name, Type: typeAAAA, Class: classINET, TTL: ttl, Data: ipv6.To16()})
+			found = true
+		case rr.Type == typeCNAME || rr.Type == typeDNAME:
+			answers = append(answers, rr)
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+
+	return &message{
+		ID:         req.ID,
+		Flags:      aResp.Flags &^ (flagAD | flagTC),
+		Questions:  req.Questions,
+		Answers:    answers,
+		Authority:  aResp.Authority,
+		Additional: ednsOnly(aResp.Additional),
+	}, nil
+}
+
+// resolvePTR answers reverse queries for addresses within the NAT64 prefix
+// from the in-addr.arpa name of the embedded IPv4 address (RFC 6147
+// section 5.3.1). Other reverse queries are forwarded unchanged.
+func (s *Server) resolvePTR(req *message, query []byte) ([]byte, error) {
+	q := req.Questions[0]
+	ipv6, ok := parseIP6Arpa(q.Name)
+	if !ok || !translator.IsNAT64Address(ipv6.String(), s.prefix) {
+		return s.exchange(query)
+	}
+
+	ipv4, err := translator.GetIPV4fromNAT64(ipv6.String(), s.prefix)
+	if err != nil {
+		return s.exchange(query)
+	}
+	octets := strings.Split(ipv4, ".")
+	arpaName := encodeName(octets[3], octets[2], octets[1], octets[0], "in-addr", "arpa")
+
+	ptrReq := &message{
+		ID:         req.ID,
+		Flags:      req.Flags,
+		Questions:  []question{{Name: arpaName, Type: typePTR, Class: classINET}},
+		Additional: ednsOnly(req.Additional),
+	}
+	raw, err := s.exchange(ptrReq.pack())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := parseMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	// Present the answer under the name that was asked
+	resp.Questions = req.Questions
+	resp.Flags &^= flagAD
+	for _, section := range [][]record{resp.Answers, resp.Authority} {
+		for i := range section {
+			if sameName(section[i].Name, arpaName) {
+				section[i].Name = q.Name
+			}
+		}
+	}
+	return resp.pack(), nil
+}
+
+// excluded4 reports whether an A record must not be synthesized from
+func (s *Server) excluded4(ip net.IP) bool {
+	for _, ipNet := range s.exclude4 {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// excluded6 reports whether an AAAA record must be ignored
+func (s *Server) excluded6(ip net.IP) bool {
+	for _, ipNet := range s.exclude6 {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// exchange sends a query to the upstream resolver over UDP, retrying over
+// TCP when the answer is truncated
+func (s *Server) exchange(query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", s.upstream, s.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout))
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		response := buf[:n]
+		// Ignore stray datagrams for other queries
+		if n < headerLen || binary.BigEndian.Uint16(response[0:2]) != binary.BigEndian.Uint16(query[0:2]) {
+			continue
+		}
+		if binary.BigEndian.Uint16(response[2:4])&flagTC != 0 {
+			return s.exchangeTCP(query)
+		}
+		return append([]byte(nil), response...), nil
+	}
+}
+
+// exchangeTCP sends a query to the upstream resolver over TCP
+func (s *Server) exchangeTCP(query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", s.upstream, s.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout))
+
+	if err := writeTCPMessage(conn, query); err != nil {
+		return nil, err
+	}
+	return readTCPMessage(conn)
+}
+
+// readTCPMessage reads a length-prefixed DNS message
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// writeTCPMessage writes a length-prefixed DNS message
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	buf := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(msg)), uint16(len(msg)))
+	_, err := w.Write(append(buf, msg...))
+	return err
+}
+
+// truncate reduces a response to its header and question with TC set
+func truncate(response []byte) []byte {
+	m, err := parseMessage(response)
+	if err != nil {
+		header := append([]byte(nil), response[:headerLen]...)
+		binary.BigEndian.PutUint16(header[2:4], binary.BigEndian.Uint16(header[2:4])|flagTC)
+		clear(header[4:])
+		return header
+	}
+	return m.truncated().pack()
+}
+
+// parseIP6Arpa returns the address of a full ip6.arpa name
+func parseIP6Arpa(name []byte) (net.IP, bool) {
+	labels := nameLabels(name)
+	if len(labels) != 34 || !strings.EqualFold(labels[32], "ip6") || !strings.EqualFold(labels[33], "arpa") {
+		return nil, false
+	}
+
+	ip := make(net.IP, net.IPv6len)
+	for i := 0; i < 32; i++ {
+		nibble, err := strconv.ParseUint(labels[i], 16, 8)
+		if err != nil || len(labels[i]) != 1 {
+			return nil, false
+		}
+		// Nibbles are listed from the least significant
+		pos := 31 - i
+		if pos%2 == 0 {
+			ip[pos/2] |= byte(nibble) << 4
+		} else {
+			ip[pos/2] |= byte(nibble)
+		}
+	}
+	return ip, true
+}
+
+// negativeTTL returns the TTL of a negative answer from its SOA record
+// (RFC 2308), if it has one
+func negativeTTL(m *message) (uint32, bool) {
+	for _, rr := range m.Authority {
+		if rr.Type != typeSOA || len(rr.Data) < 20 {
+			continue
+		}
+		minimum := binary.BigEndian.Uint32(rr.Data[len(rr.Data)-4:])
+		if rr.TTL < minimum {
+			return rr.TTL, true
+		}
+		return minimum, true
+	}
+	return 0, false
+}
+
+// ednsOnly returns the OPT record of an additional section
+func ednsOnly(additional []record) []record {
+	for _, rr := range additional {
+		if rr.Type == typeOPT {
+			return []record{rr}
+		}
+	}
+	return nil
+}
+
+// countType counts records of a type
+func countType(records []record, typ uint16) int {
+	n := 0
+	for _, rr := range records {
+		if rr.Type == typ {
+			n++
+		}
+	}
+	return n
+}
+
+// describe formats the question of a query for logging
+func describe(m *message) string {
+	if len(m.Questions) == 0 {
+		return "(no question)"
+	}
+	q := m.Questions[0]
+	return fmt.Sprintf("%s type %d", nameString(q.Name), q.Type)
+}
@@ -0,0 +1,304 @@
+package dns64
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+)
+
+// fakeUpstream is a UDP resolver answering from a table of records
+type fakeUpstream struct {
+	conn net.PacketConn
+
+	mu      sync.Mutex
+	records map[string][]record // By "name type"
+	rcodes  map[string]int      // By "name", success if absent
+	queries []question
+}
+
+// newFakeUpstream starts a fake resolver on the loopback interface
+func newFakeUpstream(t *testing.T) *fakeUpstream {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	u := &fakeUpstream{conn: conn, records: make(map[string][]record), rcodes: make(map[string]int)}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if response := u.answer(buf[:n]); response != nil {
+				conn.WriteTo(response, addr)
+			}
+		}
+	}()
+	return u
+}
+
+// add adds records answering queries for their name and type
+func (u *fakeUpstream) add(records ...record) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, rr := range records {
+		key := fmt.Sprintf("%s %d", nameString(rr.Name), rr.Type)
+		u.records[key] = append(u.records[key], rr)
+	}
+}
+
+// fail answers queries for name with rcode
+func (u *fakeUpstream) fail(name []byte, rcode int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.rcodes[nameString(name)] = rcode
+}
+
+// answer builds the response to a query
+func (u *fakeUpstream) answer(query []byte) []byte {
+	req, err := parseMessage(query)
+	if err != nil || len(req.Questions) != 1 {
+		return nil
+	}
+	q := req.Questions[0]
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.queries = append(u.queries, q)
+	resp := req.reply(u.rcodes[nameString(q.Name)])
+	resp.Answers = u.records[fmt.Sprintf("%s %d", nameString(q.Name), q.Type)]
+	if len(resp.Answers) == 0 {
+		resp.Authority = []record{soaRecord(encodeName("example", "com"), 3600, 300)}
+	}
+	return resp.pack()
+}
+
+// asked returns the questions received so far
+func (u *fakeUpstream) asked() []question {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]question(nil), u.queries...)
+}
+
+// newTestServer creates a server forwarding to the fake upstream
+func newTestServer(t *testing.T, u *fakeUpstream, exclude ...string) *Server {
+	t.Helper()
+
+	s, err := NewServer(Config{Upstream: u.conn.LocalAddr().String(), Prefix: "64:ff9b::/96", Exclude: exclude})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	return s
+}
+
+// soaRecord returns an SOA record with the given TTL and minimum
+func soaRecord(zone []byte, ttl, minimum uint32) record {
+	data := append(encodeName("ns", "example", "com"), encodeName("hostmaster", "example", "com")...)
+	data = append(data, make([]byte, 16)...)
+	data = binary.BigEndian.AppendUint32(data, minimum)
+	return record{Name: zone, Type: typeSOA, Class: classINET, TTL: ttl, Data: data}
+}
+
+// aRecord returns an A record
+func aRecord(name []byte, ttl uint32, ip string) record {
+	return record{Name: name, Type: typeA, Class: classINET, TTL: ttl, Data: net.ParseIP(ip).To4()}
+}
+
+// aaaaRecord returns an AAAA record
+func aaaaRecord(name []byte, ttl uint32, ip string) record {
+	return record{Name: name, Type: typeAAAA, Class: classINET, TTL: ttl, Data: net.ParseIP(ip).To16()}
+}
+
+// ip6ArpaName returns the reverse lookup name of an IPv6 address
+func ip6ArpaName(ip net.IP) []byte {
+	labels := make([]string, 0, 34)
+	for i := net.IPv6len - 1; i >= 0; i-- {
+		labels = append(labels, fmt.Sprintf("%x", ip[i]&0x0f), fmt.Sprintf("%x", ip[i]>>4))
+	}
+	return encodeName(append(labels, "ip6", "arpa")...)
+}
+
+// resolve sends a query for name through the server and parses the response
+func resolve(t *testing.T, s *Server, name []byte, typ uint16) *message {
+	t.Helper()
+
+	query := &message{ID: 0x4242, Flags: flagRD, Questions: []question{{Name: name, Type: typ, Class: classINET}}}
+	response, _ := s.handle(query.pack())
+	if response == nil {
+		t.Fatal("query was ignored")
+	}
+	m, err := parseMessage(response)
+	if err != nil {
+		t.Fatalf("parseMessage: %v", err)
+	}
+	if m.ID != query.ID || m.Flags&flagQR == 0 {
+		t.Fatalf("response ID %#x flags %#x, want ID %#x and QR set", m.ID, m.Flags, query.ID)
+	}
+	return m
+}
+
+// addresses returns the AAAA addresses of records with their TTLs
+func addresses(records []record) []string {
+	var addrs []string
+	for _, rr := range records {
+		if rr.Type == typeAAAA {
+			addrs = append(addrs, fmt.Sprintf("%s %d", net.IP(rr.Data), rr.TTL))
+		}
+	}
+	return addrs
+}
+
+func TestSynthesizeAAAA(t *testing.T) {
+	u := newFakeUpstream(t)
+	name := encodeName("v4only", "example", "com")
+	u.add(aRecord(name, 600, "192.0.2.33"), aRecord(name, 60, "198.51.100.7"))
+
+	m := resolve(t, newTestServer(t, u), name, typeAAAA)
+	if m.rcode() != rcodeSuccess {
+		t.Fatalf("rcode = %d, want success", m.rcode())
+	}
+
+	// TTLs are capped by the SOA minimum of the empty AAAA answer
+	want := []string{"64:ff9b::c000:221 300", "64:ff9b::c633:6407 60"}
+	if got := addresses(m.Answers); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("answers = %v, want %v", got, want)
+	}
+	if len(m.Questions) != 1 || m.Questions[0].Type != typeAAAA {
+		t.Fatalf("questions = %+v, want the AAAA question", m.Questions)
+	}
+}
+
+func TestRealAAAAIsKept(t *testing.T) {
+	u := newFakeUpstream(t)
+	name := encodeName("dual", "example", "com")
+	u.add(aaaaRecord(name, 300, "2001:db8::1"), aRecord(name, 300, "192.0.2.1"))
+
+	m := resolve(t, newTestServer(t, u), name, typeAAAA)
+	if got := addresses(m.Answers); fmt.Sprint(got) != "[2001:db8::1 300]" {
+		t.Fatalf("answers = %v, want the real AAAA record", got)
+	}
+	if asked := u.asked(); len(asked) != 1 {
+		t.Fatalf("upstream asked %d questions, want only the AAAA query", len(asked))
+	}
+}
+
+func TestExcludedRanges(t *testing.T) {
+	u := newFakeUpstream(t)
+	mapped := encodeName("mapped", "example", "com")
+	u.add(aaaaRecord(mapped, 300, "::ffff:192.0.2.1"), aRecord(mapped, 300, "192.0.2.1"))
+
+	// IPv4-mapped AAAA records are ignored by default
+	m := resolve(t, newTestServer(t, u), mapped, typeAAAA)
+	if got := addresses(m.Answers); fmt.Sprint(got) != "[64:ff9b::c000:201 300]" {
+		t.Fatalf("answers = %v, want one synthesized from the A record", got)
+	}
+
+	// IPv6 ranges drop AAAA records, IPv4 ranges are never synthesized
+	mixed := encodeName("mixed", "example", "com")
+	u.add(
+		aaaaRecord(mixed, 300, "2001:db8:bad::1"),
+		aRecord(mixed, 300, "10.1.2.3"),
+		aRecord(mixed, 300, "192.0.2.1"),
+	)
+	s := newTestServer(t, u, "2001:db8:bad::/48", "10.0.0.0/8")
+	m = resolve(t, s, mixed, typeAAAA)
+	if got := addresses(m.Answers); fmt.Sprint(got) != "[64:ff9b::c000:201 300]" {
+		t.Fatalf("answers = %v, want only 192.0.2.1 synthesized", got)
+	}
+
+	// With every A record excluded the empty AAAA answer stands
+	private := encodeName("private", "example", "com")
+	u.add(aRecord(private, 300, "10.9.9.9"))
+	m = resolve(t, s, private, typeAAAA)
+	if m.rcode() != rcodeSuccess || len(m.Answers) != 0 {
+		t.Fatalf("rcode %d with %d answers, want an empty success", m.rcode(), len(m.Answers))
+	}
+}
+
+func TestNXDomainPassesThrough(t *testing.T) {
+	u := newFakeUpstream(t)
+	name := encodeName("missing", "example", "com")
+	u.fail(name, 3)
+
+	m := resolve(t, newTestServer(t, u), name, typeAAAA)
+	if m.rcode() != 3 {
+		t.Fatalf("rcode = %d, want NXDOMAIN", m.rcode())
+	}
+	if asked := u.asked(); len(asked) != 1 {
+		t.Fatalf("upstream asked %d questions, want no A query after NXDOMAIN", len(asked))
+	}
+}
+
+func TestPTRRewrite(t *testing.T) {
+	u := newFakeUpstream(t)
+	u.add(record{
+		Name:  encodeName("33", "2", "0", "192", "in-addr", "arpa"),
+		Type:  typePTR,
+		Class: classINET,
+		TTL:   3600,
+		Data:  encodeName("host", "example", "com"),
+	})
+
+	name := ip6ArpaName(net.ParseIP("64:ff9b::192.0.2.33"))
+	m := resolve(t, newTestServer(t, u), name, typePTR)
+
+	asked := u.asked()
+	if len(asked) != 1 || nameString(asked[0].Name) != "33.2.0.192.in-addr.arpa." {
+		t.Fatalf("upstream asked %+v, want 33.2.0.192.in-addr.arpa.", asked)
+	}
+	if len(m.Questions) != 1 || !bytes.Equal(m.Questions[0].Name, name) {
+		t.Fatalf("questions = %+v, want the ip6.arpa name", m.Questions)
+	}
+	if len(m.Answers) != 1 || !bytes.Equal(m.Answers[0].Name, name) || !bytes.Equal(m.Answers[0].Data, encodeName("host", "example", "com")) {
+		t.Fatalf("answers = %+v, want host.example.com. under the ip6.arpa name", m.Answers)
+	}
+}
+
+func TestPTROutsidePrefixIsForwarded(t *testing.T) {
+	u := newFakeUpstream(t)
+	name := ip6ArpaName(net.ParseIP("2001:db8::1"))
+	u.add(record{Name: name, Type: typePTR, Class: classINET, TTL: 60, Data: encodeName("v6", "example", "com")})
+
+	m := resolve(t, newTestServer(t, u), name, typePTR)
+	if asked := u.asked(); len(asked) != 1 || !bytes.Equal(asked[0].Name, name) {
+		t.Fatalf("upstream asked %+v, want the original ip6.arpa name", asked)
+	}
+	if len(m.Answers) != 1 {
+		t.Fatalf("answers = %+v, want the upstream PTR record", m.Answers)
+	}
+}
+
+func TestMalformedQuery(t *testing.T) {
+	s := newTestServer(t, newFakeUpstream(t))
+
+	query := []byte{0xab, 0xcd, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0, 7, 'e', 'x'}
+	response, _ := s.handle(query)
+	m, err := parseMessage(response)
+	if err != nil {
+		t.Fatalf("parseMessage: %v", err)
+	}
+	if m.ID != 0xabcd || m.rcode() != rcodeFormatError {
+		t.Fatalf("response ID %#x rcode %d, want 0xabcd and FORMERR", m.ID, m.rcode())
+	}
+
+	if response, _ := s.handle([]byte{1, 2, 3}); response != nil {
+		t.Fatalf("response to a short query = %x, want none", response)
+	}
+
+	// Responses sent to the server are ignored
+	reply := (&message{ID: 1, Flags: flagQR}).pack()
+	if response, _ := s.handle(reply); response != nil {
+		t.Fatalf("response to a response = %x, want none", response)
+	}
+}
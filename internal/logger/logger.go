@@ -3,9 +3,10 @@ package logger
 import (
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fatih/color"
@@ -23,15 +24,133 @@ const (
 	FatalLevel
 )
 
+// Subsystems with their own log level
+const (
+	NAT        = "nat"
+	TUN        = "tun"
+	API        = "api"
+	Translator = "translator"
+	DNS64      = "dns64"
+)
+
+// Subsystems lists every subsystem
+var Subsystems = []string{NAT, TUN, API, Translator, DNS64}
+
+// ParseLevel parses a level name from the configuration: debug, info, warn
+// or error
+func ParseLevel(name string) (LogLevel, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return DebugLevel, nil
+	case "", "info":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	}
+	return InfoLevel, fmt.Errorf("unknown log level %q (debug, info, warn or error)", name)
+}
+
+// ParseLevelSpec parses a level followed by subsystem levels, e.g.
+// "info,nat=debug,tun=warn". Either part may be left out.
+func ParseLevelSpec(spec string) (string, map[string]string, error) {
+	var level string
+	subsystems := make(map[string]string)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, found := strings.Cut(part, "=")
+		if !found {
+			name, value = "", name
+		}
+		if _, err := ParseLevel(value); err != nil {
+			return "", nil, err
+		}
+		switch {
+		case name == "":
+			level = value
+		case isSubsystem(name):
+			subsystems[name] = value
+		default:
+			return "", nil, fmt.Errorf("unknown subsystem %q (%s)", name, strings.Join(Subsystems, ", "))
+		}
+	}
+	return level, subsystems, nil
+}
+
+// isSubsystem reports whether name is one of Subsystems
+func isSubsystem(name string) bool {
+	for _, s := range Subsystems {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the name of the level
+func (l LogLevel) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel, SuccessLevel, CompletedLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	}
+	return "fatal"
+}
+
+// Field is a key/value pair attached to log messages
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Entry is one log message
+type Entry struct {
+	Time      time.Time
+	Level     LogLevel
+	Label     string // SUCCESS, COMPLETED or the level in upper case
+	Subsystem string
+	Message   string
+	Fields    []Field
+}
+
+// core is shared by a logger and those derived from it
+type core struct {
+	mu     sync.Mutex // Serializes writes to the sink
+	sink   Sink
+	level  atomic.Int32
+	levels sync.Map // Subsystem to *atomic.Int32, -1 inherits level
+
+	sampleRate atomic.Int32 // Sampled messages per second, 0 for no limit
+}
+
+// subsystemLevel returns the level slot of a subsystem
+func (c *core) subsystemLevel(name string) *atomic.Int32 {
+	if slot, ok := c.levels.Load(name); ok {
+		return slot.(*atomic.Int32)
+	}
+	slot := &atomic.Int32{}
+	slot.Store(-1)
+	actual, _ := c.levels.LoadOrStore(name, slot)
+	return actual.(*atomic.Int32)
+}
+
+// Logger writes leveled messages with optional key/value fields. Loggers
+// derived with For, With and Sampled share their sink and levels.
 type Logger struct {
-	debugLogger     *log.Logger
-	infoLogger      *log.Logger
-	successLogger   *log.Logger
-	completedLogger *log.Logger
-	warnLogger      *log.Logger
-	errorLogger     *log.Logger
-	fatalLogger     *log.Logger
-	level           LogLevel
+	core      *core
+	subsystem string
+	level     *atomic.Int32 // Subsystem level, nil for none
+	fields    []Field
+	sampler   *sampler // nil unless Sampled
 }
 
 func New(level LogLevel) *Logger {
@@ -39,64 +158,202 @@ func New(level LogLevel) *Logger {
 }
 
 func NewWithWriter(writer io.Writer, level LogLevel) *Logger {
-	flags := 0
-	return &Logger{
-		debugLogger:     log.New(writer, "", flags),
-		infoLogger:      log.New(writer, "", flags),
-		successLogger:   log.New(writer, "", flags),
-		completedLogger: log.New(writer, "", flags),
-		warnLogger:      log.New(writer, "", flags),
-		errorLogger:     log.New(writer, "", flags),
-		fatalLogger:     log.New(writer, "", flags),
-		level:           level,
+	return NewWithSink(NewWriterSink(writer, FormatText, true), level)
+}
+
+// NewWithSink creates a logger writing to sink
+func NewWithSink(sink Sink, level LogLevel) *Logger {
+	c := &core{sink: sink}
+	c.level.Store(int32(level))
+	return &Logger{core: c}
+}
+
+// For returns a logger for a subsystem, which has its own level
+func (l *Logger) For(subsystem string) *Logger {
+	derived := *l
+	derived.subsystem = subsystem
+	derived.level = l.core.subsystemLevel(subsystem)
+	return &derived
+}
+
+// With returns a logger adding key/value pairs to every message. Keys are
+// strings; a key without a value is dropped.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	derived := *l
+	derived.fields = make([]Field, len(l.fields), len(l.fields)+len(kv)/2)
+	copy(derived.fields, l.fields)
+	for i := 0; i+1 < len(kv); i += 2 {
+		derived.fields = append(derived.fields, Field{Key: fmt.Sprint(kv[i]), Value: kv[i+1]})
 	}
+	return &derived
 }
 
-func (l *Logger) Debug(format string, v ...interface{}) {
-	if l.level <= DebugLevel {
-		l.debugLogger.Output(2, color.HiBlackString("[DEBUG] ")+fmt.Sprintf(format, v...))
+// Sampled returns a logger for per-packet messages. Each message format is
+// logged at most the configured sample rate times per second; the number
+// suppressed is reported with the next message that gets through.
+func (l *Logger) Sampled() *Logger {
+	derived := *l
+	derived.sampler = newSampler()
+	return &derived
+}
+
+// Enabled reports whether messages of a level are logged. Callers can check
+// it before computing expensive arguments.
+func (l *Logger) Enabled(level LogLevel) bool {
+	min := l.core.level.Load()
+	if l.level != nil {
+		if own := l.level.Load(); own >= 0 {
+			min = own
+		}
 	}
+	return int32(level) >= min
 }
 
-func (l *Logger) Info(format string, v ...interface{}) {
-	if l.level <= InfoLevel {
-		l.infoLogger.Output(2, color.CyanString("[INFO] ")+fmt.Sprintf(format, v...))
+// log formats and writes a message if its level is enabled. Formatting is
+// skipped for filtered messages, so arguments should be values or
+// fmt.Stringers rather than preformatted strings.
+func (l *Logger) log(level LogLevel, label, format string, v []interface{}) {
+	if !l.Enabled(level) {
+		return
 	}
+
+	fields := l.fields
+	if l.sampler != nil {
+		suppressed, ok := l.sampler.allow(format, int(l.core.sampleRate.Load()))
+		if !ok {
+			return
+		}
+		if suppressed > 0 {
+			fields = append(fields[:len(fields):len(fields)], Field{Key: "suppressed", Value: suppressed})
+		}
+	}
+
+	entry := &Entry{
+		Time:      time.Now(),
+		Level:     level,
+		Label:     label,
+		Subsystem: l.subsystem,
+		Message:   fmt.Sprintf(format, v...),
+		Fields:    fields,
+	}
+
+	l.core.mu.Lock()
+	l.core.sink.Write(entry)
+	l.core.mu.Unlock()
+}
+
+func (l *Logger) Debug(format string, v ...interface{}) {
+	l.log(DebugLevel, "DEBUG", format, v)
+}
+
+func (l *Logger) Info(format string, v ...interface{}) {
+	l.log(InfoLevel, "INFO", format, v)
 }
 
 func (l *Logger) Success(format string, v ...interface{}) {
-	if l.level <= InfoLevel {
-		l.successLogger.Output(2, color.GreenString("[SUCCESS] ")+fmt.Sprintf(format, v...))
-	}
+	l.log(SuccessLevel, "SUCCESS", format, v)
 }
 
 func (l *Logger) Completed(format string, v ...interface{}) {
-	if l.level <= InfoLevel {
-		l.completedLogger.Output(2, color.GreenString("[COMPLETED] ")+fmt.Sprintf(format, v...))
-	}
+	l.log(CompletedLevel, "COMPLETED", format, v)
 }
 
 func (l *Logger) Warn(format string, v ...interface{}) {
-	if l.level <= InfoLevel {
-		l.warnLogger.Output(2, color.YellowString("[WARN] ")+fmt.Sprintf(format, v...))
-	}
+	l.log(WarnLevel, "WARN", format, v)
 }
 
 func (l *Logger) Error(format string, v ...interface{}) {
-	if l.level <= ErrorLevel {
-		l.errorLogger.Output(2, color.RedString("[ERROR] ")+fmt.Sprintf(format, v...))
-	}
+	l.log(ErrorLevel, "ERROR", format, v)
 }
 
 func (l *Logger) Fatal(format string, v ...interface{}) {
-	if l.level <= FatalLevel {
-		l.fatalLogger.Output(2, color.HiRedString("[FATAL] ")+fmt.Sprintf(format, v...))
-		os.Exit(1)
-	}
+	l.log(FatalLevel, "FATAL", format, v)
+	l.core.mu.Lock()
+	l.core.sink.Close()
+	os.Exit(1)
 }
 
+// SetLevel sets the level of the logger's subsystem, or the level of every
+// subsystem without its own when the logger has none
 func (l *Logger) SetLevel(level LogLevel) {
-	l.level = level
+	if l.level != nil {
+		l.level.Store(int32(level))
+		return
+	}
+	l.core.level.Store(int32(level))
+}
+
+// SetSubsystemLevels sets the level of each named subsystem. Subsystems left
+// out inherit the logger's level.
+func (l *Logger) SetSubsystemLevels(levels map[string]LogLevel) {
+	for _, name := range Subsystems {
+		slot := l.core.subsystemLevel(name)
+		if level, ok := levels[name]; ok {
+			slot.Store(int32(level))
+		} else {
+			slot.Store(-1)
+		}
+	}
+}
+
+// SetSampleRate sets how many sampled messages of each format are logged per
+// second, 0 for no limit
+func (l *Logger) SetSampleRate(perSecond int) {
+	l.core.sampleRate.Store(int32(perSecond))
+}
+
+// SetSink replaces the sink, closing the previous one
+func (l *Logger) SetSink(sink Sink) {
+	l.core.mu.Lock()
+	old := l.core.sink
+	l.core.sink = sink
+	l.core.mu.Unlock()
+
+	if old != sink {
+		old.Close()
+	}
+}
+
+// Options configure the default logger
+type Options struct {
+	Level      LogLevel
+	Levels     map[string]LogLevel // Subsystem levels overriding Level
+	Format     string              // text or json
+	Output     string              // stdout, stderr, file, syslog or journald
+	File       string              // Log file when Output is file
+	MaxSize    int64               // Bytes before the file is rotated, 0 for no rotation
+	MaxBackups int                 // Rotated files kept
+	SampleRate int                 // Sampled messages per second, 0 for no limit
+}
+
+// Configure sets up the default logger: its sink, levels and sampling
+func Configure(opts Options) error {
+	sink, err := OpenSink(opts.Output, opts.Format, opts.File, opts.MaxSize, opts.MaxBackups)
+	if err != nil {
+		return err
+	}
+	defaultLogger.SetSink(sink)
+	SetLevels(opts.Level, opts.Levels)
+	defaultLogger.SetSampleRate(opts.SampleRate)
+	return nil
+}
+
+// SetLevels sets the level of the default logger and of its subsystems
+func SetLevels(level LogLevel, subsystems map[string]LogLevel) {
+	defaultLogger.SetLevel(level)
+	defaultLogger.SetSubsystemLevels(subsystems)
+}
+
+// SetSampleRate sets the sample rate of the default logger
+func SetSampleRate(perSecond int) {
+	defaultLogger.SetSampleRate(perSecond)
+}
+
+// Close flushes and closes the sink of the default logger
+func Close() error {
+	defaultLogger.core.mu.Lock()
+	defer defaultLogger.core.mu.Unlock()
+	return defaultLogger.core.sink.Close()
 }
 
 var defaultLogger = New(InfoLevel)
@@ -109,6 +366,16 @@ func GetDefaultLogger() *Logger {
 	return defaultLogger
 }
 
+// For returns a logger of the default logger for a subsystem
+func For(subsystem string) *Logger {
+	return defaultLogger.For(subsystem)
+}
+
+// With returns the default logger with key/value pairs added to every message
+func With(kv ...interface{}) *Logger {
+	return defaultLogger.With(kv...)
+}
+
 func Debug(format string, v ...interface{})     { defaultLogger.Debug(format, v...) }
 func Info(format string, v ...interface{})      { defaultLogger.Info(format, v...) }
 func Success(format string, v ...interface{})   { defaultLogger.Success(format, v...) }
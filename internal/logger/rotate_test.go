@@ -0,0 +1,133 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readFile returns the content of a file, or "(missing)" if it does not exist
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "(missing)"
+	}
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	return string(data)
+}
+
+func TestRotatingFileSizeThreshold(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "bridge.log")
+	f, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile: %v", err)
+	}
+	defer f.Close()
+
+	write := func(s string) {
+		t.Helper()
+		if n, err := f.Write([]byte(s)); err != nil || n != len(s) {
+			t.Fatalf("Write(%q) = %d, %v", s, n, err)
+		}
+	}
+
+	// Writes up to exactly the maximum size stay in one file
+	write("aaaa\n")
+	write("bbbb\n")
+	if got := readFile(t, path); got != "aaaa\nbbbb\n" {
+		t.Fatalf("log file = %q before reaching the limit", got)
+	}
+	if got := readFile(t, path+".1"); got != "(missing)" {
+		t.Fatalf("rotated before reaching the limit: %q", got)
+	}
+
+	// The next write would pass it and rotates first
+	write("cccc\n")
+	write("dddd\n")
+	write("eeee\n")
+	write("ffff\n")
+	write("gggg\n")
+
+	want := map[string]string{
+		path:        "gggg\n",
+		path + ".1": "eeee\nffff\n",
+		path + ".2": "cccc\ndddd\n",
+		path + ".3": "(missing)", // Beyond maxBackups
+	}
+	for name, content := range want {
+		if got := readFile(t, name); got != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(name), got, content)
+		}
+	}
+}
+
+func TestRotatingFileOversizedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bridge.log")
+	f, err := OpenRotatingFile(path, 8, 1)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile: %v", err)
+	}
+	defer f.Close()
+
+	// A message larger than the limit is written whole to an empty file
+	long := strings.Repeat("x", 20) + "\n"
+	f.Write([]byte(long))
+	f.Write([]byte("short\n"))
+
+	if got := readFile(t, path+".1"); got != long {
+		t.Fatalf("rotated file = %q, want the long message", got)
+	}
+	if got := readFile(t, path); got != "short\n" {
+		t.Fatalf("log file = %q, want the short message", got)
+	}
+}
+
+func TestRotatingFileAppendsToExisting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bridge.log")
+	if err := os.WriteFile(path, []byte("12345678\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	// The size of the existing file counts towards the limit
+	f, err := OpenRotatingFile(path, 12, 1)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile: %v", err)
+	}
+	f.Write([]byte("next\n"))
+	f.Close()
+
+	if got := readFile(t, path+".1"); got != "12345678\n" {
+		t.Fatalf("rotated file = %q, want the existing content", got)
+	}
+	if got := readFile(t, path); got != "next\n" {
+		t.Fatalf("log file = %q, want the new message", got)
+	}
+
+	if _, err := f.Write([]byte("closed\n")); err == nil {
+		t.Fatal("Write after Close succeeded")
+	}
+}
+
+func TestRotatingFileWithoutBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bridge.log")
+	f, err := OpenRotatingFile(path, 6, 0)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile: %v", err)
+	}
+	defer f.Close()
+
+	f.Write([]byte("first\n"))
+	f.Write([]byte("again\n"))
+
+	if got := readFile(t, path); got != "again\n" {
+		t.Fatalf("log file = %q, want only the last message", got)
+	}
+	if got := readFile(t, path+".1"); got != "(missing)" {
+		t.Fatalf("backup kept without maxBackups: %q", got)
+	}
+}
@@ -0,0 +1,55 @@
+package logger
+
+import (
+	"sync"
+	"time"
+)
+
+// sampler limits how often each message format is logged
+type sampler struct {
+	mu      sync.Mutex
+	windows map[string]*sampleWindow
+}
+
+// sampleWindow counts the messages of one format in the current second
+type sampleWindow struct {
+	start      time.Time
+	count      int
+	suppressed int
+}
+
+func newSampler() *sampler {
+	return &sampler{windows: make(map[string]*sampleWindow)}
+}
+
+// allow reports whether a message of format may be logged, allowing
+// perSecond messages each second. When it may, it also returns the number of
+// messages suppressed since the last one logged.
+func (s *sampler) allow(format string, perSecond int) (int, bool) {
+	if perSecond <= 0 {
+		return 0, true
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.windows[format]
+	if !ok {
+		w = &sampleWindow{start: now}
+		s.windows[format] = w
+	}
+	if now.Sub(w.start) >= time.Second {
+		w.start = now
+		w.count = 0
+	}
+	if w.count >= perSecond {
+		w.suppressed++
+		return 0, false
+	}
+
+	w.count++
+	suppressed := w.suppressed
+	w.suppressed = 0
+	return suppressed, true
+}
@@ -0,0 +1,110 @@
+package logger
+
+import (
+	"testing"
+	"time"
+)
+
+// recordSink keeps the entries written to it
+type recordSink struct {
+	entries []*Entry
+}
+
+func (s *recordSink) Write(entry *Entry) error {
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *recordSink) Close() error {
+	return nil
+}
+
+// expire moves the sampling window of format back by a second
+func (s *sampler) expire(format string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if w, ok := s.windows[format]; ok {
+		w.start = w.start.Add(-time.Second)
+	}
+}
+
+func TestSamplerCounts(t *testing.T) {
+	s := newSampler()
+
+	allowed, suppressedTotal := 0, 0
+	for i := 0; i < 10; i++ {
+		if suppressed, ok := s.allow("drop %s", 3); ok {
+			allowed++
+			suppressedTotal += suppressed
+		}
+	}
+	if allowed != 3 || suppressedTotal != 0 {
+		t.Fatalf("allowed %d, reported %d suppressed, want 3 and 0", allowed, suppressedTotal)
+	}
+
+	// Each format has its own budget
+	if _, ok := s.allow("other %s", 3); !ok {
+		t.Fatal("another format was suppressed")
+	}
+
+	// The first message of the next second reports the 7 suppressed
+	s.expire("drop %s")
+	suppressed, ok := s.allow("drop %s", 3)
+	if !ok || suppressed != 7 {
+		t.Fatalf("allow after a second = %d, %v, want 7, true", suppressed, ok)
+	}
+	if suppressed, ok := s.allow("drop %s", 3); !ok || suppressed != 0 {
+		t.Fatalf("second allow = %d, %v, want 0, true", suppressed, ok)
+	}
+}
+
+func TestSamplerUnlimited(t *testing.T) {
+	s := newSampler()
+	for i := 0; i < 1000; i++ {
+		if _, ok := s.allow("drop %s", 0); !ok {
+			t.Fatalf("message %d suppressed without a rate", i)
+		}
+	}
+}
+
+func TestSampledLoggerReportsSuppressed(t *testing.T) {
+	sink := &recordSink{}
+	l := NewWithSink(sink, DebugLevel)
+	l.SetSampleRate(2)
+	sampled := l.For(TUN).Sampled()
+
+	for i := 0; i < 5; i++ {
+		sampled.Debug("dropped packet %d", i)
+	}
+	// Messages of unsampled loggers are never suppressed
+	for i := 0; i < 5; i++ {
+		l.Debug("kept %d", i)
+	}
+	sampled.sampler.expire("dropped packet %d")
+	sampled.Debug("dropped packet %d", 5)
+
+	var messages []string
+	var suppressed []interface{}
+	for _, entry := range sink.entries {
+		messages = append(messages, entry.Message)
+		for _, f := range entry.Fields {
+			if f.Key == "suppressed" {
+				suppressed = append(suppressed, f.Value)
+			}
+		}
+	}
+
+	want := []string{"dropped packet 0", "dropped packet 1", "kept 0", "kept 1", "kept 2", "kept 3", "kept 4", "dropped packet 5"}
+	if len(messages) != len(want) {
+		t.Fatalf("messages = %q, want %q", messages, want)
+	}
+	for i := range want {
+		if messages[i] != want[i] {
+			t.Fatalf("messages = %q, want %q", messages, want)
+		}
+	}
+	if len(suppressed) != 1 || suppressed[0] != 3 {
+		t.Fatalf("suppressed fields = %v, want [3] on the last message", suppressed)
+	}
+}
@@ -0,0 +1,208 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+)
+
+// Output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Outputs
+const (
+	OutputStdout   = "stdout"
+	OutputStderr   = "stderr"
+	OutputFile     = "file"
+	OutputSyslog   = "syslog"
+	OutputJournald = "journald"
+)
+
+// Sink receives log entries. Writes are serialized by the logger.
+type Sink interface {
+	Write(entry *Entry) error
+	Close() error
+}
+
+// OpenSink opens the sink of an output. Files are rotated after maxSize bytes
+// when maxSize is positive, keeping maxBackups old files.
+func OpenSink(output, format, file string, maxSize int64, maxBackups int) (Sink, error) {
+	if format == "" {
+		format = FormatText
+	}
+	if format != FormatText && format != FormatJSON {
+		return nil, fmt.Errorf("unknown log format %q (text or json)", format)
+	}
+
+	switch output {
+	case "", OutputStdout:
+		return NewWriterSink(os.Stdout, format, true), nil
+	case OutputStderr:
+		return NewWriterSink(os.Stderr, format, true), nil
+	case OutputFile:
+		if file == "" {
+			return nil, fmt.Errorf("log output %s needs a file name", OutputFile)
+		}
+		w, err := OpenRotatingFile(file, maxSize, maxBackups)
+		if err != nil {
+			return nil, err
+		}
+		return NewWriterSink(w, format, false), nil
+	case OutputSyslog:
+		return NewSyslogSink("bridge")
+	case OutputJournald:
+		return NewJournaldSink("bridge")
+	}
+	return nil, fmt.Errorf("unknown log output %q (stdout, stderr, file, syslog or journald)", output)
+}
+
+// writerSink writes entries as lines of text or JSON
+type writerSink struct {
+	w        io.Writer
+	format   string
+	terminal bool // Colored labels and no timestamps, for people watching
+	buf      bytes.Buffer
+}
+
+// NewWriterSink creates a sink writing to w. Text written to a terminal gets
+// colored labels; elsewhere each line starts with a timestamp.
+func NewWriterSink(w io.Writer, format string, terminal bool) Sink {
+	return &writerSink{w: w, format: format, terminal: terminal}
+}
+
+func (s *writerSink) Write(entry *Entry) error {
+	s.buf.Reset()
+	if s.format == FormatJSON {
+		appendJSON(&s.buf, entry)
+	} else {
+		s.appendText(&s.buf, entry)
+	}
+	s.buf.WriteByte('\n')
+	_, err := s.w.Write(s.buf.Bytes())
+	return err
+}
+
+func (s *writerSink) Close() error {
+	if s.w == os.Stdout || s.w == os.Stderr {
+		return nil
+	}
+	if c, ok := s.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// labelColors colors the label of each level on terminals
+var labelColors = map[string]func(format string, a ...interface{}) string{
+	"DEBUG":     color.HiBlackString,
+	"INFO":      color.CyanString,
+	"SUCCESS":   color.GreenString,
+	"COMPLETED": color.GreenString,
+	"WARN":      color.YellowString,
+	"ERROR":     color.RedString,
+	"FATAL":     color.HiRedString,
+}
+
+// appendText formats an entry as "[LABEL] subsystem: message key=value"
+func (s *writerSink) appendText(buf *bytes.Buffer, entry *Entry) {
+	if !s.terminal {
+		buf.WriteString(entry.Time.Format(time.RFC3339Nano))
+		buf.WriteByte(' ')
+	}
+	label := "[" + entry.Label + "] "
+	if colorize, ok := labelColors[entry.Label]; ok && s.terminal {
+		label = colorize("%s", label)
+	}
+	buf.WriteString(label)
+	appendMessage(buf, entry)
+}
+
+// appendMessage formats the subsystem, message and fields of an entry
+func appendMessage(buf *bytes.Buffer, entry *Entry) {
+	if entry.Subsystem != "" {
+		buf.WriteString(entry.Subsystem)
+		buf.WriteString(": ")
+	}
+	buf.WriteString(entry.Message)
+	for _, f := range entry.Fields {
+		buf.WriteByte(' ')
+		buf.WriteString(f.Key)
+		buf.WriteByte('=')
+		appendTextValue(buf, f.Value)
+	}
+}
+
+// appendTextValue writes a field value, quoting it when it contains spaces,
+// quotes or control characters
+func appendTextValue(buf *bytes.Buffer, value interface{}) {
+	s := fieldString(value)
+	if s == "" || strings.ContainsAny(s, " \"=\t\r\n") || !utf8.ValidString(s) {
+		buf.WriteString(strconv.Quote(s))
+		return
+	}
+	buf.WriteString(s)
+}
+
+// fieldString formats a field value for text output
+func fieldString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
+
+// appendJSON formats an entry as a JSON object with the time, level,
+// subsystem, message and fields as members
+func appendJSON(buf *bytes.Buffer, entry *Entry) {
+	buf.WriteString(`{"time":`)
+	appendJSONValue(buf, entry.Time.Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	appendJSONValue(buf, entry.Level.String())
+	if entry.Subsystem != "" {
+		buf.WriteString(`,"subsystem":`)
+		appendJSONValue(buf, entry.Subsystem)
+	}
+	buf.WriteString(`,"msg":`)
+	appendJSONValue(buf, entry.Message)
+	for _, f := range entry.Fields {
+		buf.WriteByte(',')
+		appendJSONValue(buf, f.Key)
+		buf.WriteByte(':')
+		appendJSONValue(buf, f.Value)
+	}
+	buf.WriteByte('}')
+}
+
+// appendJSONValue encodes a field value. Errors and fmt.Stringers are
+// written as strings; values that cannot be encoded as their fmt form.
+func appendJSONValue(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case json.Marshaler:
+	case fmt.Stringer:
+		value = v.String()
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(data)
+}
@@ -0,0 +1,15 @@
+//go:build windows || plan9
+
+package logger
+
+import "fmt"
+
+// NewSyslogSink is not supported on this platform
+func NewSyslogSink(tag string) (Sink, error) {
+	return nil, fmt.Errorf("syslog is not supported on this platform")
+}
+
+// NewJournaldSink is not supported on this platform
+func NewJournaldSink(tag string) (Sink, error) {
+	return nil, fmt.Errorf("journald is not supported on this platform")
+}
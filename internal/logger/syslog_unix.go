@@ -0,0 +1,156 @@
+//go:build !windows && !plan9
+
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"log/syslog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// JournaldSocket is where systemd-journald receives native protocol messages
+const JournaldSocket = "/run/systemd/journal/socket"
+
+// syslogSink writes entries to the local syslog daemon
+type syslogSink struct {
+	w   *syslog.Writer
+	buf bytes.Buffer
+}
+
+// NewSyslogSink connects to the local syslog daemon, tagging messages with tag
+func NewSyslogSink(tag string) (Sink, error) {
+	w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) Write(entry *Entry) error {
+	s.buf.Reset()
+	appendMessage(&s.buf, entry)
+	msg := s.buf.String()
+
+	switch entry.Level {
+	case DebugLevel:
+		return s.w.Debug(msg)
+	case InfoLevel, SuccessLevel, CompletedLevel:
+		return s.w.Info(msg)
+	case WarnLevel:
+		return s.w.Warning(msg)
+	case ErrorLevel:
+		return s.w.Err(msg)
+	}
+	return s.w.Crit(msg)
+}
+
+func (s *syslogSink) Close() error {
+	return s.w.Close()
+}
+
+// journaldSink writes entries to systemd-journald with their fields as
+// journal fields
+type journaldSink struct {
+	conn *net.UnixConn
+	addr *net.UnixAddr
+	tag  string
+	buf  bytes.Buffer
+}
+
+// NewJournaldSink connects to systemd-journald, tagging messages with tag
+func NewJournaldSink(tag string) (Sink, error) {
+	// Fail now rather than on every message if journald is not running
+	if _, err := os.Stat(JournaldSocket); err != nil {
+		return nil, fmt.Errorf("failed to connect to journald: %w", err)
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to journald: %w", err)
+	}
+	addr := &net.UnixAddr{Name: JournaldSocket, Net: "unixgram"}
+	return &journaldSink{conn: conn, addr: addr, tag: tag}, nil
+}
+
+func (s *journaldSink) Write(entry *Entry) error {
+	s.buf.Reset()
+	var msg bytes.Buffer
+	appendMessage(&msg, &Entry{Subsystem: entry.Subsystem, Message: entry.Message})
+
+	appendJournalField(&s.buf, "MESSAGE", msg.String())
+	appendJournalField(&s.buf, "PRIORITY", strconv.Itoa(journalPriority(entry.Level)))
+	appendJournalField(&s.buf, "SYSLOG_IDENTIFIER", s.tag)
+	if entry.Subsystem != "" {
+		appendJournalField(&s.buf, "SUBSYSTEM", entry.Subsystem)
+	}
+	for _, f := range entry.Fields {
+		if name := journalFieldName(f.Key); name != "" {
+			appendJournalField(&s.buf, name, fieldString(f.Value))
+		}
+	}
+	return s.send(s.buf.Bytes())
+}
+
+// send writes one datagram to journald
+func (s *journaldSink) send(data []byte) error {
+	_, err := s.conn.WriteToUnix(data, s.addr)
+	return err
+}
+
+func (s *journaldSink) Close() error {
+	return s.conn.Close()
+}
+
+// journalPriority maps a level to a syslog priority
+func journalPriority(level LogLevel) int {
+	switch level {
+	case DebugLevel:
+		return int(syslog.LOG_DEBUG)
+	case InfoLevel, SuccessLevel, CompletedLevel:
+		return int(syslog.LOG_INFO)
+	case WarnLevel:
+		return int(syslog.LOG_WARNING)
+	case ErrorLevel:
+		return int(syslog.LOG_ERR)
+	}
+	return int(syslog.LOG_CRIT)
+}
+
+// journalFieldName turns a field key into a journal field name: upper case
+// letters, digits and underscores, not starting with an underscore
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, key)
+	return strings.TrimLeft(name, "_0123456789")
+}
+
+// appendJournalField writes a field in the native protocol. Values with
+// newlines are written as a length followed by the raw bytes.
+func appendJournalField(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteByte('\n')
+	var size [8]byte
+	n := uint64(len(value))
+	for i := range size {
+		size[i] = byte(n >> (8 * i))
+	}
+	buf.Write(size[:])
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
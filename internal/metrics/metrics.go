@@ -0,0 +1,288 @@
+// Package metrics implements counters, gauges and histograms exported in the
+// Prometheus text exposition format, without external dependencies.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// collector is a metric family that can write itself in text format
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metric families in registration order
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// register adds a metric family to the registry
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteText writes all metrics in the Prometheus text format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// desc is the name, help and label names of a metric family
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+// writeHeader writes the HELP and TYPE lines of a family
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, helpEscaper.Replace(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+}
+
+// helpEscaper escapes help text
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// labelEscaper escapes label values
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelString formats label pairs as {a="x",b="y"}, followed by extra name/value pairs
+func labelString(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], labelEscaper.Replace(extra[i+1])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formatFloat formats a sample value
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// series is a set of children keyed by their label values
+type series[T any] struct {
+	mu       sync.RWMutex
+	children map[string]*T
+	values   map[string][]string
+	create   func() *T
+}
+
+// get returns the child for the label values, creating it on first use
+func (s *series[T]) get(labels []string, values []string) *T {
+	if len(values) != len(labels) {
+		panic(fmt.Sprintf("metrics: expected %d label values, got %d", len(labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	s.mu.RLock()
+	child, exists := s.children[key]
+	s.mu.RUnlock()
+	if exists {
+		return child
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if child, exists := s.children[key]; exists {
+		return child
+	}
+	if s.children == nil {
+		s.children = make(map[string]*T)
+		s.values = make(map[string][]string)
+	}
+	child = s.create()
+	s.children[key] = child
+	s.values[key] = append([]string(nil), values...)
+	return child
+}
+
+// each calls fn for every child, ordered by label values
+func (s *series[T]) each(fn func(values []string, child *T)) {
+	s.mu.RLock()
+	keys := make([]string, 0, len(s.children))
+	for key := range s.children {
+		keys = append(keys, key)
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		s.mu.RLock()
+		child, values := s.children[key], s.values[key]
+		s.mu.RUnlock()
+		fn(values, child)
+	}
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	value atomic.Uint64
+}
+
+// Inc adds one to the counter
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add adds n to the counter
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+// Value returns the current count
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+// CounterVec is a family of counters partitioned by labels
+type CounterVec struct {
+	desc
+	series series[Counter]
+}
+
+// NewCounterVec registers a counter family
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{desc: desc{name: name, help: help, kind: "counter", labels: labels}}
+	c.series.create = func() *Counter { return &Counter{} }
+	r.register(c)
+	return c
+}
+
+// WithLabelValues returns the counter for the given label values
+func (c *CounterVec) WithLabelValues(values ...string) *Counter {
+	return c.series.get(c.labels, values)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.series.each(func(values []string, counter *Counter) {
+		fmt.Fprintf(w, "%s%s %d\n", c.name, labelString(c.labels, values), counter.Value())
+	})
+}
+
+// GaugeValue is one sample of a gauge collected at scrape time
+type GaugeValue struct {
+	Labels []string // Label values, in the order of the family's label names
+	Value  float64
+}
+
+// GaugeFunc is a gauge family whose samples are computed when scraped
+type GaugeFunc struct {
+	desc
+	collect func() []GaugeValue
+}
+
+// NewGaugeFunc registers a gauge family computed by collect on every scrape
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, collect func() []GaugeValue) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name: name, help: help, kind: "gauge", labels: labels}, collect: collect}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	for _, sample := range g.collect() {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, labelString(g.labels, sample.Labels), formatFloat(sample.Value))
+	}
+}
+
+// Histogram counts observations into buckets
+type Histogram struct {
+	buckets []float64       // Upper bounds
+	counts  []atomic.Uint64 // Per bucket, the last one is +Inf
+	sumBits atomic.Uint64   // Sum of observations as float64 bits
+}
+
+// Observe records a value
+func (h *Histogram) Observe(v float64) {
+	h.counts[sort.SearchFloat64s(h.buckets, v)].Add(1)
+
+	for {
+		old := h.sumBits.Load()
+		sum := math.Float64frombits(old) + v
+		if h.sumBits.CompareAndSwap(old, math.Float64bits(sum)) {
+			return
+		}
+	}
+}
+
+// HistogramVec is a family of histograms partitioned by labels
+type HistogramVec struct {
+	desc
+	series series[Histogram]
+}
+
+// NewHistogramVec registers a histogram family with the given bucket upper bounds
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	h := &HistogramVec{desc: desc{name: name, help: help, kind: "histogram", labels: labels}}
+	h.series.create = func() *Histogram {
+		return &Histogram{buckets: buckets, counts: make([]atomic.Uint64, len(buckets)+1)}
+	}
+	r.register(h)
+	return h
+}
+
+// WithLabelValues returns the histogram for the given label values
+func (h *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return h.series.get(h.labels, values)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.series.each(func(values []string, hist *Histogram) {
+		var cumulative uint64
+		for i, bound := range hist.buckets {
+			cumulative += hist.counts[i].Load()
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(h.labels, values, "le", formatFloat(bound)), cumulative)
+		}
+		cumulative += hist.counts[len(hist.buckets)].Load()
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(h.labels, values, "le", "+Inf"), cumulative)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelString(h.labels, values), formatFloat(math.Float64frombits(hist.sumBits.Load())))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelString(h.labels, values), cumulative)
+	})
+}
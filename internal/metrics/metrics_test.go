@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+
+	packets := r.NewCounterVec("bridge_packets_total", "Packets translated.", "direction")
+	packets.WithLabelValues("ipv6_to_ipv4").Add(3)
+	packets.WithLabelValues("ipv4_to_ipv6").Inc()
+
+	errors := r.NewCounterVec("bridge_errors_total", "Errors, by \"reason\".\nSee the README.", "reason")
+	errors.WithLabelValues(`quoted "value"`).Inc()
+	errors.WithLabelValues(`back\slash`).Inc()
+	errors.WithLabelValues("two\nlines").Inc()
+
+	r.NewCounterVec("bridge_unused_total", `Help with a \ backslash.`)
+
+	r.NewGaugeFunc("bridge_sessions", "Active sessions.", []string{"protocol"}, func() []GaugeValue {
+		return []GaugeValue{{Labels: []string{"tcp"}, Value: 2}, {Labels: []string{"udp"}, Value: 0.5}}
+	})
+	r.NewGaugeFunc("bridge_up", "Whether the bridge is running.", nil, func() []GaugeValue {
+		return []GaugeValue{{Value: 1}}
+	})
+	r.NewGaugeFunc("bridge_limit", "Special values.", []string{"kind"}, func() []GaugeValue {
+		return []GaugeValue{{Labels: []string{"inf"}, Value: math.Inf(1)}, {Labels: []string{"nan"}, Value: math.NaN()}}
+	})
+
+	latency := r.NewHistogramVec("bridge_latency_seconds", "Translation latency.", []float64{0.01, 0.001}, "direction")
+	hist := latency.WithLabelValues("ipv6_to_ipv4")
+	hist.Observe(0.0005)
+	hist.Observe(0.005)
+	hist.Observe(0.25)
+
+	var out strings.Builder
+	if err := r.WriteText(&out); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+
+	want := `# HELP bridge_packets_total Packets translated.
+# TYPE bridge_packets_total counter
+bridge_packets_total{direction="ipv4_to_ipv6"} 1
+bridge_packets_total{direction="ipv6_to_ipv4"} 3
+# HELP bridge_errors_total Errors, by "reason".\nSee the README.
+# TYPE bridge_errors_total counter
+bridge_errors_total{reason="back\\slash"} 1
+bridge_errors_total{reason="quoted \"value\""} 1
+bridge_errors_total{reason="two\nlines"} 1
+# HELP bridge_unused_total Help with a \\ backslash.
+# TYPE bridge_unused_total counter
+# HELP bridge_sessions Active sessions.
+# TYPE bridge_sessions gauge
+bridge_sessions{protocol="tcp"} 2
+bridge_sessions{protocol="udp"} 0.5
+# HELP bridge_up Whether the bridge is running.
+# TYPE bridge_up gauge
+bridge_up 1
+# HELP bridge_limit Special values.
+# TYPE bridge_limit gauge
+bridge_limit{kind="inf"} +Inf
+bridge_limit{kind="nan"} NaN
+# HELP bridge_latency_seconds Translation latency.
+# TYPE bridge_latency_seconds histogram
+bridge_latency_seconds_bucket{direction="ipv6_to_ipv4",le="0.001"} 1
+bridge_latency_seconds_bucket{direction="ipv6_to_ipv4",le="0.01"} 2
+bridge_latency_seconds_bucket{direction="ipv6_to_ipv4",le="+Inf"} 3
+bridge_latency_seconds_sum{direction="ipv6_to_ipv4"} 0.2555
+bridge_latency_seconds_count{direction="ipv6_to_ipv4"} 3
+`
+	if got := out.String(); got != want {
+		t.Fatalf("WriteText output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWithLabelValuesReturnsSameChild(t *testing.T) {
+	c := NewRegistry().NewCounterVec("requests_total", "Requests.", "method", "code")
+	c.WithLabelValues("GET", "200").Inc()
+	c.WithLabelValues("GET", "200").Inc()
+	c.WithLabelValues("GET", "404").Inc()
+
+	if got := c.WithLabelValues("GET", "200").Value(); got != 2 {
+		t.Fatalf("GET 200 = %d, want 2", got)
+	}
+	if got := c.WithLabelValues("GET", "404").Value(); got != 1 {
+		t.Fatalf("GET 404 = %d, want 1", got)
+	}
+}
+
+func TestWithLabelValuesCountMismatch(t *testing.T) {
+	c := NewRegistry().NewCounterVec("requests_total", "Requests.", "method")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("WithLabelValues with too many values did not panic")
+		}
+	}()
+	c.WithLabelValues("GET", "200")
+}
@@ -0,0 +1,143 @@
+package nat
+
+import (
+	"fmt"
+	"net"
+)
+
+// FilteringMode controls which IPv4 hosts may send to an existing binding (RFC 4787 section 5)
+type FilteringMode int
+
+const (
+	// EndpointIndependentFiltering accepts packets from any IPv4 host
+	EndpointIndependentFiltering FilteringMode = iota
+	// AddressDependentFiltering accepts packets from IPv4 hosts the IPv6 host has sent to
+	AddressDependentFiltering
+	// AddressAndPortDependentFiltering accepts packets only from IPv4 transport addresses the IPv6 host has sent to
+	AddressAndPortDependentFiltering
+)
+
+// ParseFilteringMode parses a filtering mode name from the configuration
+func ParseFilteringMode(mode string) (FilteringMode, error) {
+	switch mode {
+	case "endpoint-independent":
+		return EndpointIndependentFiltering, nil
+	case "", "address-dependent":
+		return AddressDependentFiltering, nil
+	case "address-and-port-dependent":
+		return AddressAndPortDependentFiltering, nil
+	}
+	return 0, fmt.Errorf("unknown filtering mode %q", mode)
+}
+
+// String returns the configuration name of the filtering mode
+func (m FilteringMode) String() string {
+	switch m {
+	case EndpointIndependentFiltering:
+		return "endpoint-independent"
+	case AddressDependentFiltering:
+		return "address-dependent"
+	case AddressAndPortDependentFiltering:
+		return "address-and-port-dependent"
+	}
+	return "unknown"
+}
+
+// BindingEntry maps an IPv6 transport address to an allocated IPv4 transport
+// address (RFC 6146 Binding Information Base). All sessions from the same IPv6
+// transport address share one entry, giving endpoint-independent mapping.
+type BindingEntry struct {
+	Protocol uint8
+	IPv6IP   net.IP
+	IPv6Port uint16
+	IPv4IP   net.IP
+	IPv4Port uint16
+	Static   bool // Configured rather than created by IPv6 traffic
+
+	sessions map[*SessionState]struct{}
+}
+
+// ipv6TransportAddr is an IPv6 address and port
+type ipv6TransportAddr struct {
+	ip   [16]byte
+	port uint16
+}
+
+// newIPv6TransportAddr builds an IPv6 transport address key
+func newIPv6TransportAddr(ip net.IP, port uint16) ipv6TransportAddr {
+	var key ipv6TransportAddr
+	copy(key.ip[:], ip.To16())
+	key.port = port
+	return key
+}
+
+// bindingTable holds the BIB of one protocol, indexed from both sides
+type bindingTable struct {
+	byIPv6  map[ipv6TransportAddr]*BindingEntry
+	byIPv4  map[transportAddr]*BindingEntry
+	perIPv4 map[[4]byte]uint32 // Bindings on each IPv4 address, to skip full addresses
+}
+
+// newBindingTable creates an empty BIB
+func newBindingTable() *bindingTable {
+	return &bindingTable{
+		byIPv6:  make(map[ipv6TransportAddr]*BindingEntry),
+		byIPv4:  make(map[transportAddr]*BindingEntry),
+		perIPv4: make(map[[4]byte]uint32),
+	}
+}
+
+// add stores a binding in both indices
+func (bt *bindingTable) add(entry *BindingEntry) {
+	key := newTransportAddr(entry.IPv4IP, entry.IPv4Port)
+	if _, exists := bt.byIPv4[key]; !exists {
+		bt.perIPv4[key.ip]++
+	}
+	bt.byIPv6[newIPv6TransportAddr(entry.IPv6IP, entry.IPv6Port)] = entry
+	bt.byIPv4[key] = entry
+}
+
+// remove deletes a binding from both indices
+func (bt *bindingTable) remove(entry *BindingEntry) {
+	key := newTransportAddr(entry.IPv4IP, entry.IPv4Port)
+	if _, exists := bt.byIPv4[key]; exists {
+		if bt.perIPv4[key.ip]--; bt.perIPv4[key.ip] == 0 {
+			delete(bt.perIPv4, key.ip)
+		}
+	}
+	delete(bt.byIPv6, newIPv6TransportAddr(entry.IPv6IP, entry.IPv6Port))
+	delete(bt.byIPv4, key)
+}
+
+// bound returns the number of bindings on an IPv4 address
+func (bt *bindingTable) bound(ip net.IP) uint32 {
+	return bt.perIPv4[newTransportAddr(ip, 0).ip]
+}
+
+// allows reports whether the filtering mode lets an IPv4 host reach the binding
+func (entry *BindingEntry) allows(mode FilteringMode, srcIP net.IP, srcPort uint16) bool {
+	// Static bindings exist to be reached from IPv4
+	if entry.Static {
+		return true
+	}
+
+	switch mode {
+	case EndpointIndependentFiltering:
+		return true
+	case AddressDependentFiltering:
+		for session := range entry.sessions {
+			if session.IPv4DstIP.Equal(srcIP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// normalizeProtocol maps ICMPv4 onto ICMPv6, since sessions are keyed by the IPv6 side
+func normalizeProtocol(protocol uint8) uint8 {
+	if protocol == 1 {
+		return 58
+	}
+	return protocol
+}
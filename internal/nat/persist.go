@@ -0,0 +1,189 @@
+package nat
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateVersion is the format version of the state file
+const stateVersion = 1
+
+// tableState is the on-disk snapshot of a NAT table
+type tableState struct {
+	Version  int             `json:"version"`
+	SavedAt  time.Time       `json:"saved_at"`
+	Sessions []sessionRecord `json:"sessions"`
+}
+
+// sessionRecord is the on-disk form of a session
+type sessionRecord struct {
+	Protocol        uint8     `json:"protocol"`
+	IPv6SrcIP       net.IP    `json:"ipv6_src_ip"`
+	IPv6SrcPort     uint16    `json:"ipv6_src_port"`
+	IPv6DstIP       net.IP    `json:"ipv6_dst_ip"`
+	IPv6DstPort     uint16    `json:"ipv6_dst_port"`
+	IPv4SrcIP       net.IP    `json:"ipv4_src_ip"`
+	IPv4SrcPort     uint16    `json:"ipv4_src_port"`
+	IPv4DstIP       net.IP    `json:"ipv4_dst_ip"`
+	IPv4DstPort     uint16    `json:"ipv4_dst_port"`
+	CreatedAt       time.Time `json:"created_at"`
+	LastActivity    time.Time `json:"last_activity"`
+	BytesSent       uint64    `json:"bytes_sent"`
+	BytesReceived   uint64    `json:"bytes_received"`
+	PacketsSent     uint64    `json:"packets_sent"`
+	PacketsReceived uint64    `json:"packets_received"`
+	State           string    `json:"state"`
+}
+
+// SaveState writes a snapshot of all sessions to path. The file is replaced
+// atomically so a crash never leaves a partial snapshot behind.
+func (nt *NATTable) SaveState(path string) error {
+	nt.mu.RLock()
+	state := tableState{
+		Version:  stateVersion,
+		SavedAt:  time.Now(),
+		Sessions: make([]sessionRecord, 0, len(nt.sessions)),
+	}
+	for _, session := range nt.sessions {
+		state.Sessions = append(state.Sessions, sessionRecord{
+			Protocol:        session.Protocol,
+			IPv6SrcIP:       session.IPv6SrcIP,
+			IPv6SrcPort:     session.IPv6SrcPort,
+			IPv6DstIP:       session.IPv6DstIP,
+			IPv6DstPort:     session.IPv6DstPort,
+			IPv4SrcIP:       session.IPv4SrcIP,
+			IPv4SrcPort:     session.IPv4SrcPort,
+			IPv4DstIP:       session.IPv4DstIP,
+			IPv4DstPort:     session.IPv4DstPort,
+			CreatedAt:       session.CreatedAt,
+			LastActivity:    session.LastActivity,
+			BytesSent:       session.BytesSent,
+			BytesReceived:   session.BytesReceived,
+			PacketsSent:     session.PacketsSent,
+			PacketsReceived: session.PacketsReceived,
+			State:           session.State,
+		})
+	}
+	nt.mu.RUnlock()
+
+	data, err := json.Marshal(&state)
+	if err != nil {
+		return fmt.Errorf("failed to encode NAT state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to write NAT state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write NAT state: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write NAT state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write NAT state: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write NAT state: %w", err)
+	}
+
+	return nil
+}
+
+// LoadState restores sessions from a snapshot written by SaveState. Sessions
+// that have expired in the meantime, or whose IPv4 transport address is no
+// longer in the pool or a static binding, are skipped. A missing file is not
+// an error. It returns the number of sessions restored.
+func (nt *NATTable) LoadState(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read NAT state: %w", err)
+	}
+
+	var state tableState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return 0, fmt.Errorf("failed to decode NAT state %s: %w", path, err)
+	}
+	if state.Version != stateVersion {
+		return 0, fmt.Errorf("unsupported NAT state version %d in %s", state.Version, path)
+	}
+
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+
+	now := time.Now()
+	restored := 0
+
+	for _, record := range state.Sessions {
+		session := &SessionState{
+			ID:              fmt.Sprintf("%d:%s:%d->%s:%d", record.Protocol, record.IPv6SrcIP, record.IPv6SrcPort, record.IPv6DstIP, record.IPv6DstPort),
+			Protocol:        record.Protocol,
+			IPv6SrcIP:       record.IPv6SrcIP,
+			IPv6SrcPort:     record.IPv6SrcPort,
+			IPv6DstIP:       record.IPv6DstIP,
+			IPv6DstPort:     record.IPv6DstPort,
+			IPv4SrcIP:       record.IPv4SrcIP,
+			IPv4SrcPort:     record.IPv4SrcPort,
+			IPv4DstIP:       record.IPv4DstIP,
+			IPv4DstPort:     record.IPv4DstPort,
+			CreatedAt:       record.CreatedAt,
+			LastActivity:    record.LastActivity,
+			BytesSent:       record.BytesSent,
+			BytesReceived:   record.BytesReceived,
+			PacketsSent:     record.PacketsSent,
+			PacketsReceived: record.PacketsReceived,
+			State:           record.State,
+		}
+
+		// The clock kept running while the bridge was down
+		if now.Sub(session.LastActivity) > nt.sessionTimeout(session) {
+			continue
+		}
+		if _, exists := nt.sessions[session.ID]; exists {
+			continue
+		}
+
+		// Sessions of one IPv6 transport address share a binding, which may
+		// be a static binding added before the state was loaded
+		bt := nt.bib(session.Protocol)
+		binding, exists := bt.byIPv6[newIPv6TransportAddr(session.IPv6SrcIP, session.IPv6SrcPort)]
+		if !exists {
+			if !nt.pool.Contains(session.IPv4SrcIP, session.IPv4SrcPort) {
+				continue
+			}
+			if _, taken := bt.byIPv4[newTransportAddr(session.IPv4SrcIP, session.IPv4SrcPort)]; taken {
+				continue
+			}
+
+			binding = &BindingEntry{
+				Protocol: session.Protocol,
+				IPv6IP:   session.IPv6SrcIP,
+				IPv6Port: session.IPv6SrcPort,
+				IPv4IP:   session.IPv4SrcIP,
+				IPv4Port: session.IPv4SrcPort,
+				sessions: make(map[*SessionState]struct{}),
+			}
+			bt.add(binding)
+		} else if !binding.IPv4IP.Equal(session.IPv4SrcIP) || binding.IPv4Port != session.IPv4SrcPort {
+			continue
+		}
+
+		nt.addSession(session, binding)
+		restored++
+	}
+
+	return restored, nil
+}
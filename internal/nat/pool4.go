@@ -0,0 +1,183 @@
+package nat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Default port range used when a pool4 entry does not specify one
+const (
+	DefaultPoolPortStart = 1024
+	DefaultPoolPortEnd   = 65535
+)
+
+// Pool4Range is a block of IPv4 addresses sharing a port range
+type Pool4Range struct {
+	Network   *net.IPNet
+	PortStart uint16
+	PortEnd   uint16
+}
+
+// Pool4 is the set of IPv4 transport addresses the NAT allocates from
+type Pool4 struct {
+	ranges    []Pool4Range
+	addresses uint32 // Total number of addresses across all ranges
+}
+
+// transportAddr is an IPv4 address and port in the pool
+type transportAddr struct {
+	ip   [4]byte
+	port uint16
+}
+
+// newTransportAddr builds a transport address key
+func newTransportAddr(ip net.IP, port uint16) transportAddr {
+	var key transportAddr
+	copy(key.ip[:], ip.To4())
+	key.port = port
+	return key
+}
+
+// ParsePool4Range parses an IPv4 address or CIDR and a "start-end" port range
+func ParsePool4Range(prefix, ports string) (Pool4Range, error) {
+	if !strings.Contains(prefix, "/") {
+		prefix += "/32"
+	}
+
+	ip, ipNet, err := net.ParseCIDR(prefix)
+	if err != nil || ip.To4() == nil {
+		return Pool4Range{}, fmt.Errorf("invalid pool4 prefix %q", prefix)
+	}
+	ipNet.IP = ipNet.IP.To4()
+
+	if ones, bits := ipNet.Mask.Size(); bits-ones > 16 {
+		return Pool4Range{}, fmt.Errorf("pool4 prefix %q is too large (at most /16)", prefix)
+	}
+
+	r := Pool4Range{
+		Network:   ipNet,
+		PortStart: DefaultPoolPortStart,
+		PortEnd:   DefaultPoolPortEnd,
+	}
+
+	if ports != "" {
+		start, end, found := strings.Cut(ports, "-")
+		if !found {
+			end = start
+		}
+
+		portStart, err := strconv.ParseUint(strings.TrimSpace(start), 10, 16)
+		if err != nil {
+			return Pool4Range{}, fmt.Errorf("invalid pool4 port range %q", ports)
+		}
+		portEnd, err := strconv.ParseUint(strings.TrimSpace(end), 10, 16)
+		if err != nil {
+			return Pool4Range{}, fmt.Errorf("invalid pool4 port range %q", ports)
+		}
+		if portStart == 0 || portStart > portEnd {
+			return Pool4Range{}, fmt.Errorf("invalid pool4 port range %q", ports)
+		}
+
+		r.PortStart = uint16(portStart)
+		r.PortEnd = uint16(portEnd)
+	}
+
+	return r, nil
+}
+
+// NewPool4 creates a pool from one or more ranges
+func NewPool4(ranges []Pool4Range) (*Pool4, error) {
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("pool4 is empty")
+	}
+
+	pool := &Pool4{}
+	for i, r := range ranges {
+		for _, other := range ranges[:i] {
+			if r.Network.Contains(other.Network.IP) || other.Network.Contains(r.Network.IP) {
+				return nil, fmt.Errorf("pool4 ranges %s and %s overlap", other.Network, r.Network)
+			}
+		}
+
+		pool.ranges = append(pool.ranges, r)
+		pool.addresses += r.size()
+	}
+
+	return pool, nil
+}
+
+// size returns the number of addresses in the range
+func (r Pool4Range) size() uint32 {
+	ones, bits := r.Network.Mask.Size()
+	return 1 << uint(bits-ones)
+}
+
+// ports returns the number of ports in the range
+func (r Pool4Range) ports() uint32 {
+	return uint32(r.PortEnd) - uint32(r.PortStart) + 1
+}
+
+// address returns the address at an index across all ranges, with its port range
+func (p *Pool4) address(index uint32) (net.IP, Pool4Range) {
+	for _, r := range p.ranges {
+		if index < r.size() {
+			base := binary.BigEndian.Uint32(r.Network.IP.To4())
+			ip := make(net.IP, 4)
+			binary.BigEndian.PutUint32(ip, base+index)
+			return ip, r
+		}
+		index -= r.size()
+	}
+
+	return nil, Pool4Range{}
+}
+
+// Contains checks if an IPv4 transport address belongs to the pool
+func (p *Pool4) Contains(ip net.IP, port uint16) bool {
+	for _, r := range p.ranges {
+		if r.Network.Contains(ip) && port >= r.PortStart && port <= r.PortEnd {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsAddress checks if an IPv4 address belongs to the pool, regardless of port
+func (p *Pool4) ContainsAddress(ip net.IP) bool {
+	for _, r := range p.ranges {
+		if r.Network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Size returns the total number of transport addresses in the pool
+func (p *Pool4) Size() uint64 {
+	var size uint64
+	for _, r := range p.ranges {
+		size += uint64(r.size()) * uint64(r.ports())
+	}
+	return size
+}
+
+// String returns a human-readable description of the pool
+func (p *Pool4) String() string {
+	parts := make([]string, 0, len(p.ranges))
+	for _, r := range p.ranges {
+		parts = append(parts, fmt.Sprintf("%s ports %d-%d", r.Network, r.PortStart, r.PortEnd))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// preferredAddress picks the pool address for an IPv6 host, so that all of
+// its sessions share one IPv4 address while it has free ports (RFC 4787 REQ-2)
+func (p *Pool4) preferredAddress(ipv6Src net.IP) uint32 {
+	h := fnv.New32a()
+	h.Write(ipv6Src.To16())
+	return h.Sum32() % p.addresses
+}
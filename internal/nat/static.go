@@ -0,0 +1,156 @@
+package nat
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ParseProtocol parses a protocol name or number. ICMP maps to ICMPv6, the
+// protocol sessions are keyed by.
+func ParseProtocol(name string) (uint8, error) {
+	switch strings.ToLower(name) {
+	case "tcp", "6":
+		return 6, nil
+	case "udp", "17":
+		return 17, nil
+	case "icmp", "icmpv6", "1", "58":
+		return 58, nil
+	}
+	return 0, fmt.Errorf("invalid protocol %q (tcp, udp or icmp)", name)
+}
+
+// AddStaticBinding adds a permanent binding that lets IPv4 hosts reach an
+// IPv6 transport address through an IPv4 pool address and port. Static
+// bindings accept packets from any IPv4 peer and are never expired.
+func (nt *NATTable) AddStaticBinding(protocol uint8, ipv6IP net.IP, ipv6Port uint16, ipv4IP net.IP, ipv4Port uint16) (*BindingEntry, error) {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+
+	binding, err := nt.addStaticBinding(protocol, ipv6IP, ipv6Port, ipv4IP, ipv4Port)
+	if err != nil {
+		return nil, err
+	}
+
+	copied := *binding
+	return &copied, nil
+}
+
+// addStaticBinding adds a static binding. The caller must hold nt.mu.
+func (nt *NATTable) addStaticBinding(protocol uint8, ipv6IP net.IP, ipv6Port uint16, ipv4IP net.IP, ipv4Port uint16) (*BindingEntry, error) {
+	if protocol != 6 && protocol != 17 {
+		return nil, fmt.Errorf("static bindings must be TCP or UDP")
+	}
+	if ipv6IP.To16() == nil || ipv6IP.To4() != nil {
+		return nil, fmt.Errorf("invalid IPv6 address %s for static binding", ipv6IP)
+	}
+	if ipv4IP.To4() == nil {
+		return nil, fmt.Errorf("invalid IPv4 address %s for static binding", ipv4IP)
+	}
+	if ipv6Port == 0 || ipv4Port == 0 {
+		return nil, fmt.Errorf("static binding ports must not be zero")
+	}
+	if !nt.pool.ContainsAddress(ipv4IP) {
+		return nil, fmt.Errorf("static binding address %s is not in pool4 (%s)", ipv4IP, nt.pool)
+	}
+
+	bt := nt.bib(protocol)
+	if existing, exists := bt.byIPv4[newTransportAddr(ipv4IP, ipv4Port)]; exists {
+		return nil, fmt.Errorf("%s:%d is already bound to [%s]:%d", ipv4IP, ipv4Port, existing.IPv6IP, existing.IPv6Port)
+	}
+	if existing, exists := bt.byIPv6[newIPv6TransportAddr(ipv6IP, ipv6Port)]; exists {
+		return nil, fmt.Errorf("[%s]:%d is already bound to %s:%d", ipv6IP, ipv6Port, existing.IPv4IP, existing.IPv4Port)
+	}
+
+	binding := &BindingEntry{
+		Protocol: protocol,
+		IPv6IP:   ipv6IP.To16(),
+		IPv6Port: ipv6Port,
+		IPv4IP:   ipv4IP.To4(),
+		IPv4Port: ipv4Port,
+		Static:   true,
+		sessions: make(map[*SessionState]struct{}),
+	}
+	bt.add(binding)
+	return binding, nil
+}
+
+// ReplaceStaticBindings removes the static bindings at the IPv4 transport
+// addresses of remove and adds the bindings of add in one step. If any of
+// them cannot be added the table is left unchanged, sessions included.
+func (nt *NATTable) ReplaceStaticBindings(remove, add []BindingEntry) error {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+
+	// Take the old bindings out first, so the new ones may reuse their
+	// addresses, but keep their sessions until the new ones are in
+	var removed []*BindingEntry
+	for _, entry := range remove {
+		bt := nt.bib(entry.Protocol)
+		binding, exists := bt.byIPv4[newTransportAddr(entry.IPv4IP, entry.IPv4Port)]
+		if exists && binding.Static {
+			bt.remove(binding)
+			removed = append(removed, binding)
+		}
+	}
+
+	var added []*BindingEntry
+	for _, entry := range add {
+		binding, err := nt.addStaticBinding(entry.Protocol, entry.IPv6IP, entry.IPv6Port, entry.IPv4IP, entry.IPv4Port)
+		if err != nil {
+			for _, binding := range added {
+				nt.bib(binding.Protocol).remove(binding)
+			}
+			for _, binding := range removed {
+				nt.bib(binding.Protocol).add(binding)
+			}
+			return err
+		}
+		added = append(added, binding)
+	}
+
+	for _, binding := range removed {
+		for session := range binding.sessions {
+			nt.removeSession(session, false)
+		}
+	}
+	return nil
+}
+
+// RemoveStaticBinding removes a static binding and all of its sessions. It
+// reports whether the binding existed.
+func (nt *NATTable) RemoveStaticBinding(protocol uint8, ipv4IP net.IP, ipv4Port uint16) bool {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+
+	bt := nt.bib(protocol)
+	binding, exists := bt.byIPv4[newTransportAddr(ipv4IP, ipv4Port)]
+	if !exists || !binding.Static {
+		return false
+	}
+
+	for session := range binding.sessions {
+		nt.removeSession(session, false)
+	}
+	bt.remove(binding)
+
+	return true
+}
+
+// GetStaticBindings returns all static bindings
+func (nt *NATTable) GetStaticBindings() []*BindingEntry {
+	nt.mu.RLock()
+	defer nt.mu.RUnlock()
+
+	var bindings []*BindingEntry
+	for _, bt := range nt.bibs {
+		for _, binding := range bt.byIPv4 {
+			if binding.Static {
+				copied := *binding
+				bindings = append(bindings, &copied)
+			}
+		}
+	}
+
+	return bindings
+}
@@ -1,12 +1,17 @@
 package nat
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"sync"
 	"time"
+
+	"github.com/mdxabu/bridge/internal/logger"
 )
 
+var natLog = logger.For(logger.NAT)
+
 // SessionState represents the state of a NAT session
 type SessionState struct {
 	ID              string
@@ -25,34 +30,135 @@ type SessionState struct {
 	BytesReceived   uint64
 	PacketsSent     uint64
 	PacketsReceived uint64
-	State           string // NEW, ESTABLISHED, CLOSING, CLOSED
+	State           string // NEW, ESTABLISHED for UDP and ICMP; TCPState* for TCP
+
+	binding *BindingEntry
+}
+
+// ipv4SessionKey identifies a session from the IPv4 side: the allocated
+// transport address and the IPv4 peer
+type ipv4SessionKey struct {
+	protocol uint8
+	local    transportAddr
+	remote   transportAddr
 }
 
+// Errors returned when an IPv4 packet cannot be matched to a binding
+var (
+	ErrNoBinding = errors.New("no binding for IPv4 transport address")
+	ErrFiltered  = errors.New("IPv4 packet rejected by filtering policy")
+)
+
 // NATTable manages NAT sessions
 type NATTable struct {
-	sessions       map[string]*SessionState
-	portMappings   map[uint16]*SessionState // Maps allocated ports to sessions
-	mu             sync.RWMutex
-	nextPort       uint16
-	portRangeStart uint16
-	portRangeEnd   uint16
-	timeoutTCP     time.Duration
-	timeoutUDP     time.Duration
+	sessions     map[string]*SessionState
+	ipv4Sessions map[ipv4SessionKey]*SessionState
+	bibs         map[uint8]*bindingTable // Binding tables per protocol
+	filtering    FilteringMode
+	observer     SessionObserver
+	mu           sync.RWMutex
+	pool         *Pool4
+	nextPort     uint32
+	timeoutUDP   time.Duration
+	timeoutICMP  time.Duration
+
+	timeoutTCPEst         time.Duration
+	timeoutTCPTrans       time.Duration
+	timeoutTCPIncomingSYN time.Duration
 }
 
-// NewNATTable creates a new NAT table
-func NewNATTable() *NATTable {
+// NewNATTable creates a new NAT table allocating from the given pool
+func NewNATTable(pool *Pool4) *NATTable {
 	return &NATTable{
-		sessions:       make(map[string]*SessionState),
-		portMappings:   make(map[uint16]*SessionState),
-		nextPort:       10000,
-		portRangeStart: 10000,
-		portRangeEnd:   65000,
-		timeoutTCP:     300 * time.Second,  // 5 minutes for TCP
-		timeoutUDP:     60 * time.Second,   // 1 minute for UDP
+		sessions:     make(map[string]*SessionState),
+		ipv4Sessions: make(map[ipv4SessionKey]*SessionState),
+		bibs: map[uint8]*bindingTable{
+			6:  newBindingTable(),
+			17: newBindingTable(),
+			58: newBindingTable(),
+		},
+		filtering:   AddressDependentFiltering,
+		pool:        pool,
+		timeoutUDP:  60 * time.Second, // 1 minute for UDP
+		timeoutICMP: 60 * time.Second, // 1 minute for ICMP echo
+
+		timeoutTCPEst:         TCPEstablishedTimeout,
+		timeoutTCPTrans:       TCPTransitoryTimeout,
+		timeoutTCPIncomingSYN: TCPIncomingSYNTimeout,
+	}
+}
+
+// SessionObserver is notified when sessions are created and removed. It is
+// called with the table locked and must not call back into the table.
+type SessionObserver interface {
+	SessionCreated(session *SessionState)
+	SessionRemoved(session *SessionState, expired bool)
+}
+
+// SetObserver registers an observer for session creation and removal
+func (nt *NATTable) SetObserver(observer SessionObserver) {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+
+	nt.observer = observer
+}
+
+// SetFiltering sets the policy for IPv4 packets from peers without a session
+func (nt *NATTable) SetFiltering(mode FilteringMode) {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+
+	nt.filtering = mode
+}
+
+// Timeouts are the idle timeouts of NAT sessions
+type Timeouts struct {
+	UDP            time.Duration
+	ICMP           time.Duration
+	TCPEstablished time.Duration
+	TCPTransitory  time.Duration
+}
+
+// SetTimeouts changes the idle timeouts of sessions, including existing ones.
+// Zero values leave a timeout unchanged.
+func (nt *NATTable) SetTimeouts(t Timeouts) {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+
+	if t.UDP > 0 {
+		nt.timeoutUDP = t.UDP
+	}
+	if t.ICMP > 0 {
+		nt.timeoutICMP = t.ICMP
+	}
+	if t.TCPEstablished > 0 {
+		nt.timeoutTCPEst = t.TCPEstablished
+	}
+	if t.TCPTransitory > 0 {
+		nt.timeoutTCPTrans = t.TCPTransitory
 	}
 }
 
+// SetPool replaces the pool new bindings are allocated from. Existing bindings
+// keep their transport addresses, even outside the new pool, until their
+// sessions expire.
+func (nt *NATTable) SetPool(pool *Pool4) {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+
+	nt.pool = pool
+}
+
+// bib returns the binding table of a protocol, creating it if needed
+func (nt *NATTable) bib(protocol uint8) *bindingTable {
+	bt, exists := nt.bibs[protocol]
+	if !exists {
+		bt = newBindingTable()
+		nt.bibs[protocol] = bt
+	}
+	return bt
+}
+
 // CreateSession creates a new NAT session
 func (nt *NATTable) CreateSession(protocol uint8, ipv6Src net.IP, ipv6SrcPort uint16, ipv6Dst net.IP, ipv6DstPort uint16, ipv4Dst net.IP) (*SessionState, error) {
 	nt.mu.Lock()
@@ -61,45 +167,158 @@ func (nt *NATTable) CreateSession(protocol uint8, ipv6Src net.IP, ipv6SrcPort ui
 	// Generate session ID
 	sessionID := fmt.Sprintf("%d:%s:%d->%s:%d", protocol, ipv6Src, ipv6SrcPort, ipv6Dst, ipv6DstPort)
 
-	// Check if session already exists
+	// Check if session already exists. Its lifetime is left to
+	// UpdateSession, which knows the TCP state.
 	if session, exists := nt.sessions[sessionID]; exists {
-		session.LastActivity = time.Now()
 		return session, nil
 	}
 
-	// Allocate a new port for this session
-	port, err := nt.allocatePort()
-	if err != nil {
-		return nil, err
+	// Reuse the binding of the IPv6 source transport address, so that it
+	// keeps the same IPv4 transport address for every destination
+	bt := nt.bib(protocol)
+	binding, exists := bt.byIPv6[newIPv6TransportAddr(ipv6Src, ipv6SrcPort)]
+	if !exists {
+		ipv4Src, port, err := nt.allocatePort(bt, ipv6Src)
+		if err != nil {
+			return nil, err
+		}
+
+		binding = &BindingEntry{
+			Protocol: protocol,
+			IPv6IP:   ipv6Src,
+			IPv6Port: ipv6SrcPort,
+			IPv4IP:   ipv4Src,
+			IPv4Port: port,
+			sessions: make(map[*SessionState]struct{}),
+		}
+		bt.add(binding)
 	}
 
-	// Extract IPv4 from NAT64 address
+	// ICMP echo uses the identifier in both directions
 	ipv4DstPort := ipv6DstPort
+	if protocol == 58 {
+		ipv4DstPort = binding.IPv4Port
+	}
 
 	// Create new session
 	session := &SessionState{
-		ID:            sessionID,
-		Protocol:      protocol,
-		IPv6SrcIP:     ipv6Src,
-		IPv6SrcPort:   ipv6SrcPort,
-		IPv6DstIP:     ipv6Dst,
-		IPv6DstPort:   ipv6DstPort,
-		IPv4SrcIP:     net.ParseIP("10.64.0.1").To4(), // NAT gateway address
-		IPv4SrcPort:   port,
-		IPv4DstIP:     ipv4Dst,
-		IPv4DstPort:   ipv4DstPort,
-		CreatedAt:     time.Now(),
-		LastActivity:  time.Now(),
-		State:         "NEW",
-	}
-
-	// Store session
-	nt.sessions[sessionID] = session
-	nt.portMappings[port] = session
+		ID:           sessionID,
+		Protocol:     protocol,
+		IPv6SrcIP:    ipv6Src,
+		IPv6SrcPort:  ipv6SrcPort,
+		IPv6DstIP:    ipv6Dst,
+		IPv6DstPort:  ipv6DstPort,
+		IPv4SrcIP:    binding.IPv4IP,
+		IPv4SrcPort:  binding.IPv4Port,
+		IPv4DstIP:    ipv4Dst,
+		IPv4DstPort:  ipv4DstPort,
+		CreatedAt:    time.Now(),
+		LastActivity: time.Now(),
+		State:        initialState(protocol),
+	}
+
+	nt.addSession(session, binding)
+	if nt.observer != nil {
+		nt.observer.SessionCreated(session)
+	}
 
 	return session, nil
 }
 
+// CreateInboundSession creates a session for an IPv4 peer sending to an
+// existing binding, if the filtering policy allows it. ipv6Src is the IPv4
+// peer's address embedded in the NAT64 prefix.
+func (nt *NATTable) CreateInboundSession(protocol uint8, srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, ipv6Src net.IP) (*SessionState, error) {
+	nt.mu.Lock()
+	defer nt.mu.Unlock()
+
+	protocol = normalizeProtocol(protocol)
+
+	// Another packet may have created the session in the meantime
+	key := ipv4SessionKey{protocol, newTransportAddr(dstIP, dstPort), newTransportAddr(srcIP, srcPort)}
+	if session, exists := nt.ipv4Sessions[key]; exists {
+		return session, nil
+	}
+
+	binding, exists := nt.bib(protocol).byIPv4[key.local]
+	if !exists {
+		return nil, ErrNoBinding
+	}
+	if !binding.allows(nt.filtering, srcIP, srcPort) {
+		return nil, ErrFiltered
+	}
+
+	// ICMP echo uses the identifier in both directions
+	ipv6DstPort := srcPort
+	if protocol == 58 {
+		ipv6DstPort = binding.IPv6Port
+	}
+
+	session := &SessionState{
+		ID:           fmt.Sprintf("%d:%s:%d->%s:%d", protocol, binding.IPv6IP, binding.IPv6Port, ipv6Src, ipv6DstPort),
+		Protocol:     protocol,
+		IPv6SrcIP:    binding.IPv6IP,
+		IPv6SrcPort:  binding.IPv6Port,
+		IPv6DstIP:    ipv6Src,
+		IPv6DstPort:  ipv6DstPort,
+		IPv4SrcIP:    binding.IPv4IP,
+		IPv4SrcPort:  binding.IPv4Port,
+		IPv4DstIP:    srcIP,
+		IPv4DstPort:  srcPort,
+		CreatedAt:    time.Now(),
+		LastActivity: time.Now(),
+		State:        initialState(protocol),
+	}
+
+	nt.addSession(session, binding)
+	if nt.observer != nil {
+		nt.observer.SessionCreated(session)
+	}
+
+	return session, nil
+}
+
+// initialState returns the state of a session before any packet is tracked
+func initialState(protocol uint8) string {
+	if protocol == 6 {
+		return TCPStateClosed
+	}
+	return "NEW"
+}
+
+// addSession stores a session and attaches it to its binding
+func (nt *NATTable) addSession(session *SessionState, binding *BindingEntry) {
+	session.binding = binding
+	binding.sessions[session] = struct{}{}
+
+	nt.sessions[session.ID] = session
+	nt.ipv4Sessions[ipv4SessionKey{
+		protocol: session.Protocol,
+		local:    newTransportAddr(session.IPv4SrcIP, session.IPv4SrcPort),
+		remote:   newTransportAddr(session.IPv4DstIP, session.IPv4DstPort),
+	}] = session
+}
+
+// removeSession deletes a session and releases its binding once unused
+func (nt *NATTable) removeSession(session *SessionState, expired bool) {
+	if nt.observer != nil {
+		nt.observer.SessionRemoved(session, expired)
+	}
+
+	delete(nt.sessions, session.ID)
+	delete(nt.ipv4Sessions, ipv4SessionKey{
+		protocol: session.Protocol,
+		local:    newTransportAddr(session.IPv4SrcIP, session.IPv4SrcPort),
+		remote:   newTransportAddr(session.IPv4DstIP, session.IPv4DstPort),
+	})
+
+	binding := session.binding
+	delete(binding.sessions, session)
+	if len(binding.sessions) == 0 && !binding.Static {
+		nt.bib(binding.Protocol).remove(binding)
+	}
+}
+
 // LookupSessionIPv6toIPv4 looks up a session for IPv6 to IPv4 translation
 func (nt *NATTable) LookupSessionIPv6toIPv4(protocol uint8, srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16) (*SessionState, bool) {
 	nt.mu.RLock()
@@ -107,29 +326,35 @@ func (nt *NATTable) LookupSessionIPv6toIPv4(protocol uint8, srcIP net.IP, srcPor
 
 	sessionID := fmt.Sprintf("%d:%s:%d->%s:%d", protocol, srcIP, srcPort, dstIP, dstPort)
 	session, exists := nt.sessions[sessionID]
-	
+
 	if exists {
 		return session, true
 	}
-	
+
 	return nil, false
 }
 
-// LookupSessionIPv4toIPv6 looks up a session for IPv4 to IPv6 translation (reverse)
-func (nt *NATTable) LookupSessionIPv4toIPv6(protocol uint8, dstPort uint16) (*SessionState, bool) {
+// LookupSessionIPv4toIPv6 looks up a session for IPv4 to IPv6 translation
+// (reverse) by the IPv4 peer and the allocated transport address. For ICMP
+// echo the ports are the identifier.
+func (nt *NATTable) LookupSessionIPv4toIPv6(protocol uint8, srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16) (*SessionState, bool) {
 	nt.mu.RLock()
 	defer nt.mu.RUnlock()
 
-	session, exists := nt.portMappings[dstPort]
-	if exists && session.Protocol == protocol {
-		return session, true
+	// ICMPv4 replies belong to sessions created for ICMPv6
+	key := ipv4SessionKey{
+		protocol: normalizeProtocol(protocol),
+		local:    newTransportAddr(dstIP, dstPort),
+		remote:   newTransportAddr(srcIP, srcPort),
 	}
-	
-	return nil, false
+
+	session, exists := nt.ipv4Sessions[key]
+	return session, exists
 }
 
-// UpdateSession updates session statistics
-func (nt *NATTable) UpdateSession(sessionID string, bytesSent uint64, direction string) {
+// UpdateSession updates session statistics. For TCP sessions tcpFlags are the
+// flags of the translated segment and drive the session state.
+func (nt *NATTable) UpdateSession(sessionID string, bytesSent uint64, direction string, tcpFlags uint8) {
 	nt.mu.Lock()
 	defer nt.mu.Unlock()
 
@@ -138,8 +363,10 @@ func (nt *NATTable) UpdateSession(sessionID string, bytesSent uint64, direction
 		return
 	}
 
-	session.LastActivity = time.Now()
-	
+	if session.Protocol != 6 || trackTCP(session, tcpFlags, direction) {
+		session.LastActivity = time.Now()
+	}
+
 	if direction == "outbound" {
 		session.BytesSent += bytesSent
 		session.PacketsSent++
@@ -149,24 +376,24 @@ func (nt *NATTable) UpdateSession(sessionID string, bytesSent uint64, direction
 	}
 
 	// Update state based on activity
-	if session.State == "NEW" && session.PacketsReceived > 0 {
+	if session.Protocol != 6 && session.State == "NEW" && session.PacketsReceived > 0 {
 		session.State = "ESTABLISHED"
 	}
 }
 
-// RemoveSession removes a session from the NAT table
-func (nt *NATTable) RemoveSession(sessionID string) {
+// RemoveSession removes a session from the NAT table. It reports whether the
+// session existed.
+func (nt *NATTable) RemoveSession(sessionID string) bool {
 	nt.mu.Lock()
 	defer nt.mu.Unlock()
 
 	session, exists := nt.sessions[sessionID]
 	if !exists {
-		return
+		return false
 	}
 
-	// Free the port
-	delete(nt.portMappings, session.IPv4SrcPort)
-	delete(nt.sessions, sessionID)
+	nt.removeSession(session, false)
+	return true
 }
 
 // CleanupExpiredSessions removes expired sessions
@@ -177,22 +404,10 @@ func (nt *NATTable) CleanupExpiredSessions() int {
 	now := time.Now()
 	removed := 0
 
-	for sessionID, session := range nt.sessions {
-		var timeout time.Duration
-		
-		// Set timeout based on protocol
-		if session.Protocol == 6 { // TCP
-			timeout = nt.timeoutTCP
-		} else if session.Protocol == 17 { // UDP
-			timeout = nt.timeoutUDP
-		} else {
-			timeout = 30 * time.Second
-		}
-
+	for _, session := range nt.sessions {
 		// Remove if expired
-		if now.Sub(session.LastActivity) > timeout {
-			delete(nt.portMappings, session.IPv4SrcPort)
-			delete(nt.sessions, sessionID)
+		if now.Sub(session.LastActivity) > nt.sessionTimeout(session) {
+			nt.removeSession(session, true)
 			removed++
 		}
 	}
@@ -200,27 +415,80 @@ func (nt *NATTable) CleanupExpiredSessions() int {
 	return removed
 }
 
-// GetAllSessions returns all active sessions
+// sessionTimeout returns how long a session may stay idle
+func (nt *NATTable) sessionTimeout(session *SessionState) time.Duration {
+	switch session.Protocol {
+	case 6: // TCP
+		return nt.tcpTimeout(session)
+	case 17: // UDP
+		return nt.timeoutUDP
+	case 58: // ICMPv6
+		return nt.timeoutICMP
+	}
+	return 30 * time.Second
+}
+
+// GetAllSessions returns a snapshot of all active sessions
 func (nt *NATTable) GetAllSessions() []*SessionState {
 	nt.mu.RLock()
 	defer nt.mu.RUnlock()
 
 	sessions := make([]*SessionState, 0, len(nt.sessions))
 	for _, session := range nt.sessions {
-		sessions = append(sessions, session)
+		snapshot := *session
+		sessions = append(sessions, &snapshot)
 	}
 
 	return sessions
 }
 
+// GetSession returns a snapshot of the session with the given ID
+func (nt *NATTable) GetSession(sessionID string) (*SessionState, bool) {
+	nt.mu.RLock()
+	defer nt.mu.RUnlock()
+
+	session, exists := nt.sessions[sessionID]
+	if !exists {
+		return nil, false
+	}
+
+	snapshot := *session
+	return &snapshot, true
+}
+
 // GetSessionCount returns the number of active sessions
 func (nt *NATTable) GetSessionCount() int {
 	nt.mu.RLock()
 	defer nt.mu.RUnlock()
-	
+
 	return len(nt.sessions)
 }
 
+// ProtocolUsage returns the number of sessions and bindings of a protocol
+func (nt *NATTable) ProtocolUsage(protocol uint8) (sessions, bindings int) {
+	nt.mu.RLock()
+	defer nt.mu.RUnlock()
+
+	protocol = normalizeProtocol(protocol)
+	for _, session := range nt.sessions {
+		if session.Protocol == protocol {
+			sessions++
+		}
+	}
+	if bt, exists := nt.bibs[protocol]; exists {
+		bindings = len(bt.byIPv4)
+	}
+	return sessions, bindings
+}
+
+// PoolSize returns the number of IPv4 transport addresses of each protocol
+func (nt *NATTable) PoolSize() uint64 {
+	nt.mu.RLock()
+	defer nt.mu.RUnlock()
+
+	return nt.pool.Size()
+}
+
 // GetStats returns NAT table statistics
 func (nt *NATTable) GetStats() map[string]interface{} {
 	nt.mu.RLock()
@@ -228,6 +496,7 @@ func (nt *NATTable) GetStats() map[string]interface{} {
 
 	tcpCount := 0
 	udpCount := 0
+	icmpCount := 0
 	totalBytesSent := uint64(0)
 	totalBytesReceived := uint64(0)
 
@@ -236,43 +505,71 @@ func (nt *NATTable) GetStats() map[string]interface{} {
 			tcpCount++
 		} else if session.Protocol == 17 {
 			udpCount++
+		} else if session.Protocol == 58 {
+			icmpCount++
 		}
 		totalBytesSent += session.BytesSent
 		totalBytesReceived += session.BytesReceived
 	}
 
+	allocated := 0
+	static := 0
+	for _, bt := range nt.bibs {
+		allocated += len(bt.byIPv4)
+		for _, binding := range bt.byIPv4 {
+			if binding.Static {
+				static++
+			}
+		}
+	}
+
 	return map[string]interface{}{
-		"total_sessions":   len(nt.sessions),
-		"tcp_sessions":     tcpCount,
-		"udp_sessions":     udpCount,
-		"bytes_sent":       totalBytesSent,
-		"bytes_received":   totalBytesReceived,
-		"allocated_ports":  len(nt.portMappings),
+		"total_sessions":  len(nt.sessions),
+		"tcp_sessions":    tcpCount,
+		"udp_sessions":    udpCount,
+		"icmp_sessions":   icmpCount,
+		"tcp_bindings":    len(nt.bib(6).byIPv4),
+		"udp_bindings":    len(nt.bib(17).byIPv4),
+		"icmp_bindings":   len(nt.bib(58).byIPv4),
+		"bytes_sent":      totalBytesSent,
+		"bytes_received":  totalBytesReceived,
+		"allocated_ports": allocated,
+		"static_bindings": static,
+		"pool_size":       nt.pool.Size(),
+		"pool4":           nt.pool.String(),
+		"filtering":       nt.filtering.String(),
 	}
 }
 
-// allocatePort allocates a new port for NAT
-func (nt *NATTable) allocatePort() (uint16, error) {
-	attempts := 0
-	maxAttempts := int(nt.portRangeEnd - nt.portRangeStart)
-
-	for attempts < maxAttempts {
-		port := nt.nextPort
-		nt.nextPort++
-		
-		if nt.nextPort > nt.portRangeEnd {
-			nt.nextPort = nt.portRangeStart
+// allocatePort allocates a new IPv4 transport address from the pool that is
+// free in the given binding table. Bindings of the same IPv6 host prefer the
+// same IPv4 address.
+func (nt *NATTable) allocatePort(bt *bindingTable, ipv6Src net.IP) (net.IP, uint16, error) {
+	addresses := nt.pool.addresses
+	preferred := nt.pool.preferredAddress(ipv6Src)
+
+	for i := uint32(0); i < addresses; i++ {
+		ip, r := nt.pool.address((preferred + i) % addresses)
+		ports := r.ports()
+
+		// An address with as many bindings as ports has none free, unless
+		// some of them lie outside the port range after a pool change
+		if bt.bound(ip) >= ports {
+			continue
 		}
 
-		// Check if port is available
-		if _, exists := nt.portMappings[port]; !exists {
-			return port, nil
-		}
+		for attempts := uint32(0); attempts < ports; attempts++ {
+			port := r.PortStart + uint16((nt.nextPort+attempts)%ports)
 
-		attempts++
+			// Check if port is available
+			if _, exists := bt.byIPv4[newTransportAddr(ip, port)]; !exists {
+				nt.nextPort += attempts + 1
+				return ip, port, nil
+			}
+		}
 	}
 
-	return 0, fmt.Errorf("no available ports in range %d-%d", nt.portRangeStart, nt.portRangeEnd)
+	return nil, 0, fmt.Errorf("no available ports in pool4 (%s)", nt.pool)
 }
 
 // StartCleanupRoutine starts a background goroutine to cleanup expired sessions
@@ -280,10 +577,8 @@ func (nt *NATTable) StartCleanupRoutine() {
 	ticker := time.NewTicker(30 * time.Second)
 	go func() {
 		for range ticker.C {
-			removed := nt.CleanupExpiredSessions()
-			if removed > 0 {
-				// Log cleanup if needed
-				_ = removed
+			if removed := nt.CleanupExpiredSessions(); removed > 0 {
+				natLog.Debug("Removed %d expired sessions, %d active", removed, nt.GetSessionCount())
 			}
 		}
 	}()
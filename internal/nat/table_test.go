@@ -0,0 +1,86 @@
+package nat
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// newTestTable creates a NAT table over a pool4 range
+func newTestTable(t *testing.T, prefix, ports string) *NATTable {
+	t.Helper()
+
+	r, err := ParsePool4Range(prefix, ports)
+	if err != nil {
+		t.Fatalf("ParsePool4Range: %v", err)
+	}
+	pool, err := NewPool4([]Pool4Range{r})
+	if err != nil {
+		t.Fatalf("NewPool4: %v", err)
+	}
+	return NewNATTable(pool)
+}
+
+func TestAllocatePortExhaustsPool(t *testing.T) {
+	nt := newTestTable(t, "10.64.0.0/30", "1000-1003")
+	dst := net.ParseIP("64:ff9b::c000:201")
+
+	// Every host gets its own binding until all 16 transport addresses are used
+	used := make(map[string]bool)
+	var ids []string
+	for i := 0; i < 16; i++ {
+		src := net.ParseIP(fmt.Sprintf("2001:db8::%x", i+1))
+		session, err := nt.CreateSession(17, src, 5000, dst, 53, net.ParseIP("192.0.2.1"))
+		if err != nil {
+			t.Fatalf("session %d: %v", i, err)
+		}
+		addr := fmt.Sprintf("%s:%d", session.IPv4SrcIP, session.IPv4SrcPort)
+		if used[addr] {
+			t.Fatalf("session %d reuses %s", i, addr)
+		}
+		used[addr] = true
+		ids = append(ids, session.ID)
+	}
+
+	src := net.ParseIP("2001:db8::ff")
+	if _, err := nt.CreateSession(17, src, 5000, dst, 53, net.ParseIP("192.0.2.1")); err == nil || !strings.Contains(err.Error(), "no available ports") {
+		t.Fatalf("CreateSession on a full pool: %v", err)
+	}
+
+	// Other protocols have their own port space
+	if _, err := nt.CreateSession(6, src, 5000, dst, 80, net.ParseIP("192.0.2.1")); err != nil {
+		t.Fatalf("TCP session on a full UDP pool: %v", err)
+	}
+
+	// A removed session frees its transport address again
+	if !nt.RemoveSession(ids[5]) {
+		t.Fatal("RemoveSession failed")
+	}
+	if _, err := nt.CreateSession(17, src, 5000, dst, 53, net.ParseIP("192.0.2.1")); err != nil {
+		t.Fatalf("CreateSession after a session was removed: %v", err)
+	}
+}
+
+func BenchmarkAllocatePortFullPool(b *testing.B) {
+	r, _ := ParsePool4Range("10.64.0.0/24", "1024-2047")
+	pool, _ := NewPool4([]Pool4Range{r})
+	nt := NewNATTable(pool)
+
+	// Fill every address of the UDP table
+	bt := nt.bib(17)
+	for i := uint32(0); i < pool.addresses; i++ {
+		ip, r := pool.address(i)
+		for port := uint32(r.PortStart); port <= uint32(r.PortEnd); port++ {
+			bt.add(&BindingEntry{Protocol: 17, IPv6IP: ip.To16(), IPv6Port: uint16(port), IPv4IP: ip, IPv4Port: uint16(port)})
+		}
+	}
+
+	src := net.ParseIP("2001:db8::1")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := nt.allocatePort(bt, src); err == nil {
+			b.Fatal("allocated from a full pool")
+		}
+	}
+}
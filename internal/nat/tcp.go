@@ -0,0 +1,99 @@
+package nat
+
+import "time"
+
+// TCP flags that drive the session state machine
+const (
+	TCPFlagFIN = 0x01
+	TCPFlagSYN = 0x02
+	TCPFlagRST = 0x04
+)
+
+// TCP session states (RFC 6146 section 3.5.2)
+const (
+	TCPStateClosed      = "CLOSED"
+	TCPStateV4Init      = "V4 INIT"
+	TCPStateV6Init      = "V6 INIT"
+	TCPStateEstablished = "ESTABLISHED"
+	TCPStateV4FinRcv    = "V4 FIN RCV"
+	TCPStateV6FinRcv    = "V6 FIN RCV"
+	TCPStateV4V6FinRcv  = "V4 FIN + V6 FIN RCV"
+	TCPStateTrans       = "TRANS"
+)
+
+// TCP session lifetimes (RFC 6146 section 4)
+const (
+	TCPEstablishedTimeout = 2*time.Hour + 4*time.Minute
+	TCPTransitoryTimeout  = 4 * time.Minute
+	TCPIncomingSYNTimeout = 6 * time.Second
+)
+
+// trackTCP advances the state of a TCP session for a segment with the given
+// flags. It reports whether the segment refreshes the session lifetime.
+func trackTCP(session *SessionState, flags uint8, direction string) bool {
+	outbound := direction == "outbound"
+	syn := flags&TCPFlagSYN != 0
+	fin := flags&TCPFlagFIN != 0
+	rst := flags&TCPFlagRST != 0
+
+	switch session.State {
+	case TCPStateClosed:
+		if syn && outbound {
+			session.State = TCPStateV6Init
+		} else if syn {
+			session.State = TCPStateV4Init
+		}
+
+	case TCPStateV6Init:
+		if syn && !outbound {
+			session.State = TCPStateEstablished
+		}
+
+	case TCPStateV4Init:
+		if syn && outbound {
+			session.State = TCPStateEstablished
+		}
+
+	case TCPStateEstablished:
+		switch {
+		case rst:
+			session.State = TCPStateTrans
+		case fin && outbound:
+			session.State = TCPStateV6FinRcv
+		case fin:
+			session.State = TCPStateV4FinRcv
+		}
+
+	case TCPStateV4FinRcv:
+		if fin && outbound {
+			session.State = TCPStateV4V6FinRcv
+		}
+
+	case TCPStateV6FinRcv:
+		if fin && !outbound {
+			session.State = TCPStateV4V6FinRcv
+		}
+
+	case TCPStateV4V6FinRcv:
+		// Both sides are closing; let the session run out
+		return false
+
+	case TCPStateTrans:
+		if !rst {
+			session.State = TCPStateEstablished
+		}
+	}
+
+	return true
+}
+
+// tcpTimeout returns how long a TCP session may stay idle in its current state
+func (nt *NATTable) tcpTimeout(session *SessionState) time.Duration {
+	switch session.State {
+	case TCPStateV4Init:
+		return nt.timeoutTCPIncomingSYN
+	case TCPStateEstablished, TCPStateV4FinRcv, TCPStateV6FinRcv:
+		return nt.timeoutTCPEst
+	}
+	return nt.timeoutTCPTrans
+}
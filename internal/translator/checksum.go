@@ -0,0 +1,144 @@
+package translator
+
+import (
+	"encoding/binary"
+)
+
+// Offsets of fields within the TCP/UDP header
+const (
+	srcPortOffset     = 0
+	dstPortOffset     = 2
+	tcpChecksumOffset = 16
+	udpChecksumOffset = 6
+)
+
+// checksumAdd adds data to a running one's complement sum
+func checksumAdd(sum uint32, data []byte) uint32 {
+	for i := 0; i < len(data)-1; i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i : i+2]))
+	}
+
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+
+	return sum
+}
+
+// checksumFold folds a running sum into 16 bits
+func checksumFold(sum uint32) uint16 {
+	for sum > 0xffff {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+
+	return uint16(sum)
+}
+
+// calculateChecksum computes the Internet checksum
+func calculateChecksum(data []byte) uint16 {
+	return ^checksumFold(checksumAdd(0, data))
+}
+
+// pseudoHeaderSum returns the partial sum of an IPv4 or IPv6 pseudo-header.
+// addrs holds the source and destination addresses as they appear on the wire.
+func pseudoHeaderSum(addrs []byte, protocol uint8, length int) uint32 {
+	sum := checksumAdd(0, addrs)
+	sum += uint32(protocol)
+	sum += uint32(length>>16) + uint32(length&0xffff)
+	return sum
+}
+
+// transportChecksum computes a TCP/UDP checksum over the pseudo-header and segment.
+// The checksum field in the segment must be zero.
+func transportChecksum(addrs []byte, protocol uint8, segment []byte) uint16 {
+	sum := pseudoHeaderSum(addrs, protocol, len(segment))
+	sum = checksumAdd(sum, segment)
+	return ^checksumFold(sum)
+}
+
+// adjustChecksum incrementally updates a checksum when oldData is replaced
+// by newData, using equation 3 of RFC 1624: HC' = ~(~HC + ~m + m')
+func adjustChecksum(checksum uint16, oldData, newData []byte) uint16 {
+	sum := uint32(^checksum)
+	sum += uint32(^checksumFold(checksumAdd(0, oldData)))
+	sum = checksumAdd(sum, newData)
+	return ^checksumFold(sum)
+}
+
+// checksumOffset returns the offset of the checksum field for a transport protocol
+func checksumOffset(protocol uint8) (int, bool) {
+	switch protocol {
+	case 6: // TCP
+		return tcpChecksumOffset, true
+	case 17: // UDP
+		return udpChecksumOffset, true
+	}
+	return 0, false
+}
+
+// updatePseudoHeaderChecksum rewrites the TCP/UDP checksum of a segment after
+// its pseudo-header addresses changed from oldAddrs to newAddrs. The protocol
+// and upper-layer length are the same in both pseudo-headers, so only the
+// addresses need to be swapped out of the sum.
+func updatePseudoHeaderChecksum(segment []byte, protocol uint8, oldAddrs, newAddrs []byte) {
+	offset, ok := checksumOffset(protocol)
+	if !ok || len(segment) < offset+2 {
+		return
+	}
+
+	checksum := binary.BigEndian.Uint16(segment[offset : offset+2])
+
+	// An IPv4 UDP checksum of zero means none was computed, so there is
+	// nothing to adjust. IPv6 forbids it, so compute it from scratch.
+	if protocol == 17 && checksum == 0 {
+		binary.BigEndian.PutUint16(segment[offset:offset+2], udpChecksum(newAddrs, segment))
+		return
+	}
+
+	checksum = adjustChecksum(checksum, oldAddrs, newAddrs)
+	if protocol == 17 && checksum == 0 {
+		checksum = 0xffff
+	}
+	binary.BigEndian.PutUint16(segment[offset:offset+2], checksum)
+}
+
+// rewritePort replaces the TCP/UDP port at the given offset and incrementally
+// updates the checksum to match
+func rewritePort(segment []byte, protocol uint8, portOffset int, newPort uint16) {
+	offset, ok := checksumOffset(protocol)
+	if !ok || len(segment) < offset+2 {
+		return
+	}
+
+	oldPort := binary.BigEndian.Uint16(segment[portOffset : portOffset+2])
+	if oldPort == newPort {
+		return
+	}
+
+	var oldData, newData [2]byte
+	binary.BigEndian.PutUint16(oldData[:], oldPort)
+	binary.BigEndian.PutUint16(newData[:], newPort)
+	binary.BigEndian.PutUint16(segment[portOffset:portOffset+2], newPort)
+
+	checksum := binary.BigEndian.Uint16(segment[offset : offset+2])
+	if protocol == 17 && checksum == 0 {
+		return
+	}
+
+	checksum = adjustChecksum(checksum, oldData[:], newData[:])
+	if protocol == 17 && checksum == 0 {
+		checksum = 0xffff
+	}
+	binary.BigEndian.PutUint16(segment[offset:offset+2], checksum)
+}
+
+// udpChecksum computes a UDP checksum, mapping a zero result to 0xffff
+func udpChecksum(addrs []byte, segment []byte) uint16 {
+	binary.BigEndian.PutUint16(segment[udpChecksumOffset:udpChecksumOffset+2], 0)
+
+	checksum := transportChecksum(addrs, 17, segment)
+	if checksum == 0 {
+		checksum = 0xffff
+	}
+	return checksum
+}
@@ -0,0 +1,212 @@
+package translator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/mdxabu/bridge/internal/nat"
+)
+
+// testPrefix returns the well-known NAT64 prefix
+func testPrefix(t *testing.T) *NAT64Prefix {
+	t.Helper()
+
+	prefix, err := ParseNAT64Prefix(WellKnownPrefix)
+	if err != nil {
+		t.Fatalf("ParseNAT64Prefix: %v", err)
+	}
+	return prefix
+}
+
+// tcpSegment returns a TCP header without options followed by payload
+func tcpSegment(sport, dport uint16, payload []byte) []byte {
+	segment := make([]byte, 20+len(payload))
+	binary.BigEndian.PutUint16(segment[0:2], sport)
+	binary.BigEndian.PutUint16(segment[2:4], dport)
+	binary.BigEndian.PutUint32(segment[4:8], 0x01020304)
+	segment[12] = 5 << 4
+	segment[13] = 0x18 // PSH, ACK
+	binary.BigEndian.PutUint16(segment[14:16], 65535)
+	copy(segment[20:], payload)
+	return segment
+}
+
+// udpSegment returns a UDP header followed by payload
+func udpSegment(sport, dport uint16, payload []byte) []byte {
+	segment := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint16(segment[0:2], sport)
+	binary.BigEndian.PutUint16(segment[2:4], dport)
+	binary.BigEndian.PutUint16(segment[4:6], uint16(len(segment)))
+	copy(segment[8:], payload)
+	return segment
+}
+
+// testIPv6Packet builds and parses an IPv6 packet with a valid transport checksum
+func testIPv6Packet(t *testing.T, src, dst string, protocol uint8, segment []byte) *Packet {
+	t.Helper()
+
+	packet, _ := buildIPv6Packet(net.ParseIP(src), net.ParseIP(dst), protocol, segment)
+	if err := RecalculateTransportChecksum(packet, true); err != nil {
+		t.Fatalf("RecalculateTransportChecksum: %v", err)
+	}
+	if protocol == 58 {
+		binary.BigEndian.PutUint16(packet[42:44], 0)
+		binary.BigEndian.PutUint16(packet[42:44], transportChecksum(packet[8:40], 58, packet[40:]))
+	}
+
+	pkt, err := ParseIPv6Packet(packet)
+	if err != nil {
+		t.Fatalf("ParseIPv6Packet: %v", err)
+	}
+	return pkt
+}
+
+// testIPv4Packet builds and parses an IPv4 packet with a valid transport checksum
+func testIPv4Packet(t *testing.T, src, dst string, protocol uint8, segment []byte) *Packet {
+	t.Helper()
+
+	packet, _ := buildIPv4Packet(net.ParseIP(src), net.ParseIP(dst), protocol, segment)
+	if protocol == 17 {
+		// A zero checksum would be left alone as "not computed"
+		binary.BigEndian.PutUint16(packet[20+udpChecksumOffset:], 0xffff)
+	}
+	if err := RecalculateTransportChecksum(packet, false); err != nil {
+		t.Fatalf("RecalculateTransportChecksum: %v", err)
+	}
+	if protocol == 1 {
+		binary.BigEndian.PutUint16(packet[22:24], 0)
+		binary.BigEndian.PutUint16(packet[22:24], calculateChecksum(packet[20:]))
+	}
+
+	pkt, err := ParseIPv4Packet(packet)
+	if err != nil {
+		t.Fatalf("ParseIPv4Packet: %v", err)
+	}
+	return pkt
+}
+
+// checkTransportChecksum compares the transport checksum of a translated
+// packet with one computed from scratch
+func checkTransportChecksum(t *testing.T, packet []byte, isIPv6 bool) {
+	t.Helper()
+
+	full := bytes.Clone(packet)
+	if err := RecalculateTransportChecksum(full, isIPv6); err != nil {
+		t.Fatalf("RecalculateTransportChecksum: %v", err)
+	}
+	if !bytes.Equal(packet, full) {
+		t.Fatalf("incremental checksum differs from full recomputation:\n got %x\nwant %x", packet, full)
+	}
+}
+
+func TestIncrementalChecksumIPv6ToIPv4(t *testing.T) {
+	session := &nat.SessionState{
+		ID:          "test",
+		IPv4SrcIP:   net.ParseIP("192.0.2.1"),
+		IPv4SrcPort: 61000,
+	}
+
+	tests := []struct {
+		name     string
+		protocol uint8
+		segment  []byte
+		session  *nat.SessionState
+	}{
+		{"tcp", 6, tcpSegment(40000, 443, []byte("hello, world")), session},
+		{"tcp odd length", 6, tcpSegment(40000, 443, []byte("hello")), session},
+		{"udp", 17, udpSegment(40000, 53, []byte("query")), session},
+		{"udp same port", 17, udpSegment(61000, 53, []byte("query")), session},
+		{"udp without session", 17, udpSegment(40000, 53, []byte("query")), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "2001:db8::1"
+			if tt.session == nil {
+				src = "64:ff9b::c000:205"
+			}
+			pkt := testIPv6Packet(t, src, "64:ff9b::198.51.100.7", tt.protocol, tt.segment)
+
+			packet, err := TranslateIPv6ToIPv4(pkt, testPrefix(t), tt.session)
+			if err != nil {
+				t.Fatalf("TranslateIPv6ToIPv4: %v", err)
+			}
+			if tt.session != nil {
+				if port := binary.BigEndian.Uint16(packet[20:22]); port != tt.session.IPv4SrcPort {
+					t.Fatalf("source port = %d, want %d", port, tt.session.IPv4SrcPort)
+				}
+			}
+			checkTransportChecksum(t, packet, false)
+		})
+	}
+}
+
+func TestIncrementalChecksumIPv4ToIPv6(t *testing.T) {
+	session := &nat.SessionState{
+		ID:          "test",
+		IPv6SrcIP:   net.ParseIP("2001:db8::1"),
+		IPv6SrcPort: 40000,
+	}
+
+	tests := []struct {
+		name     string
+		protocol uint8
+		segment  []byte
+	}{
+		{"tcp", 6, tcpSegment(443, 61000, []byte("hello, world"))},
+		{"tcp odd length", 6, tcpSegment(443, 61000, []byte("hello"))},
+		{"udp", 17, udpSegment(53, 61000, []byte("answer"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkt := testIPv4Packet(t, "198.51.100.7", "192.0.2.1", tt.protocol, tt.segment)
+
+			packet, err := TranslateIPv4ToIPv6(pkt, testPrefix(t), session)
+			if err != nil {
+				t.Fatalf("TranslateIPv4ToIPv6: %v", err)
+			}
+			if port := binary.BigEndian.Uint16(packet[42:44]); port != session.IPv6SrcPort {
+				t.Fatalf("destination port = %d, want %d", port, session.IPv6SrcPort)
+			}
+			checkTransportChecksum(t, packet, true)
+		})
+	}
+}
+
+func TestZeroUDPChecksumIPv4ToIPv6(t *testing.T) {
+	session := &nat.SessionState{
+		ID:          "test",
+		IPv6SrcIP:   net.ParseIP("2001:db8::1"),
+		IPv6SrcPort: 40000,
+	}
+	pkt := testIPv4Packet(t, "198.51.100.7", "192.0.2.1", 17, udpSegment(53, 61000, []byte("answer")))
+	binary.BigEndian.PutUint16(pkt.Payload[udpChecksumOffset:], 0)
+
+	packet, err := TranslateIPv4ToIPv6(pkt, testPrefix(t), session)
+	if err != nil {
+		t.Fatalf("TranslateIPv4ToIPv6: %v", err)
+	}
+
+	// IPv6 does not allow a zero UDP checksum, so one must be computed
+	if checksum := binary.BigEndian.Uint16(packet[40+udpChecksumOffset:]); checksum == 0 {
+		t.Fatal("translated UDP checksum is zero")
+	}
+	checkTransportChecksum(t, packet, true)
+}
+
+func TestAdjustChecksum(t *testing.T) {
+	data := []byte{0x45, 0x00, 0x00, 0x54, 0x12, 0x34, 0x40, 0x00, 0x40, 0x01, 0x00, 0x00, 0xc0, 0x00, 0x02, 0x01, 0xc6, 0x33, 0x64, 0x07}
+	checksum := calculateChecksum(data)
+
+	for _, replacement := range [][]byte{{0x00, 0x00}, {0xff, 0xff}, {0xc0, 0xa8}} {
+		updated := bytes.Clone(data)
+		copy(updated[12:14], replacement)
+
+		if got, want := adjustChecksum(checksum, data[12:14], replacement), calculateChecksum(updated); got != want {
+			t.Errorf("adjustChecksum to %x = %#04x, want %#04x", replacement, got, want)
+		}
+	}
+}
@@ -4,76 +4,175 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
+
+	"github.com/mdxabu/bridge/internal/nat"
 )
 
-// TranslateIPv6ToIPv4 translates an IPv6 packet to IPv4
-func TranslateIPv6ToIPv4(pkt *Packet, nat64Prefix string) ([]byte, error) {
+// TranslateIPv6ToIPv4 translates an IPv6 packet to IPv4. When a NAT session
+// is given, the source address and port are rewritten to the session's
+// allocated IPv4 transport address.
+func TranslateIPv6ToIPv4(pkt *Packet, mapper AddressMapper, session *nat.SessionState) ([]byte, error) {
 	if !pkt.IsIPv6 {
 		return nil, fmt.Errorf("packet is not IPv6")
 	}
 
-	// Extract IPv4 address from NAT64 address
-	ipv4Dst, err := GetIPV4fromNAT64(pkt.DstIP.String())
-	if err != nil {
-		return nil, fmt.Errorf("failed to extract IPv4 from NAT64: %w", err)
+	// Source-routed packets must not be translated (RFC 7915 section 5.1)
+	if pkt.SegmentsLeft != 0 {
+		return nil, ErrUntranslatable
 	}
 
-	// Parse destination IPv4
-	dstIPv4 := net.ParseIP(ipv4Dst).To4()
-	if dstIPv4 == nil {
-		return nil, fmt.Errorf("invalid IPv4 address: %s", ipv4Dst)
+	// Fragments are translated one by one, so the fragmentable part must
+	// start with the upper-layer header and ICMP must be reassembled first
+	if pkt.IsFragment() {
+		if pkt.IsFirstFragment() && pkt.TransportOffset != pkt.Fragment.dataOffset {
+			return nil, ErrUntranslatable
+		}
+		if pkt.Type == PacketTypeICMP {
+			return nil, ErrUntranslatable
+		}
 	}
 
-	// Extract IPv4 from source (for NAT64, we'll use a mapped address)
-	srcIPv4, err := GetIPV4fromNAT64(pkt.SrcIP.String())
+	// Map the destination to its IPv4 address
+	dstIPv4, err := mapper.Extract(pkt.DstIP)
 	if err != nil {
-		// If source is not NAT64, we need to map it
-		// For now, use a default internal address
-		srcIPv4 = "10.64.0.1"
+		return nil, fmt.Errorf("failed to map destination to IPv4: %w", err)
+	}
+
+	var srcIP net.IP
+	if session != nil {
+		srcIP = session.IPv4SrcIP.To4()
+		if srcIP == nil {
+			return nil, fmt.Errorf("session %s has no IPv4 source address", session.ID)
+		}
+	} else {
+		// Without a session the source must itself be an IPv4-embedded address
+		srcIP, err = mapper.Extract(pkt.SrcIP)
+		if err != nil {
+			return nil, fmt.Errorf("no IPv4 mapping for source: %w", err)
+		}
 	}
 
-	srcIP := net.ParseIP(srcIPv4).To4()
-	if srcIP == nil {
-		return nil, fmt.Errorf("invalid source IPv4 address")
+	payload := pkt.Payload
+	if pkt.Type == PacketTypeICMP && pkt.IsFirstFragment() {
+		payload, err = translateICMPv6ToICMPv4(pkt, mapper, session)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Build IPv4 packet
-	ipv4Packet, err := buildIPv4Packet(srcIP, dstIPv4, pkt)
+	ipv4Packet, err := buildIPv4Packet(srcIP, dstIPv4, pkt.Protocol, payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build IPv4 packet: %w", err)
 	}
 
+	// Translate the Fragment header into IPv4 fragmentation fields
+	var frag *FragmentHeader
+	if pkt.IsFragment() {
+		frag = pkt.Fragment
+	}
+	setIPv4Fragmentation(ipv4Packet, frag)
+
+	if !pkt.IsFirstFragment() {
+		return ipv4Packet, nil
+	}
+
+	if pkt.Type == PacketTypeTCP || pkt.Type == PacketTypeUDP {
+		// Swap the IPv6 pseudo-header out of the transport checksum
+		updatePseudoHeaderChecksum(ipv4Packet[20:], pkt.Protocol, pkt.IPv6Header[8:40], ipv4Packet[12:20])
+
+		// Rewrite the source port to the allocated NAT port
+		if session != nil {
+			rewritePort(ipv4Packet[20:], pkt.Protocol, srcPortOffset, session.IPv4SrcPort)
+		}
+	}
+
 	return ipv4Packet, nil
 }
 
-// TranslateIPv4ToIPv6 translates an IPv4 packet to IPv6
-func TranslateIPv4ToIPv6(pkt *Packet, nat64Prefix string) ([]byte, error) {
+// TranslateIPv4ToIPv6 translates an IPv4 packet to IPv6. When a NAT session
+// is given, the destination address and port are restored to the session's
+// original IPv6 source transport address.
+func TranslateIPv4ToIPv6(pkt *Packet, mapper AddressMapper, session *nat.SessionState) ([]byte, error) {
 	if pkt.IsIPv6 {
 		return nil, fmt.Errorf("packet is already IPv6")
 	}
 
-	// Convert IPv4 addresses to NAT64 IPv6 addresses
-	srcIPv6, err := IPv4ToNAT64(pkt.SrcIP.String(), nat64Prefix)
-	if err != nil {
-		return nil, fmt.Errorf("failed to convert source to NAT64: %w", err)
+	// ICMP must be reassembled before translation, and a zero UDP checksum
+	// cannot be computed from a single fragment
+	if pkt.IsFragment() {
+		if pkt.Type == PacketTypeICMP {
+			return nil, ErrUntranslatable
+		}
+		if pkt.IsFirstFragment() && pkt.Type == PacketTypeUDP && len(pkt.Payload) >= 8 &&
+			binary.BigEndian.Uint16(pkt.Payload[udpChecksumOffset:udpChecksumOffset+2]) == 0 {
+			return nil, ErrUntranslatable
+		}
 	}
 
-	dstIPv6, err := IPv4ToNAT64(pkt.DstIP.String(), nat64Prefix)
+	// Map the IPv4 addresses to IPv6
+	srcIPv6, err := mapper.Embed(pkt.SrcIP)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert destination to NAT64: %w", err)
+		return nil, fmt.Errorf("failed to map source to IPv6: %w", err)
+	}
+
+	var dstIPv6 net.IP
+	if session != nil {
+		dstIPv6 = session.IPv6SrcIP.To16()
+		if dstIPv6 == nil {
+			return nil, fmt.Errorf("session %s has no IPv6 source address", session.ID)
+		}
+	} else {
+		dstIPv6, err = mapper.Embed(pkt.DstIP)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map destination to IPv6: %w", err)
+		}
+	}
+
+	payload := pkt.Payload
+	if pkt.Type == PacketTypeICMP && pkt.IsFirstFragment() {
+		payload, err = translateICMPv4ToICMPv6(pkt, mapper, session)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Build IPv6 packet
-	ipv6Packet, err := buildIPv6Packet(srcIPv6, dstIPv6, pkt)
+	ipv6Packet, err := buildIPv6Packet(srcIPv6, dstIPv6, pkt.Protocol, payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build IPv6 packet: %w", err)
 	}
 
+	if !pkt.IsFirstFragment() {
+		return insertFragmentHeader(ipv6Packet, pkt.Fragment), nil
+	}
+
+	switch pkt.Type {
+	case PacketTypeTCP, PacketTypeUDP:
+		// Swap the IPv4 pseudo-header out of the transport checksum
+		updatePseudoHeaderChecksum(ipv6Packet[40:], pkt.Protocol, pkt.IPv4Header[12:20], ipv6Packet[8:40])
+
+		// Restore the original IPv6 source port as the destination port
+		if session != nil {
+			rewritePort(ipv6Packet[40:], pkt.Protocol, dstPortOffset, session.IPv6SrcPort)
+		}
+
+	case PacketTypeICMP:
+		// ICMPv6 checksums cover the pseudo-header
+		message := ipv6Packet[40:]
+		binary.BigEndian.PutUint16(message[2:4], transportChecksum(ipv6Packet[8:40], 58, message))
+	}
+
+	// Carry IPv4 fragmentation over in a Fragment header
+	if pkt.IsFragment() {
+		ipv6Packet = insertFragmentHeader(ipv6Packet, pkt.Fragment)
+	}
+
 	return ipv6Packet, nil
 }
 
 // buildIPv4Packet constructs an IPv4 packet
-func buildIPv4Packet(srcIP, dstIP net.IP, pkt *Packet) ([]byte, error) {
+func buildIPv4Packet(srcIP, dstIP net.IP, protocol uint8, payload []byte) ([]byte, error) {
 	// IPv4 header is 20 bytes (without options)
 	header := make([]byte, 20)
 
@@ -84,20 +183,17 @@ func buildIPv4Packet(srcIP, dstIP net.IP, pkt *Packet) ([]byte, error) {
 	header[1] = 0
 
 	// Total length (will be set after payload is added)
-	totalLen := uint16(20 + len(pkt.Payload))
+	totalLen := uint16(20 + len(payload))
 	binary.BigEndian.PutUint16(header[2:4], totalLen)
 
-	// Identification
+	// Identification, flags and fragment offset are set by setIPv4Fragmentation
 	binary.BigEndian.PutUint16(header[4:6], 0)
-
-	// Flags and Fragment offset
 	binary.BigEndian.PutUint16(header[6:8], 0x4000) // Don't fragment
 
 	// TTL
 	header[8] = 64
 
 	// Protocol (translate ICMPv6 to ICMPv4)
-	protocol := pkt.Protocol
 	if protocol == 58 { // ICMPv6 -> ICMPv4
 		protocol = 1
 	}
@@ -117,13 +213,13 @@ func buildIPv4Packet(srcIP, dstIP net.IP, pkt *Packet) ([]byte, error) {
 	binary.BigEndian.PutUint16(header[10:12], checksum)
 
 	// Combine header and payload
-	packet := append(header, pkt.Payload...)
+	packet := append(header, payload...)
 
 	return packet, nil
 }
 
 // buildIPv6Packet constructs an IPv6 packet
-func buildIPv6Packet(srcIP, dstIP net.IP, pkt *Packet) ([]byte, error) {
+func buildIPv6Packet(srcIP, dstIP net.IP, protocol uint8, payload []byte) ([]byte, error) {
 	// IPv6 header is 40 bytes
 	header := make([]byte, 40)
 
@@ -134,11 +230,11 @@ func buildIPv6Packet(srcIP, dstIP net.IP, pkt *Packet) ([]byte, error) {
 	header[3] = 0
 
 	// Payload length
-	payloadLen := uint16(len(pkt.Payload))
+	payloadLen := uint16(len(payload))
 	binary.BigEndian.PutUint16(header[4:6], payloadLen)
 
 	// Next header (translate ICMPv4 to ICMPv6)
-	nextHeader := pkt.Protocol
+	nextHeader := protocol
 	if nextHeader == 1 { // ICMPv4 -> ICMPv6
 		nextHeader = 58
 	}
@@ -154,61 +250,11 @@ func buildIPv6Packet(srcIP, dstIP net.IP, pkt *Packet) ([]byte, error) {
 	copy(header[24:40], dstIP.To16())
 
 	// Combine header and payload
-	packet := append(header, pkt.Payload...)
+	packet := append(header, payload...)
 
 	return packet, nil
 }
 
-// IPv4ToNAT64 converts an IPv4 address to NAT64 format
-func IPv4ToNAT64(ipv4Addr, nat64Prefix string) (net.IP, error) {
-	ip := net.ParseIP(ipv4Addr).To4()
-	if ip == nil {
-		return nil, fmt.Errorf("invalid IPv4 address: %s", ipv4Addr)
-	}
-
-	// Using well-known prefix 64:ff9b::/96
-	nat64IP := make([]byte, 16)
-	nat64IP[0] = 0x00
-	nat64IP[1] = 0x64
-	nat64IP[2] = 0xff
-	nat64IP[3] = 0x9b
-	nat64IP[4] = 0x00
-	nat64IP[5] = 0x00
-	nat64IP[6] = 0x00
-	nat64IP[7] = 0x00
-	nat64IP[8] = 0x00
-	nat64IP[9] = 0x00
-	nat64IP[10] = 0x00
-	nat64IP[11] = 0x00
-
-	// Embed IPv4 address in last 4 bytes
-	nat64IP[12] = ip[0]
-	nat64IP[13] = ip[1]
-	nat64IP[14] = ip[2]
-	nat64IP[15] = ip[3]
-
-	return net.IP(nat64IP), nil
-}
-
-// calculateChecksum computes the Internet checksum
-func calculateChecksum(data []byte) uint16 {
-	sum := uint32(0)
-
-	for i := 0; i < len(data)-1; i += 2 {
-		sum += uint32(binary.BigEndian.Uint16(data[i : i+2]))
-	}
-
-	if len(data)%2 == 1 {
-		sum += uint32(data[len(data)-1]) << 8
-	}
-
-	for sum > 0xffff {
-		sum = (sum & 0xffff) + (sum >> 16)
-	}
-
-	return ^uint16(sum)
-}
-
 // RecalculateTransportChecksum recalculates TCP/UDP checksum for translated packets
 func RecalculateTransportChecksum(packet []byte, isIPv6 bool) error {
 	var pkt *Packet
@@ -235,13 +281,31 @@ func RecalculateTransportChecksum(packet []byte, isIPv6 bool) error {
 }
 
 func recalculateTCPChecksum(packet []byte, pkt *Packet, isIPv6 bool) error {
-	// TCP checksum calculation with pseudo-header
-	// This is a simplified version
+	segment := pkt.Payload
+	binary.BigEndian.PutUint16(segment[tcpChecksumOffset:tcpChecksumOffset+2], 0)
+
+	checksum := transportChecksum(pseudoHeaderAddrs(packet, isIPv6), 6, segment)
+	binary.BigEndian.PutUint16(segment[tcpChecksumOffset:tcpChecksumOffset+2], checksum)
 	return nil
 }
 
 func recalculateUDPChecksum(packet []byte, pkt *Packet, isIPv6 bool) error {
-	// UDP checksum calculation with pseudo-header
-	// This is a simplified version
+	segment := pkt.Payload
+
+	// A zero checksum in IPv4 means the sender did not compute one
+	if !isIPv6 && binary.BigEndian.Uint16(segment[udpChecksumOffset:udpChecksumOffset+2]) == 0 {
+		return nil
+	}
+
+	checksum := udpChecksum(pseudoHeaderAddrs(packet, isIPv6), segment)
+	binary.BigEndian.PutUint16(segment[udpChecksumOffset:udpChecksumOffset+2], checksum)
 	return nil
 }
+
+// pseudoHeaderAddrs returns the source and destination address bytes of a packet
+func pseudoHeaderAddrs(packet []byte, isIPv6 bool) []byte {
+	if isIPv6 {
+		return packet[8:40]
+	}
+	return packet[12:20]
+}
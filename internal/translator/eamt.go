@@ -0,0 +1,245 @@
+package translator
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+// AddressMapper maps addresses between the IPv6 and IPv4 sides of the
+// translator. NAT64Prefix maps through an RFC 6052 prefix; SIITMapper adds an
+// Explicit Address Mapping Table in front of it.
+type AddressMapper interface {
+	// Extract returns the IPv4 address for an IPv6 address
+	Extract(ip net.IP) (net.IP, error)
+	// Embed returns the IPv6 address for an IPv4 address
+	Embed(ip net.IP) (net.IP, error)
+}
+
+// EAMEntry maps an IPv4 prefix onto an IPv6 prefix (RFC 7757). The suffix
+// bits of the IPv4 address follow the IPv6 prefix.
+type EAMEntry struct {
+	IPv4 *net.IPNet
+	IPv6 *net.IPNet
+}
+
+// ParseEAMEntry parses an EAMT entry from an IPv4 and an IPv6 address or prefix
+func ParseEAMEntry(ipv4, ipv6 string) (EAMEntry, error) {
+	v4, err := parsePrefix(ipv4, 32)
+	if err != nil || v4.IP.To4() == nil {
+		return EAMEntry{}, fmt.Errorf("invalid EAMT IPv4 prefix %q", ipv4)
+	}
+	v6, err := parsePrefix(ipv6, 128)
+	if err != nil || v6.IP.To4() != nil {
+		return EAMEntry{}, fmt.Errorf("invalid EAMT IPv6 prefix %q", ipv6)
+	}
+
+	ones4, _ := v4.Mask.Size()
+	ones6, _ := v6.Mask.Size()
+	if 32-ones4 > 128-ones6 {
+		return EAMEntry{}, fmt.Errorf("EAMT IPv6 prefix %s is too long for IPv4 prefix %s", v6, v4)
+	}
+
+	v4.IP = v4.IP.To4()
+	return EAMEntry{IPv4: v4, IPv6: v6}, nil
+}
+
+// parsePrefix parses a CIDR prefix, treating a bare address as a host prefix
+func parsePrefix(s string, bits int) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		if bits == 32 {
+			ip = ip.To4()
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, prefix, err := net.ParseCIDR(s)
+	return prefix, err
+}
+
+// String returns the entry as "ipv4-prefix <-> ipv6-prefix"
+func (e EAMEntry) String() string {
+	return fmt.Sprintf("%s <-> %s", e.IPv4, e.IPv6)
+}
+
+// EAMT is an Explicit Address Mapping Table. Lookups use the longest matching
+// prefix on each side.
+type EAMT struct {
+	byIPv4 []EAMEntry // Sorted by IPv4 prefix length, longest first
+	byIPv6 []EAMEntry // Sorted by IPv6 prefix length, longest first
+}
+
+// NewEAMT creates a table from entries, rejecting duplicate prefixes
+func NewEAMT(entries []EAMEntry) (*EAMT, error) {
+	for i, e := range entries {
+		for _, other := range entries[:i] {
+			if e.IPv4.String() == other.IPv4.String() {
+				return nil, fmt.Errorf("duplicate EAMT IPv4 prefix %s", e.IPv4)
+			}
+			if e.IPv6.String() == other.IPv6.String() {
+				return nil, fmt.Errorf("duplicate EAMT IPv6 prefix %s", e.IPv6)
+			}
+		}
+	}
+
+	t := &EAMT{
+		byIPv4: append([]EAMEntry(nil), entries...),
+		byIPv6: append([]EAMEntry(nil), entries...),
+	}
+	sort.SliceStable(t.byIPv4, func(i, j int) bool {
+		a, _ := t.byIPv4[i].IPv4.Mask.Size()
+		b, _ := t.byIPv4[j].IPv4.Mask.Size()
+		return a > b
+	})
+	sort.SliceStable(t.byIPv6, func(i, j int) bool {
+		a, _ := t.byIPv6[i].IPv6.Mask.Size()
+		b, _ := t.byIPv6[j].IPv6.Mask.Size()
+		return a > b
+	})
+
+	return t, nil
+}
+
+// Len returns the number of entries
+func (t *EAMT) Len() int {
+	return len(t.byIPv4)
+}
+
+// MapIPv4 returns the IPv6 address of an IPv4 address covered by the table
+func (t *EAMT) MapIPv4(ip net.IP) (net.IP, bool) {
+	ipv4 := ip.To4()
+	if ipv4 == nil {
+		return nil, false
+	}
+
+	for _, e := range t.byIPv4 {
+		if !e.IPv4.Contains(ipv4) {
+			continue
+		}
+
+		ones4, _ := e.IPv4.Mask.Size()
+		ones6, _ := e.IPv6.Mask.Size()
+
+		out := make(net.IP, 16)
+		copy(out, e.IPv6.IP.To16())
+		copyBits(out, ones6, ipv4, ones4, 32-ones4)
+		return out, true
+	}
+
+	return nil, false
+}
+
+// MapIPv6 returns the IPv4 address of an IPv6 address covered by the table
+func (t *EAMT) MapIPv6(ip net.IP) (net.IP, bool) {
+	ipv6 := ip.To16()
+	if ipv6 == nil || ip.To4() != nil {
+		return nil, false
+	}
+
+	for _, e := range t.byIPv6 {
+		if !e.IPv6.Contains(ipv6) {
+			continue
+		}
+
+		ones4, _ := e.IPv4.Mask.Size()
+		ones6, _ := e.IPv6.Mask.Size()
+
+		out := make(net.IP, 4)
+		copy(out, e.IPv4.IP.To4())
+		copyBits(out, ones4, ipv6, ones6, 32-ones4)
+		return out, true
+	}
+
+	return nil, false
+}
+
+// copyBits copies n bits from src starting at bit srcOff into dst at bit dstOff
+func copyBits(dst []byte, dstOff int, src []byte, srcOff, n int) {
+	for i := 0; i < n; i++ {
+		s, d := srcOff+i, dstOff+i
+		bit := (src[s/8] >> (7 - uint(s%8))) & 1
+		mask := byte(1) << (7 - uint(d%8))
+		if bit == 1 {
+			dst[d/8] |= mask
+		} else {
+			dst[d/8] &^= mask
+		}
+	}
+}
+
+// SIITMapper maps addresses for stateless translation: through the EAMT
+// first, then through the pool6 prefix.
+type SIITMapper struct {
+	EAMT  *EAMT        // May be nil
+	Pool6 *NAT64Prefix // May be nil when every address has an EAMT entry
+}
+
+// Extract returns the IPv4 address for an IPv6 address
+func (m *SIITMapper) Extract(ip net.IP) (net.IP, error) {
+	if m.EAMT != nil {
+		if ipv4, ok := m.EAMT.MapIPv6(ip); ok {
+			return ipv4, nil
+		}
+	}
+	if m.Pool6 != nil && m.Pool6.Contains(ip) {
+		return m.Pool6.Extract(ip)
+	}
+	return nil, fmt.Errorf("no EAMT entry or pool6 mapping for %s", ip)
+}
+
+// Embed returns the IPv6 address for an IPv4 address
+func (m *SIITMapper) Embed(ip net.IP) (net.IP, error) {
+	if m.EAMT != nil {
+		if ipv6, ok := m.EAMT.MapIPv4(ip); ok {
+			return ipv6, nil
+		}
+	}
+	if m.Pool6 != nil {
+		return m.Pool6.Embed(ip)
+	}
+	return nil, fmt.Errorf("no EAMT entry or pool6 mapping for %s", ip)
+}
+
+// CLATMapper maps addresses for the customer-side translator of 464XLAT
+// (RFC 6877): the local IPv4 address to the CLAT's own IPv6 address, and
+// every remote IPv4 address into the PLAT prefix.
+type CLATMapper struct {
+	IPv4 net.IP
+	IPv6 net.IP
+	PLAT *NAT64Prefix
+}
+
+// NewCLATMapper creates a mapper for the given CLAT addresses and PLAT prefix
+func NewCLATMapper(ipv4, ipv6 string, plat *NAT64Prefix) (*CLATMapper, error) {
+	v4 := net.ParseIP(ipv4).To4()
+	if v4 == nil {
+		return nil, fmt.Errorf("invalid CLAT IPv4 address %q", ipv4)
+	}
+	v6 := net.ParseIP(ipv6)
+	if v6 == nil || v6.To4() != nil {
+		return nil, fmt.Errorf("invalid CLAT IPv6 address %q", ipv6)
+	}
+	if plat.Contains(v6) {
+		return nil, fmt.Errorf("CLAT IPv6 address %s lies within the PLAT prefix %s", v6, plat)
+	}
+	return &CLATMapper{IPv4: v4, IPv6: v6, PLAT: plat}, nil
+}
+
+// Extract returns the IPv4 address for an IPv6 address
+func (m *CLATMapper) Extract(ip net.IP) (net.IP, error) {
+	if ip.Equal(m.IPv6) {
+		return m.IPv4, nil
+	}
+	if m.PLAT.Contains(ip) {
+		return m.PLAT.Extract(ip)
+	}
+	return nil, fmt.Errorf("%s is neither the CLAT address nor within the PLAT prefix", ip)
+}
+
+// Embed returns the IPv6 address for an IPv4 address
+func (m *CLATMapper) Embed(ip net.IP) (net.IP, error) {
+	if ip.Equal(m.IPv4) {
+		return m.IPv6, nil
+	}
+	return m.PLAT.Embed(ip)
+}
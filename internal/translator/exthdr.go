@@ -0,0 +1,113 @@
+package translator
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// IPv6 extension header types
+const (
+	ipv6HopByHop    = 0
+	ipv6Routing     = 43
+	ipv6Fragment    = 44
+	ipv6AuthHeader  = 51
+	ipv6NoNextHdr   = 59
+	ipv6DestOptions = 60
+	ipv6Mobility    = 135
+)
+
+// FragmentHeader holds the fragmentation fields of an IPv6 Fragment header
+// or of a fragmented IPv4 packet
+type FragmentHeader struct {
+	Identification uint32
+	Offset         uint16 // Fragment offset in bytes
+	MoreFragments  bool
+	NextHeader     uint8 // Protocol of the fragmented data
+
+	headerOffset    int // Start of the Fragment header (IPv6) or end of the header (IPv4)
+	nextHeaderIndex int // Byte naming the Fragment header, patched on reassembly
+	dataOffset      int // Start of the fragment data in RawData
+}
+
+// IsFragment reports whether the packet is part of a fragmented datagram.
+// Atomic fragments (offset 0 and no more fragments) are not.
+func (p *Packet) IsFragment() bool {
+	return p.Fragment != nil && (p.Fragment.Offset != 0 || p.Fragment.MoreFragments)
+}
+
+// IsFirstFragment reports whether the packet carries the upper-layer header
+func (p *Packet) IsFirstFragment() bool {
+	return p.Fragment == nil || p.Fragment.Offset == 0
+}
+
+// walkExtensionHeaders follows the IPv6 extension header chain and records the
+// upper-layer protocol, its offset and any Fragment header in the packet
+func walkExtensionHeaders(pkt *Packet, data []byte) error {
+	next := data[6]
+	nextIndex := 6
+	offset := 40
+
+	for {
+		switch next {
+		case ipv6HopByHop, ipv6Routing, ipv6DestOptions, ipv6Mobility:
+			if len(data) < offset+8 {
+				return fmt.Errorf("packet too small for IPv6 extension header %d", next)
+			}
+			if next == ipv6Routing {
+				pkt.SegmentsLeft = data[offset+3]
+			}
+			length := (int(data[offset+1]) + 1) * 8
+			if len(data) < offset+length {
+				return fmt.Errorf("truncated IPv6 extension header %d", next)
+			}
+			next = data[offset]
+			nextIndex = offset
+			offset += length
+
+		case ipv6AuthHeader:
+			if len(data) < offset+8 {
+				return fmt.Errorf("packet too small for IPv6 authentication header")
+			}
+			length := (int(data[offset+1]) + 2) * 4
+			if len(data) < offset+length {
+				return fmt.Errorf("truncated IPv6 authentication header")
+			}
+			next = data[offset]
+			nextIndex = offset
+			offset += length
+
+		case ipv6Fragment:
+			if len(data) < offset+8 {
+				return fmt.Errorf("packet too small for IPv6 fragment header")
+			}
+			if pkt.Fragment != nil {
+				return fmt.Errorf("multiple IPv6 fragment headers")
+			}
+			offsetFlags := binary.BigEndian.Uint16(data[offset+2 : offset+4])
+			pkt.Fragment = &FragmentHeader{
+				Identification:  binary.BigEndian.Uint32(data[offset+4 : offset+8]),
+				Offset:          offsetFlags &^ 0x7,
+				MoreFragments:   offsetFlags&0x1 != 0,
+				NextHeader:      data[offset],
+				headerOffset:    offset,
+				nextHeaderIndex: nextIndex,
+				dataOffset:      offset + 8,
+			}
+			next = data[offset]
+			nextIndex = offset
+			offset += 8
+
+			// Only the first fragment carries the rest of the chain
+			if pkt.Fragment.Offset != 0 {
+				pkt.Protocol = next
+				pkt.TransportOffset = offset
+				return nil
+			}
+
+		default:
+			pkt.Protocol = next
+			pkt.TransportOffset = offset
+			return nil
+		}
+	}
+}
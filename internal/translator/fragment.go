@@ -0,0 +1,464 @@
+package translator
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxFragmentsPerPacket bounds the number of fragments buffered for one datagram
+const maxFragmentsPerPacket = 64
+
+// maxUnfragmentedIPv4Size is the largest translated IPv4 packet sent with DF
+// cleared (RFC 7915 section 5.1)
+const maxUnfragmentedIPv4Size = 1260
+
+// ErrFragmentationNeeded is returned when a packet exceeds the MTU but must not be fragmented
+var ErrFragmentationNeeded = errors.New("packet exceeds MTU and must not be fragmented")
+
+// fragmentID is the source of IPv4 and IPv6 fragment identifications
+var fragmentID uint32
+
+// nextFragmentID returns a new fragment identification
+func nextFragmentID() uint32 {
+	return atomic.AddUint32(&fragmentID, 1)
+}
+
+// fragmentKey identifies the fragments of one datagram
+type fragmentKey struct {
+	src      [16]byte
+	dst      [16]byte
+	protocol uint8
+	id       uint32
+}
+
+// fragmentBuffer collects the fragments of one datagram
+type fragmentBuffer struct {
+	first    *Packet
+	pieces   map[uint16][]byte
+	totalLen int // Length of the fragmented data, -1 until the last fragment arrives
+	received int
+	expires  time.Time
+}
+
+// Reassembler reassembles fragmented IPv4 or IPv6 packets
+type Reassembler struct {
+	mu         sync.Mutex
+	buffers    map[fragmentKey]*fragmentBuffer
+	timeout    time.Duration
+	maxBuffers int
+}
+
+// NewReassembler creates a reassembler holding at most maxBuffers incomplete
+// datagrams, each for at most timeout
+func NewReassembler(timeout time.Duration, maxBuffers int) *Reassembler {
+	return &Reassembler{
+		buffers:    make(map[fragmentKey]*fragmentBuffer),
+		timeout:    timeout,
+		maxBuffers: maxBuffers,
+	}
+}
+
+// Add queues a fragment. It returns the reassembled packet once every fragment
+// of the datagram has arrived, or nil while fragments are still missing.
+func (r *Reassembler) Add(pkt *Packet) (*Packet, error) {
+	if !pkt.IsFragment() {
+		return pkt, nil
+	}
+
+	frag := pkt.Fragment
+	data := pkt.RawData[frag.dataOffset:]
+	if frag.MoreFragments && len(data)%8 != 0 {
+		return nil, fmt.Errorf("fragment length %d is not a multiple of 8", len(data))
+	}
+	if int(frag.Offset)+len(data) > 0xffff {
+		return nil, fmt.Errorf("fragment exceeds maximum packet size")
+	}
+
+	key := fragmentKey{protocol: frag.NextHeader, id: frag.Identification}
+	copy(key.src[:], pkt.SrcIP.To16())
+	copy(key.dst[:], pkt.DstIP.To16())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	buf, exists := r.buffers[key]
+	if !exists {
+		if len(r.buffers) >= r.maxBuffers {
+			return nil, fmt.Errorf("reassembly buffers full (%d)", r.maxBuffers)
+		}
+		buf = &fragmentBuffer{
+			pieces:   make(map[uint16][]byte),
+			totalLen: -1,
+			expires:  time.Now().Add(r.timeout),
+		}
+		r.buffers[key] = buf
+	}
+
+	if len(buf.pieces) >= maxFragmentsPerPacket {
+		delete(r.buffers, key)
+		return nil, fmt.Errorf("too many fragments for one packet")
+	}
+
+	// Overlapping fragments are not allowed (RFC 5722), drop the whole datagram
+	end := int(frag.Offset) + len(data)
+	for offset, piece := range buf.pieces {
+		if int(frag.Offset) < int(offset)+len(piece) && int(offset) < end {
+			if offset == frag.Offset && len(piece) == len(data) {
+				return nil, nil // Duplicate
+			}
+			delete(r.buffers, key)
+			return nil, fmt.Errorf("overlapping fragments")
+		}
+	}
+
+	// Every fragment must lie within the datagram the last fragment ends
+	if buf.totalLen >= 0 && (end > buf.totalLen || (!frag.MoreFragments && end != buf.totalLen)) {
+		delete(r.buffers, key)
+		return nil, fmt.Errorf("fragment ends beyond the last fragment")
+	}
+	if !frag.MoreFragments {
+		for offset, piece := range buf.pieces {
+			if int(offset)+len(piece) > end {
+				delete(r.buffers, key)
+				return nil, fmt.Errorf("fragment ends beyond the last fragment")
+			}
+		}
+	}
+
+	buf.pieces[frag.Offset] = append([]byte{}, data...)
+	buf.received += len(data)
+	if frag.Offset == 0 {
+		// Keep only the headers, the caller may reuse the packet's buffer
+		first := *pkt
+		first.RawData = append([]byte(nil), pkt.RawData[:frag.headerOffset]...)
+		buf.first = &first
+	}
+	if !frag.MoreFragments {
+		buf.totalLen = end
+	}
+
+	if buf.first == nil || buf.totalLen < 0 {
+		return nil, nil
+	}
+	if buf.length() > 0xffff {
+		delete(r.buffers, key)
+		return nil, fmt.Errorf("reassembled packet exceeds maximum packet size")
+	}
+	if buf.received != buf.totalLen || !buf.complete() {
+		return nil, nil
+	}
+
+	delete(r.buffers, key)
+	return buf.reassemble()
+}
+
+// complete reports whether the pieces cover the datagram without gaps
+func (buf *fragmentBuffer) complete() bool {
+	offsets := make([]int, 0, len(buf.pieces))
+	for offset := range buf.pieces {
+		offsets = append(offsets, int(offset))
+	}
+	slices.Sort(offsets)
+
+	next := 0
+	for _, offset := range offsets {
+		if offset != next {
+			return false
+		}
+		next += len(buf.pieces[uint16(offset)])
+	}
+	return next == buf.totalLen
+}
+
+// length returns the value the reassembled header's length field must hold
+func (buf *fragmentBuffer) length() int {
+	length := buf.first.Fragment.headerOffset + buf.totalLen
+	if buf.first.IsIPv6 {
+		length -= 40 // Payload length excludes the base header
+	}
+	return length
+}
+
+// reassemble rebuilds the complete packet from the first fragment's header
+// and the collected data
+func (buf *fragmentBuffer) reassemble() (*Packet, error) {
+	first := buf.first
+	frag := first.Fragment
+
+	if buf.length() > 0xffff {
+		return nil, fmt.Errorf("reassembled packet exceeds maximum packet size")
+	}
+
+	packet := make([]byte, frag.headerOffset, frag.headerOffset+buf.totalLen)
+	copy(packet, first.RawData[:frag.headerOffset])
+
+	data := make([]byte, buf.totalLen)
+	for offset, piece := range buf.pieces {
+		copy(data[offset:], piece)
+	}
+	packet = append(packet, data...)
+
+	if first.IsIPv6 {
+		// Drop the Fragment header from the chain
+		packet[frag.nextHeaderIndex] = frag.NextHeader
+		binary.BigEndian.PutUint16(packet[4:6], uint16(len(packet)-40))
+		return ParseIPv6Packet(packet)
+	}
+
+	flags := binary.BigEndian.Uint16(packet[6:8]) & 0x4000
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+	binary.BigEndian.PutUint16(packet[6:8], flags)
+	binary.BigEndian.PutUint16(packet[10:12], 0)
+	binary.BigEndian.PutUint16(packet[10:12], calculateChecksum(packet[:frag.headerOffset]))
+	return ParseIPv4Packet(packet)
+}
+
+// SetLimits changes the timeout and buffer limit. Datagrams already buffered
+// keep the timeout they started with.
+func (r *Reassembler) SetLimits(timeout time.Duration, maxBuffers int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.timeout = timeout
+	r.maxBuffers = maxBuffers
+}
+
+// Expire drops incomplete datagrams whose timeout has passed
+func (r *Reassembler) Expire() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	removed := 0
+	for key, buf := range r.buffers {
+		if now.After(buf.expires) {
+			delete(r.buffers, key)
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// Pending returns the number of incomplete datagrams
+func (r *Reassembler) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.buffers)
+}
+
+// setIPv4Fragmentation fills in the identification, flags and fragment offset
+// of a translated IPv4 packet (RFC 7915 section 5.1)
+func setIPv4Fragmentation(packet []byte, frag *FragmentHeader) {
+	var id uint16
+	var flags uint16
+
+	if frag != nil {
+		id = uint16(frag.Identification)
+		flags = frag.Offset / 8
+		if frag.MoreFragments {
+			flags |= 0x2000
+		}
+	} else {
+		id = uint16(nextFragmentID())
+		if len(packet) > maxUnfragmentedIPv4Size {
+			flags = 0x4000 // Don't fragment
+		}
+	}
+
+	binary.BigEndian.PutUint16(packet[4:6], id)
+	binary.BigEndian.PutUint16(packet[6:8], flags)
+	binary.BigEndian.PutUint16(packet[10:12], 0)
+	binary.BigEndian.PutUint16(packet[10:12], calculateChecksum(packet[:20]))
+}
+
+// insertFragmentHeader adds a Fragment header after the base header of a
+// translated IPv6 packet (RFC 7915 section 4.1)
+func insertFragmentHeader(packet []byte, frag *FragmentHeader) []byte {
+	header := make([]byte, 8)
+	header[0] = packet[6]
+	offsetFlags := frag.Offset
+	if frag.MoreFragments {
+		offsetFlags |= 0x1
+	}
+	binary.BigEndian.PutUint16(header[2:4], offsetFlags)
+	binary.BigEndian.PutUint32(header[4:8], frag.Identification)
+
+	out := make([]byte, 0, len(packet)+8)
+	out = append(out, packet[:40]...)
+	out = append(out, header...)
+	out = append(out, packet[40:]...)
+
+	out[6] = ipv6Fragment
+	binary.BigEndian.PutUint16(out[4:6], uint16(len(out)-40))
+	return out
+}
+
+// FragmentIPv4 splits a translated IPv4 packet into fragments that fit the MTU
+func FragmentIPv4(packet []byte, mtu int) ([][]byte, error) {
+	if len(packet) <= mtu {
+		return [][]byte{packet}, nil
+	}
+
+	flags := binary.BigEndian.Uint16(packet[6:8])
+	if flags&0x4000 != 0 {
+		return nil, ErrFragmentationNeeded
+	}
+
+	headerLen := int(packet[0]&0x0F) * 4
+	chunk := (mtu - headerLen) &^ 7
+	if chunk <= 0 {
+		return nil, fmt.Errorf("MTU %d too small to fragment", mtu)
+	}
+
+	baseOffset := int(flags&0x1fff) * 8
+	moreFragments := flags&0x2000 != 0
+	data := packet[headerLen:]
+
+	var fragments [][]byte
+	for start := 0; start < len(data); start += chunk {
+		end := start + chunk
+		if end > len(data) {
+			end = len(data)
+		}
+
+		fragment := make([]byte, headerLen+end-start)
+		copy(fragment, packet[:headerLen])
+		copy(fragment[headerLen:], data[start:end])
+
+		fragFlags := uint16((baseOffset + start) / 8)
+		if end < len(data) || moreFragments {
+			fragFlags |= 0x2000
+		}
+		binary.BigEndian.PutUint16(fragment[2:4], uint16(len(fragment)))
+		binary.BigEndian.PutUint16(fragment[6:8], fragFlags)
+		binary.BigEndian.PutUint16(fragment[10:12], 0)
+		binary.BigEndian.PutUint16(fragment[10:12], calculateChecksum(fragment[:headerLen]))
+
+		fragments = append(fragments, fragment)
+	}
+
+	return fragments, nil
+}
+
+// FragmentIPv6 splits a translated IPv6 packet into fragments that fit the MTU,
+// reusing its Fragment header if it already has one
+func FragmentIPv6(packet []byte, mtu int) ([][]byte, error) {
+	if len(packet) <= mtu {
+		return [][]byte{packet}, nil
+	}
+
+	var frag FragmentHeader
+	var data []byte
+	if packet[6] == ipv6Fragment && len(packet) >= 48 {
+		offsetFlags := binary.BigEndian.Uint16(packet[42:44])
+		frag = FragmentHeader{
+			Identification: binary.BigEndian.Uint32(packet[44:48]),
+			Offset:         offsetFlags &^ 0x7,
+			MoreFragments:  offsetFlags&0x1 != 0,
+			NextHeader:     packet[40],
+		}
+		data = packet[48:]
+	} else {
+		frag = FragmentHeader{
+			Identification: nextFragmentID(),
+			NextHeader:     packet[6],
+		}
+		data = packet[40:]
+	}
+
+	chunk := (mtu - 48) &^ 7
+	if chunk <= 0 {
+		return nil, fmt.Errorf("MTU %d too small to fragment", mtu)
+	}
+
+	var fragments [][]byte
+	for start := 0; start < len(data); start += chunk {
+		end := start + chunk
+		if end > len(data) {
+			end = len(data)
+		}
+
+		fragment := make([]byte, 48+end-start)
+		copy(fragment, packet[:40])
+		copy(fragment[48:], data[start:end])
+
+		fragment[6] = ipv6Fragment
+		binary.BigEndian.PutUint16(fragment[4:6], uint16(len(fragment)-40))
+		fragment[40] = frag.NextHeader
+		offsetFlags := frag.Offset + uint16(start)
+		if end < len(data) || frag.MoreFragments {
+			offsetFlags |= 0x1
+		}
+		binary.BigEndian.PutUint16(fragment[42:44], offsetFlags)
+		binary.BigEndian.PutUint32(fragment[44:48], frag.Identification)
+
+		fragments = append(fragments, fragment)
+	}
+
+	return fragments, nil
+}
+
+// BuildPacketTooBig builds an ICMPv6 Packet Too Big message sent back to the
+// source of an IPv6 packet that could not be forwarded
+func BuildPacketTooBig(pkt *Packet, mtu int) ([]byte, error) {
+	if !pkt.IsIPv6 {
+		return nil, fmt.Errorf("packet is not IPv6")
+	}
+	if mtu < minIPv6MTU {
+		mtu = minIPv6MTU
+	}
+
+	message := make([]byte, 8)
+	message[0] = icmpv6PacketTooBig
+	binary.BigEndian.PutUint32(message[4:8], uint32(mtu))
+
+	original := pkt.RawData
+	if len(original) > minIPv6MTU-48 {
+		original = original[:minIPv6MTU-48]
+	}
+	message = append(message, original...)
+
+	packet, err := buildIPv6Packet(pkt.DstIP, pkt.SrcIP, 58, message)
+	if err != nil {
+		return nil, err
+	}
+
+	binary.BigEndian.PutUint16(packet[42:44], transportChecksum(packet[8:40], 58, packet[40:]))
+	return packet, nil
+}
+
+// BuildFragmentationNeeded builds an ICMPv4 Fragmentation Needed message sent
+// back to the source of an IPv4 packet that could not be forwarded
+func BuildFragmentationNeeded(pkt *Packet, mtu int) ([]byte, error) {
+	if pkt.IsIPv6 {
+		return nil, fmt.Errorf("packet is not IPv4")
+	}
+
+	message := make([]byte, 8)
+	message[0] = icmpv4DestUnreachable
+	message[1] = 4 // Fragmentation needed and DF set
+	binary.BigEndian.PutUint16(message[6:8], uint16(mtu))
+
+	// Keep the error within the 576 bytes every IPv4 host accepts
+	original := pkt.RawData
+	if len(original) > 576-28 {
+		original = original[:576-28]
+	}
+	message = append(message, original...)
+	binary.BigEndian.PutUint16(message[2:4], calculateChecksum(message))
+
+	packet, err := buildIPv4Packet(pkt.DstIP, pkt.SrcIP, 1, message)
+	if err != nil {
+		return nil, err
+	}
+
+	setIPv4Fragmentation(packet, nil)
+	return packet, nil
+}
@@ -0,0 +1,113 @@
+package translator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// ipv4Fragment builds an IPv4 UDP fragment carrying data at offset
+func ipv4Fragment(t *testing.T, id uint16, offset int, more bool, data []byte) *Packet {
+	t.Helper()
+
+	packet := make([]byte, 20+len(data))
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+	binary.BigEndian.PutUint16(packet[4:6], id)
+	flags := uint16(offset / 8)
+	if more {
+		flags |= 0x2000
+	}
+	binary.BigEndian.PutUint16(packet[6:8], flags)
+	packet[8] = 64
+	packet[9] = 17
+	copy(packet[12:16], []byte{192, 0, 2, 1})
+	copy(packet[16:20], []byte{198, 51, 100, 1})
+	binary.BigEndian.PutUint16(packet[10:12], calculateChecksum(packet[:20]))
+	copy(packet[20:], data)
+
+	pkt, err := ParseIPv4Packet(packet)
+	if err != nil {
+		t.Fatalf("ParseIPv4Packet: %v", err)
+	}
+	return pkt
+}
+
+// udpData returns a UDP header and payload of n bytes in total
+func udpData(n int) []byte {
+	data := make([]byte, n)
+	binary.BigEndian.PutUint16(data[0:2], 5000)
+	binary.BigEndian.PutUint16(data[2:4], 53)
+	binary.BigEndian.PutUint16(data[4:6], uint16(n))
+	for i := 8; i < n; i++ {
+		data[i] = byte(i)
+	}
+	return data
+}
+
+func TestReassemblerOutOfOrder(t *testing.T) {
+	r := NewReassembler(time.Second, 16)
+	data := udpData(24)
+
+	for _, frag := range []struct {
+		offset int
+		more   bool
+	}{{16, false}, {0, true}, {8, true}} {
+		pkt, err := r.Add(ipv4Fragment(t, 1, frag.offset, frag.more, data[frag.offset:frag.offset+8]))
+		if err != nil {
+			t.Fatalf("Add(offset %d): %v", frag.offset, err)
+		}
+		if frag.offset != 8 {
+			if pkt != nil {
+				t.Fatalf("Add(offset %d) returned a packet before all fragments arrived", frag.offset)
+			}
+			continue
+		}
+		if pkt == nil {
+			t.Fatal("datagram not reassembled")
+		}
+		if pkt.IsFragment() || !bytes.Equal(pkt.RawData[20:], data) {
+			t.Fatalf("reassembled %x, want %x", pkt.RawData[20:], data)
+		}
+	}
+	if r.Pending() != 0 {
+		t.Fatalf("Pending() = %d after reassembly", r.Pending())
+	}
+}
+
+func TestReassemblerRejectsInconsistentFragments(t *testing.T) {
+	tests := []struct {
+		name  string
+		frags [][3]int // offset, length, more fragments
+	}{
+		// Byte count matches the last fragment's end, but a piece lies beyond it
+		{"piece beyond last fragment", [][3]int{{0, 8, 1}, {32, 8, 1}, {16, 8, 0}}},
+		{"fragment beyond last fragment", [][3]int{{0, 8, 1}, {16, 8, 0}, {32, 8, 1}}},
+		{"two different ends", [][3]int{{0, 8, 1}, {16, 8, 0}, {8, 16, 0}}},
+		{"too long", [][3]int{{0, 8, 1}, {65512, 16, 0}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReassembler(time.Second, 16)
+			data := udpData(65536)
+			var lastErr error
+			for _, f := range tt.frags {
+				pkt, err := r.Add(ipv4Fragment(t, 2, f[0], f[2] == 1, data[f[0]:f[0]+f[1]]))
+				if pkt != nil {
+					t.Fatalf("inconsistent fragments reassembled into %d bytes", len(pkt.RawData))
+				}
+				if err != nil {
+					lastErr = err
+				}
+			}
+			if lastErr == nil {
+				t.Fatal("no error for inconsistent fragments")
+			}
+			if r.Pending() != 0 {
+				t.Fatalf("Pending() = %d, want the datagram dropped", r.Pending())
+			}
+		})
+	}
+}
@@ -0,0 +1,506 @@
+package translator
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/mdxabu/bridge/internal/nat"
+)
+
+// ICMPv4 message types
+const (
+	icmpv4EchoReply       = 0
+	icmpv4DestUnreachable = 3
+	icmpv4EchoRequest     = 8
+	icmpv4TimeExceeded    = 11
+	icmpv4ParamProblem    = 12
+)
+
+// ICMPv6 message types
+const (
+	icmpv6DestUnreachable = 1
+	icmpv6PacketTooBig    = 2
+	icmpv6TimeExceeded    = 3
+	icmpv6ParamProblem    = 4
+	icmpv6EchoRequest     = 128
+	icmpv6EchoReply       = 129
+)
+
+// minIPv6MTU is the minimum link MTU required by IPv6
+const minIPv6MTU = 1280
+
+// ErrUntranslatable is returned for packets that RFC 7915 says to silently drop
+var ErrUntranslatable = errors.New("packet cannot be translated")
+
+// icmpv6PointerMap maps IPv6 header offsets to IPv4 header offsets (RFC 7915 section 5.2)
+var icmpv6PointerMap = map[uint32]uint8{
+	0: 0, // Version/Traffic Class -> Version/IHL
+	1: 1, // Traffic Class/Flow Label -> Type Of Service
+	4: 2, // Payload Length -> Total Length
+	5: 2, // Payload Length -> Total Length
+	6: 9, // Next Header -> Protocol
+	7: 8, // Hop Limit -> TTL
+}
+
+// icmpv4PointerMap maps IPv4 header offsets to IPv6 header offsets (RFC 7915 section 4.2)
+var icmpv4PointerMap = map[uint8]uint32{
+	0: 0, // Version/IHL -> Version/Traffic Class
+	1: 1, // Type Of Service -> Traffic Class/Flow Label
+	2: 4, // Total Length -> Payload Length
+	3: 4, // Total Length -> Payload Length
+	8: 7, // TTL -> Hop Limit
+	9: 6, // Protocol -> Next Header
+}
+
+// isICMPEcho reports whether an ICMP type is an echo request or reply
+func isICMPEcho(isIPv6 bool, icmpType uint8) bool {
+	if isIPv6 {
+		return icmpType == icmpv6EchoRequest || icmpType == icmpv6EchoReply
+	}
+	return icmpType == icmpv4EchoRequest || icmpType == icmpv4EchoReply
+}
+
+// isICMPError reports whether an ICMP type is an error message carrying an embedded packet
+func isICMPError(isIPv6 bool, icmpType uint8) bool {
+	if isIPv6 {
+		return icmpType >= icmpv6DestUnreachable && icmpType <= icmpv6ParamProblem
+	}
+	return icmpType == icmpv4DestUnreachable || icmpType == icmpv4TimeExceeded || icmpType == icmpv4ParamProblem
+}
+
+// IsICMPEcho reports whether the packet is an ICMP echo request or reply
+func (p *Packet) IsICMPEcho() bool {
+	return p.Type == PacketTypeICMP && isICMPEcho(p.IsIPv6, p.ICMPType)
+}
+
+// IsICMPError reports whether the packet is an ICMP error message
+func (p *Packet) IsICMPError() bool {
+	return p.Type == PacketTypeICMP && p.Inner != nil
+}
+
+// parseICMP fills in the ICMP fields of a packet. For echo messages the
+// identifier is used as both ports so the NAT can track it like a port.
+func parseICMP(pkt *Packet, payload []byte) error {
+	pkt.ICMPType = payload[0]
+	pkt.ICMPCode = payload[1]
+
+	if isICMPEcho(pkt.IsIPv6, pkt.ICMPType) {
+		if len(payload) < 8 {
+			return fmt.Errorf("packet too small for ICMP echo header")
+		}
+		pkt.SrcPort = binary.BigEndian.Uint16(payload[4:6])
+		pkt.DstPort = pkt.SrcPort
+	} else if isICMPError(pkt.IsIPv6, pkt.ICMPType) {
+		if len(payload) < 8 {
+			return fmt.Errorf("packet too small for ICMP error header")
+		}
+		inner, err := parseEmbeddedPacket(payload[8:], pkt.IsIPv6)
+		if err != nil {
+			return fmt.Errorf("invalid packet embedded in ICMP error: %w", err)
+		}
+		pkt.Inner = inner
+	}
+
+	return nil
+}
+
+// parseEmbeddedPacket parses the packet carried in an ICMP error. It is
+// usually truncated, so only the IP header and ports are required.
+func parseEmbeddedPacket(data []byte, isIPv6 bool) (*Packet, error) {
+	pkt := &Packet{
+		RawData: data,
+		IsIPv6:  isIPv6,
+	}
+
+	var payload []byte
+	if isIPv6 {
+		if len(data) < 40 {
+			return nil, fmt.Errorf("packet too small for IPv6 header")
+		}
+		pkt.IPv6Header = data[:40]
+		pkt.SrcIP = append(net.IP(nil), data[8:24]...)
+		pkt.DstIP = append(net.IP(nil), data[24:40]...)
+		if err := walkExtensionHeaders(pkt, data); err != nil {
+			return nil, err
+		}
+		if !pkt.IsFirstFragment() {
+			return nil, ErrUntranslatable
+		}
+		payload = data[pkt.TransportOffset:]
+	} else {
+		if len(data) < 20 {
+			return nil, fmt.Errorf("packet too small for IPv4 header")
+		}
+		headerLen := int(data[0]&0x0F) * 4
+		if headerLen < 20 || len(data) < headerLen {
+			return nil, fmt.Errorf("invalid IPv4 header length")
+		}
+		pkt.IPv4Header = data[:headerLen]
+		pkt.Protocol = data[9]
+		pkt.SrcIP = net.IPv4(data[12], data[13], data[14], data[15])
+		pkt.DstIP = net.IPv4(data[16], data[17], data[18], data[19])
+		payload = data[headerLen:]
+	}
+
+	pkt.Payload = payload
+
+	pkt.Type = packetTypeForProtocol(pkt.Protocol)
+
+	switch pkt.Type {
+	case PacketTypeTCP, PacketTypeUDP:
+		if len(payload) < 4 {
+			return nil, fmt.Errorf("embedded packet too small for ports")
+		}
+		pkt.SrcPort = binary.BigEndian.Uint16(payload[0:2])
+		pkt.DstPort = binary.BigEndian.Uint16(payload[2:4])
+
+	case PacketTypeICMP:
+		if len(payload) < 8 {
+			return nil, fmt.Errorf("embedded packet too small for ICMP header")
+		}
+		pkt.ICMPType = payload[0]
+		pkt.ICMPCode = payload[1]
+		if !isICMPEcho(isIPv6, pkt.ICMPType) {
+			return nil, ErrUntranslatable
+		}
+		pkt.SrcPort = binary.BigEndian.Uint16(payload[4:6])
+		pkt.DstPort = pkt.SrcPort
+	}
+
+	return pkt, nil
+}
+
+// translateICMPv6ToICMPv4 translates an ICMPv6 message to ICMPv4 (RFC 7915 section 5.2)
+func translateICMPv6ToICMPv4(pkt *Packet, mapper AddressMapper, session *nat.SessionState) ([]byte, error) {
+	msg := pkt.Payload
+	if len(msg) < 8 {
+		return nil, fmt.Errorf("ICMPv6 message too small")
+	}
+
+	out := make([]byte, 8, len(msg))
+
+	switch pkt.ICMPType {
+	case icmpv6EchoRequest, icmpv6EchoReply:
+		out[0] = icmpv4EchoRequest
+		if pkt.ICMPType == icmpv6EchoReply {
+			out[0] = icmpv4EchoReply
+		}
+		copy(out[4:8], msg[4:8])
+		if session != nil {
+			binary.BigEndian.PutUint16(out[4:6], session.IPv4SrcPort)
+		}
+		out = append(out, msg[8:]...)
+
+	case icmpv6DestUnreachable:
+		out[0] = icmpv4DestUnreachable
+		switch pkt.ICMPCode {
+		case 0, 2, 3: // No route, beyond scope, address unreachable
+			out[1] = 1 // Host unreachable
+		case 1: // Administratively prohibited
+			out[1] = 10 // Communication with host administratively prohibited
+		case 4: // Port unreachable
+			out[1] = 3
+		default:
+			return nil, ErrUntranslatable
+		}
+
+	case icmpv6PacketTooBig:
+		out[0] = icmpv4DestUnreachable
+		out[1] = 4 // Fragmentation needed and DF set
+		mtu := binary.BigEndian.Uint32(msg[4:8])
+		if mtu > 0xffff+20 {
+			mtu = 0xffff + 20
+		}
+		if mtu < 20+68 {
+			mtu = 20 + 68
+		}
+		binary.BigEndian.PutUint16(out[6:8], uint16(mtu-20))
+
+	case icmpv6TimeExceeded:
+		out[0] = icmpv4TimeExceeded
+		out[1] = pkt.ICMPCode
+
+	case icmpv6ParamProblem:
+		switch pkt.ICMPCode {
+		case 0: // Erroneous header field
+			pointer := binary.BigEndian.Uint32(msg[4:8])
+			var mapped uint8
+			switch {
+			case pointer >= 8 && pointer < 24:
+				mapped = 12 // Source Address
+			case pointer >= 24 && pointer < 40:
+				mapped = 16 // Destination Address
+			default:
+				p, ok := icmpv6PointerMap[pointer]
+				if !ok {
+					return nil, ErrUntranslatable
+				}
+				mapped = p
+			}
+			out[0] = icmpv4ParamProblem
+			out[4] = mapped
+		case 1: // Unrecognized Next Header
+			out[0] = icmpv4DestUnreachable
+			out[1] = 2 // Protocol unreachable
+		default:
+			return nil, ErrUntranslatable
+		}
+
+	default:
+		return nil, ErrUntranslatable
+	}
+
+	if pkt.Inner != nil {
+		inner, err := translateEmbeddedIPv6ToIPv4(pkt.Inner, mapper, session)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, inner...)
+	}
+
+	binary.BigEndian.PutUint16(out[2:4], 0)
+	binary.BigEndian.PutUint16(out[2:4], calculateChecksum(out))
+
+	return out, nil
+}
+
+// translateICMPv4ToICMPv6 translates an ICMPv4 message to ICMPv6 (RFC 7915 section 4.2).
+// The checksum covers the IPv6 pseudo-header, so it is left for the caller to fill in.
+func translateICMPv4ToICMPv6(pkt *Packet, mapper AddressMapper, session *nat.SessionState) ([]byte, error) {
+	msg := pkt.Payload
+	if len(msg) < 8 {
+		return nil, fmt.Errorf("ICMPv4 message too small")
+	}
+
+	out := make([]byte, 8, len(msg)+20)
+
+	switch pkt.ICMPType {
+	case icmpv4EchoRequest, icmpv4EchoReply:
+		out[0] = icmpv6EchoRequest
+		if pkt.ICMPType == icmpv4EchoReply {
+			out[0] = icmpv6EchoReply
+		}
+		copy(out[4:8], msg[4:8])
+		if session != nil {
+			binary.BigEndian.PutUint16(out[4:6], session.IPv6SrcPort)
+		}
+		out = append(out, msg[8:]...)
+
+	case icmpv4DestUnreachable:
+		out[0] = icmpv6DestUnreachable
+		switch pkt.ICMPCode {
+		case 0, 1, 5, 6, 7, 8, 11, 12: // Net/host unreachable, source route failed, unknown, isolated, TOS
+			out[1] = 0 // No route to destination
+		case 2: // Protocol unreachable
+			out[0] = icmpv6ParamProblem
+			out[1] = 1                              // Unrecognized Next Header
+			binary.BigEndian.PutUint32(out[4:8], 6) // Points to Next Header
+		case 3: // Port unreachable
+			out[1] = 4
+		case 4: // Fragmentation needed and DF set
+			out[0] = icmpv6PacketTooBig
+			out[1] = 0
+			mtu := uint32(binary.BigEndian.Uint16(msg[6:8])) + 20
+			if mtu < minIPv6MTU {
+				mtu = minIPv6MTU
+			}
+			binary.BigEndian.PutUint32(out[4:8], mtu)
+		case 9, 10, 13, 15: // Administratively prohibited, precedence cutoff
+			out[1] = 1 // Communication administratively prohibited
+		default:
+			return nil, ErrUntranslatable
+		}
+
+	case icmpv4TimeExceeded:
+		out[0] = icmpv6TimeExceeded
+		out[1] = pkt.ICMPCode
+
+	case icmpv4ParamProblem:
+		switch pkt.ICMPCode {
+		case 0, 2: // Pointer indicates the error, bad length
+			pointer := msg[4]
+			var mapped uint32
+			switch {
+			case pointer >= 12 && pointer < 16:
+				mapped = 8 // Source Address
+			case pointer >= 16 && pointer < 20:
+				mapped = 24 // Destination Address
+			default:
+				p, ok := icmpv4PointerMap[pointer]
+				if !ok {
+					return nil, ErrUntranslatable
+				}
+				mapped = p
+			}
+			out[0] = icmpv6ParamProblem
+			out[1] = 0 // Erroneous header field
+			binary.BigEndian.PutUint32(out[4:8], mapped)
+		default:
+			return nil, ErrUntranslatable
+		}
+
+	default:
+		return nil, ErrUntranslatable
+	}
+
+	if pkt.Inner != nil {
+		inner, err := translateEmbeddedIPv4ToIPv6(pkt.Inner, mapper, session)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, inner...)
+
+		// ICMPv6 errors must fit in the minimum IPv6 MTU
+		if len(out) > minIPv6MTU-40 {
+			out = out[:minIPv6MTU-40]
+		}
+	}
+
+	return out, nil
+}
+
+// translateEmbeddedIPv6ToIPv4 translates the packet carried in an ICMPv6 error.
+// It was sent towards the IPv6 host, so its destination is the NAT session's
+// IPv6 source and is mapped back to the allocated IPv4 transport address.
+func translateEmbeddedIPv6ToIPv4(inner *Packet, mapper AddressMapper, session *nat.SessionState) ([]byte, error) {
+	srcIP, err := mapper.Extract(inner.SrcIP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract IPv4 from embedded source: %w", err)
+	}
+
+	var dstIP net.IP
+	if session != nil {
+		dstIP = session.IPv4SrcIP.To4()
+	} else {
+		dstIP, err = mapper.Extract(inner.DstIP)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract IPv4 from embedded destination: %w", err)
+		}
+	}
+
+	payload := append([]byte{}, inner.Payload...)
+	packet, err := buildIPv4Packet(srcIP, dstIP, inner.Protocol, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// The embedded header keeps the length of the original packet, not the truncated copy
+	upperLen := int(binary.BigEndian.Uint16(inner.IPv6Header[4:6])) - (inner.TransportOffset - 40)
+	totalLen := 20 + upperLen
+	binary.BigEndian.PutUint16(packet[2:4], uint16(totalLen))
+	packet[8] = inner.IPv6Header[7]
+	binary.BigEndian.PutUint16(packet[10:12], 0)
+	binary.BigEndian.PutUint16(packet[10:12], calculateChecksum(packet[:20]))
+
+	segment := packet[20:]
+	switch inner.Type {
+	case PacketTypeTCP, PacketTypeUDP:
+		updatePseudoHeaderChecksum(segment, inner.Protocol, inner.IPv6Header[8:40], packet[12:20])
+		if session != nil {
+			rewritePort(segment, inner.Protocol, dstPortOffset, session.IPv4SrcPort)
+		}
+
+	case PacketTypeICMP:
+		newType := uint8(icmpv4EchoRequest)
+		if inner.ICMPType == icmpv6EchoReply {
+			newType = icmpv4EchoReply
+		}
+		newID := inner.SrcPort
+		if session != nil {
+			newID = session.IPv4SrcPort
+		}
+		pseudo := icmpv6PseudoHeader(inner.IPv6Header[8:40], upperLen)
+		rewriteEmbeddedEcho(segment, newType, newID, pseudo, nil)
+	}
+
+	return packet, nil
+}
+
+// translateEmbeddedIPv4ToIPv6 translates the packet carried in an ICMPv4 error.
+// It was sent by the NAT on behalf of the IPv6 host, so its source is the
+// allocated IPv4 transport address and is mapped back to the IPv6 host.
+func translateEmbeddedIPv4ToIPv6(inner *Packet, mapper AddressMapper, session *nat.SessionState) ([]byte, error) {
+	dstIP, err := mapper.Embed(inner.DstIP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map embedded destination to IPv6: %w", err)
+	}
+
+	var srcIP net.IP
+	if session != nil {
+		srcIP = session.IPv6SrcIP.To16()
+	} else {
+		srcIP, err = mapper.Embed(inner.SrcIP)
+		if err != nil {
+			return nil, fmt.Errorf("failed to map embedded source to IPv6: %w", err)
+		}
+	}
+
+	payload := append([]byte{}, inner.Payload...)
+	packet, err := buildIPv6Packet(srcIP, dstIP, inner.Protocol, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// The embedded header keeps the length of the original packet, not the truncated copy
+	headerLen := len(inner.IPv4Header)
+	payloadLen := int(binary.BigEndian.Uint16(inner.IPv4Header[2:4])) - headerLen
+	if payloadLen < 0 {
+		payloadLen = 0
+	}
+	binary.BigEndian.PutUint16(packet[4:6], uint16(payloadLen))
+	packet[7] = inner.IPv4Header[8]
+
+	segment := packet[40:]
+	switch inner.Type {
+	case PacketTypeTCP, PacketTypeUDP:
+		updatePseudoHeaderChecksum(segment, inner.Protocol, inner.IPv4Header[12:20], packet[8:40])
+		if session != nil {
+			rewritePort(segment, inner.Protocol, srcPortOffset, session.IPv6SrcPort)
+		}
+
+	case PacketTypeICMP:
+		newType := uint8(icmpv6EchoRequest)
+		if inner.ICMPType == icmpv4EchoReply {
+			newType = icmpv6EchoReply
+		}
+		newID := inner.SrcPort
+		if session != nil {
+			newID = session.IPv6SrcPort
+		}
+		pseudo := icmpv6PseudoHeader(packet[8:40], payloadLen)
+		rewriteEmbeddedEcho(segment, newType, newID, nil, pseudo)
+	}
+
+	return packet, nil
+}
+
+// icmpv6PseudoHeader returns the pseudo-header covered by an ICMPv6 checksum
+func icmpv6PseudoHeader(addrs []byte, length int) []byte {
+	pseudo := make([]byte, 40)
+	copy(pseudo[0:32], addrs)
+	binary.BigEndian.PutUint32(pseudo[32:36], uint32(length))
+	pseudo[39] = 58
+	return pseudo
+}
+
+// rewriteEmbeddedEcho rewrites the type and identifier of a possibly truncated
+// echo message and incrementally updates its checksum, removing or adding the
+// ICMPv6 pseudo-header as needed
+func rewriteEmbeddedEcho(segment []byte, newType uint8, newID uint16, oldPseudo, newPseudo []byte) {
+	if len(segment) < 8 {
+		return
+	}
+
+	oldData := append(append([]byte{}, oldPseudo...), segment[0], segment[1], segment[4], segment[5])
+
+	segment[0] = newType
+	segment[1] = 0
+	binary.BigEndian.PutUint16(segment[4:6], newID)
+
+	newData := append(append([]byte{}, newPseudo...), segment[0], segment[1], segment[4], segment[5])
+
+	checksum := binary.BigEndian.Uint16(segment[2:4])
+	binary.BigEndian.PutUint16(segment[2:4], adjustChecksum(checksum, oldData, newData))
+}
@@ -0,0 +1,284 @@
+package translator
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/mdxabu/bridge/internal/nat"
+)
+
+// icmpMessage returns an ICMP header with the given rest-of-header word
+// followed by body. The checksum is left zero.
+func icmpMessage(icmpType, code uint8, rest uint32, body []byte) []byte {
+	msg := make([]byte, 8+len(body))
+	msg[0] = icmpType
+	msg[1] = code
+	binary.BigEndian.PutUint32(msg[4:8], rest)
+	copy(msg[8:], body)
+	return msg
+}
+
+// embeddedIPv6 returns the UDP packet an ICMPv6 error refers to: one sent
+// from 198.51.100.7 to 192.0.2.1, both in the well-known prefix
+func embeddedIPv6(t *testing.T) []byte {
+	return testIPv6Packet(t, "64:ff9b::198.51.100.7", "64:ff9b::192.0.2.1", 17, udpSegment(53, 5000, []byte("answer"))).RawData
+}
+
+// embeddedIPv4 returns the UDP packet an ICMPv4 error refers to: one sent
+// from 192.0.2.1 to 198.51.100.7
+func embeddedIPv4(t *testing.T) []byte {
+	return testIPv4Packet(t, "192.0.2.1", "198.51.100.7", 17, udpSegment(5000, 53, []byte("query"))).RawData
+}
+
+// icmpResult is the type, code and rest-of-header word of a translated message
+type icmpResult struct {
+	icmpType uint8
+	code     uint8
+	rest     uint32
+}
+
+// translateICMPv6 translates an ICMPv6 message and returns the ICMPv4 message
+func translateICMPv6(t *testing.T, msg []byte, session *nat.SessionState) ([]byte, error) {
+	t.Helper()
+
+	pkt := testIPv6Packet(t, "64:ff9b::192.0.2.5", "64:ff9b::198.51.100.7", 58, msg)
+	packet, err := TranslateIPv6ToIPv4(pkt, testPrefix(t), session)
+	if err != nil {
+		return nil, err
+	}
+	if packet[9] != 1 {
+		t.Fatalf("translated protocol = %d, want 1", packet[9])
+	}
+	if calculateChecksum(packet[20:]) != 0 {
+		t.Fatalf("ICMPv4 checksum %#04x is wrong", binary.BigEndian.Uint16(packet[22:24]))
+	}
+	return packet[20:], nil
+}
+
+// translateICMPv4 translates an ICMPv4 message and returns the ICMPv6 message
+func translateICMPv4(t *testing.T, msg []byte, session *nat.SessionState) ([]byte, error) {
+	t.Helper()
+
+	pkt := testIPv4Packet(t, "198.51.100.1", "192.0.2.1", 1, msg)
+	packet, err := TranslateIPv4ToIPv6(pkt, testPrefix(t), session)
+	if err != nil {
+		return nil, err
+	}
+	if packet[6] != 58 {
+		t.Fatalf("translated next header = %d, want 58", packet[6])
+	}
+	if transportChecksum(packet[8:40], 58, packet[40:]) != 0 {
+		t.Fatalf("ICMPv6 checksum %#04x is wrong", binary.BigEndian.Uint16(packet[42:44]))
+	}
+	return packet[40:], nil
+}
+
+func TestTranslateICMPv6ToICMPv4(t *testing.T) {
+	tests := []struct {
+		name     string
+		icmpType uint8
+		code     uint8
+		rest     uint32
+		want     *icmpResult // nil if dropped
+	}{
+		{"echo request", 128, 0, 0x12340001, &icmpResult{8, 0, 0x12340001}},
+		{"echo reply", 129, 0, 0x12340001, &icmpResult{0, 0, 0x12340001}},
+
+		{"no route", 1, 0, 0, &icmpResult{3, 1, 0}},
+		{"administratively prohibited", 1, 1, 0, &icmpResult{3, 10, 0}},
+		{"beyond scope", 1, 2, 0, &icmpResult{3, 1, 0}},
+		{"address unreachable", 1, 3, 0, &icmpResult{3, 1, 0}},
+		{"port unreachable", 1, 4, 0, &icmpResult{3, 3, 0}},
+		{"source address failed policy", 1, 5, 0, nil},
+
+		{"packet too big", 2, 0, 1400, &icmpResult{3, 4, 1380}},
+		{"packet too big minimum MTU", 2, 0, 1280, &icmpResult{3, 4, 1260}},
+		{"packet too big below IPv4 minimum", 2, 0, 60, &icmpResult{3, 4, 68}},
+		{"packet too big jumbo", 2, 0, 100000, &icmpResult{3, 4, 0xffff}},
+
+		{"hop limit exceeded", 3, 0, 0, &icmpResult{11, 0, 0}},
+		{"reassembly time exceeded", 3, 1, 0, &icmpResult{11, 1, 0}},
+
+		{"pointer version", 4, 0, 0, &icmpResult{12, 0, 0 << 24}},
+		{"pointer flow label", 4, 0, 1, &icmpResult{12, 0, 1 << 24}},
+		{"pointer payload length", 4, 0, 4, &icmpResult{12, 0, 2 << 24}},
+		{"pointer next header", 4, 0, 6, &icmpResult{12, 0, 9 << 24}},
+		{"pointer hop limit", 4, 0, 7, &icmpResult{12, 0, 8 << 24}},
+		{"pointer source address", 4, 0, 8, &icmpResult{12, 0, 12 << 24}},
+		{"pointer end of source address", 4, 0, 23, &icmpResult{12, 0, 12 << 24}},
+		{"pointer destination address", 4, 0, 24, &icmpResult{12, 0, 16 << 24}},
+		{"pointer end of destination address", 4, 0, 39, &icmpResult{12, 0, 16 << 24}},
+		{"pointer flow label low bits", 4, 0, 2, nil},
+		{"pointer beyond header", 4, 0, 40, nil},
+		{"unrecognized next header", 4, 1, 6, &icmpResult{3, 2, 0}},
+		{"unrecognized option", 4, 2, 42, nil},
+
+		{"multicast listener query", 130, 0, 0, nil},
+		{"router solicitation", 133, 0, 0, nil},
+		{"neighbor solicitation", 135, 0, 0, nil},
+		{"redirect", 137, 0, 0, nil},
+		{"unknown informational", 200, 0, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := []byte("ping")
+			if isICMPError(true, tt.icmpType) {
+				body = embeddedIPv6(t)
+			}
+
+			msg, err := translateICMPv6(t, icmpMessage(tt.icmpType, tt.code, tt.rest, body), nil)
+			if tt.want == nil {
+				if !errors.Is(err, ErrUntranslatable) {
+					t.Fatalf("error = %v, want ErrUntranslatable", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TranslateIPv6ToIPv4: %v", err)
+			}
+
+			got := icmpResult{msg[0], msg[1], binary.BigEndian.Uint32(msg[4:8])}
+			if got != *tt.want {
+				t.Fatalf("translated to %+v, want %+v", got, *tt.want)
+			}
+		})
+	}
+}
+
+func TestTranslateICMPv4ToICMPv6(t *testing.T) {
+	tests := []struct {
+		name     string
+		icmpType uint8
+		code     uint8
+		rest     uint32
+		want     *icmpResult // nil if dropped
+	}{
+		{"echo request", 8, 0, 0x12340001, &icmpResult{128, 0, 0x12340001}},
+		{"echo reply", 0, 0, 0x12340001, &icmpResult{129, 0, 0x12340001}},
+
+		{"net unreachable", 3, 0, 0, &icmpResult{1, 0, 0}},
+		{"host unreachable", 3, 1, 0, &icmpResult{1, 0, 0}},
+		{"protocol unreachable", 3, 2, 0, &icmpResult{4, 1, 6}},
+		{"port unreachable", 3, 3, 0, &icmpResult{1, 4, 0}},
+		{"source route failed", 3, 5, 0, &icmpResult{1, 0, 0}},
+		{"network prohibited", 3, 9, 0, &icmpResult{1, 1, 0}},
+		{"host prohibited", 3, 10, 0, &icmpResult{1, 1, 0}},
+		{"communication prohibited", 3, 13, 0, &icmpResult{1, 1, 0}},
+		{"host precedence violation", 3, 14, 0, nil},
+		{"precedence cutoff", 3, 15, 0, &icmpResult{1, 1, 0}},
+
+		{"fragmentation needed", 3, 4, 1400, &icmpResult{2, 0, 1420}},
+		{"fragmentation needed below IPv6 minimum", 3, 4, 576, &icmpResult{2, 0, 1280}},
+		{"fragmentation needed without MTU", 3, 4, 0, &icmpResult{2, 0, 1280}},
+
+		{"TTL exceeded", 11, 0, 0, &icmpResult{3, 0, 0}},
+		{"reassembly time exceeded", 11, 1, 0, &icmpResult{3, 1, 0}},
+
+		{"pointer version", 12, 0, 0 << 24, &icmpResult{4, 0, 0}},
+		{"pointer type of service", 12, 0, 1 << 24, &icmpResult{4, 0, 1}},
+		{"pointer total length", 12, 0, 2 << 24, &icmpResult{4, 0, 4}},
+		{"pointer TTL", 12, 0, 8 << 24, &icmpResult{4, 0, 7}},
+		{"pointer protocol", 12, 0, 9 << 24, &icmpResult{4, 0, 6}},
+		{"pointer source address", 12, 0, 12 << 24, &icmpResult{4, 0, 8}},
+		{"pointer end of source address", 12, 0, 15 << 24, &icmpResult{4, 0, 8}},
+		{"pointer destination address", 12, 0, 16 << 24, &icmpResult{4, 0, 24}},
+		{"pointer bad length", 12, 2, 3 << 24, &icmpResult{4, 0, 4}},
+		{"pointer identification", 12, 0, 4 << 24, nil},
+		{"pointer header checksum", 12, 0, 10 << 24, nil},
+		{"missing required option", 12, 1, 0, nil},
+
+		{"source quench", 4, 0, 0, nil},
+		{"redirect", 5, 0, 0, nil},
+		{"timestamp", 13, 0, 0, nil},
+		{"information request", 15, 0, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := []byte("ping")
+			if tt.icmpType != 0 && tt.icmpType != 8 {
+				body = embeddedIPv4(t)
+			}
+
+			msg, err := translateICMPv4(t, icmpMessage(tt.icmpType, tt.code, tt.rest, body), nil)
+			if tt.want == nil {
+				if !errors.Is(err, ErrUntranslatable) {
+					t.Fatalf("error = %v, want ErrUntranslatable", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TranslateIPv4ToIPv6: %v", err)
+			}
+
+			got := icmpResult{msg[0], msg[1], binary.BigEndian.Uint32(msg[4:8])}
+			if got != *tt.want {
+				t.Fatalf("translated to %+v, want %+v", got, *tt.want)
+			}
+		})
+	}
+}
+
+func TestICMPEchoIdentifierFollowsSession(t *testing.T) {
+	session := &nat.SessionState{
+		ID:          "test",
+		IPv6SrcIP:   net.ParseIP("2001:db8::1"),
+		IPv6SrcPort: 0x1234,
+		IPv4SrcIP:   net.ParseIP("192.0.2.1"),
+		IPv4SrcPort: 0x4321,
+	}
+
+	msg, err := translateICMPv6(t, icmpMessage(128, 0, 0x12340001, []byte("ping")), session)
+	if err != nil {
+		t.Fatalf("TranslateIPv6ToIPv4: %v", err)
+	}
+	if id, seq := binary.BigEndian.Uint16(msg[4:6]), binary.BigEndian.Uint16(msg[6:8]); id != 0x4321 || seq != 1 {
+		t.Fatalf("echo request identifier %#04x sequence %d, want 0x4321 and 1", id, seq)
+	}
+	if string(msg[8:]) != "ping" {
+		t.Fatalf("echo request data = %q, want %q", msg[8:], "ping")
+	}
+
+	msg, err = translateICMPv4(t, icmpMessage(0, 0, 0x43210001, []byte("ping")), session)
+	if err != nil {
+		t.Fatalf("TranslateIPv4ToIPv6: %v", err)
+	}
+	if id, seq := binary.BigEndian.Uint16(msg[4:6]), binary.BigEndian.Uint16(msg[6:8]); id != 0x1234 || seq != 1 {
+		t.Fatalf("echo reply identifier %#04x sequence %d, want 0x1234 and 1", id, seq)
+	}
+}
+
+func TestICMPErrorEmbeddedPacket(t *testing.T) {
+	msg, err := translateICMPv6(t, icmpMessage(1, 4, 0, embeddedIPv6(t)), nil)
+	if err != nil {
+		t.Fatalf("TranslateIPv6ToIPv4: %v", err)
+	}
+	inner, err := parseEmbeddedPacket(msg[8:], false)
+	if err != nil {
+		t.Fatalf("embedded packet: %v", err)
+	}
+	if !inner.SrcIP.Equal(net.ParseIP("198.51.100.7")) || !inner.DstIP.Equal(net.ParseIP("192.0.2.1")) || inner.Protocol != 17 {
+		t.Fatalf("embedded packet %s -> %s protocol %d, want 198.51.100.7 -> 192.0.2.1 protocol 17", inner.SrcIP, inner.DstIP, inner.Protocol)
+	}
+	if calculateChecksum(msg[8:28]) != 0 {
+		t.Fatal("embedded IPv4 header checksum is wrong")
+	}
+
+	msg, err = translateICMPv4(t, icmpMessage(3, 3, 0, embeddedIPv4(t)), nil)
+	if err != nil {
+		t.Fatalf("TranslateIPv4ToIPv6: %v", err)
+	}
+	inner, err = parseEmbeddedPacket(msg[8:], true)
+	if err != nil {
+		t.Fatalf("embedded packet: %v", err)
+	}
+	if !inner.SrcIP.Equal(net.ParseIP("64:ff9b::192.0.2.1")) || !inner.DstIP.Equal(net.ParseIP("64:ff9b::198.51.100.7")) || inner.Protocol != 17 {
+		t.Fatalf("embedded packet %s -> %s protocol %d, want 64:ff9b::192.0.2.1 -> 64:ff9b::198.51.100.7 protocol 17", inner.SrcIP, inner.DstIP, inner.Protocol)
+	}
+	if inner.SrcPort != 5000 || inner.DstPort != 53 {
+		t.Fatalf("embedded ports %d -> %d, want 5000 -> 53", inner.SrcPort, inner.DstPort)
+	}
+}
@@ -3,46 +3,169 @@ package translator
 import (
 	"fmt"
 	"net"
-	"strings"
 )
 
-// IsNAT64Address checks if an IP address is in the NAT64 prefix range
-func IsNAT64Address(ip string) bool {
-	parsedIP := net.ParseIP(ip)
-	if parsedIP == nil {
+// WellKnownPrefix is the NAT64 well-known prefix defined in RFC 6052
+const WellKnownPrefix = "64:ff9b::/96"
+
+// NAT64Prefix is a parsed RFC 6052 IPv4-embedded IPv6 prefix
+type NAT64Prefix struct {
+	IP     net.IP
+	Length int
+}
+
+// ipv4Offsets lists the byte positions of the embedded IPv4 address for each
+// prefix length allowed by RFC 6052 section 2.2. Byte 8 (bits 64-71) is the
+// reserved u-octet and is always skipped.
+var ipv4Offsets = map[int][4]int{
+	32: {4, 5, 6, 7},
+	40: {5, 6, 7, 9},
+	48: {6, 7, 9, 10},
+	56: {7, 9, 10, 11},
+	64: {9, 10, 11, 12},
+	96: {12, 13, 14, 15},
+}
+
+// ParseNAT64Prefix parses a NAT64 prefix in CIDR notation
+func ParseNAT64Prefix(prefix string) (*NAT64Prefix, error) {
+	ip, ipNet, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NAT64 prefix %q: %w", prefix, err)
+	}
+
+	if ip.To4() != nil {
+		return nil, fmt.Errorf("NAT64 prefix %q is not an IPv6 prefix", prefix)
+	}
+
+	length, _ := ipNet.Mask.Size()
+	if _, ok := ipv4Offsets[length]; !ok {
+		return nil, fmt.Errorf("NAT64 prefix length /%d is not allowed (must be 32, 40, 48, 56, 64 or 96)", length)
+	}
+
+	if !ip.Equal(ipNet.IP) {
+		return nil, fmt.Errorf("NAT64 prefix %q has host bits set", prefix)
+	}
+
+	if length == 96 && ipNet.IP[8] != 0 {
+		return nil, fmt.Errorf("NAT64 prefix %q has non-zero bits 64-71", prefix)
+	}
+
+	return &NAT64Prefix{
+		IP:     ipNet.IP.To16(),
+		Length: length,
+	}, nil
+}
+
+// String returns the prefix in CIDR notation
+func (p *NAT64Prefix) String() string {
+	return fmt.Sprintf("%s/%d", p.IP, p.Length)
+}
+
+// Contains checks if an IPv6 address falls within the prefix
+func (p *NAT64Prefix) Contains(ip net.IP) bool {
+	if ip.To4() != nil {
 		return false
 	}
 
-	ipv6 := parsedIP.To16()
+	ipv6 := ip.To16()
 	if ipv6 == nil {
 		return false
 	}
 
-	// Check for well-known NAT64 prefix 64:ff9b::/96
-	return ipv6[0] == 0x00 && ipv6[1] == 0x64 &&
-		ipv6[2] == 0xff && ipv6[3] == 0x9b &&
-		ipv6[4] == 0x00 && ipv6[5] == 0x00 &&
-		ipv6[6] == 0x00 && ipv6[7] == 0x00 &&
-		ipv6[8] == 0x00 && ipv6[9] == 0x00 &&
-		ipv6[10] == 0x00 && ipv6[11] == 0x00
+	mask := net.CIDRMask(p.Length, 128)
+	for i := 0; i < 16; i++ {
+		if ipv6[i]&mask[i] != p.IP[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Extract returns the IPv4 address embedded in an IPv6 address
+func (p *NAT64Prefix) Extract(ip net.IP) (net.IP, error) {
+	if !p.Contains(ip) {
+		return nil, fmt.Errorf("address %s is not in NAT64 prefix %s", ip, p)
+	}
+
+	ipv6 := ip.To16()
+	if ipv6[8] != 0 {
+		return nil, fmt.Errorf("address %s has non-zero bits 64-71", ip)
+	}
+	offsets := ipv4Offsets[p.Length]
+
+	return net.IPv4(ipv6[offsets[0]], ipv6[offsets[1]], ipv6[offsets[2]], ipv6[offsets[3]]).To4(), nil
+}
+
+// Embed synthesizes an IPv6 address by embedding an IPv4 address in the prefix
+func (p *NAT64Prefix) Embed(ip net.IP) (net.IP, error) {
+	ipv4 := ip.To4()
+	if ipv4 == nil {
+		return nil, fmt.Errorf("invalid IPv4 address: %s", ip)
+	}
+
+	nat64IP := make(net.IP, 16)
+	copy(nat64IP, p.IP)
+
+	// The u-octet and suffix must be zero
+	for i := p.Length / 8; i < 16; i++ {
+		nat64IP[i] = 0
+	}
+
+	offsets := ipv4Offsets[p.Length]
+	for i, offset := range offsets {
+		nat64IP[offset] = ipv4[i]
+	}
+
+	return nat64IP, nil
+}
+
+// IsNAT64Address checks if an IP address is in the NAT64 prefix range
+func IsNAT64Address(ip string, nat64Prefix string) bool {
+	prefix, err := ParseNAT64Prefix(nat64Prefix)
+	if err != nil {
+		return false
+	}
+
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return false
+	}
+
+	return prefix.Contains(parsedIP)
 }
 
 // GetIPV4fromNAT64 extracts the embedded IPv4 address from a NAT64 IPv6 address
-func GetIPV4fromNAT64(nat64 string) (string, error) {
+func GetIPV4fromNAT64(nat64 string, nat64Prefix string) (string, error) {
+	prefix, err := ParseNAT64Prefix(nat64Prefix)
+	if err != nil {
+		return "", err
+	}
+
 	ip := net.ParseIP(nat64)
 	if ip == nil {
 		return "", fmt.Errorf("invalid IPv6 address: %s", nat64)
 	}
 
-	ip = ip.To16()
-	if ip == nil || len(ip) != 16 {
-		return "", fmt.Errorf("invalid IPv6 address format")
+	ipv4, err := prefix.Extract(ip)
+	if err != nil {
+		return "", err
 	}
 
-	if !strings.HasPrefix(nat64, "64:ff9b::") && !(ip[0] == 0x00 && ip[1] == 0x64 && ip[2] == 0xff && ip[3] == 0x9b) {
-		return "", fmt.Errorf("address is not in NAT64 prefix")
+	return ipv4.String(), nil
+}
+
+// IPv4ToNAT64 converts an IPv4 address to NAT64 format
+func IPv4ToNAT64(ipv4Addr, nat64Prefix string) (net.IP, error) {
+	prefix, err := ParseNAT64Prefix(nat64Prefix)
+	if err != nil {
+		return nil, err
 	}
 
-	ipv4 := net.IPv4(ip[12], ip[13], ip[14], ip[15])
-	return ipv4.String(), nil
+	ip := net.ParseIP(ipv4Addr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IPv4 address: %s", ipv4Addr)
+	}
+
+	return prefix.Embed(ip)
 }
@@ -0,0 +1,105 @@
+package translator
+
+import (
+	"net"
+	"testing"
+)
+
+// rfc6052Vectors are the examples of RFC 6052 section 2.4 for 192.0.2.33
+var rfc6052Vectors = []struct {
+	prefix string
+	ipv6   string
+}{
+	{"2001:db8::/32", "2001:db8:c000:221::"},
+	{"2001:db8:100::/40", "2001:db8:1c0:2:21::"},
+	{"2001:db8:122::/48", "2001:db8:122:c000:2:2100::"},
+	{"2001:db8:122:300::/56", "2001:db8:122:3c0:0:221::"},
+	{"2001:db8:122:344::/64", "2001:db8:122:344:c0:2:2100:0"},
+	{"2001:db8:122:344::/96", "2001:db8:122:344::192.0.2.33"},
+	{"64:ff9b::/96", "64:ff9b::192.0.2.33"},
+}
+
+func TestNAT64PrefixEmbed(t *testing.T) {
+	ipv4 := net.ParseIP("192.0.2.33")
+	for _, tt := range rfc6052Vectors {
+		prefix, err := ParseNAT64Prefix(tt.prefix)
+		if err != nil {
+			t.Fatalf("ParseNAT64Prefix(%q): %v", tt.prefix, err)
+		}
+
+		got, err := prefix.Embed(ipv4)
+		if err != nil {
+			t.Fatalf("%s: Embed: %v", tt.prefix, err)
+		}
+		if !got.Equal(net.ParseIP(tt.ipv6)) {
+			t.Errorf("%s: Embed(%s) = %s, want %s", tt.prefix, ipv4, got, tt.ipv6)
+		}
+		if got[8] != 0 {
+			t.Errorf("%s: Embed(%s) set u-octet to %#x", tt.prefix, ipv4, got[8])
+		}
+	}
+}
+
+func TestNAT64PrefixExtract(t *testing.T) {
+	for _, tt := range rfc6052Vectors {
+		prefix, err := ParseNAT64Prefix(tt.prefix)
+		if err != nil {
+			t.Fatalf("ParseNAT64Prefix(%q): %v", tt.prefix, err)
+		}
+
+		got, err := prefix.Extract(net.ParseIP(tt.ipv6))
+		if err != nil {
+			t.Fatalf("%s: Extract(%s): %v", tt.prefix, tt.ipv6, err)
+		}
+		if !got.Equal(net.ParseIP("192.0.2.33")) {
+			t.Errorf("%s: Extract(%s) = %s, want 192.0.2.33", tt.prefix, tt.ipv6, got)
+		}
+	}
+}
+
+func TestNAT64PrefixExtractRejectsUOctet(t *testing.T) {
+	for _, tt := range rfc6052Vectors {
+		prefix, err := ParseNAT64Prefix(tt.prefix)
+		if err != nil {
+			t.Fatalf("ParseNAT64Prefix(%q): %v", tt.prefix, err)
+		}
+
+		ip := net.ParseIP(tt.ipv6)
+		ip[8] = 0x01
+		if got, err := prefix.Extract(ip); err == nil {
+			t.Errorf("%s: Extract(%s) = %s, want error", tt.prefix, ip, got)
+		}
+	}
+}
+
+func TestNAT64PrefixExtractOutsidePrefix(t *testing.T) {
+	prefix, err := ParseNAT64Prefix("2001:db8:122::/48")
+	if err != nil {
+		t.Fatalf("ParseNAT64Prefix: %v", err)
+	}
+	if got, err := prefix.Extract(net.ParseIP("2001:db8:123:c000:2:2100::")); err == nil {
+		t.Errorf("Extract outside prefix = %s, want error", got)
+	}
+}
+
+func TestParseNAT64Prefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		valid  bool
+	}{
+		{"64:ff9b::/96", true},
+		{"2001:db8::/32", true},
+		{"2001:db8::/33", false},
+		{"2001:db8::1/96", false},
+		{"2001:db8:0:0:100::/96", false},
+		{"192.0.2.0/24", false},
+		{"not-a-prefix", false},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseNAT64Prefix(tt.prefix)
+		if (err == nil) != tt.valid {
+			t.Errorf("ParseNAT64Prefix(%q) error = %v, want valid %v", tt.prefix, err, tt.valid)
+		}
+	}
+}
@@ -32,6 +32,16 @@ type Packet struct {
 	TCPHeader  []byte
 	UDPHeader  []byte
 	ICMPHeader []byte
+	ICMPType   uint8
+	ICMPCode   uint8
+	Inner      *Packet // Embedded packet of an ICMP error message
+
+	// IPv6 extension header information
+	TransportOffset int             // Offset of the upper-layer header in RawData
+	Fragment        *FragmentHeader // Fragment header, if present
+	SegmentsLeft    uint8           // Segments Left of the Routing header, if present
+
+	DontFragment bool // IPv4 DF flag
 }
 
 // ParseIPv6Packet parses an IPv6 packet
@@ -46,12 +56,24 @@ func ParseIPv6Packet(data []byte) (*Packet, error) {
 		IPv6Header: data[:40],
 	}
 
-	// Parse IPv6 header
-	pkt.Protocol = data[6]
-	pkt.SrcIP = net.IP(data[8:24])
-	pkt.DstIP = net.IP(data[24:40])
+	// Parse IPv6 header. Addresses are copied as NAT sessions keep them
+	// after the packet's buffer is reused.
+	pkt.SrcIP = append(net.IP(nil), data[8:24]...)
+	pkt.DstIP = append(net.IP(nil), data[24:40]...)
+
+	// Walk the extension headers to find the upper-layer protocol
+	if err := walkExtensionHeaders(pkt, data); err != nil {
+		return nil, err
+	}
+
+	payload := data[pkt.TransportOffset:]
 
-	payload := data[40:]
+	// Non-first fragments carry no upper-layer header
+	if !pkt.IsFirstFragment() {
+		pkt.Type = packetTypeForProtocol(pkt.Protocol)
+		pkt.Payload = payload
+		return pkt, nil
+	}
 
 	// Parse transport layer based on protocol
 	switch pkt.Protocol {
@@ -82,6 +104,9 @@ func ParseIPv6Packet(data []byte) (*Packet, error) {
 		}
 		pkt.ICMPHeader = payload
 		pkt.Payload = payload
+		if err := parseICMP(pkt, payload); err != nil {
+			return nil, err
+		}
 
 	default:
 		pkt.Type = PacketTypeUnknown
@@ -91,6 +116,19 @@ func ParseIPv6Packet(data []byte) (*Packet, error) {
 	return pkt, nil
 }
 
+// packetTypeForProtocol returns the packet type for an IP protocol number
+func packetTypeForProtocol(protocol uint8) PacketType {
+	switch protocol {
+	case 6:
+		return PacketTypeTCP
+	case 17:
+		return PacketTypeUDP
+	case 1, 58:
+		return PacketTypeICMP
+	}
+	return PacketTypeUnknown
+}
+
 // ParseIPv4Packet parses an IPv4 packet
 func ParseIPv4Packet(data []byte) (*Packet, error) {
 	if len(data) < 20 {
@@ -108,13 +146,41 @@ func ParseIPv4Packet(data []byte) (*Packet, error) {
 		return nil, fmt.Errorf("invalid IPv4 header length")
 	}
 
+	// Ignore any trailing bytes beyond the total length
+	totalLen := int(binary.BigEndian.Uint16(data[2:4]))
+	if totalLen >= headerLen && totalLen < len(data) {
+		data = data[:totalLen]
+		pkt.RawData = data
+	}
+
 	pkt.IPv4Header = data[:headerLen]
 	pkt.Protocol = data[9]
 	pkt.SrcIP = net.IPv4(data[12], data[13], data[14], data[15])
 	pkt.DstIP = net.IPv4(data[16], data[17], data[18], data[19])
 
+	// Parse flags and fragment offset
+	flags := binary.BigEndian.Uint16(data[6:8])
+	pkt.DontFragment = flags&0x4000 != 0
+	if flags&0x2000 != 0 || flags&0x1fff != 0 {
+		pkt.Fragment = &FragmentHeader{
+			Identification: uint32(binary.BigEndian.Uint16(data[4:6])),
+			Offset:         (flags & 0x1fff) * 8,
+			MoreFragments:  flags&0x2000 != 0,
+			NextHeader:     pkt.Protocol,
+			headerOffset:   headerLen,
+			dataOffset:     headerLen,
+		}
+	}
+
 	payload := data[headerLen:]
 
+	// Non-first fragments carry no upper-layer header
+	if !pkt.IsFirstFragment() {
+		pkt.Type = packetTypeForProtocol(pkt.Protocol)
+		pkt.Payload = payload
+		return pkt, nil
+	}
+
 	// Parse transport layer
 	switch pkt.Protocol {
 	case 6: // TCP
@@ -144,6 +210,9 @@ func ParseIPv4Packet(data []byte) (*Packet, error) {
 		}
 		pkt.ICMPHeader = payload
 		pkt.Payload = payload
+		if err := parseICMP(pkt, payload); err != nil {
+			return nil, err
+		}
 
 	default:
 		pkt.Type = PacketTypeUnknown
@@ -153,6 +222,14 @@ func ParseIPv4Packet(data []byte) (*Packet, error) {
 	return pkt, nil
 }
 
+// TCPFlags returns the flags byte of the TCP header, or 0 for other packets
+func (p *Packet) TCPFlags() uint8 {
+	if len(p.TCPHeader) < 14 {
+		return 0
+	}
+	return p.TCPHeader[13]
+}
+
 // String returns a string representation of the packet
 func (p *Packet) String() string {
 	proto := "Unknown"
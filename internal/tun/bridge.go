@@ -1,66 +1,298 @@
 package tun
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/mdxabu/bridge/internal/capture"
+	"github.com/mdxabu/bridge/internal/config"
 	"github.com/mdxabu/bridge/internal/logger"
 	"github.com/mdxabu/bridge/internal/nat"
 	"github.com/mdxabu/bridge/internal/translator"
-	"github.com/songgao/water"
+)
+
+// Loggers of the bridge. Messages about single packets are sampled so that
+// a flood of packets cannot flood the log.
+var (
+	tunLog     = logger.For(logger.TUN)
+	queueLog   = logger.For(logger.TUN).Sampled()
+	packetLog  = logger.For(logger.Translator).Sampled()
+	sessionLog = logger.For(logger.NAT).Sampled()
 )
 
 // Bridge represents the NAT64 bridge
 type Bridge struct {
-	tunIPv6     *water.Interface
-	tunIPv4     *water.Interface
-	natTable    *nat.NATTable
-	nat64Prefix string
-	running     bool
-	packetChan  chan []byte
+	tunIPv6      PacketDevice // First queue of the IPv6 side, used for writing
+	tunIPv4      PacketDevice
+	natTable     *nat.NATTable
+	nat64Prefix  *translator.NAT64Prefix
+	mode         string                   // config.ModeNAT64, config.ModeSIIT or config.ModeCLAT
+	mapper       translator.AddressMapper // Address mapping used by the translator
+	ipv4MTU      int
+	ipv6MTU      int
+	reassembler4 *translator.Reassembler // nil when reassembly is disabled
+	reassembler6 *translator.Reassembler
+	state        config.StateConfig
+	running      atomic.Bool
+	startedAt    time.Time
+	traffic      trafficCounters
+	metrics      *bridgeMetrics
+	queuesIPv6   []PacketDevice // All queues of each side, each with its own reader
+	queuesIPv4   []PacketDevice
+	tunQueues    int
+	workers      *workerPool
+	cfg          *config.BridgeConfig // Running configuration, updated by Reload
+	reloadMu     sync.Mutex
+	capturing    atomic.Pointer[capture.Capture] // Current or last packet capture
+	captureMu    sync.Mutex                      // Serializes starting and stopping captures
+}
+
+// trafficCounters counts translated packets and bytes in each direction
+type trafficCounters struct {
+	ipv6ToIPv4Packets atomic.Uint64
+	ipv6ToIPv4Bytes   atomic.Uint64
+	ipv4ToIPv6Packets atomic.Uint64
+	ipv4ToIPv6Bytes   atomic.Uint64
 }
 
 // NewBridge creates a new NAT64 bridge
-func NewBridge(nat64Prefix string) (*Bridge, error) {
-	return &Bridge{
-		natTable:    nat.NewNATTable(),
-		nat64Prefix: nat64Prefix,
-		packetChan:  make(chan []byte, 1000),
-	}, nil
+func NewBridge(cfg *config.BridgeConfig) (*Bridge, error) {
+	prefix, err := translator.ParseNAT64Prefix(cfg.GetNAT64Prefix())
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := newPool4(cfg.GetPool4())
+	if err != nil {
+		return nil, err
+	}
+
+	filtering, err := nat.ParseFilteringMode(cfg.GetFiltering())
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bridge{
+		natTable:    nat.NewNATTable(pool),
+		nat64Prefix: prefix,
+		mode:        cfg.GetMode(),
+		mapper:      prefix,
+		ipv4MTU:     cfg.GetIPv4MTU(),
+		ipv6MTU:     cfg.GetIPv6MTU(),
+		state:       cfg.GetState(),
+		tunQueues:   cfg.GetPipeline().TUNQueues,
+		cfg:         cfg,
+	}
+	b.natTable.SetFiltering(filtering)
+	b.natTable.SetTimeouts(natTimeouts(cfg.GetTimeouts()))
+	b.metrics = newBridgeMetrics(b.natTable)
+
+	pipeline := cfg.GetPipeline()
+	if b.tunQueues < 1 {
+		b.tunQueues = 1
+	}
+	b.workers = newWorkerPool(pipeline.Workers, pipeline.QueueSize, max(b.ipv4MTU, b.ipv6MTU), b.translate, b.translatePanicked)
+	b.metrics.observeWorkers(b.workers)
+
+	if b.state.Interval <= 0 {
+		b.state.Interval = config.DefaultStateInterval
+	}
+
+	switch b.mode {
+	case config.ModeSIIT:
+		// Stateless modes keep no sessions, so bindings and snapshots do not apply
+		mapper, err := newSIITMapper(cfg)
+		if err != nil {
+			return nil, err
+		}
+		b.mapper = mapper
+		b.state.File = ""
+	case config.ModeCLAT:
+		mapper, err := newCLATMapper(cfg)
+		if err != nil {
+			return nil, err
+		}
+		b.mapper = mapper
+		b.state.File = ""
+	default:
+		if err := b.loadNATState(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Fragments.Reassemble {
+		b.reassembler4 = translator.NewReassembler(cfg.Fragments.Timeout, cfg.Fragments.MaxBuffers)
+		b.reassembler6 = translator.NewReassembler(cfg.Fragments.Timeout, cfg.Fragments.MaxBuffers)
+	}
+
+	return b, nil
 }
 
-// CreateTUNInterface creates a TUN interface
-func (b *Bridge) CreateTUNInterface(name string, isIPv6 bool) error {
-	config := water.Config{
-		DeviceType: water.TUN,
+// newPool4 builds the pool4 of the NAT from its configured ranges
+func newPool4(entries []config.Pool4Entry) (*nat.Pool4, error) {
+	ranges := make([]nat.Pool4Range, 0, len(entries))
+	for _, entry := range entries {
+		r, err := nat.ParsePool4Range(entry.Prefix, entry.Ports)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
 	}
+	return nat.NewPool4(ranges)
+}
 
-	// On macOS, TUN interface names must be utun[0-9]+
-	// Let the system auto-assign the name by not setting it
-	// The water library will handle platform-specific naming
+// natTimeouts converts the configured session timeouts
+func natTimeouts(t config.TimeoutConfig) nat.Timeouts {
+	return nat.Timeouts{
+		UDP:            t.UDP,
+		ICMP:           t.ICMP,
+		TCPEstablished: t.TCPEstablished,
+		TCPTransitory:  t.TCPTransitory,
+	}
+}
 
-	iface, err := water.New(config)
+// newSIITMapper builds the EAMT and pool6 mapping for SIIT mode
+func newSIITMapper(cfg *config.BridgeConfig) (*translator.SIITMapper, error) {
+	mapper := &translator.SIITMapper{}
+
+	if cfg.GetPool6() != "" {
+		pool6, err := translator.ParseNAT64Prefix(cfg.GetPool6())
+		if err != nil {
+			return nil, fmt.Errorf("invalid pool6: %w", err)
+		}
+		mapper.Pool6 = pool6
+	}
+
+	entries := make([]translator.EAMEntry, 0, len(cfg.GetEAMT()))
+	for _, e := range cfg.GetEAMT() {
+		entry, err := translator.ParseEAMEntry(e.IPv4, e.IPv6)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	eamt, err := translator.NewEAMT(entries)
+	if err != nil {
+		return nil, err
+	}
+	mapper.EAMT = eamt
+
+	return mapper, nil
+}
+
+// newCLATMapper builds the address mapping of the 464XLAT customer side
+func newCLATMapper(cfg *config.BridgeConfig) (*translator.CLATMapper, error) {
+	clat := cfg.GetCLAT()
+	if clat.IPv6 == "" {
+		return nil, fmt.Errorf("clat.ipv6 must be set in %s mode", config.ModeCLAT)
+	}
+
+	plat, err := translator.ParseNAT64Prefix(clat.PLATPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PLAT prefix: %w", err)
+	}
+
+	return translator.NewCLATMapper(clat.IPv4, clat.IPv6, plat)
+}
+
+// loadNATState installs the static bindings and restores saved sessions
+func (b *Bridge) loadNATState(cfg *config.BridgeConfig) error {
+	// Static bindings go first so restored sessions can attach to them
+	for _, entry := range cfg.GetStaticBindings() {
+		if err := b.addConfiguredBinding(entry); err != nil {
+			return err
+		}
+	}
+
+	// Pick up the sessions of the previous run
+	if b.state.File != "" {
+		restored, err := b.natTable.LoadState(b.state.File)
+		if err != nil {
+			return err
+		}
+		if restored > 0 {
+			tunLog.Info("Restored %d NAT sessions from %s", restored, b.state.File)
+		}
+	}
+
+	return nil
+}
+
+// stateless reports whether the bridge translates without NAT sessions
+func (b *Bridge) stateless() bool {
+	return b.mode == config.ModeSIIT || b.mode == config.ModeCLAT
+}
+
+// CreateTUNInterface creates a TUN interface with the configured number of
+// queues
+func (b *Bridge) CreateTUNInterface(name string, isIPv6 bool) error {
+	if b.tunQueues > 1 && !multiQueueSupported {
+		return fmt.Errorf("multi-queue TUN interfaces are not supported on this platform")
+	}
+
+	// Let the system assign the name of the first queue, later queues
+	// attach to the same interface
+	multiQueue := b.tunQueues > 1
+	first, err := newTUNDevice("", multiQueue)
 	if err != nil {
 		return fmt.Errorf("failed to create TUN interface: %w", err)
 	}
 
+	queues := []PacketDevice{first}
+	for len(queues) < b.tunQueues {
+		queue, err := newTUNDevice(first.Name(), multiQueue)
+		if err != nil {
+			for _, q := range queues {
+				q.Close()
+			}
+			return fmt.Errorf("failed to open queue %d of TUN interface %s: %w", len(queues), first.Name(), err)
+		}
+		queues = append(queues, queue)
+	}
+
+	for _, queue := range queues {
+		b.AttachDevice(queue, isIPv6)
+	}
 	if isIPv6 {
-		b.tunIPv6 = iface
-		logger.Success("Created IPv6 TUN interface: %s", iface.Name())
+		tunLog.Success("Created IPv6 TUN interface: %s", first.Name())
 	} else {
-		b.tunIPv4 = iface
-		logger.Success("Created IPv4 TUN interface: %s", iface.Name())
+		tunLog.Success("Created IPv4 TUN interface: %s", first.Name())
+	}
+	if multiQueue {
+		tunLog.Info("TUN interface %s has %d queues", first.Name(), len(queues))
 	}
 
 	return nil
 }
 
+// AttachDevice adds a device to the IPv6 or IPv4 side of the bridge. The first
+// device of each side is also the one translated packets are written to.
+// Devices must be attached before Start.
+func (b *Bridge) AttachDevice(dev PacketDevice, isIPv6 bool) {
+	if isIPv6 {
+		if b.tunIPv6 == nil {
+			b.tunIPv6 = dev
+		}
+		b.queuesIPv6 = append(b.queuesIPv6, dev)
+	} else {
+		if b.tunIPv4 == nil {
+			b.tunIPv4 = dev
+		}
+		b.queuesIPv4 = append(b.queuesIPv4, dev)
+	}
+}
+
 // ConfigureInterface configures the TUN interface with IP address
 func ConfigureInterface(ifaceName string, ipAddr string, isIPv6 bool) error {
 	// Note: This requires system commands and privileges
 	// In production, this would use syscalls or exec commands
-	logger.Info("Interface %s should be configured with %s", ifaceName, ipAddr)
+	tunLog.Info("Interface %s should be configured with %s", ifaceName, ipAddr)
 	return nil
 }
 
@@ -70,117 +302,332 @@ func (b *Bridge) Start() error {
 		return fmt.Errorf("TUN interfaces not created")
 	}
 
-	b.running = true
+	b.startedAt = time.Now()
+	b.running.Store(true)
 	b.natTable.StartCleanupRoutine()
+	b.startReassemblyCleanup()
+	b.startStateSnapshots()
 
-	// Start packet processing goroutines
-	go b.readIPv6Packets()
-	go b.readIPv4Packets()
-	go b.processPackets()
+	// Start the translation workers and one reader per TUN queue
+	b.workers.start()
+	for _, queue := range b.queuesIPv6 {
+		go b.readPackets(queue, directionIPv6ToIPv4)
+	}
+	for _, queue := range b.queuesIPv4 {
+		go b.readPackets(queue, directionIPv4ToIPv6)
+	}
 
-	logger.Success("NAT64 Bridge started successfully")
+	tunLog.Success("NAT64 Bridge started successfully")
 	return nil
 }
 
 // Stop stops the NAT64 bridge
 func (b *Bridge) Stop() error {
-	b.running = false
+	b.running.Store(false)
 
-	if b.tunIPv6 != nil {
-		b.tunIPv6.Close()
+	for _, queue := range b.queuesIPv6 {
+		queue.Close()
 	}
-
-	if b.tunIPv4 != nil {
-		b.tunIPv4.Close()
+	for _, queue := range b.queuesIPv4 {
+		queue.Close()
 	}
 
-	close(b.packetChan)
-	logger.Info("NAT64 Bridge stopped")
+	b.workers.stop()
+	if c := b.capturing.Load(); c != nil {
+		c.Stop()
+	}
+	b.saveState()
+	tunLog.Info("NAT64 Bridge stopped")
 	return nil
 }
 
-// readIPv6Packets reads packets from the IPv6 TUN interface
-func (b *Bridge) readIPv6Packets() {
-	buffer := make([]byte, 2000)
-
-	for b.running {
-		n, err := b.tunIPv6.Read(buffer)
+// readPackets reads packets from one device queue and hands them to the workers
+func (b *Bridge) readPackets(queue PacketDevice, direction string) {
+	for b.running.Load() {
+		buf := b.workers.getBuffer()
+		n, err := queue.Read(*buf)
 		if err != nil {
-			if err != io.EOF && b.running {
-				logger.Error("Error reading from IPv6 TUN: %v", err)
+			b.workers.putBuffer(buf)
+			if err == io.EOF {
+				// The device is gone, nothing more will arrive
+				return
+			}
+			if b.running.Load() {
+				queueLog.Error("Error reading from %s: %v", queue.Name(), err)
 			}
 			continue
 		}
 
-		// Create a copy of the packet
-		packet := make([]byte, n)
-		copy(packet, buffer[:n])
+		if direction == directionIPv6ToIPv4 {
+			b.capturePacket(capture.StageIPv6In, (*buf)[:n])
+		} else {
+			b.capturePacket(capture.StageIPv4In, (*buf)[:n])
+		}
 
-		// Send to processing channel
-		select {
-		case b.packetChan <- packet:
-		default:
-			logger.Warn("Packet channel full, dropping IPv6 packet")
+		if !b.workers.dispatch(buf, n, direction) {
+			b.workers.putBuffer(buf)
+			queueLog.Warn("Worker queue full, dropping packet from %s", queue.Name())
+			b.metrics.dropped(direction, dropQueueFull)
 		}
 	}
 }
 
-// readIPv4Packets reads packets from the IPv4 TUN interface
-func (b *Bridge) readIPv4Packets() {
-	buffer := make([]byte, 2000)
-
-	for b.running {
-		n, err := b.tunIPv4.Read(buffer)
-		if err != nil {
-			if err != io.EOF && b.running {
-				logger.Error("Error reading from IPv4 TUN: %v", err)
-			}
-			continue
-		}
-
-		// Create a copy of the packet
-		packet := make([]byte, n)
-		copy(packet, buffer[:n])
-
-		// Process IPv4 to IPv6 translation
-		go b.translateIPv4ToIPv6(packet)
+// translate translates a packet read in the given direction
+func (b *Bridge) translate(direction string, data []byte) {
+	if direction == directionIPv6ToIPv4 {
+		b.translateIPv6ToIPv4(data)
+	} else {
+		b.translateIPv4ToIPv6(data)
 	}
 }
 
-// processPackets processes packets from the channel
-func (b *Bridge) processPackets() {
-	for packet := range b.packetChan {
-		go b.translateIPv6ToIPv4(packet)
-	}
+// translatePanicked records a packet whose translation panicked
+func (b *Bridge) translatePanicked(direction string, v interface{}, stack []byte) {
+	tunLog.Error("Recovered from panic translating %s packet: %v\n%s", direction, v, stack)
+	b.metrics.dropped(direction, dropPanic)
 }
 
 // translateIPv6ToIPv4 translates and forwards IPv6 packets to IPv4
 func (b *Bridge) translateIPv6ToIPv4(data []byte) {
+	start := time.Now()
+
 	// Parse IPv6 packet
 	pkt, err := translator.ParseIPv6Packet(data)
+	if errors.Is(err, translator.ErrUntranslatable) {
+		packetLog.Debug("Dropping untranslatable IPv6 packet: %v", err)
+		b.metrics.dropped(directionIPv6ToIPv4, dropUntranslatable)
+		return
+	}
 	if err != nil {
-		logger.Error("Failed to parse IPv6 packet: %v", err)
+		packetLog.Error("Failed to parse IPv6 packet: %v", err)
+		b.metrics.dropped(directionIPv6ToIPv4, dropParseError)
 		return
 	}
 
-	// Check if destination is NAT64 address
-	if !translator.IsNAT64Address(pkt.DstIP.String()) {
-		logger.Debug("Packet destination is not NAT64 address: %s", pkt.DstIP)
+	// Check that the destination maps to IPv4
+	if !b.translatesIPv6(pkt.DstIP) {
+		packetLog.Debug("Packet destination is not NAT64 address: %s", pkt.DstIP)
+		b.metrics.dropped(directionIPv6ToIPv4, dropNotNAT64)
 		return
 	}
 
-	// Extract IPv4 destination
-	ipv4Dst, err := translator.GetIPV4fromNAT64(pkt.DstIP.String())
+	// Fragmented packets cannot be matched to a session without reassembly.
+	// SIIT translates fragments one by one, except ICMP whose checksum
+	// covers the whole message.
+	if pkt.IsFragment() && (!b.stateless() || pkt.Type == translator.PacketTypeICMP) {
+		pkt = b.reassemble(b.reassembler6, pkt, directionIPv6ToIPv4)
+		if pkt == nil {
+			return
+		}
+	}
+
+	// Only ICMP echo and error messages have an IPv4 equivalent
+	if pkt.Type == translator.PacketTypeICMP && !pkt.IsICMPEcho() && !pkt.IsICMPError() {
+		packetLog.Debug("Dropping untranslatable ICMPv6 message: type %d code %d", pkt.ICMPType, pkt.ICMPCode)
+		b.metrics.dropped(directionIPv6ToIPv4, dropUntranslatable)
+		return
+	}
+
+	// SIIT translates without a session
+	var session *nat.SessionState
+	if !b.stateless() {
+		var ok bool
+		if session, ok = b.outboundSession(pkt); !ok {
+			return
+		}
+	}
+
+	// Translate packet
+	ipv4Packet, err := translator.TranslateIPv6ToIPv4(pkt, b.mapper, session)
+	if errors.Is(err, translator.ErrUntranslatable) {
+		packetLog.Debug("Dropping untranslatable IPv6 packet: %s", pkt)
+		b.metrics.dropped(directionIPv6ToIPv4, dropUntranslatable)
+		return
+	}
+	if err != nil {
+		packetLog.Error("Failed to translate packet: %v", err)
+		b.metrics.dropped(directionIPv6ToIPv4, dropTranslation)
+		return
+	}
+
+	// Fragment to the IPv4 MTU, or tell the IPv6 host to send smaller packets
+	fragments, err := translator.FragmentIPv4(ipv4Packet, b.ipv4MTU)
+	if errors.Is(err, translator.ErrFragmentationNeeded) {
+		b.sendPacketTooBig(pkt, b.ipv4MTU+20)
+		b.metrics.dropped(directionIPv6ToIPv4, dropTooBig)
+		return
+	}
+	if err != nil {
+		packetLog.Error("Failed to fragment IPv4 packet: %v", err)
+		b.metrics.dropped(directionIPv6ToIPv4, dropTranslation)
+		return
+	}
+
+	// Update session statistics. ICMP errors do not keep a session alive.
+	if session != nil && !pkt.IsICMPError() {
+		b.natTable.UpdateSession(session.ID, uint64(len(ipv4Packet)), "outbound", pkt.TCPFlags())
+	}
+
+	// Write to IPv4 TUN interface
+	for _, fragment := range fragments {
+		b.capturePacket(capture.StageIPv4Out, fragment)
+		_, err = b.tunIPv4.Write(fragment)
+		if err != nil {
+			packetLog.Error("Failed to write to IPv4 TUN: %v", err)
+			b.metrics.dropped(directionIPv6ToIPv4, dropWriteError)
+			return
+		}
+	}
+
+	b.traffic.ipv6ToIPv4Packets.Add(1)
+	b.traffic.ipv6ToIPv4Bytes.Add(uint64(len(ipv4Packet)))
+	b.metrics.translated(directionIPv6ToIPv4, pkt.Protocol, len(ipv4Packet), start)
+	packetLog.Debug("Translated IPv6->IPv4: %s", pkt)
+}
+
+// translateIPv4ToIPv6 translates and forwards IPv4 packets to IPv6
+func (b *Bridge) translateIPv4ToIPv6(data []byte) {
+	start := time.Now()
+
+	// Parse IPv4 packet
+	pkt, err := translator.ParseIPv4Packet(data)
+	if errors.Is(err, translator.ErrUntranslatable) {
+		packetLog.Debug("Dropping untranslatable IPv4 packet: %v", err)
+		b.metrics.dropped(directionIPv4ToIPv6, dropUntranslatable)
+		return
+	}
 	if err != nil {
-		logger.Error("Failed to extract IPv4 from NAT64: %v", err)
+		packetLog.Error("Failed to parse IPv4 packet: %v", err)
+		b.metrics.dropped(directionIPv4ToIPv6, dropParseError)
 		return
 	}
 
-	ipv4DstIP := net.ParseIP(ipv4Dst).To4()
-	if ipv4DstIP == nil {
-		logger.Error("Invalid IPv4 destination: %s", ipv4Dst)
+	// In SIIT mode only destinations with an IPv6 mapping are translated
+	if b.stateless() {
+		if _, err := b.mapper.Embed(pkt.DstIP); err != nil {
+			packetLog.Debug("Packet destination has no IPv6 mapping: %s", pkt.DstIP)
+			b.metrics.dropped(directionIPv4ToIPv6, dropNotNAT64)
+			return
+		}
+	}
+
+	// Fragmented packets cannot be matched to a session without reassembly.
+	// SIIT translates fragments one by one, except ICMP.
+	if pkt.IsFragment() && (!b.stateless() || pkt.Type == translator.PacketTypeICMP) {
+		pkt = b.reassemble(b.reassembler4, pkt, directionIPv4ToIPv6)
+		if pkt == nil {
+			return
+		}
+	}
+
+	// SIIT translates without a session
+	var session *nat.SessionState
+	if !b.stateless() {
+		var ok bool
+		if session, ok = b.inboundSession(pkt); !ok {
+			return
+		}
+	}
+
+	// Translate packet
+	ipv6Packet, err := translator.TranslateIPv4ToIPv6(pkt, b.mapper, session)
+	if errors.Is(err, translator.ErrUntranslatable) {
+		packetLog.Debug("Dropping untranslatable IPv4 packet: %s", pkt)
+		b.metrics.dropped(directionIPv4ToIPv6, dropUntranslatable)
 		return
 	}
+	if err != nil {
+		packetLog.Error("Failed to translate packet: %v", err)
+		b.metrics.dropped(directionIPv4ToIPv6, dropTranslation)
+		return
+	}
+
+	// Fragment to the IPv6 MTU unless the IPv4 sender forbade it
+	if len(ipv6Packet) > b.ipv6MTU && pkt.DontFragment {
+		b.sendFragmentationNeeded(pkt, b.ipv6MTU-20)
+		b.metrics.dropped(directionIPv4ToIPv6, dropTooBig)
+		return
+	}
+	fragments, err := translator.FragmentIPv6(ipv6Packet, b.ipv6MTU)
+	if err != nil {
+		packetLog.Error("Failed to fragment IPv6 packet: %v", err)
+		b.metrics.dropped(directionIPv4ToIPv6, dropTranslation)
+		return
+	}
+
+	// Update session statistics. ICMP errors do not keep a session alive.
+	if session != nil && !pkt.IsICMPError() {
+		b.natTable.UpdateSession(session.ID, uint64(len(ipv6Packet)), "inbound", pkt.TCPFlags())
+	}
+
+	// Write to IPv6 TUN interface
+	for _, fragment := range fragments {
+		b.capturePacket(capture.StageIPv6Out, fragment)
+		_, err = b.tunIPv6.Write(fragment)
+		if err != nil {
+			packetLog.Error("Failed to write to IPv6 TUN: %v", err)
+			b.metrics.dropped(directionIPv4ToIPv6, dropWriteError)
+			return
+		}
+	}
+
+	b.traffic.ipv4ToIPv6Packets.Add(1)
+	b.traffic.ipv4ToIPv6Bytes.Add(uint64(len(ipv6Packet)))
+	b.metrics.translated(directionIPv4ToIPv6, pkt.Protocol, len(ipv6Packet), start)
+	packetLog.Debug("Translated IPv4->IPv6: %s", pkt)
+}
+
+// translatesIPv6 reports whether an IPv6 destination has an IPv4 mapping
+func (b *Bridge) translatesIPv6(ip net.IP) bool {
+	if b.stateless() {
+		_, err := b.mapper.Extract(ip)
+		return err == nil
+	}
+	return b.nat64Prefix.Contains(ip)
+}
+
+// outboundSession returns the NAT session of an IPv6 packet, creating it
+// when needed. It records the drop and returns false when there is none.
+func (b *Bridge) outboundSession(pkt *translator.Packet) (*nat.SessionState, bool) {
+	if pkt.IsICMPError() {
+		// ICMP errors belong to the session of the packet they embed,
+		// which travelled in the opposite direction
+		inner := pkt.Inner
+		session, found := b.natTable.LookupSessionIPv6toIPv4(inner.Protocol, inner.DstIP, inner.DstPort, inner.SrcIP, inner.SrcPort)
+		if !found {
+			packetLog.Debug("No NAT session found for ICMPv6 error: %s", pkt)
+			b.metrics.dropped(directionIPv6ToIPv4, dropNoSession)
+			return nil, false
+		}
+		return session, true
+	}
+
+	// Only TCP, UDP and ICMP can be mapped to an IPv4 transport address
+	if pkt.Type == translator.PacketTypeUnknown {
+		packetLog.Debug("Dropping IPv6 packet of untranslatable protocol %d: %s", pkt.Protocol, pkt)
+		b.metrics.dropped(directionIPv6ToIPv4, dropUntranslatable)
+		return nil, false
+	}
+
+	// Only a SYN creates TCP state (RFC 6146 section 3.5.2), other segments
+	// need an existing session
+	if pkt.Type == translator.PacketTypeTCP && pkt.TCPFlags()&nat.TCPFlagSYN == 0 {
+		session, found := b.natTable.LookupSessionIPv6toIPv4(pkt.Protocol, pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort)
+		if !found {
+			packetLog.Debug("No NAT session found for TCP segment without SYN: %s", pkt)
+			b.metrics.dropped(directionIPv6ToIPv4, dropNoSession)
+			return nil, false
+		}
+		return session, true
+	}
+
+	// Extract IPv4 destination
+	ipv4DstIP, err := b.nat64Prefix.Extract(pkt.DstIP)
+	if err != nil {
+		packetLog.Error("Failed to extract IPv4 from NAT64: %v", err)
+		b.metrics.dropped(directionIPv6ToIPv4, dropTranslation)
+		return nil, false
+	}
 
 	// Create or lookup NAT session
 	session, err := b.natTable.CreateSession(
@@ -192,72 +639,278 @@ func (b *Bridge) translateIPv6ToIPv4(data []byte) {
 		ipv4DstIP,
 	)
 	if err != nil {
-		logger.Error("Failed to create NAT session: %v", err)
-		return
+		sessionLog.Error("Failed to create NAT session: %v", err)
+		b.metrics.dropped(directionIPv6ToIPv4, dropSessionError)
+		return nil, false
 	}
+	return session, true
+}
 
-	// Translate packet
-	ipv4Packet, err := translator.TranslateIPv6ToIPv4(pkt, b.nat64Prefix)
+// inboundSession returns the NAT session of an IPv4 packet (reverse
+// direction). It records the drop and returns false when there is none.
+func (b *Bridge) inboundSession(pkt *translator.Packet) (*nat.SessionState, bool) {
+	// ICMP errors belong to the session of the packet they embed, which
+	// was sent from the NAT
+	if pkt.IsICMPError() {
+		inner := pkt.Inner
+		session, found := b.natTable.LookupSessionIPv4toIPv6(inner.Protocol, inner.DstIP, inner.DstPort, inner.SrcIP, inner.SrcPort)
+		if !found {
+			packetLog.Debug("No NAT session found for ICMPv4 error: %s", pkt)
+			b.metrics.dropped(directionIPv4ToIPv6, dropNoSession)
+			return nil, false
+		}
+		return session, true
+	}
+
+	if session, found := b.natTable.LookupSessionIPv4toIPv6(pkt.Protocol, pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort); found {
+		return session, true
+	}
+
+	// A new IPv4 peer may reach an existing binding if filtering allows it
+	ipv6SrcIP, err := b.nat64Prefix.Embed(pkt.SrcIP)
 	if err != nil {
-		logger.Error("Failed to translate packet: %v", err)
-		return
+		packetLog.Error("Failed to embed IPv4 in NAT64: %v", err)
+		b.metrics.dropped(directionIPv4ToIPv6, dropTranslation)
+		return nil, false
 	}
 
-	// Update session statistics
-	b.natTable.UpdateSession(session.ID, uint64(len(ipv4Packet)), "outbound")
+	session, err := b.natTable.CreateInboundSession(pkt.Protocol, pkt.SrcIP, pkt.SrcPort, pkt.DstIP, pkt.DstPort, ipv6SrcIP)
+	if errors.Is(err, nat.ErrNoBinding) || errors.Is(err, nat.ErrFiltered) {
+		packetLog.Debug("Dropping IPv4 packet %s: %v", pkt, err)
+		if errors.Is(err, nat.ErrFiltered) {
+			b.metrics.dropped(directionIPv4ToIPv6, dropFiltered)
+		} else {
+			b.metrics.dropped(directionIPv4ToIPv6, dropNoSession)
+		}
+		return nil, false
+	}
+	if err != nil {
+		sessionLog.Error("Failed to create NAT session: %v", err)
+		b.metrics.dropped(directionIPv4ToIPv6, dropSessionError)
+		return nil, false
+	}
+	return session, true
+}
 
-	// Write to IPv4 TUN interface
-	_, err = b.tunIPv4.Write(ipv4Packet)
+// reassemble queues a fragment and returns the complete packet once all of
+// its fragments have arrived. It returns nil while fragments are missing or
+// when reassembly is disabled.
+func (b *Bridge) reassemble(r *translator.Reassembler, pkt *translator.Packet, direction string) *translator.Packet {
+	if r == nil {
+		packetLog.Debug("Dropping fragment, reassembly is disabled: %s", pkt)
+		b.metrics.dropped(direction, dropReassembly)
+		return nil
+	}
+
+	complete, err := r.Add(pkt)
 	if err != nil {
-		logger.Error("Failed to write to IPv4 TUN: %v", err)
+		packetLog.Warn("Dropping fragment: %v", err)
+		b.metrics.dropped(direction, dropReassembly)
+		return nil
+	}
+
+	return complete
+}
+
+// startReassemblyCleanup periodically drops incomplete datagrams
+func (b *Bridge) startReassemblyCleanup() {
+	if b.reassembler4 == nil {
 		return
 	}
 
-	logger.Debug("Translated IPv6->IPv4: %s", pkt.String())
+	ticker := time.NewTicker(time.Second)
+	go func() {
+		defer ticker.Stop()
+		for range ticker.C {
+			if !b.running.Load() {
+				return
+			}
+			b.reassembler4.Expire()
+			b.reassembler6.Expire()
+		}
+	}()
 }
 
-// translateIPv4ToIPv6 translates and forwards IPv4 packets to IPv6
-func (b *Bridge) translateIPv4ToIPv6(data []byte) {
-	// Parse IPv4 packet
-	pkt, err := translator.ParseIPv4Packet(data)
-	if err != nil {
-		logger.Error("Failed to parse IPv4 packet: %v", err)
+// startStateSnapshots periodically saves the NAT table to the state file
+func (b *Bridge) startStateSnapshots() {
+	if b.state.File == "" {
 		return
 	}
 
-	// Lookup NAT session (reverse direction)
-	session, found := b.natTable.LookupSessionIPv4toIPv6(pkt.Protocol, pkt.DstPort)
-	if !found {
-		logger.Debug("No NAT session found for IPv4 packet: %s", pkt.String())
+	ticker := time.NewTicker(b.state.Interval)
+	go func() {
+		defer ticker.Stop()
+		for range ticker.C {
+			if !b.running.Load() {
+				return
+			}
+			b.saveState()
+		}
+	}()
+}
+
+// saveState writes the NAT table to the state file, if one is configured
+func (b *Bridge) saveState() {
+	if b.state.File == "" {
 		return
 	}
 
-	// Translate packet
-	ipv6Packet, err := translator.TranslateIPv4ToIPv6(pkt, b.nat64Prefix)
+	if err := b.natTable.SaveState(b.state.File); err != nil {
+		tunLog.Error("Failed to save NAT state: %v", err)
+		return
+	}
+	tunLog.Debug("Saved NAT state to %s", b.state.File)
+}
+
+// sendPacketTooBig tells an IPv6 host that its packet does not fit the IPv4 MTU
+func (b *Bridge) sendPacketTooBig(pkt *translator.Packet, mtu int) {
+	reply, err := translator.BuildPacketTooBig(pkt, mtu)
 	if err != nil {
-		logger.Error("Failed to translate packet: %v", err)
+		packetLog.Error("Failed to build ICMPv6 Packet Too Big: %v", err)
 		return
 	}
 
-	// Update session statistics
-	b.natTable.UpdateSession(session.ID, uint64(len(ipv6Packet)), "inbound")
+	b.capturePacket(capture.StageIPv6Out, reply)
+	if _, err := b.tunIPv6.Write(reply); err != nil {
+		packetLog.Error("Failed to write to IPv6 TUN: %v", err)
+	}
+}
 
-	// Write to IPv6 TUN interface
-	_, err = b.tunIPv6.Write(ipv6Packet)
+// sendFragmentationNeeded tells an IPv4 host that its packet does not fit the IPv6 MTU
+func (b *Bridge) sendFragmentationNeeded(pkt *translator.Packet, mtu int) {
+	reply, err := translator.BuildFragmentationNeeded(pkt, mtu)
 	if err != nil {
-		logger.Error("Failed to write to IPv6 TUN: %v", err)
+		packetLog.Error("Failed to build ICMPv4 Fragmentation Needed: %v", err)
 		return
 	}
 
-	logger.Debug("Translated IPv4->IPv6: %s", pkt.String())
+	b.capturePacket(capture.StageIPv4Out, reply)
+	if _, err := b.tunIPv4.Write(reply); err != nil {
+		packetLog.Error("Failed to write to IPv4 TUN: %v", err)
+	}
+}
+
+// IsRunning reports whether the bridge is forwarding packets
+func (b *Bridge) IsRunning() bool {
+	return b.running.Load()
 }
 
 // GetStats returns bridge statistics
 func (b *Bridge) GetStats() map[string]interface{} {
-	return b.natTable.GetStats()
+	stats := b.natTable.GetStats()
+	stats["ipv6_to_ipv4_packets"] = b.traffic.ipv6ToIPv4Packets.Load()
+	stats["ipv6_to_ipv4_bytes"] = b.traffic.ipv6ToIPv4Bytes.Load()
+	stats["ipv4_to_ipv6_packets"] = b.traffic.ipv4ToIPv6Packets.Load()
+	stats["ipv4_to_ipv6_bytes"] = b.traffic.ipv4ToIPv6Bytes.Load()
+	return stats
+}
+
+// GetStatus returns the identity and configuration of the running bridge
+func (b *Bridge) GetStatus() map[string]interface{} {
+	status := map[string]interface{}{
+		"running":      b.running.Load(),
+		"mode":         b.mode,
+		"nat64_prefix": b.nat64Prefix.String(),
+	}
+
+	switch m := b.mapper.(type) {
+	case *translator.SIITMapper:
+		if m.Pool6 != nil {
+			status["pool6"] = m.Pool6.String()
+		}
+		status["eamt_entries"] = m.EAMT.Len()
+	case *translator.CLATMapper:
+		status["plat_prefix"] = m.PLAT.String()
+		status["clat_ipv4"] = m.IPv4.String()
+		status["clat_ipv6"] = m.IPv6.String()
+	}
+
+	if b.running.Load() {
+		status["started_at"] = b.startedAt.Format(time.RFC3339)
+		status["uptime"] = time.Since(b.startedAt).Seconds()
+	}
+	if b.tunIPv6 != nil {
+		status["ipv6_interface"] = b.tunIPv6.Name()
+		status["ipv6_queues"] = len(b.queuesIPv6)
+	}
+	if b.tunIPv4 != nil {
+		status["ipv4_interface"] = b.tunIPv4.Name()
+		status["ipv4_queues"] = len(b.queuesIPv4)
+	}
+
+	return status
 }
 
 // GetActiveSessions returns all active NAT sessions
 func (b *Bridge) GetActiveSessions() []*nat.SessionState {
 	return b.natTable.GetAllSessions()
 }
+
+// addConfiguredBinding adds a static binding from the configuration
+func (b *Bridge) addConfiguredBinding(entry config.StaticBinding) error {
+	binding, err := parseStaticBinding(entry)
+	if err != nil {
+		return err
+	}
+
+	added, err := b.natTable.AddStaticBinding(binding.Protocol, binding.IPv6IP, binding.IPv6Port, binding.IPv4IP, binding.IPv4Port)
+	if err != nil {
+		return err
+	}
+
+	tunLog.Info("Static binding %s %s:%d -> [%s]:%d", entry.Protocol, added.IPv4IP, added.IPv4Port, added.IPv6IP, added.IPv6Port)
+	return nil
+}
+
+// parseStaticBinding parses a static binding from the configuration
+func parseStaticBinding(entry config.StaticBinding) (nat.BindingEntry, error) {
+	protocol, err := nat.ParseProtocol(entry.Protocol)
+	if err != nil {
+		return nat.BindingEntry{}, fmt.Errorf("static binding: %w", err)
+	}
+
+	ipv6IP := net.ParseIP(entry.IPv6)
+	if ipv6IP == nil {
+		return nat.BindingEntry{}, fmt.Errorf("static binding: invalid IPv6 address %q", entry.IPv6)
+	}
+	ipv4IP := net.ParseIP(entry.IPv4)
+	if ipv4IP == nil {
+		return nat.BindingEntry{}, fmt.Errorf("static binding: invalid IPv4 address %q", entry.IPv4)
+	}
+
+	return nat.BindingEntry{
+		Protocol: protocol,
+		IPv6IP:   ipv6IP,
+		IPv6Port: entry.IPv6Port,
+		IPv4IP:   ipv4IP,
+		IPv4Port: entry.IPv4Port,
+	}, nil
+}
+
+// GetStaticBindings returns the static bindings
+func (b *Bridge) GetStaticBindings() []*nat.BindingEntry {
+	return b.natTable.GetStaticBindings()
+}
+
+// AddStaticBinding adds a static binding at runtime
+func (b *Bridge) AddStaticBinding(protocol uint8, ipv6IP net.IP, ipv6Port uint16, ipv4IP net.IP, ipv4Port uint16) (*nat.BindingEntry, error) {
+	if b.stateless() {
+		return nil, fmt.Errorf("static bindings are not supported in %s mode", b.mode)
+	}
+	return b.natTable.AddStaticBinding(protocol, ipv6IP, ipv6Port, ipv4IP, ipv4Port)
+}
+
+// RemoveStaticBinding removes a static binding and its sessions
+func (b *Bridge) RemoveStaticBinding(protocol uint8, ipv4IP net.IP, ipv4Port uint16) bool {
+	return b.natTable.RemoveStaticBinding(protocol, ipv4IP, ipv4Port)
+}
+
+// GetSession returns the NAT session with the given ID
+func (b *Bridge) GetSession(id string) (*nat.SessionState, bool) {
+	return b.natTable.GetSession(id)
+}
+
+// RemoveSession terminates a NAT session and reports whether it existed
+func (b *Bridge) RemoveSession(id string) bool {
+	return b.natTable.RemoveSession(id)
+}
@@ -0,0 +1,422 @@
+package tun
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mdxabu/bridge/internal/config"
+	"github.com/mdxabu/bridge/internal/translator"
+)
+
+// newTestBridge starts a bridge over pipes and returns the host ends of its
+// IPv6 and IPv4 sides
+func newTestBridge(tb testing.TB, cfg *config.BridgeConfig) (*Bridge, PacketDevice, PacketDevice) {
+	tb.Helper()
+
+	b, err := NewBridge(cfg)
+	if err != nil {
+		tb.Fatalf("NewBridge: %v", err)
+	}
+	dev6, host6 := NewPipe("ipv6", cfg.GetIPv6MTU())
+	dev4, host4 := NewPipe("ipv4", cfg.GetIPv4MTU())
+	b.AttachDevice(dev6, true)
+	b.AttachDevice(dev4, false)
+	if err := b.Start(); err != nil {
+		tb.Fatalf("Start: %v", err)
+	}
+	tb.Cleanup(func() {
+		b.Stop()
+		host6.Close()
+		host4.Close()
+	})
+	return b, host6, host4
+}
+
+// checksum computes the Internet checksum of data
+func checksum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// ipv6Packet builds an IPv6 packet around a transport header and payload
+// and fills in its transport checksum
+func ipv6Packet(tb testing.TB, protocol uint8, src, dst string, transport []byte) []byte {
+	tb.Helper()
+
+	packet := make([]byte, 40+len(transport))
+	packet[0] = 0x60
+	binary.BigEndian.PutUint16(packet[4:6], uint16(len(transport)))
+	packet[6] = protocol
+	packet[7] = 64
+	copy(packet[8:24], net.ParseIP(src).To16())
+	copy(packet[24:40], net.ParseIP(dst).To16())
+	copy(packet[40:], transport)
+	if err := translator.RecalculateTransportChecksum(packet, true); err != nil {
+		tb.Fatalf("RecalculateTransportChecksum: %v", err)
+	}
+	return packet
+}
+
+// ipv4Packet builds an IPv4 packet around a transport header and payload
+// and fills in its header and transport checksums
+func ipv4Packet(tb testing.TB, protocol uint8, src, dst string, transport []byte) []byte {
+	tb.Helper()
+
+	packet := make([]byte, 20+len(transport))
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+	packet[6] = 0x40 // Don't Fragment
+	packet[8] = 64
+	packet[9] = protocol
+	copy(packet[12:16], net.ParseIP(src).To4())
+	copy(packet[16:20], net.ParseIP(dst).To4())
+	binary.BigEndian.PutUint16(packet[10:12], checksum(packet[:20]))
+	copy(packet[20:], transport)
+	if err := translator.RecalculateTransportChecksum(packet, false); err != nil {
+		tb.Fatalf("RecalculateTransportChecksum: %v", err)
+	}
+	return packet
+}
+
+// udpHeader returns a UDP header followed by payload. The checksum is left
+// for the packet builders.
+func udpHeader(srcPort, dstPort uint16, payload []byte) []byte {
+	udp := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint16(udp[0:2], srcPort)
+	binary.BigEndian.PutUint16(udp[2:4], dstPort)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(len(udp)))
+	copy(udp[8:], payload)
+	return udp
+}
+
+// readPacket reads the next packet from a host end of the bridge
+func readPacket(tb testing.TB, dev PacketDevice) []byte {
+	tb.Helper()
+
+	type result struct {
+		packet []byte
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		buf := make([]byte, 65536)
+		n, err := dev.Read(buf)
+		done <- result{buf[:n], err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			tb.Fatalf("reading from %s: %v", dev.Name(), r.err)
+		}
+		return r.packet
+	case <-time.After(2 * time.Second):
+		tb.Fatalf("no packet on %s", dev.Name())
+		return nil
+	}
+}
+
+// checkIPv4 checks the addresses and header checksum of an IPv4 packet and
+// returns its protocol
+func checkIPv4(t *testing.T, packet []byte, src, dst string) uint8 {
+	t.Helper()
+
+	if len(packet) < 20 || packet[0]>>4 != 4 {
+		t.Fatalf("not an IPv4 packet: %x", packet)
+	}
+	if got := net.IP(packet[12:16]); !got.Equal(net.ParseIP(src)) {
+		t.Errorf("IPv4 source = %s, want %s", got, src)
+	}
+	if got := net.IP(packet[16:20]); !got.Equal(net.ParseIP(dst)) {
+		t.Errorf("IPv4 destination = %s, want %s", got, dst)
+	}
+	if int(binary.BigEndian.Uint16(packet[2:4])) != len(packet) {
+		t.Errorf("IPv4 total length = %d, packet has %d bytes", binary.BigEndian.Uint16(packet[2:4]), len(packet))
+	}
+	if checksum(packet[:20]) != 0 {
+		t.Errorf("bad IPv4 header checksum %#04x", binary.BigEndian.Uint16(packet[10:12]))
+	}
+	return packet[9]
+}
+
+// checkIPv6 checks the addresses of an IPv6 packet and returns its next header
+func checkIPv6(t *testing.T, packet []byte, src, dst string) uint8 {
+	t.Helper()
+
+	if len(packet) < 40 || packet[0]>>4 != 6 {
+		t.Fatalf("not an IPv6 packet: %x", packet)
+	}
+	if got := net.IP(packet[8:24]); !got.Equal(net.ParseIP(src)) {
+		t.Errorf("IPv6 source = %s, want %s", got, src)
+	}
+	if got := net.IP(packet[24:40]); !got.Equal(net.ParseIP(dst)) {
+		t.Errorf("IPv6 destination = %s, want %s", got, dst)
+	}
+	if int(binary.BigEndian.Uint16(packet[4:6])) != len(packet)-40 {
+		t.Errorf("IPv6 payload length = %d, packet has %d bytes", binary.BigEndian.Uint16(packet[4:6]), len(packet))
+	}
+	return packet[6]
+}
+
+// checkTransportChecksum verifies the UDP, TCP or ICMP checksum of a packet
+func checkTransportChecksum(t *testing.T, packet []byte) {
+	t.Helper()
+
+	var pseudo, transport []byte
+	var protocol uint8
+	if packet[0]>>4 == 6 {
+		protocol, transport = packet[6], packet[40:]
+		pseudo = make([]byte, 40)
+		copy(pseudo, packet[8:40])
+		binary.BigEndian.PutUint32(pseudo[32:36], uint32(len(transport)))
+		pseudo[39] = protocol
+	} else {
+		protocol, transport = packet[9], packet[int(packet[0]&0x0f)*4:]
+		if protocol != 1 { // ICMPv4 has no pseudo-header
+			pseudo = make([]byte, 12)
+			copy(pseudo, packet[12:20])
+			pseudo[9] = protocol
+			binary.BigEndian.PutUint16(pseudo[10:12], uint16(len(transport)))
+		}
+	}
+
+	if checksum(append(pseudo, transport...)) != 0 {
+		t.Errorf("bad checksum in protocol %d packet %x", protocol, packet)
+	}
+}
+
+// ports returns the source and destination ports of a transport header
+func ports(transport []byte) (uint16, uint16) {
+	return binary.BigEndian.Uint16(transport[0:2]), binary.BigEndian.Uint16(transport[2:4])
+}
+
+func TestBridgeNAT64UDP(t *testing.T) {
+	b, host6, host4 := newTestBridge(t, config.Defaults())
+
+	host6.Write(ipv6Packet(t, 17, "2001:db8::1", "64:ff9b::c000:201", udpHeader(5000, 53, []byte("query"))))
+	out := readPacket(t, host4)
+	if checkIPv4(t, out, "10.64.0.1", "192.0.2.1") != 17 {
+		t.Fatalf("translated protocol = %d, want UDP", out[9])
+	}
+	checkTransportChecksum(t, out)
+	srcPort, dstPort := ports(out[20:])
+	if dstPort != 53 || srcPort < 10000 || srcPort > 65000 {
+		t.Fatalf("translated ports = %d -> %d, want pool4 port -> 53", srcPort, dstPort)
+	}
+	if string(out[28:]) != "query" {
+		t.Fatalf("translated payload = %q", out[28:])
+	}
+
+	sessions := b.GetActiveSessions()
+	if len(sessions) != 1 || sessions[0].IPv4SrcPort != srcPort {
+		t.Fatalf("sessions = %+v, want one with IPv4 port %d", sessions, srcPort)
+	}
+
+	// The reply goes back to the IPv6 host through the session
+	host4.Write(ipv4Packet(t, 17, "192.0.2.1", "10.64.0.1", udpHeader(53, srcPort, []byte("answer"))))
+	in := readPacket(t, host6)
+	if checkIPv6(t, in, "64:ff9b::c000:201", "2001:db8::1") != 17 {
+		t.Fatalf("translated next header = %d, want UDP", in[6])
+	}
+	checkTransportChecksum(t, in)
+	if srcPort, dstPort := ports(in[40:]); srcPort != 53 || dstPort != 5000 {
+		t.Fatalf("translated ports = %d -> %d, want 53 -> 5000", srcPort, dstPort)
+	}
+	if string(in[48:]) != "answer" {
+		t.Fatalf("translated payload = %q", in[48:])
+	}
+}
+
+func TestBridgeNAT64ICMPError(t *testing.T) {
+	_, host6, host4 := newTestBridge(t, config.Defaults())
+
+	host6.Write(ipv6Packet(t, 17, "2001:db8::1", "64:ff9b::c000:201", udpHeader(5000, 53, []byte("query"))))
+	out := readPacket(t, host4)
+	srcPort, _ := ports(out[20:])
+
+	// Port unreachable quoting the translated packet
+	icmp := append([]byte{3, 3, 0, 0, 0, 0, 0, 0}, out...)
+	host4.Write(ipv4Packet(t, 1, "192.0.2.1", "10.64.0.1", icmp))
+	in := readPacket(t, host6)
+	if checkIPv6(t, in, "64:ff9b::c000:201", "2001:db8::1") != 58 {
+		t.Fatalf("translated next header = %d, want ICMPv6", in[6])
+	}
+	checkTransportChecksum(t, in)
+	if in[40] != 1 || in[41] != 4 {
+		t.Fatalf("ICMPv6 type %d code %d, want port unreachable (1/4)", in[40], in[41])
+	}
+
+	// The quoted packet is translated back to the one the IPv6 host sent
+	inner := in[48:]
+	if checkIPv6(t, inner, "2001:db8::1", "64:ff9b::c000:201") != 17 {
+		t.Fatalf("quoted next header = %d, want UDP", inner[6])
+	}
+	if src, dst := ports(inner[40:]); src != 5000 || dst != 53 {
+		t.Fatalf("quoted ports = %d -> %d, want 5000 -> 53 (mapped from %d)", src, dst, srcPort)
+	}
+}
+
+func TestBridgeNAT64PacketTooBig(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.IPv4MTU = 1280
+	_, host6, _ := newTestBridge(t, cfg)
+
+	// Translated packets above 1260 bytes have Don't Fragment set
+	host6.Write(ipv6Packet(t, 17, "2001:db8::1", "64:ff9b::c000:201", udpHeader(5000, 53, make([]byte, 1400))))
+	reply := readPacket(t, host6)
+	if checkIPv6(t, reply, "64:ff9b::c000:201", "2001:db8::1") != 58 {
+		t.Fatalf("reply next header = %d, want ICMPv6", reply[6])
+	}
+	checkTransportChecksum(t, reply)
+	if reply[40] != 2 || reply[41] != 0 {
+		t.Fatalf("ICMPv6 type %d code %d, want Packet Too Big", reply[40], reply[41])
+	}
+	if mtu := binary.BigEndian.Uint32(reply[44:48]); mtu != 1300 {
+		t.Fatalf("Packet Too Big MTU = %d, want 1300", mtu)
+	}
+}
+
+func TestBridgeSIIT(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.Mode = config.ModeSIIT
+	cfg.Pool6 = "64:ff9b::/96"
+	cfg.EAMT = []config.EAMTEntry{{IPv4: "192.0.2.10", IPv6: "2001:db8:1::10"}}
+	b, host6, host4 := newTestBridge(t, cfg)
+
+	host6.Write(ipv6Packet(t, 17, "2001:db8:1::10", "64:ff9b::c633:6401", udpHeader(5000, 53, []byte("query"))))
+	out := readPacket(t, host4)
+	checkIPv4(t, out, "192.0.2.10", "198.51.100.1")
+	checkTransportChecksum(t, out)
+	if src, dst := ports(out[20:]); src != 5000 || dst != 53 {
+		t.Fatalf("translated ports = %d -> %d, want 5000 -> 53", src, dst)
+	}
+
+	host4.Write(ipv4Packet(t, 17, "198.51.100.1", "192.0.2.10", udpHeader(53, 5000, []byte("answer"))))
+	in := readPacket(t, host6)
+	checkIPv6(t, in, "64:ff9b::c633:6401", "2001:db8:1::10")
+	checkTransportChecksum(t, in)
+	if src, dst := ports(in[40:]); src != 53 || dst != 5000 {
+		t.Fatalf("translated ports = %d -> %d, want 53 -> 5000", src, dst)
+	}
+
+	if sessions := b.GetActiveSessions(); len(sessions) != 0 {
+		t.Fatalf("SIIT created %d sessions", len(sessions))
+	}
+}
+
+func TestBridgeCLAT(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.Mode = config.ModeCLAT
+	cfg.CLAT.PLATPrefix = "64:ff9b::/96"
+	cfg.CLAT.IPv6 = "2001:db8:c1a7::1"
+	_, host6, host4 := newTestBridge(t, cfg)
+
+	// Local IPv4 applications reach the PLAT through the CLAT address
+	host4.Write(ipv4Packet(t, 17, "192.0.0.2", "198.51.100.1", udpHeader(40000, 443, []byte("hello"))))
+	out := readPacket(t, host6)
+	checkIPv6(t, out, "2001:db8:c1a7::1", "64:ff9b::c633:6401")
+	checkTransportChecksum(t, out)
+	if src, dst := ports(out[40:]); src != 40000 || dst != 443 {
+		t.Fatalf("translated ports = %d -> %d, want 40000 -> 443", src, dst)
+	}
+
+	host6.Write(ipv6Packet(t, 17, "64:ff9b::c633:6401", "2001:db8:c1a7::1", udpHeader(443, 40000, []byte("world"))))
+	in := readPacket(t, host4)
+	checkIPv4(t, in, "198.51.100.1", "192.0.0.2")
+	checkTransportChecksum(t, in)
+	if src, dst := ports(in[20:]); src != 443 || dst != 40000 {
+		t.Fatalf("translated ports = %d -> %d, want 443 -> 40000", src, dst)
+	}
+}
+
+func BenchmarkBridgeNAT64IPv6ToIPv4(b *testing.B) {
+	_, host6, host4 := newTestBridge(b, config.Defaults())
+	packet := ipv6Packet(b, 17, "2001:db8::1", "64:ff9b::c000:201", udpHeader(5000, 53, make([]byte, 512)))
+	buf := make([]byte, 65536)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(packet)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := host6.Write(packet); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := host4.Read(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBridgeNAT64IPv4ToIPv6(b *testing.B) {
+	_, host6, host4 := newTestBridge(b, config.Defaults())
+
+	// Open the session the replies belong to
+	host6.Write(ipv6Packet(b, 17, "2001:db8::1", "64:ff9b::c000:201", udpHeader(5000, 53, nil)))
+	outbound := readPacket(b, host4)
+	port := binary.BigEndian.Uint16(outbound[20:22])
+
+	packet := ipv4Packet(b, 17, "192.0.2.1", "10.64.0.1", udpHeader(53, port, make([]byte, 512)))
+	buf := make([]byte, 65536)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(packet)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := host4.Write(packet); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := host6.Read(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestBridgeNAT64CreatesSessionsOnlyForNewFlows(t *testing.T) {
+	b, host6, host4 := newTestBridge(t, config.Defaults())
+
+	// A TCP ACK without a session and a GRE packet must not take pool4 ports
+	ack := make([]byte, 20)
+	binary.BigEndian.PutUint16(ack[0:2], 40000)
+	binary.BigEndian.PutUint16(ack[2:4], 80)
+	ack[12] = 5 << 4
+	ack[13] = 0x10 // ACK
+	host6.Write(ipv6Packet(t, 6, "2001:db8::1", "64:ff9b::c000:201", ack))
+	host6.Write(ipv6Packet(t, 47, "2001:db8::1", "64:ff9b::c000:201", make([]byte, 8)))
+
+	// Packets between the same hosts are translated in order, so once the
+	// SYN comes out the others have been handled
+	syn := append([]byte(nil), ack...)
+	syn[13] = 0x02 // SYN
+	host6.Write(ipv6Packet(t, 6, "2001:db8::1", "64:ff9b::c000:201", syn))
+	out := readPacket(t, host4)
+	if out[9] != 6 || out[20+13] != 0x02 {
+		t.Fatalf("first translated packet is protocol %d flags %#x, want the SYN", out[9], out[33])
+	}
+	checkTransportChecksum(t, out)
+
+	sessions := b.GetActiveSessions()
+	if len(sessions) != 1 || sessions[0].Protocol != 6 {
+		t.Fatalf("sessions = %+v, want one TCP session", sessions)
+	}
+
+	// Once the SYN opened the session, the ACK is translated
+	host6.Write(ipv6Packet(t, 6, "2001:db8::1", "64:ff9b::c000:201", ack))
+	out = readPacket(t, host4)
+	if out[20+13] != 0x10 {
+		t.Fatalf("translated flags %#x, want the ACK", out[33])
+	}
+	if srcPort, _ := ports(out[20:]); srcPort != sessions[0].IPv4SrcPort {
+		t.Fatalf("ACK translated from port %d, want the session's port %d", srcPort, sessions[0].IPv4SrcPort)
+	}
+}
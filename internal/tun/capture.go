@@ -0,0 +1,93 @@
+package tun
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mdxabu/bridge/internal/capture"
+)
+
+// StartCapture starts writing the packets passing opts.Filter at opts.Stages
+// to a pcapng file. opts.File is a file name within the capture directory.
+// The size limit defaults to, and may not exceed, capture.max_size. Only one
+// capture runs at a time.
+func (b *Bridge) StartCapture(opts capture.Options) (*capture.Status, error) {
+	b.captureMu.Lock()
+	defer b.captureMu.Unlock()
+
+	if current := b.capturing.Load(); current != nil && current.Running() {
+		return nil, capture.ErrRunning
+	}
+
+	b.reloadMu.Lock()
+	cfg := b.cfg.GetCapture()
+	b.reloadMu.Unlock()
+
+	name := opts.File
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("invalid capture file name %q", name)
+	}
+	if !strings.HasSuffix(name, ".pcapng") {
+		name += ".pcapng"
+	}
+	dir, err := filepath.Abs(cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid capture directory: %w", err)
+	}
+	opts.File = filepath.Join(dir, name)
+
+	limit := int64(cfg.MaxSize) << 20
+	if opts.MaxSize == 0 {
+		opts.MaxSize = limit
+	}
+	if opts.MaxSize > limit {
+		return nil, fmt.Errorf("size limit %d exceeds capture.max_size (%d MB)", opts.MaxSize, cfg.MaxSize)
+	}
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create capture directory: %w", err)
+	}
+	c, err := capture.Start(opts)
+	if err != nil {
+		return nil, err
+	}
+	b.capturing.Store(c)
+
+	status := c.Status()
+	tunLog.Info("Capturing %s to %s", opts.Filter, status.File)
+	return &status, nil
+}
+
+// StopCapture stops the running capture and returns its final status
+func (b *Bridge) StopCapture() (*capture.Status, error) {
+	b.captureMu.Lock()
+	defer b.captureMu.Unlock()
+
+	c := b.capturing.Load()
+	if c == nil || !c.Running() {
+		return nil, capture.ErrNotRunning
+	}
+
+	status := c.Stop()
+	tunLog.Info("Capture to %s stopped: %d packets, %d bytes (%s)", status.File, status.Packets, status.Bytes, status.Reason)
+	return &status, nil
+}
+
+// CaptureStatus returns the progress of the current or last capture
+func (b *Bridge) CaptureStatus() (*capture.Status, bool) {
+	c := b.capturing.Load()
+	if c == nil {
+		return nil, false
+	}
+	status := c.Status()
+	return &status, true
+}
+
+// capturePacket hands a packet to the running capture, if any
+func (b *Bridge) capturePacket(stage string, data []byte) {
+	if c := b.capturing.Load(); c != nil {
+		c.Packet(stage, data)
+	}
+}
@@ -0,0 +1,46 @@
+package tun
+
+import (
+	"net"
+
+	"github.com/songgao/water"
+)
+
+// PacketDevice reads and writes whole IP packets. A TUN queue is one; a pipe
+// created by NewPipe is another that needs no privileges.
+type PacketDevice interface {
+	// Read reads one packet into p, truncating it if p is too small
+	Read(p []byte) (int, error)
+	// Write writes one packet
+	Write(p []byte) (int, error)
+	// Name returns the interface name
+	Name() string
+	// MTU returns the largest packet the device carries, 0 if unknown
+	MTU() int
+	// Close closes the device, unblocking pending reads
+	Close() error
+}
+
+// tunDevice is one queue of a TUN interface
+type tunDevice struct {
+	*water.Interface
+}
+
+// newTUNDevice opens a queue of a TUN interface. The system assigns the name
+// when name is empty.
+func newTUNDevice(name string, multiQueue bool) (*tunDevice, error) {
+	iface, err := water.New(tunConfig(name, multiQueue))
+	if err != nil {
+		return nil, err
+	}
+	return &tunDevice{Interface: iface}, nil
+}
+
+// MTU returns the MTU the system reports for the interface
+func (d *tunDevice) MTU() int {
+	iface, err := net.InterfaceByName(d.Name())
+	if err != nil {
+		return 0
+	}
+	return iface.MTU
+}
@@ -0,0 +1,150 @@
+package tun
+
+import (
+	"io"
+	"time"
+
+	"github.com/mdxabu/bridge/internal/metrics"
+	"github.com/mdxabu/bridge/internal/nat"
+)
+
+// Translation directions used as metric labels
+const (
+	directionIPv6ToIPv4 = "ipv6_to_ipv4"
+	directionIPv4ToIPv6 = "ipv4_to_ipv6"
+)
+
+// Reasons for dropping a packet used as metric labels
+const (
+	dropQueueFull      = "queue_full"
+	dropParseError     = "parse_error"
+	dropUntranslatable = "untranslatable"
+	dropNotNAT64       = "not_nat64"
+	dropReassembly     = "reassembly"
+	dropNoSession      = "no_session"
+	dropFiltered       = "filtered"
+	dropSessionError   = "session_error"
+	dropTranslation    = "translation_error"
+	dropTooBig         = "too_big"
+	dropWriteError     = "write_error"
+	dropPanic          = "panic"
+)
+
+// bridgeMetrics holds the Prometheus metrics of a bridge
+type bridgeMetrics struct {
+	registry        *metrics.Registry
+	packets         *metrics.CounterVec
+	bytes           *metrics.CounterVec
+	drops           *metrics.CounterVec
+	sessionsCreated *metrics.CounterVec
+	sessionsExpired *metrics.CounterVec
+	sessionLifetime *metrics.HistogramVec
+	latency         *metrics.HistogramVec
+}
+
+// newBridgeMetrics registers the bridge metrics and observes the NAT table
+func newBridgeMetrics(natTable *nat.NATTable) *bridgeMetrics {
+	r := metrics.NewRegistry()
+
+	m := &bridgeMetrics{
+		registry: r,
+		packets: r.NewCounterVec("bridge_packets_translated_total",
+			"Packets translated, by direction and protocol.", "direction", "protocol"),
+		bytes: r.NewCounterVec("bridge_bytes_translated_total",
+			"Bytes of translated packets, by direction and protocol.", "direction", "protocol"),
+		drops: r.NewCounterVec("bridge_packets_dropped_total",
+			"Packets dropped instead of translated, by direction and reason.", "direction", "reason"),
+		sessionsCreated: r.NewCounterVec("bridge_nat_sessions_created_total",
+			"NAT sessions created, by protocol.", "protocol"),
+		sessionsExpired: r.NewCounterVec("bridge_nat_sessions_expired_total",
+			"NAT sessions removed after their idle timeout, by protocol.", "protocol"),
+		sessionLifetime: r.NewHistogramVec("bridge_nat_session_lifetime_seconds",
+			"Lifetime of removed NAT sessions, by protocol.",
+			[]float64{1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600, 7440, 14400}, "protocol"),
+		latency: r.NewHistogramVec("bridge_translation_latency_seconds",
+			"Time spent translating and writing a packet, by direction.",
+			[]float64{0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1}, "direction"),
+	}
+
+	r.NewGaugeFunc("bridge_nat_sessions_active", "Active NAT sessions, by protocol.",
+		[]string{"protocol"}, func() []metrics.GaugeValue {
+			return natUsage(natTable, func(sessions, _ int) float64 { return float64(sessions) })
+		})
+	r.NewGaugeFunc("bridge_nat_pool_free_ports", "Free pool4 transport addresses, by protocol.",
+		[]string{"protocol"}, func() []metrics.GaugeValue {
+			size := natTable.PoolSize()
+			return natUsage(natTable, func(_, bindings int) float64 {
+				// Bindings outside a reloaded pool may outnumber its addresses
+				if uint64(bindings) > size {
+					return 0
+				}
+				return float64(size - uint64(bindings))
+			})
+		})
+
+	natTable.SetObserver(m)
+	return m
+}
+
+// observeWorkers exports the queue lengths of the translation workers
+func (m *bridgeMetrics) observeWorkers(workers *workerPool) {
+	m.registry.NewGaugeFunc("bridge_worker_queue_length", "Packets waiting for translation, by worker.",
+		[]string{"worker"}, workers.queueLengths)
+}
+
+// natUsage computes a gauge value for each NAT protocol
+func natUsage(natTable *nat.NATTable, value func(sessions, bindings int) float64) []metrics.GaugeValue {
+	var samples []metrics.GaugeValue
+	for _, protocol := range []uint8{6, 17, 58} {
+		sessions, bindings := natTable.ProtocolUsage(protocol)
+		samples = append(samples, metrics.GaugeValue{
+			Labels: []string{protocolLabel(protocol)},
+			Value:  value(sessions, bindings),
+		})
+	}
+	return samples
+}
+
+// SessionCreated implements nat.SessionObserver
+func (m *bridgeMetrics) SessionCreated(session *nat.SessionState) {
+	m.sessionsCreated.WithLabelValues(protocolLabel(session.Protocol)).Inc()
+}
+
+// SessionRemoved implements nat.SessionObserver
+func (m *bridgeMetrics) SessionRemoved(session *nat.SessionState, expired bool) {
+	protocol := protocolLabel(session.Protocol)
+	if expired {
+		m.sessionsExpired.WithLabelValues(protocol).Inc()
+	}
+	m.sessionLifetime.WithLabelValues(protocol).Observe(time.Since(session.CreatedAt).Seconds())
+}
+
+// translated records a translated packet
+func (m *bridgeMetrics) translated(direction string, protocol uint8, size int, start time.Time) {
+	m.packets.WithLabelValues(direction, protocolLabel(protocol)).Inc()
+	m.bytes.WithLabelValues(direction, protocolLabel(protocol)).Add(uint64(size))
+	m.latency.WithLabelValues(direction).Observe(time.Since(start).Seconds())
+}
+
+// dropped records a dropped packet
+func (m *bridgeMetrics) dropped(direction, reason string) {
+	m.drops.WithLabelValues(direction, reason).Inc()
+}
+
+// protocolLabel returns the metric label of an IP protocol number
+func protocolLabel(protocol uint8) string {
+	switch protocol {
+	case 6:
+		return "tcp"
+	case 17:
+		return "udp"
+	case 1, 58:
+		return "icmp"
+	}
+	return "other"
+}
+
+// WriteMetrics writes the bridge metrics in the Prometheus text format
+func (b *Bridge) WriteMetrics(w io.Writer) error {
+	return b.metrics.registry.WriteText(w)
+}
@@ -0,0 +1,95 @@
+package tun
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// pipeQueueSize is the number of packets a pipe buffers in each direction
+const pipeQueueSize = 1024
+
+// pipeEnd is one end of an in-memory packet pipe
+type pipeEnd struct {
+	name       string
+	mtu        int
+	rx         <-chan []byte
+	tx         chan<- []byte
+	closed     chan struct{}
+	peerClosed <-chan struct{}
+	closeOnce  sync.Once
+}
+
+// NewPipe creates a pair of connected in-memory packet devices. A packet
+// written to one end is read from the other, so one end can be attached to a
+// Bridge in place of a TUN interface while the other plays the host.
+func NewPipe(name string, mtu int) (PacketDevice, PacketDevice) {
+	aToB := make(chan []byte, pipeQueueSize)
+	bToA := make(chan []byte, pipeQueueSize)
+	aClosed := make(chan struct{})
+	bClosed := make(chan struct{})
+
+	a := &pipeEnd{name: name, mtu: mtu, rx: bToA, tx: aToB, closed: aClosed, peerClosed: bClosed}
+	b := &pipeEnd{name: name + "-peer", mtu: mtu, rx: aToB, tx: bToA, closed: bClosed, peerClosed: aClosed}
+	return a, b
+}
+
+// Read reads the next packet written to the other end. Packets already
+// written are still returned after the other end is closed.
+func (p *pipeEnd) Read(b []byte) (int, error) {
+	select {
+	case packet := <-p.rx:
+		return copy(b, packet), nil
+	case <-p.closed:
+		return 0, io.EOF
+	case <-p.peerClosed:
+		select {
+		case packet := <-p.rx:
+			return copy(b, packet), nil
+		default:
+			return 0, io.EOF
+		}
+	}
+}
+
+// Write sends a copy of a packet to the other end, blocking while its queue
+// is full
+func (p *pipeEnd) Write(b []byte) (int, error) {
+	if p.mtu > 0 && len(b) > p.mtu {
+		return 0, fmt.Errorf("packet of %d bytes exceeds MTU %d of %s", len(b), p.mtu, p.name)
+	}
+
+	packet := append([]byte(nil), b...)
+	select {
+	case <-p.closed:
+		return 0, io.ErrClosedPipe
+	case <-p.peerClosed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+
+	select {
+	case p.tx <- packet:
+		return len(b), nil
+	case <-p.closed:
+		return 0, io.ErrClosedPipe
+	case <-p.peerClosed:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+// Name returns the name of this end
+func (p *pipeEnd) Name() string {
+	return p.name
+}
+
+// MTU returns the MTU the pipe was created with
+func (p *pipeEnd) MTU() int {
+	return p.mtu
+}
+
+// Close closes this end. Reads on both ends return io.EOF once drained.
+func (p *pipeEnd) Close() error {
+	p.closeOnce.Do(func() { close(p.closed) })
+	return nil
+}
@@ -0,0 +1,163 @@
+package tun
+
+import (
+	"runtime/debug"
+	"strconv"
+	"sync"
+
+	"github.com/mdxabu/bridge/internal/metrics"
+)
+
+// minPacketBufferSize is the smallest buffer a packet is read into
+const minPacketBufferSize = 2000
+
+// packetJob is a packet waiting for a translation worker
+type packetJob struct {
+	buf       *[]byte
+	n         int
+	direction string
+}
+
+// workerPool translates packets on a fixed set of workers. Each packet goes to
+// the worker picked by a hash of its addresses, so the packets of one flow
+// are translated in the order they were read.
+type workerPool struct {
+	queues  []chan packetJob
+	buffers sync.Pool
+	handle  func(direction string, data []byte)
+	failed  func(direction string, v interface{}, stack []byte)
+	wg      sync.WaitGroup
+
+	mu     sync.RWMutex // Guards closing the queues against dispatch
+	closed bool
+}
+
+// newWorkerPool creates a pool of workers, each with its own queue. Packets
+// are read into pooled buffers of bufferSize bytes. failed is called with the
+// recovered value when handling a packet panics.
+func newWorkerPool(workers, queueSize, bufferSize int, handle func(direction string, data []byte),
+	failed func(direction string, v interface{}, stack []byte)) *workerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if bufferSize < minPacketBufferSize {
+		bufferSize = minPacketBufferSize
+	}
+
+	p := &workerPool{
+		queues: make([]chan packetJob, workers),
+		handle: handle,
+		failed: failed,
+	}
+	p.buffers.New = func() interface{} {
+		buf := make([]byte, bufferSize)
+		return &buf
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan packetJob, queueSize)
+	}
+	return p
+}
+
+// start runs the workers
+func (p *workerPool) start() {
+	for _, queue := range p.queues {
+		p.wg.Add(1)
+		go p.run(queue)
+	}
+}
+
+// run translates the packets of one queue until it is closed
+func (p *workerPool) run(queue chan packetJob) {
+	defer p.wg.Done()
+
+	for job := range queue {
+		p.process(job)
+	}
+}
+
+// process handles one packet. A panic only loses that packet, the worker
+// goes on with the rest of its queue.
+func (p *workerPool) process(job packetJob) {
+	defer func() {
+		if v := recover(); v != nil && p.failed != nil {
+			p.failed(job.direction, v, debug.Stack())
+		}
+		p.putBuffer(job.buf)
+	}()
+
+	p.handle(job.direction, (*job.buf)[:job.n])
+}
+
+// stop closes the queues and waits for the workers to finish queued packets
+func (p *workerPool) stop() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, queue := range p.queues {
+			close(queue)
+		}
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
+
+// getBuffer returns a buffer to read a packet into
+func (p *workerPool) getBuffer() *[]byte {
+	return p.buffers.Get().(*[]byte)
+}
+
+// putBuffer returns a buffer to the pool
+func (p *workerPool) putBuffer(buf *[]byte) {
+	p.buffers.Put(buf)
+}
+
+// dispatch queues the first n bytes of buf for translation. The worker
+// returns the buffer to the pool. It returns false, leaving the buffer to the
+// caller, when the worker's queue is full or the pool is stopped.
+func (p *workerPool) dispatch(buf *[]byte, n int, direction string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return false
+	}
+
+	queue := p.queues[flowHash((*buf)[:n])%uint32(len(p.queues))]
+	select {
+	case queue <- packetJob{buf: buf, n: n, direction: direction}:
+		return true
+	default:
+		return false
+	}
+}
+
+// queueLengths returns the number of packets waiting in each queue
+func (p *workerPool) queueLengths() []metrics.GaugeValue {
+	samples := make([]metrics.GaugeValue, len(p.queues))
+	for i, queue := range p.queues {
+		samples[i] = metrics.GaugeValue{Labels: []string{strconv.Itoa(i)}, Value: float64(len(queue))}
+	}
+	return samples
+}
+
+// flowHash hashes the source and destination addresses of an IP packet
+// (FNV-1a). Ports are left out so that all fragments of a datagram go to the
+// same worker.
+func flowHash(packet []byte) uint32 {
+	var addrs []byte
+	switch {
+	case len(packet) >= 40 && packet[0]>>4 == 6:
+		addrs = packet[8:40]
+	case len(packet) >= 20 && packet[0]>>4 == 4:
+		addrs = packet[12:20]
+	}
+
+	h := uint32(2166136261)
+	for _, c := range addrs {
+		h ^= uint32(c)
+		h *= 16777619
+	}
+	return h
+}
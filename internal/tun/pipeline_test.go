@@ -0,0 +1,111 @@
+package tun
+
+import (
+	"encoding/binary"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// flowPacket returns an IPv4 header of a flow from 10.0.0.<flow> carrying a
+// sequence number in its identification field
+func flowPacket(flow byte, seq uint16) []byte {
+	packet := make([]byte, 20)
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[4:6], seq)
+	copy(packet[12:16], []byte{10, 0, 0, flow})
+	copy(packet[16:20], []byte{192, 0, 2, 1})
+	return packet
+}
+
+// dispatchPacket copies a packet into a pooled buffer and queues it, retrying
+// while the worker's queue is full
+func dispatchPacket(p *workerPool, packet []byte, direction string) {
+	buf := p.getBuffer()
+	n := copy(*buf, packet)
+	for !p.dispatch(buf, n, direction) {
+		runtime.Gosched()
+	}
+}
+
+func TestWorkerPoolKeepsFlowOrder(t *testing.T) {
+	var mu sync.Mutex
+	last := make(map[byte]int)
+	pool := newWorkerPool(4, 16, 0, func(direction string, data []byte) {
+		flow, seq := data[15], int(binary.BigEndian.Uint16(data[4:6]))
+
+		mu.Lock()
+		defer mu.Unlock()
+		if prev, seen := last[flow]; seen && seq != prev+1 {
+			t.Errorf("flow %d: packet %d after %d", flow, seq, prev)
+		}
+		last[flow] = seq
+	}, nil)
+	pool.start()
+
+	for seq := uint16(0); seq < 200; seq++ {
+		for flow := byte(1); flow <= 8; flow++ {
+			dispatchPacket(pool, flowPacket(flow, seq), directionIPv4ToIPv6)
+		}
+	}
+	pool.stop()
+
+	for flow := byte(1); flow <= 8; flow++ {
+		if last[flow] != 199 {
+			t.Errorf("flow %d: last packet %d, want 199", flow, last[flow])
+		}
+	}
+}
+
+func TestWorkerPoolRecoversFromPanic(t *testing.T) {
+	var handled, failed atomic.Int32
+	pool := newWorkerPool(1, 4, 0, func(direction string, data []byte) {
+		if data[0] == 0 {
+			panic("bad packet")
+		}
+		handled.Add(1)
+	}, func(direction string, v interface{}, stack []byte) {
+		if direction != directionIPv4ToIPv6 || v != "bad packet" || len(stack) == 0 {
+			t.Errorf("failed(%q, %v) called with %d bytes of stack", direction, v, len(stack))
+		}
+		failed.Add(1)
+	})
+	pool.start()
+
+	for _, first := range []byte{0, 1, 0, 1} {
+		buf := pool.getBuffer()
+		(*buf)[0] = first
+		if !pool.dispatch(buf, 1, directionIPv4ToIPv6) {
+			t.Fatal("dispatch failed")
+		}
+	}
+	pool.stop()
+
+	if handled.Load() != 2 || failed.Load() != 2 {
+		t.Fatalf("handled %d and failed %d packets, want 2 and 2", handled.Load(), failed.Load())
+	}
+}
+
+func BenchmarkWorkerPool(b *testing.B) {
+	packets := make([][]byte, 64)
+	for i := range packets {
+		packets[i] = append(flowPacket(byte(i), 0), make([]byte, 1480)...)
+	}
+
+	var handled sync.WaitGroup
+	pool := newWorkerPool(runtime.NumCPU(), 256, 0, func(direction string, data []byte) {
+		handled.Done()
+	}, nil)
+	pool.start()
+	defer pool.stop()
+
+	b.ReportAllocs()
+	b.SetBytes(1500)
+	b.ResetTimer()
+	handled.Add(b.N)
+	for i := 0; i < b.N; i++ {
+		dispatchPacket(pool, packets[i%len(packets)], directionIPv6ToIPv4)
+	}
+	handled.Wait()
+}
@@ -0,0 +1,21 @@
+//go:build linux
+
+package tun
+
+import "github.com/songgao/water"
+
+// multiQueueSupported reports whether TUN devices can have several queues
+const multiQueueSupported = true
+
+// tunConfig returns the configuration of one queue of a TUN device. The first
+// queue is created with a system-assigned name; further queues attach to it
+// by name.
+func tunConfig(name string, multiQueue bool) water.Config {
+	return water.Config{
+		DeviceType: water.TUN,
+		PlatformSpecificParams: water.PlatformSpecificParams{
+			Name:       name,
+			MultiQueue: multiQueue,
+		},
+	}
+}
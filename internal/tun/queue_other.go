@@ -0,0 +1,16 @@
+//go:build !linux
+
+package tun
+
+import "github.com/songgao/water"
+
+// multiQueueSupported reports whether TUN devices can have several queues
+const multiQueueSupported = false
+
+// tunConfig returns the configuration of a TUN device. Names are always
+// system-assigned, on macOS they must be utun[0-9]+.
+func tunConfig(name string, multiQueue bool) water.Config {
+	return water.Config{
+		DeviceType: water.TUN,
+	}
+}
@@ -0,0 +1,238 @@
+package tun
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/mdxabu/bridge/internal/config"
+	"github.com/mdxabu/bridge/internal/logger"
+	"github.com/mdxabu/bridge/internal/nat"
+)
+
+// liveKeys are the configuration keys a running bridge changes in place, in
+// the order they are applied. Every other key takes effect after a restart.
+var liveKeys = []string{
+	"log_level",
+	"logging.levels.nat",
+	"logging.levels.tun",
+	"logging.levels.api",
+	"logging.levels.translator",
+	"logging.levels.dns64",
+	"logging.sample_rate",
+	"pool4",
+	"filtering",
+	"static_bindings",
+	"fragments.timeout",
+	"fragments.max_buffers",
+	"timeouts.udp",
+	"timeouts.icmp",
+	"timeouts.tcp_established",
+	"timeouts.tcp_transitory",
+	"capture.dir",
+	"capture.max_size",
+}
+
+// ReloadResult reports what a configuration reload changed
+type ReloadResult struct {
+	Applied         []string          `json:"applied"`                  // Keys changed in place
+	RestartRequired []string          `json:"restart_required"`         // Changed keys that take effect after a restart
+	Failed          map[string]string `json:"failed,omitempty"`         // Keys that could not be applied, with the reason
+	NotApplicable   []string          `json:"not_applicable,omitempty"` // Changed keys the running bridge does not use
+}
+
+// Reload applies a new, validated configuration to the running bridge. Session
+// timeouts, pool4, filtering, static bindings, fragment limits, log levels,
+// log sampling and capture settings change in place, keeping existing
+// sessions. A pool4 that would leave a static binding outside it is not
+// applied. Other changed keys are reported as requiring a restart and keep
+// their running values, as do keys the running bridge does not use.
+func (b *Bridge) Reload(cfg *config.BridgeConfig) (*ReloadResult, error) {
+	b.reloadMu.Lock()
+	defer b.reloadMu.Unlock()
+
+	result := &ReloadResult{Applied: []string{}, RestartRequired: []string{}}
+	changed := make(map[string]bool)
+	for _, key := range config.Diff(b.cfg, cfg) {
+		if slices.Contains(liveKeys, key) {
+			changed[key] = true
+		} else {
+			result.RestartRequired = append(result.RestartRequired, key)
+		}
+	}
+
+	// Parse everything first so that a bad value changes nothing
+	var pool, oldPool *nat.Pool4
+	if changed["pool4"] {
+		var err error
+		if pool, err = newPool4(cfg.GetPool4()); err != nil {
+			return nil, fmt.Errorf("pool4: %w", err)
+		}
+		if oldPool, err = newPool4(b.cfg.GetPool4()); err != nil {
+			return nil, fmt.Errorf("pool4: %w", err)
+		}
+	}
+	filtering, err := nat.ParseFilteringMode(cfg.GetFiltering())
+	if err != nil {
+		return nil, fmt.Errorf("filtering: %w", err)
+	}
+	logOpts, err := cfg.LoggerOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	// Static bindings the reload removes need not fit a new pool4
+	var gone []config.StaticBinding
+	if changed["static_bindings"] && b.uses("static_bindings") {
+		for _, entry := range b.cfg.GetStaticBindings() {
+			if !containsBinding(cfg.GetStaticBindings(), entry) {
+				gone = append(gone, entry)
+			}
+		}
+	}
+
+	fail := func(key string, err error) {
+		if result.Failed == nil {
+			result.Failed = make(map[string]string)
+		}
+		result.Failed[key] = err.Error()
+	}
+
+	for _, key := range liveKeys {
+		if !changed[key] {
+			continue
+		}
+		if !b.uses(key) {
+			result.NotApplicable = append(result.NotApplicable, key)
+			continue
+		}
+
+		var err error
+		switch key {
+		case "log_level", "logging.levels.nat", "logging.levels.tun", "logging.levels.api",
+			"logging.levels.translator", "logging.levels.dns64":
+			logger.SetLevels(logOpts.Level, logOpts.Levels)
+		case "logging.sample_rate":
+			logger.SetSampleRate(logOpts.SampleRate)
+		case "pool4":
+			if err = b.checkPool(pool, gone); err == nil {
+				b.natTable.SetPool(pool)
+			}
+		case "filtering":
+			b.natTable.SetFiltering(filtering)
+		case "static_bindings":
+			err = b.reloadStaticBindings(b.cfg.GetStaticBindings(), cfg.GetStaticBindings())
+			if err != nil && slices.Contains(result.Applied, "pool4") {
+				// The old bindings stay, so the old pool4 may have to as well
+				if poolErr := b.checkPool(pool, nil); poolErr != nil {
+					b.natTable.SetPool(oldPool)
+					result.Applied = slices.DeleteFunc(result.Applied, func(k string) bool { return k == "pool4" })
+					fail("pool4", poolErr)
+				}
+			}
+		case "fragments.timeout", "fragments.max_buffers":
+			b.reassembler4.SetLimits(cfg.Fragments.Timeout, cfg.Fragments.MaxBuffers)
+			b.reassembler6.SetLimits(cfg.Fragments.Timeout, cfg.Fragments.MaxBuffers)
+		case "capture.dir", "capture.max_size":
+			// Read when a capture starts
+		default: // Session timeouts
+			b.natTable.SetTimeouts(natTimeouts(cfg.GetTimeouts()))
+		}
+
+		if err != nil {
+			fail(key, err)
+			continue
+		}
+		result.Applied = append(result.Applied, key)
+	}
+
+	b.cfg = b.cfg.Update(cfg, result.Applied)
+	return result, nil
+}
+
+// uses reports whether the running bridge uses a live key. Static bindings
+// need NAT sessions and fragment limits need reassembly, neither of which
+// can be turned on without a restart.
+func (b *Bridge) uses(key string) bool {
+	switch key {
+	case "static_bindings":
+		return !b.stateless()
+	case "fragments.timeout", "fragments.max_buffers":
+		return b.reassembler4 != nil
+	}
+	return true
+}
+
+// reloadStaticBindings removes the configured static bindings that are gone
+// and adds the new ones in one step: if one cannot be added, the old ones stay.
+// Bindings added through the API are left alone.
+func (b *Bridge) reloadStaticBindings(old, new []config.StaticBinding) error {
+	var removed, added []config.StaticBinding
+	var remove, add []nat.BindingEntry
+	for _, entry := range old {
+		if containsBinding(new, entry) {
+			continue
+		}
+		binding, err := parseStaticBinding(entry)
+		if err != nil {
+			continue // Never added
+		}
+		removed = append(removed, entry)
+		remove = append(remove, binding)
+	}
+	for _, entry := range new {
+		if containsBinding(old, entry) {
+			continue
+		}
+		binding, err := parseStaticBinding(entry)
+		if err != nil {
+			return err
+		}
+		added = append(added, entry)
+		add = append(add, binding)
+	}
+
+	if err := b.natTable.ReplaceStaticBindings(remove, add); err != nil {
+		return err
+	}
+	for _, entry := range removed {
+		tunLog.Info("Removed static binding %s %s:%d", entry.Protocol, entry.IPv4, entry.IPv4Port)
+	}
+	for _, entry := range added {
+		tunLog.Info("Static binding %s %s:%d -> [%s]:%d", entry.Protocol, entry.IPv4, entry.IPv4Port, entry.IPv6, entry.IPv6Port)
+	}
+	return nil
+}
+
+// checkPool returns an error if a static binding, other than those in gone,
+// has an address outside pool
+func (b *Bridge) checkPool(pool *nat.Pool4, gone []config.StaticBinding) error {
+	var skip []nat.BindingEntry
+	for _, entry := range gone {
+		if binding, err := parseStaticBinding(entry); err == nil {
+			skip = append(skip, binding)
+		}
+	}
+
+	for _, binding := range b.natTable.GetStaticBindings() {
+		if pool.ContainsAddress(binding.IPv4IP) {
+			continue
+		}
+		removed := slices.ContainsFunc(skip, func(entry nat.BindingEntry) bool {
+			return entry.Protocol == binding.Protocol && entry.IPv4IP.Equal(binding.IPv4IP) && entry.IPv4Port == binding.IPv4Port
+		})
+		if !removed {
+			return fmt.Errorf("static binding %s:%d is not in the new pool4 (%s)", binding.IPv4IP, binding.IPv4Port, pool)
+		}
+	}
+	return nil
+}
+
+// containsBinding reports whether a static binding is in a list
+func containsBinding(bindings []config.StaticBinding, entry config.StaticBinding) bool {
+	for _, other := range bindings {
+		if other == entry {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package tun
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mdxabu/bridge/internal/config"
+)
+
+// staticBindings returns the IPv4 and IPv6 addresses of the static bindings
+// of a bridge, sorted
+func staticBindings(b *Bridge) []string {
+	var bindings []string
+	for _, binding := range b.GetStaticBindings() {
+		bindings = append(bindings, binding.IPv4IP.String()+" "+binding.IPv6IP.String())
+	}
+	slices.Sort(bindings)
+	return bindings
+}
+
+func TestReloadStaticBindingsIsAtomic(t *testing.T) {
+	dns := config.StaticBinding{Protocol: "udp", IPv6: "2001:db8::10", IPv6Port: 53, IPv4: "10.64.0.1", IPv4Port: 53}
+	web := config.StaticBinding{Protocol: "tcp", IPv6: "2001:db8::20", IPv6Port: 80, IPv4: "10.64.0.1", IPv4Port: 80}
+
+	cfg := config.Defaults()
+	cfg.StaticBindings = []config.StaticBinding{dns, web}
+	b, err := NewBridge(cfg)
+	if err != nil {
+		t.Fatalf("NewBridge: %v", err)
+	}
+	before := staticBindings(b)
+
+	// Moving the DNS binding succeeds, but the second new binding takes the
+	// address of the web binding, which stays
+	moved := dns
+	moved.IPv6 = "2001:db8::11"
+	conflict := config.StaticBinding{Protocol: "tcp", IPv6: "2001:db8::30", IPv6Port: 80, IPv4: "10.64.0.1", IPv4Port: 80}
+	next := config.Defaults()
+	next.StaticBindings = []config.StaticBinding{moved, web, conflict}
+
+	result, err := b.Reload(next)
+	if err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if _, failed := result.Failed["static_bindings"]; !failed {
+		t.Fatalf("Reload result %+v, want static_bindings failed", result)
+	}
+	if after := staticBindings(b); !slices.Equal(after, before) {
+		t.Fatalf("static bindings after a failed reload = %v, want %v", after, before)
+	}
+
+	// The DNS binding can move to another IPv6 host at the same IPv4 address
+	next = config.Defaults()
+	next.StaticBindings = []config.StaticBinding{moved, web}
+	result, err = b.Reload(next)
+	if err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if !slices.Contains(result.Applied, "static_bindings") {
+		t.Fatalf("Reload result %+v, want static_bindings applied", result)
+	}
+	want := []string{"10.64.0.1 2001:db8::11", "10.64.0.1 2001:db8::20"}
+	if after := staticBindings(b); !slices.Equal(after, want) {
+		t.Fatalf("static bindings = %v, want %v", after, want)
+	}
+}
+
+func TestReloadPool4KeepsStaticBindingsInside(t *testing.T) {
+	dns := config.StaticBinding{Protocol: "udp", IPv6: "2001:db8::10", IPv6Port: 53, IPv4: "10.64.0.1", IPv4Port: 53}
+	newPool := []config.Pool4Entry{{Prefix: "192.0.2.1/32", Ports: "10000-65000"}}
+
+	cfg := config.Defaults()
+	cfg.StaticBindings = []config.StaticBinding{dns}
+	b, err := NewBridge(cfg)
+	if err != nil {
+		t.Fatalf("NewBridge: %v", err)
+	}
+	before := staticBindings(b)
+
+	// The DNS binding would be left outside the new pool
+	next := config.Defaults()
+	next.Pool4 = newPool
+	next.StaticBindings = []config.StaticBinding{dns}
+	result, err := b.Reload(next)
+	if err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if _, failed := result.Failed["pool4"]; !failed || slices.Contains(result.Applied, "pool4") {
+		t.Fatalf("Reload result %+v, want pool4 failed", result)
+	}
+
+	// A static binding that fails to move keeps the old pool4 as well
+	moved := dns
+	moved.IPv4 = "192.0.2.1"
+	conflict := config.StaticBinding{Protocol: "udp", IPv6: "2001:db8::20", IPv6Port: 53, IPv4: "192.0.2.1", IPv4Port: 53}
+	next.StaticBindings = []config.StaticBinding{moved, conflict}
+	result, err = b.Reload(next)
+	if err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if _, failed := result.Failed["pool4"]; !failed || slices.Contains(result.Applied, "pool4") {
+		t.Fatalf("Reload result %+v, want pool4 failed", result)
+	}
+	if _, failed := result.Failed["static_bindings"]; !failed {
+		t.Fatalf("Reload result %+v, want static_bindings failed", result)
+	}
+	if after := staticBindings(b); !slices.Equal(after, before) {
+		t.Fatalf("static bindings after a failed reload = %v, want %v", after, before)
+	}
+
+	// The binding can move into the new pool together with it
+	next.StaticBindings = []config.StaticBinding{moved}
+	result, err = b.Reload(next)
+	if err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if !slices.Contains(result.Applied, "pool4") || !slices.Contains(result.Applied, "static_bindings") {
+		t.Fatalf("Reload result %+v, want pool4 and static_bindings applied", result)
+	}
+	want := []string{"192.0.2.1 2001:db8::10"}
+	if after := staticBindings(b); !slices.Equal(after, want) {
+		t.Fatalf("static bindings = %v, want %v", after, want)
+	}
+}
+
+func TestReloadFragmentLimitsWithoutReassembly(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.Fragments.Reassemble = false
+	b, err := NewBridge(cfg)
+	if err != nil {
+		t.Fatalf("NewBridge: %v", err)
+	}
+
+	next := config.Defaults()
+	next.Fragments.Reassemble = false
+	next.Fragments.Timeout = 5 * time.Second
+	result, err := b.Reload(next)
+	if err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if !slices.Contains(result.NotApplicable, "fragments.timeout") || slices.Contains(result.Applied, "fragments.timeout") {
+		t.Fatalf("Reload result %+v, want fragments.timeout not applicable", result)
+	}
+}